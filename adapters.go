@@ -0,0 +1,66 @@
+package jsonmap
+
+import (
+	"net/http"
+)
+
+// The adapters in this file allow jsonmap to be used as the binding and
+// validation layer of popular routers without importing any of them. Each
+// relies on Go's structural typing to satisfy the router's own interfaces.
+
+// RouterBinding adapts a RequestBinder to the binding interface used by Gin
+// (binding.Binding), so it can be passed to c.ShouldBindWith(). Errors
+// returned are jsonmap's MultiValidationError rather than Gin's own binding
+// errors.
+type RouterBinding struct {
+	Binder  *RequestBinder
+	Context Context
+}
+
+// Name identifies the binding.
+func (b RouterBinding) Name() string {
+	return "jsonmap"
+}
+
+// Bind validates r into obj.
+func (b RouterBinding) Bind(r *http.Request, obj interface{}) error {
+	return b.Binder.Bind(b.Context, r, obj)
+}
+
+// NewRouterBinding returns a RouterBinding which binds requests using the
+// given RequestBinder and Context.
+func NewRouterBinding(ctx Context, binder *RequestBinder) RouterBinding {
+	return RouterBinding{
+		Binder:  binder,
+		Context: ctx,
+	}
+}
+
+// RequestFunc is the shape shared by most routers' request accessors, for
+// example Echo's c.Request().
+type RequestFunc func() *http.Request
+
+// BindFrom binds the request returned by req. It is intended for routers such
+// as Echo whose binder interfaces are expressed in terms of their own context
+// types:
+//
+//	err := binder.BindFrom(ctx, c.Request, &dst)
+func (b *RequestBinder) BindFrom(ctx Context, req RequestFunc, dst interface{}) error {
+	return b.Bind(ctx, req(), dst)
+}
+
+// PathParamsFunc builds a RequestBinder.PathParams function from a router's
+// path parameter accessor, such as chi.URLParam, or (*http.Request).PathValue
+// on Go 1.22 and later. Only the named parameters are extracted, and empty
+// values are treated as absent.
+func PathParamsFunc(get func(r *http.Request, name string) string, names ...string) func(r *http.Request) map[string][]string {
+	return func(r *http.Request) map[string][]string {
+		params := make(map[string][]string, len(names))
+		for _, name := range names {
+			if v := get(r, name); v != "" {
+				params[name] = []string{v}
+			}
+		}
+		return params
+	}
+}
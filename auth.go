@@ -0,0 +1,124 @@
+package jsonmap
+
+import (
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// credentialsMapper is implemented by QueryParameterMappers whose values are
+// secret, and so must not be included in error messages.
+type credentialsMapper interface {
+	credentials()
+}
+
+// token68 is the syntax of credentials such as bearer tokens, per RFC 7235.
+var token68 = regexp.MustCompile(`^[A-Za-z0-9\-._~+/]+=*$`)
+
+// authorizationCredentials splits the value of an Authorization header,
+// requiring that it use the given scheme, which is compared case
+// insensitively. It returns an empty string if there is no header.
+func authorizationCredentials(scheme string, required bool, src []string) (string, error) {
+	if len(src) > 1 {
+		return "", NewValidationError("too many values")
+	}
+
+	if len(src) == 0 || src[0] == "" {
+		if required {
+			return "", NewValidationError("missing credentials")
+		}
+		return "", nil
+	}
+
+	parts := strings.SplitN(src[0], " ", 2)
+	if !strings.EqualFold(parts[0], scheme) {
+		return "", NewValidationError("expected %s credentials", scheme)
+	}
+
+	if len(parts) != 2 {
+		return "", NewValidationError("malformed %s credentials", scheme)
+	}
+
+	credentials := strings.TrimLeft(parts[1], " ")
+	if !token68.MatchString(credentials) {
+		return "", NewValidationError("malformed %s credentials", scheme)
+	}
+
+	return credentials, nil
+}
+
+// BearerQueryParameterMapper maps an "Authorization: Bearer <token>" header
+// to a string field containing the token.
+type BearerQueryParameterMapper struct {
+	// Required rejects requests without an Authorization header. Otherwise
+	// the field is left empty.
+	Required bool
+}
+
+func (bqpm BearerQueryParameterMapper) credentials() {}
+
+func (bqpm BearerQueryParameterMapper) Decode(src ...string) (interface{}, error) {
+	return authorizationCredentials("Bearer", bqpm.Required, src)
+}
+
+func (bqpm BearerQueryParameterMapper) Encode(src reflect.Value) ([]string, error) {
+	if src.Kind() != reflect.String {
+		return nil, fmt.Errorf("expected string but got: %s", src.Kind())
+	}
+
+	return []string{"Bearer " + src.String()}, nil
+}
+
+// BasicCredentials holds the credentials of an "Authorization: Basic" header.
+type BasicCredentials struct {
+	Username string
+	Password string
+}
+
+// BasicQueryParameterMapper maps an "Authorization: Basic" header to a
+// BasicCredentials field.
+type BasicQueryParameterMapper struct {
+	// Required rejects requests without an Authorization header. Otherwise
+	// the field is left empty.
+	Required bool
+}
+
+func (bqpm BasicQueryParameterMapper) credentials() {}
+
+func (bqpm BasicQueryParameterMapper) Decode(src ...string) (interface{}, error) {
+	encoded, err := authorizationCredentials("Basic", bqpm.Required, src)
+	if err != nil || encoded == "" {
+		return BasicCredentials{}, err
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, NewValidationError("malformed Basic credentials")
+	}
+
+	parts := strings.SplitN(string(decoded), ":", 2)
+	if len(parts) != 2 {
+		return nil, NewValidationError("malformed Basic credentials")
+	}
+
+	return BasicCredentials{
+		Username: parts[0],
+		Password: parts[1],
+	}, nil
+}
+
+func (bqpm BasicQueryParameterMapper) Encode(src reflect.Value) ([]string, error) {
+	creds, ok := src.Interface().(BasicCredentials)
+	if !ok {
+		return nil, fmt.Errorf("expected jsonmap.BasicCredentials but got: %s", src.Type())
+	}
+
+	if strings.Contains(creds.Username, ":") {
+		return nil, fmt.Errorf("username may not contain a colon")
+	}
+
+	encoded := base64.StdEncoding.EncodeToString([]byte(creds.Username + ":" + creds.Password))
+	return []string{"Basic " + encoded}, nil
+}
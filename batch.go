@@ -0,0 +1,170 @@
+package jsonmap
+
+import "fmt"
+
+// BatchCheck checks many values at once against an external system, such as
+// a database uniqueness constraint or a permissions service. It returns one
+// error per value, nil where the value passed, or an error if the check could
+// not be made at all.
+type BatchCheck func(ctx Context, values []interface{}) ([]error, error)
+
+// BatchedValidator is a Validator which performs its check in a batch. See
+// Batched().
+type BatchedValidator struct {
+	Check BatchCheck
+}
+
+func (v *BatchedValidator) Validate(value interface{}) (interface{}, error) {
+	return v.ValidateContext(EmptyContext, value)
+}
+
+func (v *BatchedValidator) ValidateContext(ctx Context, value interface{}) (interface{}, error) {
+	if b := batchOf(ctx); b != nil {
+		b.add(v, pathOf(ctx), value)
+		return value, nil
+	}
+
+	// Outside of UnmarshalBatched() the check is made immediately
+	errs, err := v.Check(ctx, []interface{}{value})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(errs) != 1 {
+		return nil, fmt.Errorf("batch check returned %d results for 1 value", len(errs))
+	}
+
+	if errs[0] != nil {
+		return nil, errs[0]
+	}
+
+	return value, nil
+}
+
+// Batched returns a Validator which, when used with
+// TypeMapper.UnmarshalBatched(), defers its check until the entire document
+// has been validated, then checks every value it was given in a single call.
+// Any failures are reported at the path of the value which caused them. When
+// used in any other way the check is made immediately, one value at a time.
+func Batched(check BatchCheck) *BatchedValidator {
+	return &BatchedValidator{
+		Check: check,
+	}
+}
+
+type batchContextKey struct{}
+
+type batchedValue struct {
+	path  []string
+	value interface{}
+}
+
+// batch collects the values passed to each BatchedValidator during a call to
+// UnmarshalBatched().
+type batch struct {
+	validators []*BatchedValidator
+	values     map[*BatchedValidator][]batchedValue
+}
+
+func (b *batch) add(v *BatchedValidator, path []string, value interface{}) {
+	if _, ok := b.values[v]; !ok {
+		b.validators = append(b.validators, v)
+	}
+	b.values[v] = append(b.values[v], batchedValue{path, value})
+}
+
+// run makes each deferred check, returning the resulting validation errors
+// in a ValidationError whose root carries no field or message.
+func (b *batch) run(ctx Context) (*ValidationError, error) {
+	errs := &ValidationError{}
+
+	for _, v := range b.validators {
+		batched := b.values[v]
+
+		values := make([]interface{}, len(batched))
+		for i, bv := range batched {
+			values[i] = bv.value
+		}
+
+		results, err := v.Check(ctx, values)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(results) != len(values) {
+			return nil, fmt.Errorf("batch check returned %d results for %d values", len(results), len(values))
+		}
+
+		for i, result := range results {
+			if result != nil {
+				errs.AddError(pathError(batched[i].path, result))
+			}
+		}
+	}
+
+	return errs, nil
+}
+
+// pathError attributes err to the value at the given path, nesting it within
+// a ValidationError for each parent.
+func pathError(path []string, err error) *ValidationError {
+	if len(path) == 0 {
+		ve, ok := err.(*ValidationError)
+		if !ok {
+			ve = NewValidationError("%s", err.Error())
+		}
+		return ve
+	}
+
+	ve := fieldError(path[len(path)-1], err)
+	for i := len(path) - 2; i >= 0; i-- {
+		parent := NewValidationErrorWithField(path[i], "")
+		parent.AddError(ve)
+		ve = parent
+	}
+	return ve
+}
+
+func batchOf(ctx Context) *batch {
+	c, ok := ctx.(*Ctx)
+	if !ok {
+		return nil
+	}
+
+	b, _ := c.Value(batchContextKey{}).(*batch)
+	return b
+}
+
+// UnmarshalBatched unmarshals data into dest, as with Unmarshal, except that
+// checks made by Batched() validators are collected and made once the
+// document has been validated, with a single call for each validator. Any
+// failures are merged with other validation errors in the returned
+// MultiValidationError.
+func (tm *TypeMapper) UnmarshalBatched(ctx Context, data []byte, dest interface{}) error {
+	b := &batch{
+		values: map[*BatchedValidator][]batchedValue{},
+	}
+
+	err := tm.Unmarshal(NewCtx(ctx).With(batchContextKey{}, b), data, dest)
+
+	errs, ok := asMultiValidationError(err)
+	if err != nil && (!ok || tm.FailFast) {
+		return err
+	}
+
+	if errs == nil {
+		errs = &MultiValidationError{}
+	}
+
+	batchErrs, berr := b.run(ctx)
+	if berr != nil {
+		return berr
+	}
+
+	errs.NestedErrors = append(errs.NestedErrors, batchErrs.Flatten().Errors()...)
+
+	if len(errs.Errors()) == 0 {
+		return nil
+	}
+	return errs
+}
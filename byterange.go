@@ -0,0 +1,169 @@
+package jsonmap
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ByteRange is a single range of bytes, as requested by a Range header or
+// described by a Content-Range header.
+type ByteRange struct {
+	// Start and End are the offsets of the first and last bytes in the range.
+	// End is -1 if the range extends to the end of the representation.
+	Start int64
+	End   int64
+
+	// Suffix, if non-zero, is the number of bytes requested from the end of
+	// the representation, as in "bytes=-500", and Start and End are unused.
+	Suffix int64
+
+	// Size is the complete length of the representation, or 0 if unknown.
+	// It is only used for Content-Range.
+	Size int64
+}
+
+// Resolve returns the range for a representation of the given size, with
+// Start and End both set, clamping End to the last byte. It fails if the
+// range can't be satisfied, in which case a 416 response is appropriate.
+func (r ByteRange) Resolve(size int64) (ByteRange, error) {
+	resolved := ByteRange{Start: r.Start, End: r.End, Size: size}
+
+	if r.Suffix != 0 {
+		resolved.Start = size - r.Suffix
+		if resolved.Start < 0 {
+			resolved.Start = 0
+		}
+		resolved.End = size - 1
+	} else if r.End < 0 || r.End >= size {
+		resolved.End = size - 1
+	}
+
+	if resolved.Start >= size {
+		return ByteRange{}, NewValidationError("range not satisfiable")
+	}
+
+	return resolved, nil
+}
+
+// Length returns the number of bytes in a resolved range.
+func (r ByteRange) Length() int64 {
+	return r.End - r.Start + 1
+}
+
+// parseOffset parses a non-negative byte offset.
+func parseOffset(s string) (int64, error) {
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, NewValidationError("invalid byte offset: %s", s)
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, NewValidationError("byte offset out of range: %s", s)
+	}
+	return n, nil
+}
+
+// RangeQueryParameterMapper maps a Range header to a *ByteRange field, which
+// is left nil if there is no header. Only a single range of bytes is
+// supported. When encoding, the field is formatted as a Content-Range header,
+// so must have been resolved with ByteRange.Resolve().
+type RangeQueryParameterMapper struct {
+	// MaxLength, if non-zero, is the greatest number of bytes which may be
+	// requested. Open-ended ranges are not limited, as their length depends
+	// on the representation.
+	MaxLength int64
+}
+
+func (rqpm RangeQueryParameterMapper) Decode(src ...string) (interface{}, error) {
+	if len(src) > 1 {
+		return nil, NewValidationError("too many values")
+	}
+
+	if len(src) == 0 || src[0] == "" {
+		return (*ByteRange)(nil), nil
+	}
+
+	spec := strings.TrimSpace(src[0])
+	if !strings.HasPrefix(spec, "bytes=") {
+		return nil, NewValidationError("unsupported range unit")
+	}
+	spec = strings.TrimSpace(spec[len("bytes="):])
+
+	if strings.Contains(spec, ",") {
+		return nil, NewValidationError("multiple ranges are not supported")
+	}
+
+	parts := strings.SplitN(spec, "-", 2)
+	if len(parts) != 2 {
+		return nil, NewValidationError("invalid byte range: %s", spec)
+	}
+
+	r := &ByteRange{End: -1}
+
+	if parts[0] == "" {
+		suffix, err := parseOffset(parts[1])
+		if err != nil {
+			return nil, err
+		}
+
+		if suffix == 0 {
+			return nil, NewValidationError("range not satisfiable")
+		}
+
+		if rqpm.MaxLength != 0 && suffix > rqpm.MaxLength {
+			return nil, NewValidationError("range may not exceed %d bytes", rqpm.MaxLength)
+		}
+
+		r.Suffix = suffix
+		return r, nil
+	}
+
+	start, err := parseOffset(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	r.Start = start
+
+	if parts[1] != "" {
+		end, err := parseOffset(parts[1])
+		if err != nil {
+			return nil, err
+		}
+
+		if end < start {
+			return nil, NewValidationError("range ends before it starts")
+		}
+
+		if rqpm.MaxLength != 0 && end-start >= rqpm.MaxLength {
+			return nil, NewValidationError("range may not exceed %d bytes", rqpm.MaxLength)
+		}
+
+		r.End = end
+	}
+
+	return r, nil
+}
+
+func (rqpm RangeQueryParameterMapper) Encode(src reflect.Value) ([]string, error) {
+	r, ok := src.Interface().(*ByteRange)
+	if !ok {
+		return nil, fmt.Errorf("expected *jsonmap.ByteRange but got: %s", src.Type())
+	}
+
+	if r == nil {
+		return nil, nil
+	}
+
+	if r.Suffix != 0 || r.End < 0 {
+		return nil, fmt.Errorf("byte range must be resolved before encoding")
+	}
+
+	size := "*"
+	if r.Size != 0 {
+		size = strconv.FormatInt(r.Size, 10)
+	}
+
+	return []string{fmt.Sprintf("bytes %d-%d/%s", r.Start, r.End, size)}, nil
+}
@@ -0,0 +1,87 @@
+package jsonmap
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+)
+
+var bytesType = reflect.TypeOf([]byte(nil))
+
+// BytesMap maps base64 encoded strings to []byte fields.
+type BytesMap struct {
+	// Encoding is the base64 encoding to use, such as base64.StdEncoding or
+	// base64.RawURLEncoding.
+	Encoding *base64.Encoding
+
+	// MinLen and MaxLen bound the length of the decoded data, in bytes.
+	MinLen int
+	MaxLen int
+}
+
+func (m *BytesMap) Unmarshal(ctx Context, parent *reflect.Value, partial interface{}, dstValue reflect.Value) error {
+	if dstValue.Type() != bytesType {
+		panic("target field for jsonmap.Bytes() is not a []byte")
+	}
+
+	s, ok := partial.(string)
+	if !ok {
+		return NewValidationError("not a string")
+	}
+
+	// Check the length before decoding, so an oversized value costs nothing.
+	// DecodedLen doesn't account for padding, which may be up to 2 bytes.
+	if m.Encoding.DecodedLen(len(s)) > m.MaxLen+2 {
+		return NewValidationError("too long, may not be more than %d bytes", m.MaxLen)
+	}
+
+	b, err := m.Encoding.DecodeString(s)
+	if err != nil {
+		return NewValidationError("not valid base64")
+	}
+
+	if len(b) < m.MinLen {
+		return NewValidationError("too short, must be at least %d bytes", m.MinLen)
+	}
+
+	if len(b) > m.MaxLen {
+		return NewValidationError("too long, may not be more than %d bytes", m.MaxLen)
+	}
+
+	dstValue.SetBytes(b)
+
+	return nil
+}
+
+func (m *BytesMap) Marshal(ctx Context, parent *reflect.Value, src reflect.Value) (json.Marshaler, error) {
+	buf := &bytes.Buffer{}
+	err := m.marshalTo(ctx, parent, src, buf)
+	if err != nil {
+		return nil, err
+	}
+	return RawMessage{buf.Bytes()}, nil
+}
+
+func (m *BytesMap) marshalTo(ctx Context, parent *reflect.Value, src reflect.Value, buf *bytes.Buffer) error {
+	if src.Type() != bytesType {
+		panic("source field for jsonmap.Bytes() is not a []byte")
+	}
+
+	if src.IsNil() {
+		buf.Write(nullJSONValue)
+		return nil
+	}
+	return marshalValueTo(m.Encoding.EncodeToString(src.Bytes()), buf)
+}
+
+// Bytes returns a TypeMap for []byte fields, which are represented as strings
+// using the given base64 encoding, and must decode to between minLen and
+// maxLen bytes. A nil slice is marshaled as null.
+func Bytes(encoding *base64.Encoding, minLen, maxLen int) TypeMap {
+	return &BytesMap{
+		Encoding: encoding,
+		MinLen:   minLen,
+		MaxLen:   maxLen,
+	}
+}
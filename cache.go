@@ -0,0 +1,163 @@
+package jsonmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sync"
+)
+
+// CachedMap memoizes the marshaled representation of values mapped by the
+// TypeMap it contains. See Cached().
+type CachedMap struct {
+	Contains RegisterableTypeMap
+
+	// Key returns the key which identifies the representation of src, such as
+	// its ID and entity tag or version, or false if it shouldn't be cached.
+	// src is always a pointer to the value.
+	Key func(ctx Context, src interface{}) (string, bool)
+
+	entries sync.Map
+}
+
+// cacheEntryKey identifies a cached representation by the key of the value,
+// and by the options in the Context which affect how it is marshaled.
+type cacheEntryKey struct {
+	key           string
+	version       int
+	hasVersion    bool
+	emptyNilMaps  bool
+	protoJSON     bool
+	unmappedTypes UnmappedTypes
+}
+
+// cacheable reports whether output marshaled with ctx may be cached. Field
+// sets, expansions and redaction change the output in ways which aren't
+// worth keying on, and tracing and validation are lost on a cache hit.
+func cacheable(ctx Context) bool {
+	if _, ok := expansionOf(ctx); ok {
+		return false
+	}
+	return fieldSetOf(ctx) == nil && !redacts(ctx) && traceOf(ctx) == nil && !validatesOnMarshal(ctx)
+}
+
+// entryKey returns the key of the cache entry for src, if it may be cached.
+func (cm *CachedMap) entryKey(ctx Context, src reflect.Value) (cacheEntryKey, bool) {
+	if !cacheable(ctx) {
+		return cacheEntryKey{}, false
+	}
+
+	for src.Kind() == reflect.Ptr || src.Kind() == reflect.Interface {
+		if src.IsNil() {
+			return cacheEntryKey{}, false
+		}
+		src = src.Elem()
+	}
+
+	var ptr reflect.Value
+	if src.CanAddr() {
+		ptr = src.Addr()
+	} else {
+		ptr = reflect.New(src.Type())
+		ptr.Elem().Set(src)
+	}
+
+	key, ok := cm.Key(ctx, ptr.Interface())
+	if !ok {
+		return cacheEntryKey{}, false
+	}
+
+	entry := cacheEntryKey{
+		key:           key,
+		protoJSON:     isProtoJSON(ctx),
+		unmappedTypes: unmappedTypesOf(ctx),
+	}
+	entry.version, entry.hasVersion = VersionOf(ctx)
+	if c, ok := ctx.(*Ctx); ok {
+		_, entry.emptyNilMaps = c.Get(emptyNilMapsContextKey{})
+	}
+	return entry, true
+}
+
+// Invalidate removes the cached representations of the value with the given
+// key, for every API version.
+func (cm *CachedMap) Invalidate(key string) {
+	cm.entries.Range(func(k, _ interface{}) bool {
+		if k.(cacheEntryKey).key == key {
+			cm.entries.Delete(k)
+		}
+		return true
+	})
+}
+
+// Purge removes every cached representation.
+func (cm *CachedMap) Purge() {
+	cm.entries.Range(func(k, _ interface{}) bool {
+		cm.entries.Delete(k)
+		return true
+	})
+}
+
+func (cm *CachedMap) GetUnderlyingType() reflect.Type {
+	return cm.Contains.GetUnderlyingType()
+}
+
+func (cm *CachedMap) Unmarshal(ctx Context, parent *reflect.Value, partial interface{}, dstValue reflect.Value) error {
+	return cm.Contains.Unmarshal(ctx, parent, partial, dstValue)
+}
+
+func (cm *CachedMap) unmarshalStream(ctx Context, parent *reflect.Value, ts *tokenStream, dstValue reflect.Value) error {
+	return unmarshalStream(ctx, cm.Contains, parent, ts, dstValue)
+}
+
+func (cm *CachedMap) Marshal(ctx Context, parent *reflect.Value, src reflect.Value) (json.Marshaler, error) {
+	buf := &bytes.Buffer{}
+	err := cm.marshalTo(ctx, parent, src, buf)
+	if err != nil {
+		return nil, err
+	}
+	return RawMessage{buf.Bytes()}, nil
+}
+
+func (cm *CachedMap) marshalTo(ctx Context, parent *reflect.Value, src reflect.Value, buf *bytes.Buffer) error {
+	key, ok := cm.entryKey(ctx, src)
+	if !ok {
+		return marshalTo(ctx, cm.Contains, parent, src, buf)
+	}
+
+	if data, ok := cm.entries.Load(key); ok {
+		buf.Write(data.([]byte))
+		return nil
+	}
+
+	start := buf.Len()
+	err := marshalTo(ctx, cm.Contains, parent, src, buf)
+	if err != nil {
+		return err
+	}
+
+	data := make([]byte, buf.Len()-start)
+	copy(data, buf.Bytes()[start:])
+	cm.entries.Store(key, data)
+	return nil
+}
+
+// Cached returns a TypeMap which caches the marshaled representation of each
+// value mapped by tm, keyed by the string returned by key along with the API
+// version specified by WithVersion and other options affecting the output,
+// such as WithProtoJSON. It is intended for read-mostly reference
+// objects which are marshaled repeatedly, such as on hot list endpoints, and
+// may be registered in place of tm.
+//
+// The key must change whenever the representation would, for example by
+// including an entity tag or version number of the value, or else entries
+// must be removed with Invalidate or Purge when the value changes. Anything
+// else which affects the output, such as a ContextTransform, must also be
+// accounted for by the key. Marshaling with a FieldSet, expansions,
+// redaction, tracing or ValidateOnMarshal bypasses the cache.
+func Cached(tm RegisterableTypeMap, key func(ctx Context, src interface{}) (string, bool)) *CachedMap {
+	return &CachedMap{
+		Contains: tm,
+		Key:      key,
+	}
+}
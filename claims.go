@@ -0,0 +1,209 @@
+package jsonmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"time"
+)
+
+// RegisteredClaims holds the registered claims of a JWT claim set, as
+// described by RFC 7519. Embed it in a struct mapped by ClaimsMap() to map
+// custom claims alongside them.
+type RegisteredClaims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	ExpiresAt time.Time
+	NotBefore time.Time
+	IssuedAt  time.Time
+	ID        string
+}
+
+// NumericDateMap maps time.Time fields to JWT NumericDate values, that is
+// numbers of seconds since the Unix epoch.
+type NumericDateMap struct {
+	// check, if set, validates the time after unmarshaling
+	check func(t time.Time) error
+}
+
+func (m *NumericDateMap) Unmarshal(ctx Context, parent *reflect.Value, partial interface{}, dstValue reflect.Value) error {
+	if _, ok := dstValue.Interface().(time.Time); !ok {
+		panic("target field for jsonmap.NumericDate() is not a time.Time")
+	}
+
+	secs, ok := partial.(float64)
+	if !ok {
+		return NewValidationError("not a number")
+	}
+
+	if secs > math.MaxInt64/float64(time.Second) || secs < math.MinInt64/float64(time.Second) {
+		return NewValidationError("date out of range")
+	}
+
+	whole, frac := math.Modf(secs)
+	t := time.Unix(int64(whole), int64(frac*float64(time.Second))).UTC()
+
+	if m.check != nil {
+		err := m.check(t)
+		if err != nil {
+			return err
+		}
+	}
+
+	dstValue.Set(reflect.ValueOf(t))
+
+	return nil
+}
+
+func (m *NumericDateMap) Marshal(ctx Context, parent *reflect.Value, src reflect.Value) (json.Marshaler, error) {
+	buf := &bytes.Buffer{}
+	err := m.marshalTo(ctx, parent, src, buf)
+	if err != nil {
+		return nil, err
+	}
+	return RawMessage{buf.Bytes()}, nil
+}
+
+func (m *NumericDateMap) marshalTo(ctx Context, parent *reflect.Value, src reflect.Value, buf *bytes.Buffer) error {
+	t, ok := src.Interface().(time.Time)
+	if !ok {
+		panic("source field for jsonmap.NumericDate() is not a time.Time")
+	}
+
+	return marshalValueTo(t.Unix(), buf)
+}
+
+// NumericDate returns a TypeMap for time.Time fields represented as JWT
+// NumericDate values. Fractional seconds are accepted, but times are
+// marshaled as whole seconds.
+func NumericDate() TypeMap {
+	return &NumericDateMap{}
+}
+
+var stringSliceType = reflect.TypeOf([]string(nil))
+
+// audienceMap maps the "aud" claim, which may be either a single string or a
+// list of strings, to a []string.
+type audienceMap struct {
+	list TypeMap
+}
+
+func (m *audienceMap) Unmarshal(ctx Context, parent *reflect.Value, partial interface{}, dstValue reflect.Value) error {
+	if dstValue.Type() != stringSliceType {
+		panic("target field for jsonmap audience is not a []string")
+	}
+
+	if s, ok := partial.(string); ok {
+		partial = []interface{}{s}
+	}
+
+	return m.list.Unmarshal(ctx, parent, partial, dstValue)
+}
+
+func (m *audienceMap) Marshal(ctx Context, parent *reflect.Value, src reflect.Value) (json.Marshaler, error) {
+	buf := &bytes.Buffer{}
+	err := m.marshalTo(ctx, parent, src, buf)
+	if err != nil {
+		return nil, err
+	}
+	return RawMessage{buf.Bytes()}, nil
+}
+
+func (m *audienceMap) marshalTo(ctx Context, parent *reflect.Value, src reflect.Value, buf *bytes.Buffer) error {
+	aud := src.Interface().([]string)
+
+	// A single audience is conventionally given as a string
+	if len(aud) == 1 {
+		return marshalValueTo(aud[0], buf)
+	}
+	return marshalValueTo(aud, buf)
+}
+
+// ClaimsMap returns a StructMap for a JWT claim set, which must be a struct
+// embedding RegisteredClaims. The registered claims are all optional, and are
+// omitted when marshaling if unset. When unmarshaling, the "exp" and "nbf"
+// claims are checked against the current time, allowing for the given leeway
+// to account for clock skew. Custom claims are mapped by the given fields.
+func ClaimsMap(underlying interface{}, leeway time.Duration, custom ...MappedField) StructMap {
+	t := reflect.TypeOf(underlying)
+	if f, ok := t.FieldByName("RegisteredClaims"); !ok || !f.Anonymous || f.Type != reflect.TypeOf(RegisteredClaims{}) {
+		panic("jsonmap.ClaimsMap() requires a struct embedding jsonmap.RegisteredClaims")
+	}
+
+	expiry := &NumericDateMap{
+		check: func(exp time.Time) error {
+			if time.Now().After(exp.Add(leeway)) {
+				return NewValidationError("token has expired")
+			}
+			return nil
+		},
+	}
+
+	notBefore := &NumericDateMap{
+		check: func(nbf time.Time) error {
+			if time.Now().Add(leeway).Before(nbf) {
+				return NewValidationError("token is not yet valid")
+			}
+			return nil
+		},
+	}
+
+	fields := []MappedField{
+		{
+			StructFieldName: "Issuer",
+			JSONFieldName:   "iss",
+			Validator:       String(1, 2048),
+			Optional:        true,
+			OmitEmpty:       true,
+		},
+		{
+			StructFieldName: "Subject",
+			JSONFieldName:   "sub",
+			Validator:       String(1, 2048),
+			Optional:        true,
+			OmitEmpty:       true,
+		},
+		{
+			StructFieldName: "Audience",
+			JSONFieldName:   "aud",
+			Contains:        &audienceMap{list: SliceOf(NewPrimitiveMap(String(1, 2048)))},
+			Optional:        true,
+			OmitEmpty:       true,
+		},
+		{
+			StructFieldName: "ExpiresAt",
+			JSONFieldName:   "exp",
+			Contains:        expiry,
+			Optional:        true,
+			OmitEmpty:       true,
+		},
+		{
+			StructFieldName: "NotBefore",
+			JSONFieldName:   "nbf",
+			Contains:        notBefore,
+			Optional:        true,
+			OmitEmpty:       true,
+		},
+		{
+			StructFieldName: "IssuedAt",
+			JSONFieldName:   "iat",
+			Contains:        NumericDate(),
+			Optional:        true,
+			OmitEmpty:       true,
+		},
+		{
+			StructFieldName: "ID",
+			JSONFieldName:   "jti",
+			Validator:       String(1, 2048),
+			Optional:        true,
+			OmitEmpty:       true,
+		},
+	}
+
+	return StructMap{
+		UnderlyingType: underlying,
+		Fields:         append(fields, custom...),
+	}
+}
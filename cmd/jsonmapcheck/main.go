@@ -0,0 +1,154 @@
+// Command jsonmapcheck validates JSON documents against a TypeMap, printing
+// the path of each validation error. It is intended for checking test
+// fixtures in CI, and for reproducing problems with payloads sent by clients.
+//
+// The TypeMap is either loaded from a schema file (see jsonmap.ParseSchema),
+// or looked up by name in a Go plugin built with -buildmode=plugin against
+// the same version of jsonmap, for example:
+//
+//	jsonmapcheck -schema schema.yaml -type Dog fixtures/*.json
+//	jsonmapcheck -plugin api.so -type DogTypeMap < payload.json
+//
+// With no files, documents are read from stdin, which may contain any number
+// of them in sequence. The exit status is 1 if any document is invalid.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"plugin"
+	"reflect"
+
+	"github.com/russellhaering/jsonmap"
+)
+
+func main() {
+	schemaPath := flag.String("schema", "", "schema file defining the type")
+	pluginPath := flag.String("plugin", "", "Go plugin declaring the TypeMap")
+	typeName := flag.String("type", "", "name of the type in the schema, or of the TypeMap variable in the plugin")
+	flag.Parse()
+
+	if *typeName == "" || (*schemaPath == "") == (*pluginPath == "") {
+		fmt.Fprintln(os.Stderr, "usage: jsonmapcheck (-schema <file> | -plugin <file>) -type <name> [<JSON file>...]")
+		os.Exit(2)
+	}
+
+	var m jsonmap.RegisterableTypeMap
+	var err error
+	if *schemaPath != "" {
+		m, err = loadSchema(*schemaPath, *typeName)
+	} else {
+		m, err = loadPlugin(*pluginPath, *typeName)
+	}
+	if err != nil {
+		fatal(err)
+	}
+
+	tm := jsonmap.NewTypeMapper(m)
+
+	valid := true
+	if flag.NArg() == 0 {
+		valid, err = check(tm, m, "<stdin>", os.Stdin)
+		if err != nil {
+			fatal(err)
+		}
+	}
+
+	for _, name := range flag.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			fatal(err)
+		}
+
+		ok, err := check(tm, m, name, f)
+		f.Close()
+		if err != nil {
+			fatal(err)
+		}
+		valid = valid && ok
+	}
+
+	if !valid {
+		os.Exit(1)
+	}
+}
+
+func loadSchema(path, name string) (jsonmap.RegisterableTypeMap, error) {
+	s, err := jsonmap.LoadSchema(path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	m, ok := s.TypeMap(name)
+	if !ok {
+		return nil, fmt.Errorf("%s: no such type: %s", path, name)
+	}
+	return m, nil
+}
+
+func loadPlugin(path, name string) (jsonmap.RegisterableTypeMap, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	sym, err := p.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Looking up a variable yields a pointer to it
+	m, ok := sym.(jsonmap.RegisterableTypeMap)
+	if !ok {
+		return nil, fmt.Errorf("%s: %s is a %T, not a TypeMap", path, name, sym)
+	}
+	return m, nil
+}
+
+// check validates each document read from r, reporting whether they were
+// all valid. Errors are returned only if r can't be read as JSON.
+func check(tm *jsonmap.TypeMapper, m jsonmap.RegisterableTypeMap, name string, r io.Reader) (bool, error) {
+	dec := json.NewDecoder(r)
+
+	valid := true
+	for i := 1; ; i++ {
+		var doc json.RawMessage
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			return valid, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("%s: document %d: %v", name, i, err)
+		}
+
+		dest := reflect.New(m.GetUnderlyingType()).Interface()
+		err = tm.Unmarshal(jsonmap.EmptyContext, doc, dest)
+		if err == nil {
+			continue
+		}
+		valid = false
+
+		var me *jsonmap.MultiValidationError
+		if !errors.As(err, &me) {
+			fmt.Printf("%s: document %d: %v\n", name, i, err)
+			continue
+		}
+
+		for _, e := range me.Errors() {
+			path := e.Path
+			if path == "" {
+				path = "/"
+			}
+			fmt.Printf("%s: document %d: %s: %s\n", name, i, path, e.Message)
+		}
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "jsonmapcheck:", err)
+	os.Exit(1)
+}
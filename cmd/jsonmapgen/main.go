@@ -0,0 +1,109 @@
+// Command jsonmapgen generates reflection-free marshaling code for StructMaps.
+//
+// StructMaps are only available at runtime, so jsonmapgen writes a small
+// program which imports the package declaring them, and runs it to call
+// jsonmap.GenerateStatic. It is intended to be invoked by go generate from the
+// directory of the package, for example:
+//
+//	//go:generate jsonmapgen -pkg example.com/api -out jsonmap_static.go DogTypeMap CatTypeMap
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"text/template"
+)
+
+var driverTemplate = template.Must(template.New("driver").Parse(`package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+
+	"github.com/russellhaering/jsonmap"
+	target {{ printf "%q" .ImportPath }}
+)
+
+func main() {
+	buf := &bytes.Buffer{}
+	err := jsonmap.GenerateStatic(buf, {{ printf "%q" .PackageName }},
+{{- range .Names }}
+		jsonmap.StaticTarget{Name: {{ printf "%q" . }}, Map: target.{{ . }}},
+{{- end }}
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = ioutil.WriteFile({{ printf "%q" .Out }}, buf.Bytes(), 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+`))
+
+func main() {
+	importPath := flag.String("pkg", "", "import path of the package declaring the StructMaps")
+	packageName := flag.String("name", "", "name of the package declaring the StructMaps (defaults to the last element of -pkg)")
+	out := flag.String("out", "jsonmap_static.go", "file to write generated code to")
+	flag.Parse()
+
+	if *importPath == "" || flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: jsonmapgen -pkg <import path> [-name <package name>] [-out <file>] <StructMap variable>...")
+		os.Exit(2)
+	}
+
+	if *packageName == "" {
+		*packageName = path.Base(*importPath)
+	}
+
+	outPath, err := filepath.Abs(*out)
+	if err != nil {
+		fatal(err)
+	}
+
+	driver := &bytes.Buffer{}
+	err = driverTemplate.Execute(driver, map[string]interface{}{
+		"ImportPath":  *importPath,
+		"PackageName": *packageName,
+		"Names":       flag.Args(),
+		"Out":         outPath,
+	})
+	if err != nil {
+		fatal(err)
+	}
+
+	// The driver must live within the current module in order to resolve
+	// the target package.
+	dir, err := ioutil.TempDir(".", "jsonmapgen")
+	if err != nil {
+		fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	driverPath := filepath.Join(dir, "main.go")
+	err = ioutil.WriteFile(driverPath, driver.Bytes(), 0644)
+	if err != nil {
+		fatal(err)
+	}
+
+	cmd := exec.Command("go", "run", driverPath)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "jsonmapgen:", err)
+	os.Exit(1)
+}
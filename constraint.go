@@ -0,0 +1,208 @@
+package jsonmap
+
+import (
+	"math"
+	"reflect"
+)
+
+// ConstraintKind is the JSON type of the values accepted by a Validator.
+type ConstraintKind string
+
+const (
+	ConstraintString  ConstraintKind = "string"
+	ConstraintInteger ConstraintKind = "integer"
+	ConstraintNumber  ConstraintKind = "number"
+	ConstraintBoolean ConstraintKind = "boolean"
+
+	// ConstraintAny is the kind of validators which accept values of any type
+	ConstraintAny ConstraintKind = "any"
+)
+
+// Constraint describes the values accepted by a Validator, so that they can
+// be documented or exported (for example as a JSON schema) without knowledge
+// of the Validator's type. Fields which don't apply are left as their zero
+// value.
+type Constraint struct {
+	Kind ConstraintKind
+
+	// Min and Max bound the value of numbers, or the length in characters of
+	// strings
+	Min *float64
+	Max *float64
+
+	// Enum lists the values allowed, if only certain values are
+	Enum []interface{}
+
+	// Pattern is a regular expression which strings must match
+	Pattern string
+
+	// Format names the format of strings, such as "uuid"
+	Format string
+}
+
+// ConstraintDescriber is implemented by Validators which can describe the
+// values they accept, as are those in this package which check the type of
+// values. Validators such as ExistsIn(), whose constraints are opaque, don't
+// implement it.
+type ConstraintDescriber interface {
+	Describe() Constraint
+}
+
+// DescribeValidator returns the Constraint describing v, if it implements
+// ConstraintDescriber.
+func DescribeValidator(v Validator) (Constraint, bool) {
+	if d, ok := v.(ConstraintDescriber); ok {
+		return d.Describe(), true
+	}
+	return Constraint{}, false
+}
+
+func bound(f float64) *float64 {
+	return &f
+}
+
+// Describe leaves Max unset for validators which don't limit the length of
+// strings, such as those returned by Regex().
+func (v *StringValidator) Describe() Constraint {
+	c := Constraint{
+		Kind: ConstraintString,
+		Min:  bound(float64(v.MinLen)),
+	}
+	if v.MaxLen != math.MaxInt {
+		c.Max = bound(float64(v.MaxLen))
+	}
+	if v.RE != nil {
+		c.Pattern = v.RE.String()
+	}
+	return c
+}
+
+func (v *BooleanValidator) Describe() Constraint {
+	return Constraint{Kind: ConstraintBoolean}
+}
+
+// Describe describes the values marshaled, rather than the strings which are
+// also accepted.
+func (v *BooleanStringsValidator) Describe() Constraint {
+	return Constraint{Kind: ConstraintBoolean}
+}
+
+func (v *IntegerValidator) Describe() Constraint {
+	return Constraint{
+		Kind: ConstraintInteger,
+		Min:  bound(float64(v.MinVal)),
+		Max:  bound(float64(v.MaxVal)),
+	}
+}
+
+func (v *FloatValidator) Describe() Constraint {
+	return Constraint{
+		Kind: ConstraintNumber,
+		Min:  bound(v.MinVal),
+		Max:  bound(v.MaxVal),
+	}
+}
+
+func (v *LossyUint64Validator) Describe() Constraint {
+	return Constraint{
+		Kind: ConstraintInteger,
+		Min:  bound(float64(v.MinVal)),
+		Max:  bound(float64(v.MaxVal)),
+	}
+}
+
+func (v *InterfaceValidator) Describe() Constraint {
+	return Constraint{Kind: ConstraintAny}
+}
+
+func (v *UUIDStringValidator) Describe() Constraint {
+	return Constraint{
+		Kind:   ConstraintString,
+		Format: "uuid",
+	}
+}
+
+func (v *URLStringValidator) Describe() Constraint {
+	return Constraint{
+		Kind:   ConstraintString,
+		Format: "uri",
+	}
+}
+
+func (v *EnumeratedValuesValidator) Describe() Constraint {
+	enum := make([]interface{}, len(v.AllowedSlice))
+	for i, value := range v.AllowedSlice {
+		enum[i] = value
+	}
+
+	return Constraint{
+		Kind: ConstraintString,
+		Enum: enum,
+	}
+}
+
+// Describe gives the kind of the allowed values, or ConstraintAny if they are
+// of different kinds.
+func (v *EnumeratedJSONValuesValidator) Describe() Constraint {
+	c := Constraint{Enum: append([]interface{}(nil), v.AllowedSlice...)}
+
+	for _, value := range v.AllowedSlice {
+		kind := constraintKindOf(value)
+		if c.Kind != "" && kind != c.Kind {
+			c.Kind = ConstraintAny
+			break
+		}
+		c.Kind = kind
+	}
+	return c
+}
+
+// constraintKindOf returns the kind of the JSON encoding of value.
+func constraintKindOf(value interface{}) ConstraintKind {
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.String:
+		return ConstraintString
+	case reflect.Bool:
+		return ConstraintBoolean
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return ConstraintInteger
+	case reflect.Float32, reflect.Float64:
+		return ConstraintNumber
+	}
+	return ConstraintAny
+}
+
+// Describe combines the constraints of each of the validators which
+// implements ConstraintDescriber, such that values must satisfy them all: the
+// narrowest bounds are taken, and the kind, allowed values, pattern and
+// format of the last validator to give them.
+func (v *AllOfValidator) Describe() Constraint {
+	var c Constraint
+	for _, validator := range v.Validators {
+		d, ok := DescribeValidator(validator)
+		if !ok {
+			continue
+		}
+
+		if d.Kind != "" && (c.Kind == "" || d.Kind != ConstraintAny) {
+			c.Kind = d.Kind
+		}
+		if d.Min != nil && (c.Min == nil || *d.Min > *c.Min) {
+			c.Min = d.Min
+		}
+		if d.Max != nil && (c.Max == nil || *d.Max < *c.Max) {
+			c.Max = d.Max
+		}
+		if d.Enum != nil {
+			c.Enum = d.Enum
+		}
+		if d.Pattern != "" {
+			c.Pattern = d.Pattern
+		}
+		if d.Format != "" {
+			c.Format = d.Format
+		}
+	}
+	return c
+}
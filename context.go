@@ -0,0 +1,250 @@
+package jsonmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strconv"
+)
+
+// Ctx is a Context which carries key/value pairs, in the style of
+// context.Context. Each call to With returns a child Ctx, leaving its parent
+// untouched, so a Ctx may be safely derived for a nested TypeMap without
+// affecting its siblings. Any other Context may be wrapped by a Ctx, and
+// remains available as its Base.
+type Ctx struct {
+	base   Context
+	parent *Ctx
+	key    interface{}
+	value  interface{}
+}
+
+// NewCtx returns a Ctx wrapping base. If base is already a Ctx it is returned
+// as-is.
+func NewCtx(base Context) *Ctx {
+	if c, ok := base.(*Ctx); ok {
+		return c
+	}
+	return &Ctx{base: base}
+}
+
+// Base returns the Context originally wrapped by NewCtx.
+func (c *Ctx) Base() Context {
+	return c.base
+}
+
+// With returns a child of c in which key is associated with value.
+func (c *Ctx) With(key, value interface{}) *Ctx {
+	return &Ctx{
+		base:   c.base,
+		parent: c,
+		key:    key,
+		value:  value,
+	}
+}
+
+// Get returns the value most recently associated with key, and whether there
+// was one.
+func (c *Ctx) Get(key interface{}) (interface{}, bool) {
+	for n := c; n.parent != nil; n = n.parent {
+		if n.key == key {
+			return n.value, true
+		}
+	}
+	return nil, false
+}
+
+// Value returns the value associated with key, or nil. It is convenient for
+// use from templates: {{ .Context.Value "user" }}
+func (c *Ctx) Value(key interface{}) interface{} {
+	v, _ := c.Get(key)
+	return v
+}
+
+// Lookup stores the value associated with key in the value pointed to by dst,
+// reporting whether there was a value of a suitable type to store.
+func (c *Ctx) Lookup(key interface{}, dst interface{}) bool {
+	v, ok := c.Get(key)
+	if !ok || v == nil {
+		return false
+	}
+
+	dstValue := reflect.ValueOf(dst)
+	if dstValue.Kind() != reflect.Ptr || dstValue.IsNil() {
+		panic("Lookup requires a non-nil pointer")
+	}
+
+	val := reflect.ValueOf(v)
+	if !val.Type().AssignableTo(dstValue.Elem().Type()) {
+		return false
+	}
+
+	dstValue.Elem().Set(val)
+	return true
+}
+
+type parentContextKey struct{}
+
+// ParentOf returns the parent object injected into ctx by WithParentContext.
+func ParentOf(ctx Context) (interface{}, bool) {
+	c, ok := ctx.(*Ctx)
+	if !ok {
+		return nil, false
+	}
+	return c.Get(parentContextKey{})
+}
+
+type childContextMap struct {
+	Contains TypeMap
+	derive   func(ctx *Ctx, parent interface{}) *Ctx
+}
+
+func (cm *childContextMap) childContext(ctx Context, parent *reflect.Value) Context {
+	var p interface{}
+	if parent != nil && parent.IsValid() {
+		if parent.CanAddr() {
+			p = parent.Addr().Interface()
+		} else {
+			p = parent.Interface()
+		}
+	}
+	return cm.derive(NewCtx(ctx), p)
+}
+
+func (cm *childContextMap) Unmarshal(ctx Context, parent *reflect.Value, partial interface{}, dstValue reflect.Value) error {
+	return cm.Contains.Unmarshal(cm.childContext(ctx, parent), parent, partial, dstValue)
+}
+
+func (cm *childContextMap) unmarshalStream(ctx Context, parent *reflect.Value, ts *tokenStream, dstValue reflect.Value) error {
+	return unmarshalStream(cm.childContext(ctx, parent), cm.Contains, parent, ts, dstValue)
+}
+
+func (cm *childContextMap) Marshal(ctx Context, parent *reflect.Value, src reflect.Value) (json.Marshaler, error) {
+	return cm.Contains.Marshal(cm.childContext(ctx, parent), parent, src)
+}
+
+func (cm *childContextMap) marshalTo(ctx Context, parent *reflect.Value, src reflect.Value, buf *bytes.Buffer) error {
+	return marshalTo(cm.childContext(ctx, parent), cm.Contains, parent, src, buf)
+}
+
+// WithContext wraps a TypeMap such that it, and any TypeMaps nested within
+// it, receive a Ctx derived by the given function. The function is passed the
+// object containing the field (a pointer to it where possible), and the
+// current Context wrapped in a Ctx.
+func WithContext(tm TypeMap, derive func(ctx *Ctx, parent interface{}) *Ctx) TypeMap {
+	return &childContextMap{
+		Contains: tm,
+		derive:   derive,
+	}
+}
+
+// WithParentContext wraps a TypeMap such that the object containing the field
+// is available to it, and to any TypeMaps nested within it, via ParentOf.
+func WithParentContext(tm TypeMap) TypeMap {
+	return WithContext(tm, func(ctx *Ctx, parent interface{}) *Ctx {
+		return ctx.With(parentContextKey{}, parent)
+	})
+}
+
+type versionContextKey struct{}
+
+// WithVersion returns a Ctx specifying the API version to marshal or
+// unmarshal, which determines the fields that are active according to their
+// SinceVersion and UntilVersion.
+func WithVersion(ctx Context, version int) *Ctx {
+	return NewCtx(ctx).With(versionContextKey{}, version)
+}
+
+// VersionOf returns the API version specified by WithVersion, if any.
+func VersionOf(ctx Context) (int, bool) {
+	c, ok := ctx.(*Ctx)
+	if !ok {
+		return 0, false
+	}
+
+	v, ok := c.Get(versionContextKey{})
+	if !ok {
+		return 0, false
+	}
+	return v.(int), true
+}
+
+// activeFor reports whether the field applies to the API version specified by
+// ctx. When no version is specified the latest applies, so that only fields
+// without an UntilVersion are active, and fields replaced in later versions
+// don't collide with their replacements.
+func (f MappedField) activeFor(ctx Context) bool {
+	if f.SinceVersion == 0 && f.UntilVersion == 0 {
+		return true
+	}
+
+	version, ok := VersionOf(ctx)
+	if !ok {
+		return f.UntilVersion == 0
+	}
+
+	return (f.SinceVersion == 0 || version >= f.SinceVersion) &&
+		(f.UntilVersion == 0 || version <= f.UntilVersion)
+}
+
+// checkVersions panics if two fields of sm share a JSON name in any API
+// version, in which case both would be marshaled and unmarshaled.
+func checkVersions(sm StructMap) {
+	for i, a := range sm.Fields {
+		for _, b := range sm.Fields[i+1:] {
+			if a.JSONFieldName == b.JSONFieldName && a.overlaps(b) {
+				panic("fields share JSON name in overlapping versions: " + a.JSONFieldName)
+			}
+		}
+	}
+}
+
+// overlaps reports whether the ranges of versions for which f and o are
+// active intersect.
+func (f MappedField) overlaps(o MappedField) bool {
+	return (f.UntilVersion == 0 || o.SinceVersion <= f.UntilVersion) &&
+		(o.UntilVersion == 0 || f.SinceVersion <= o.UntilVersion)
+}
+
+type pathContextKey struct{}
+
+// recordsPath reports whether anything in ctx needs to know the path of the
+// value being unmarshaled or marshaled, which is otherwise not worth keeping
+// track of.
+func recordsPath(ctx Context) bool {
+	return batchOf(ctx) != nil || traceOf(ctx) != nil || validatesOnMarshal(ctx)
+}
+
+// pathOf returns the path to the value being unmarshaled or marshaled, if
+// recorded.
+func pathOf(ctx Context) []string {
+	c, ok := ctx.(*Ctx)
+	if !ok {
+		return nil
+	}
+
+	path, _ := c.Value(pathContextKey{}).([]string)
+	return path
+}
+
+// pathContext returns the Context for the member of the current value with
+// the given key, which records its path if necessary.
+func pathContext(ctx Context, key string) Context {
+	if !recordsPath(ctx) {
+		return ctx
+	}
+
+	parent := pathOf(ctx)
+	path := make([]string, len(parent), len(parent)+1)
+	copy(path, parent)
+	return ctx.(*Ctx).With(pathContextKey{}, append(path, key))
+}
+
+// elementContext is pathContext for the element of a slice at the given
+// index.
+func elementContext(ctx Context, i int) Context {
+	if !recordsPath(ctx) {
+		return ctx
+	}
+	return pathContext(ctx, strconv.Itoa(i))
+}
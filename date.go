@@ -0,0 +1,95 @@
+package jsonmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+// CivilDate is a date without a time of day or location, which may be used
+// in place of a time.Time as the target of a Date() field.
+type CivilDate struct {
+	Year  int
+	Month time.Month
+	Day   int
+}
+
+// String returns the date in the form YYYY-MM-DD.
+func (d CivilDate) String() string {
+	return fmt.Sprintf("%04d-%02d-%02d", d.Year, d.Month, d.Day)
+}
+
+// In returns the time at the start of the date in the given location.
+func (d CivilDate) In(loc *time.Location) time.Time {
+	return time.Date(d.Year, d.Month, d.Day, 0, 0, 0, 0, loc)
+}
+
+type DateMap struct{}
+
+func (m *DateMap) Unmarshal(ctx Context, parent *reflect.Value, partial interface{}, dstValue reflect.Value) error {
+	switch dstValue.Interface().(type) {
+	case time.Time, CivilDate:
+	default:
+		panic("target field for jsonmap.Date() is not a time.Time or jsonmap.CivilDate")
+	}
+
+	dstring, ok := partial.(string)
+
+	if !ok {
+		return NewValidationError("not a string")
+	}
+
+	t, err := time.Parse(dateLayout, dstring)
+
+	if err != nil {
+		l, ok := localeOf(ctx)
+		if !ok {
+			return NewValidationError("not a valid date, expected YYYY-MM-DD")
+		}
+
+		t, ok = l.parseDate(dstring)
+		if !ok {
+			return NewValidationError("not a valid date, expected YYYY-MM-DD")
+		}
+	}
+
+	if _, ok := dstValue.Interface().(CivilDate); ok {
+		dstValue.Set(reflect.ValueOf(CivilDate{t.Year(), t.Month(), t.Day()}))
+	} else {
+		dstValue.Set(reflect.ValueOf(t))
+	}
+
+	return nil
+}
+
+func (m *DateMap) Marshal(ctx Context, parent *reflect.Value, src reflect.Value) (json.Marshaler, error) {
+	buf := &bytes.Buffer{}
+	err := m.marshalTo(ctx, parent, src, buf)
+	if err != nil {
+		return nil, err
+	}
+	return RawMessage{buf.Bytes()}, nil
+}
+
+func (m *DateMap) marshalTo(ctx Context, parent *reflect.Value, src reflect.Value, buf *bytes.Buffer) error {
+	switch v := src.Interface().(type) {
+	case time.Time:
+		return marshalValueTo(v.Format(dateLayout), buf)
+	case CivilDate:
+		return marshalValueTo(v.String(), buf)
+	default:
+		panic("source field for jsonmap.Date() is not a time.Time or jsonmap.CivilDate")
+	}
+}
+
+// Date returns a TypeMap for dates of the form YYYY-MM-DD, which rejects
+// values with a time component. The target field may be a time.Time, which
+// receives midnight UTC on the date, or a CivilDate. When marshaling a
+// time.Time, its time of day is ignored.
+func Date() TypeMap {
+	return &DateMap{}
+}
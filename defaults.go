@@ -0,0 +1,68 @@
+package jsonmap
+
+import (
+	"reflect"
+)
+
+// Defaulter may be implemented by mapped types in order to set default values
+// for their fields. It is called on each new or zero struct mapped by a
+// StructMap before any of its fields are unmarshaled, so that fields absent
+// from the document keep their defaults, and on structs initialized because
+// of InitIfAbsent. Under MergeReset, absent fields of populated structs are
+// likewise reset to their defaults.
+type Defaulter interface {
+	SetDefaults(ctx Context)
+}
+
+var defaulterType = reflect.TypeOf((*Defaulter)(nil)).Elem()
+
+// applyDefaults calls the Defaulter of dstValue, if it implements one.
+func applyDefaults(ctx Context, dstValue reflect.Value) {
+	if !dstValue.CanAddr() || !dstValue.IsZero() {
+		return
+	}
+
+	if d, ok := dstValue.Addr().Interface().(Defaulter); ok {
+		d.SetDefaults(ctx)
+	}
+}
+
+// initAbsent initializes the nil pointer dstField of a field marked
+// InitIfAbsent which was absent from the document, along with any fields of
+// its own which are marked InitIfAbsent. initializing holds the types being
+// initialized, so that recursive types don't recurse forever.
+func initAbsent(ctx Context, field MappedField, dstField reflect.Value, initializing map[reflect.Type]bool) {
+	if dstField.Kind() != reflect.Ptr || !dstField.IsNil() {
+		return
+	}
+
+	t := dstField.Type().Elem()
+	if initializing[t] {
+		return
+	}
+	initializing[t] = true
+	defer delete(initializing, t)
+
+	dstField.Set(reflect.New(t))
+	dstValue := dstField.Elem()
+
+	fieldCtx := pathContext(ctx, field.JSONFieldName)
+	applyDefaults(fieldCtx, dstValue)
+
+	var sm StructMap
+	switch m := field.Contains.(type) {
+	case StructMap:
+		sm = m
+	case *StructMap:
+		sm = *m
+	default:
+		return
+	}
+
+	for _, nested := range sm.Fields {
+		if !nested.InitIfAbsent || nested.ReadOnly || !nested.activeFor(fieldCtx) {
+			continue
+		}
+		initAbsent(fieldCtx, nested, fieldByName(dstValue, nested.StructFieldName), initializing)
+	}
+}
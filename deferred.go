@@ -0,0 +1,113 @@
+package jsonmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+)
+
+// DeferredValue holds the raw JSON for a field mapped with Deferred(), which
+// is only validated and decoded when Decode() is called.
+type DeferredValue struct {
+	raw     json.RawMessage
+	ctx     Context
+	typeMap TypeMap
+}
+
+// IsSet reports whether a non-null value was supplied for the field.
+func (d DeferredValue) IsSet() bool {
+	return len(d.raw) != 0
+}
+
+// Raw returns the unvalidated JSON supplied for the field.
+func (d DeferredValue) Raw() json.RawMessage {
+	return d.raw
+}
+
+// Decode validates the deferred value into dst, which must be a pointer to
+// the type expected by the wrapped TypeMap. Paths in any validation errors are
+// relative to the deferred field. The Context used is the one originally
+// passed to Unmarshal.
+func (d DeferredValue) Decode(dst interface{}) error {
+	if reflect.TypeOf(dst).Kind() != reflect.Ptr || dst == nil {
+		panic("cannot decode to non-pointer")
+	}
+
+	if !d.IsSet() {
+		return NewValidationError("no value supplied")
+	}
+
+	ts := newTokenStream(bytes.NewReader(d.raw), false)
+	err := unmarshalStream(d.ctx, d.typeMap, nil, ts, reflect.ValueOf(dst).Elem())
+	if ts.err != nil {
+		return wrapStreamError(ts.err)
+	}
+
+	if e, ok := err.(*ValidationError); ok {
+		return e.Flatten()
+	}
+	return err
+}
+
+var deferredValueType = reflect.TypeOf(DeferredValue{})
+
+// DeferredMap captures the raw JSON of a field at unmarshal time. See
+// Deferred().
+type DeferredMap struct {
+	Contains TypeMap
+}
+
+func (dm *DeferredMap) set(ctx Context, dstValue reflect.Value, raw json.RawMessage) {
+	if dstValue.Type() != deferredValueType {
+		panic("target field for jsonmap.Deferred() is not a jsonmap.DeferredValue")
+	}
+
+	if bytes.Equal(raw, nullJSONValue) {
+		raw = nil
+	}
+
+	dstValue.Set(reflect.ValueOf(DeferredValue{
+		raw:     raw,
+		ctx:     ctx,
+		typeMap: dm.Contains,
+	}))
+}
+
+func (dm *DeferredMap) Unmarshal(ctx Context, parent *reflect.Value, partial interface{}, dstValue reflect.Value) error {
+	raw, err := json.Marshal(partial)
+	if err != nil {
+		return err
+	}
+
+	dm.set(ctx, dstValue, raw)
+	return nil
+}
+
+func (dm *DeferredMap) unmarshalStream(ctx Context, parent *reflect.Value, ts *tokenStream, dstValue reflect.Value) error {
+	raw, err := ts.readRaw()
+	if err != nil {
+		return err
+	}
+
+	dm.set(ctx, dstValue, raw)
+	return nil
+}
+
+func (dm *DeferredMap) Marshal(ctx Context, parent *reflect.Value, src reflect.Value) (json.Marshaler, error) {
+	d := src.Interface().(DeferredValue)
+	if !d.IsSet() {
+		return nullRawMessage, nil
+	}
+	return RawMessage{d.raw}, nil
+}
+
+// Deferred wraps a TypeMap such that the field's raw JSON is captured at
+// unmarshal time, but only validated and decoded if and when the caller asks
+// for it by calling DeferredValue.Decode(). The underlying field must be a
+// DeferredValue. This allows endpoints which only conditionally process a
+// large sub-document to avoid paying for it up front.
+func Deferred(tm TypeMap) TypeMap {
+	return &DeferredMap{
+		Contains: tm,
+	}
+}
@@ -0,0 +1,173 @@
+package jsonmap
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/rnd42/go-jsonpointer"
+)
+
+// PatchOperation is a single JSON Patch (RFC 6902) operation.
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// ChangeSet describes the differences between two values, in terms of their
+// mapped JSON representations.
+type ChangeSet struct {
+	// Paths holds a JSON pointer to each changed value
+	Paths []string
+
+	// Operations is a JSON Patch which transforms the old value into the new
+	// one
+	Operations []PatchOperation
+}
+
+// JSONPatch returns the ChangeSet as a JSON Patch document.
+func (cs *ChangeSet) JSONPatch() ([]byte, error) {
+	if cs.Operations == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(cs.Operations)
+}
+
+func (cs *ChangeSet) add(op string, path []string, value interface{}) error {
+	pointer := jsonpointer.NewJSONPointerFromTokens(&path).String()
+
+	var raw json.RawMessage
+	if op != "remove" {
+		var err error
+		raw, err = json.Marshal(value)
+		if err != nil {
+			return err
+		}
+	}
+
+	cs.Paths = append(cs.Paths, pointer)
+	cs.Operations = append(cs.Operations, PatchOperation{
+		Op:    op,
+		Path:  pointer,
+		Value: raw,
+	})
+	return nil
+}
+
+// Diff compares two instances of a registered type, returning the changes
+// between them. Because both values are marshaled using their TypeMaps, only
+// mapped fields are compared and differences are reported in terms of JSON
+// field names.
+func (tm *TypeMapper) Diff(ctx Context, old, new interface{}) (*ChangeSet, error) {
+	oldPartial, err := tm.marshalPartial(ctx, old)
+	if err != nil {
+		return nil, err
+	}
+
+	newPartial, err := tm.marshalPartial(ctx, new)
+	if err != nil {
+		return nil, err
+	}
+
+	cs := &ChangeSet{}
+	err = cs.diff([]string{}, oldPartial, newPartial)
+	if err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+// marshalPartial marshals src and decodes the result, producing the generic
+// representation that TypeMaps unmarshal from.
+func (tm *TypeMapper) marshalPartial(ctx Context, src interface{}) (interface{}, error) {
+	data, err := tm.Marshal(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+
+	var partial interface{}
+	err = json.Unmarshal(data, &partial)
+	if err != nil {
+		return nil, err
+	}
+	return partial, nil
+}
+
+func (cs *ChangeSet) diff(path []string, old, new interface{}) error {
+	switch o := old.(type) {
+	case map[string]interface{}:
+		n, ok := new.(map[string]interface{})
+		if !ok {
+			break
+		}
+
+		keys := make([]string, 0, len(o)+len(n))
+		for key := range o {
+			keys = append(keys, key)
+		}
+		for key := range n {
+			if _, ok := o[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			keyPath := append(path[:len(path):len(path)], key)
+			oldVal, inOld := o[key]
+			newVal, inNew := n[key]
+
+			var err error
+			switch {
+			case !inNew:
+				err = cs.add("remove", keyPath, nil)
+			case !inOld:
+				err = cs.add("add", keyPath, newVal)
+			default:
+				err = cs.diff(keyPath, oldVal, newVal)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case []interface{}:
+		n, ok := new.([]interface{})
+		if !ok {
+			break
+		}
+
+		for i := 0; i < len(o) && i < len(n); i++ {
+			err := cs.diff(append(path[:len(path):len(path)], strconv.Itoa(i)), o[i], n[i])
+			if err != nil {
+				return err
+			}
+		}
+
+		for i := len(o); i < len(n); i++ {
+			err := cs.add("add", append(path[:len(path):len(path)], strconv.Itoa(i)), n[i])
+			if err != nil {
+				return err
+			}
+		}
+
+		// Remove from the end so that each index remains valid as the patch
+		// is applied
+		for i := len(o) - 1; i >= len(n); i-- {
+			err := cs.add("remove", append(path[:len(path):len(path)], strconv.Itoa(i)), nil)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if reflect.DeepEqual(old, new) {
+		return nil
+	}
+
+	return cs.add("replace", path, new)
+}
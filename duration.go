@@ -0,0 +1,104 @@
+package jsonmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"time"
+)
+
+// DurationFormat is the representation in which a Duration() field is
+// marshaled.
+type DurationFormat int
+
+const (
+	// DurationString marshals durations as strings such as "1h30m", in the
+	// format accepted by time.ParseDuration.
+	DurationString DurationFormat = iota
+
+	// DurationSeconds marshals durations as a (possibly fractional) number of
+	// seconds.
+	DurationSeconds
+
+	// DurationMilliseconds marshals durations as a (possibly fractional)
+	// number of milliseconds.
+	DurationMilliseconds
+)
+
+type DurationMap struct {
+	Format DurationFormat
+}
+
+// unit returns the duration represented by a numeric value of 1.
+func (m *DurationMap) unit() time.Duration {
+	if m.Format == DurationMilliseconds {
+		return time.Millisecond
+	}
+	return time.Second
+}
+
+func (m *DurationMap) Unmarshal(ctx Context, parent *reflect.Value, partial interface{}, dstValue reflect.Value) error {
+	if _, ok := dstValue.Interface().(time.Duration); !ok {
+		panic("target field for jsonmap.Duration() is not a time.Duration")
+	}
+
+	var d time.Duration
+
+	switch v := partial.(type) {
+	case string:
+		var err error
+		d, err = time.ParseDuration(v)
+		if err != nil {
+			return NewValidationError("not a valid duration")
+		}
+	case float64:
+		f := v * float64(m.unit())
+		// float64(math.MaxInt64) rounds up to 2^63, which is itself out of range
+		if f >= math.MaxInt64 || f < math.MinInt64 {
+			return NewValidationError("duration out of range")
+		}
+		d = time.Duration(f)
+	default:
+		return NewValidationError("not a string or number")
+	}
+
+	dstValue.Set(reflect.ValueOf(d))
+
+	return nil
+}
+
+func (m *DurationMap) Marshal(ctx Context, parent *reflect.Value, src reflect.Value) (json.Marshaler, error) {
+	buf := &bytes.Buffer{}
+	err := m.marshalTo(ctx, parent, src, buf)
+	if err != nil {
+		return nil, err
+	}
+	return RawMessage{buf.Bytes()}, nil
+}
+
+func (m *DurationMap) marshalTo(ctx Context, parent *reflect.Value, src reflect.Value, buf *bytes.Buffer) error {
+	d, ok := src.Interface().(time.Duration)
+	if !ok {
+		panic("source field for jsonmap.Duration() is not a time.Duration")
+	}
+
+	if isProtoJSON(ctx) {
+		return marshalValueTo(protoDuration(d), buf)
+	}
+
+	if m.Format == DurationString {
+		return marshalValueTo(d.String(), buf)
+	}
+	return marshalValueTo(float64(d)/float64(m.unit()), buf)
+}
+
+// Duration returns a TypeMap for time.Duration fields, which accepts either a
+// duration string such as "1h30m", or a number of seconds (or milliseconds,
+// when using DurationMilliseconds), and marshals durations in the given
+// format.
+func Duration(format DurationFormat) TypeMap {
+	return &DurationMap{
+		Format: format,
+	}
+}
@@ -0,0 +1,181 @@
+package jsonmap
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"reflect"
+)
+
+// CipherProvider encrypts and decrypts the serialized values of fields mapped
+// with Encrypted(). The Context passed to Marshal or Unmarshal is made
+// available so that keys may be chosen per tenant, request, etc. Decrypt
+// should return a ValidationError if the ciphertext is not valid, and other
+// errors only where decryption could not be attempted.
+type CipherProvider interface {
+	Encrypt(ctx Context, plaintext []byte) ([]byte, error)
+	Decrypt(ctx Context, ciphertext []byte) ([]byte, error)
+}
+
+type aesGCMProvider struct {
+	key func(ctx Context) ([]byte, error)
+}
+
+func (p *aesGCMProvider) aead(ctx Context) (cipher.AEAD, error) {
+	key, err := p.key(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func (p *aesGCMProvider) Encrypt(ctx Context, plaintext []byte) ([]byte, error) {
+	aead, err := p.aead(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (p *aesGCMProvider) Decrypt(ctx Context, ciphertext []byte) ([]byte, error) {
+	aead, err := p.aead(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, NewValidationError("could not be decrypted")
+	}
+
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, NewValidationError("could not be decrypted")
+	}
+
+	return plaintext, nil
+}
+
+// AESGCM returns a CipherProvider which uses AES-GCM with a random nonce,
+// which is prepended to the ciphertext. The key, which must be 16, 24 or 32
+// bytes long, is obtained by calling the given function with the Context,
+// such as to look it up in a Ctx.
+func AESGCM(key func(ctx Context) ([]byte, error)) CipherProvider {
+	return &aesGCMProvider{
+		key: key,
+	}
+}
+
+// EncryptedMap encrypts the serialized value of a field. See Encrypted().
+type EncryptedMap struct {
+	Contains TypeMap
+	Cipher   CipherProvider
+}
+
+func (em *EncryptedMap) Unmarshal(ctx Context, parent *reflect.Value, partial interface{}, dstValue reflect.Value) error {
+	plaintext, err := em.decrypt(ctx, partial)
+	if err != nil {
+		return err
+	}
+
+	var val interface{}
+	err = json.Unmarshal(plaintext, &val)
+	if err != nil {
+		return NewValidationError("could not be decrypted")
+	}
+
+	return em.Contains.Unmarshal(ctx, parent, val, dstValue)
+}
+
+// unmarshalStream streams the decrypted value to the wrapped TypeMap, so that
+// TypeMaps such as OrderedMapOf see it as it was written.
+func (em *EncryptedMap) unmarshalStream(ctx Context, parent *reflect.Value, ts *tokenStream, dstValue reflect.Value) error {
+	val, err := ts.readValue()
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := em.decrypt(ctx, val)
+	if err != nil {
+		return err
+	}
+
+	if !json.Valid(plaintext) {
+		return NewValidationError("could not be decrypted")
+	}
+
+	pts := newTokenStream(bytes.NewReader(plaintext), ts.failFast)
+	err = unmarshalStream(ctx, em.Contains, parent, pts, dstValue)
+	if pts.err != nil {
+		return NewValidationError("could not be decrypted")
+	}
+	return err
+}
+
+// decrypt returns the plaintext of the encrypted value partial.
+func (em *EncryptedMap) decrypt(ctx Context, partial interface{}) ([]byte, error) {
+	s, ok := partial.(string)
+	if !ok {
+		return nil, NewValidationError("expected an encrypted value")
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, NewValidationError("expected an encrypted value")
+	}
+
+	return em.Cipher.Decrypt(ctx, ciphertext)
+}
+
+func (em *EncryptedMap) Marshal(ctx Context, parent *reflect.Value, src reflect.Value) (json.Marshaler, error) {
+	buf := &bytes.Buffer{}
+	err := em.marshalTo(ctx, parent, src, buf)
+	if err != nil {
+		return nil, err
+	}
+	return RawMessage{buf.Bytes()}, nil
+}
+
+func (em *EncryptedMap) marshalTo(ctx Context, parent *reflect.Value, src reflect.Value, buf *bytes.Buffer) error {
+	plaintext := &bytes.Buffer{}
+	err := marshalTo(ctx, em.Contains, parent, src, plaintext)
+	if err != nil {
+		return err
+	}
+
+	ciphertext, err := em.Cipher.Encrypt(ctx, plaintext.Bytes())
+	if err != nil {
+		return err
+	}
+
+	return marshalValueTo(base64.StdEncoding.EncodeToString(ciphertext), buf)
+}
+
+// Encrypted wraps a TypeMap such that the field's value is marshaled as
+// usual, then encrypted using the given CipherProvider and represented as a
+// base64 encoded string. On unmarshal the value is decrypted and then
+// validated by the wrapped TypeMap. This is intended for PII and other
+// sensitive fields in documents which are persisted as JSON.
+func Encrypted(tm TypeMap, cipher CipherProvider) TypeMap {
+	return &EncryptedMap{
+		Contains: tm,
+		Cipher:   cipher,
+	}
+}
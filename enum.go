@@ -0,0 +1,98 @@
+package jsonmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// EnumMap maps string names on the wire to integer constants in an int-typed
+// struct field.
+type EnumMap struct {
+	values    map[string]int
+	names     map[int64]string
+	validator Validator
+}
+
+func isIntKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	}
+	return false
+}
+
+func (m *EnumMap) Unmarshal(ctx Context, parent *reflect.Value, partial interface{}, dstValue reflect.Value) error {
+	if !isIntKind(dstValue.Kind()) {
+		panic("target field for jsonmap.Enum() is not an integer: " + dstValue.Type().String())
+	}
+
+	// protojson also accepts the numbers of enum values
+	if f, ok := partial.(float64); ok && isProtoJSON(ctx) {
+		if name, ok := m.names[int64(f)]; ok && float64(int64(f)) == f {
+			partial = name
+		}
+	}
+
+	name, err := m.validator.Validate(partial)
+	if err != nil {
+		return err
+	}
+
+	value := int64(m.values[name.(string)])
+	if dstValue.OverflowInt(value) {
+		return intRangeError(dstValue.Type(), value < 0)
+	}
+	dstValue.SetInt(value)
+
+	return nil
+}
+
+func (m *EnumMap) Marshal(ctx Context, parent *reflect.Value, src reflect.Value) (json.Marshaler, error) {
+	buf := &bytes.Buffer{}
+	err := m.marshalTo(ctx, parent, src, buf)
+	if err != nil {
+		return nil, err
+	}
+	return RawMessage{buf.Bytes()}, nil
+}
+
+func (m *EnumMap) marshalTo(ctx Context, parent *reflect.Value, src reflect.Value, buf *bytes.Buffer) error {
+	if !isIntKind(src.Kind()) {
+		panic("source field for jsonmap.Enum() is not an integer: " + src.Type().String())
+	}
+
+	name, ok := m.names[src.Int()]
+	if !ok {
+		return fmt.Errorf("no name for enum value: %d", src.Int())
+	}
+	return marshalValueTo(name, buf)
+}
+
+// Enum returns a TypeMap for integer fields, typically of a named type with
+// constants declared using iota, which are represented on the wire by the
+// names given in values. Each value must have a single name.
+func Enum(values map[string]int) TypeMap {
+	m := &EnumMap{
+		values: values,
+		names:  map[int64]string{},
+	}
+
+	names := make([]string, 0, len(values))
+	for name, value := range values {
+		if existing, ok := m.names[int64(value)]; ok {
+			panic(fmt.Sprintf("enum value %d has multiple names: %s, %s", value, existing, name))
+		}
+		m.names[int64(value)] = name
+		names = append(names, name)
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		return values[names[i]] < values[names[j]]
+	})
+	m.validator = OneOf(names...)
+
+	return m
+}
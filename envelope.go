@@ -0,0 +1,41 @@
+package jsonmap
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// MarshalEnveloped marshals v wrapped in an envelope of the form
+// {"data": ..., "meta": ...}, as is common for list endpoints. meta is
+// marshaled using the TypeMap registered for its type, such as a StructMap
+// describing pagination info, or with encoding/json if there is none. It is
+// omitted from the envelope if nil.
+func (tm *TypeMapper) MarshalEnveloped(ctx Context, v interface{}, meta interface{}) ([]byte, error) {
+	data, err := tm.Marshal(ctx, v)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(`{"data":`)
+	buf.Write(data)
+
+	if meta != nil {
+		if _, ok := tm.lookupTypeMap(meta); ok {
+			data, err = tm.Marshal(ctx, meta)
+		} else {
+			data, err = json.Marshal(meta)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		buf.WriteString(`,"meta":`)
+		buf.Write(data)
+	}
+
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,208 @@
+package jsonmap
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ETag is an entity tag, as used by the ETag, If-Match and If-None-Match
+// headers.
+type ETag struct {
+	Tag  string
+	Weak bool
+}
+
+func (e ETag) String() string {
+	if e.Weak {
+		return `W/"` + e.Tag + `"`
+	}
+	return `"` + e.Tag + `"`
+}
+
+// StrongMatch reports whether e and other match using the strong comparison
+// of RFC 7232, under which both must be strong and their tags equal.
+func (e ETag) StrongMatch(other ETag) bool {
+	return !e.Weak && !other.Weak && e.Tag == other.Tag
+}
+
+// WeakMatch reports whether e and other match using the weak comparison of
+// RFC 7232, under which only their tags must be equal.
+func (e ETag) WeakMatch(other ETag) bool {
+	return e.Tag == other.Tag
+}
+
+// ETagList is the list of entity tags in an If-Match or If-None-Match header.
+// A header of "*" matches any current representation, and is held as Any.
+type ETagList struct {
+	Tags []ETag
+	Any  bool
+}
+
+// IsZero reports whether the header was absent.
+func (l ETagList) IsZero() bool {
+	return !l.Any && len(l.Tags) == 0
+}
+
+// StrongMatch reports whether the list matches the current entity tag using
+// strong comparison, as is required for If-Match.
+func (l ETagList) StrongMatch(current ETag) bool {
+	if l.Any {
+		return true
+	}
+
+	for _, tag := range l.Tags {
+		if tag.StrongMatch(current) {
+			return true
+		}
+	}
+	return false
+}
+
+// WeakMatch reports whether the list matches the current entity tag using
+// weak comparison, as is required for If-None-Match.
+func (l ETagList) WeakMatch(current ETag) bool {
+	if l.Any {
+		return true
+	}
+
+	for _, tag := range l.Tags {
+		if tag.WeakMatch(current) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseETag parses a single entity tag from the start of s, returning the
+// remainder of s.
+func parseETag(s string) (ETag, string, error) {
+	tag := ETag{}
+	if strings.HasPrefix(s, "W/") {
+		tag.Weak = true
+		s = s[2:]
+	}
+
+	if !strings.HasPrefix(s, `"`) {
+		return ETag{}, "", NewValidationError("entity tag must be quoted")
+	}
+
+	end := strings.IndexByte(s[1:], '"')
+	if end < 0 {
+		return ETag{}, "", NewValidationError("unterminated entity tag")
+	}
+
+	tag.Tag = s[1 : end+1]
+	for _, c := range tag.Tag {
+		if c <= ' ' || c == 0x7f {
+			return ETag{}, "", NewValidationError("invalid character in entity tag")
+		}
+	}
+
+	return tag, s[end+2:], nil
+}
+
+// ETagQueryParameterMapper maps an ETag header to an ETag field. A missing
+// header leaves the field zero.
+type ETagQueryParameterMapper struct{}
+
+func (eqpm ETagQueryParameterMapper) Decode(src ...string) (interface{}, error) {
+	if len(src) > 1 {
+		return nil, NewValidationError("too many values")
+	}
+
+	if len(src) == 0 || src[0] == "" {
+		return ETag{}, nil
+	}
+
+	tag, rest, err := parseETag(strings.TrimSpace(src[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	if rest != "" {
+		return nil, NewValidationError("unexpected data after entity tag")
+	}
+
+	return tag, nil
+}
+
+func (eqpm ETagQueryParameterMapper) Encode(src reflect.Value) ([]string, error) {
+	tag, ok := src.Interface().(ETag)
+	if !ok {
+		return nil, fmt.Errorf("expected jsonmap.ETag but got: %s", src.Type())
+	}
+
+	return []string{tag.String()}, nil
+}
+
+// ETagListQueryParameterMapper maps an If-Match or If-None-Match header to an
+// ETagList field. The list may be split across several headers. A missing
+// header leaves the field zero, unless Required is set.
+type ETagListQueryParameterMapper struct {
+	Required bool
+}
+
+func (elqpm ETagListQueryParameterMapper) Decode(src ...string) (interface{}, error) {
+	list := ETagList{}
+
+	for _, header := range src {
+		rest := strings.TrimSpace(header)
+		if rest == "*" {
+			list.Any = true
+			continue
+		}
+
+		for rest != "" {
+			var tag ETag
+			var err error
+			tag, rest, err = parseETag(rest)
+			if err != nil {
+				return nil, err
+			}
+			list.Tags = append(list.Tags, tag)
+
+			rest = strings.TrimSpace(rest)
+			if rest == "" {
+				break
+			}
+
+			if rest[0] != ',' {
+				return nil, NewValidationError("entity tags must be separated by commas")
+			}
+			rest = strings.TrimSpace(rest[1:])
+		}
+	}
+
+	if list.Any && len(list.Tags) != 0 {
+		return nil, NewValidationError("* may not be combined with entity tags")
+	}
+
+	if elqpm.Required && list.IsZero() {
+		return nil, NewValidationError("missing precondition")
+	}
+
+	return list, nil
+}
+
+func (elqpm ETagListQueryParameterMapper) Encode(src reflect.Value) ([]string, error) {
+	list, ok := src.Interface().(ETagList)
+	if !ok {
+		return nil, fmt.Errorf("expected jsonmap.ETagList but got: %s", src.Type())
+	}
+
+	if list.Any {
+		return []string{"*"}, nil
+	}
+
+	if len(list.Tags) == 0 {
+		return nil, nil
+	}
+
+	tags := make([]string, len(list.Tags))
+	for i, tag := range list.Tags {
+		tags[i] = tag.String()
+	}
+
+	return []string{strings.Join(tags, ", ")}, nil
+}
@@ -0,0 +1,133 @@
+package jsonmap
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// EventHandler handles the payload of a decoded event. The payload is a
+// pointer to a newly allocated instance of the type registered for the event.
+type EventHandler func(ctx Context, payload interface{}) error
+
+type event struct {
+	Type    string
+	Payload interface{}
+}
+
+// EventMapper maps event frames of the form {"type": "...", "payload": {...}},
+// such as those exchanged over a WebSocket, using a VariableType switched on
+// the "type" property to validate each payload against its registered
+// StructMap.
+//
+// Events should be registered before the EventMapper is put to use, as
+// Register is not safe for concurrent use with the other methods.
+type EventMapper struct {
+	mapping    map[string]TypeMap
+	handlers   map[string]EventHandler
+	eventTypes map[reflect.Type]string
+	envelope   StructMap
+}
+
+func NewEventMapper() *EventMapper {
+	em := &EventMapper{
+		mapping:    map[string]TypeMap{},
+		handlers:   map[string]EventHandler{},
+		eventTypes: map[reflect.Type]string{},
+	}
+	em.envelope = StructMap{
+		UnderlyingType: event{},
+		Fields: []MappedField{
+			{
+				StructFieldName: "Type",
+				JSONFieldName:   "type",
+				Validator:       KeyFromVariableTypeMap(em.mapping),
+			},
+			{
+				StructFieldName: "Payload",
+				JSONFieldName:   "payload",
+				Contains:        VariableType("Type", em.mapping),
+			},
+		},
+	}
+	return em
+}
+
+// Register associates an event type with the TypeMap used to validate its
+// payload, and optionally a handler to Dispatch it to.
+func (em *EventMapper) Register(eventType string, tm RegisterableTypeMap, handler EventHandler) {
+	if _, ok := em.mapping[eventType]; ok {
+		panic("event type already registered: " + eventType)
+	}
+
+	em.mapping[eventType] = tm
+	em.eventTypes[tm.GetUnderlyingType()] = eventType
+	if handler != nil {
+		em.handlers[eventType] = handler
+	}
+
+	// The set of valid type identifiers has changed
+	em.envelope.Fields[0].Validator = KeyFromVariableTypeMap(em.mapping)
+}
+
+// Decode validates an event frame, returning its type and a pointer to its
+// decoded payload.
+func (em *EventMapper) Decode(ctx Context, data []byte) (string, interface{}, error) {
+	partial := map[string]interface{}{}
+	err := json.Unmarshal(data, &partial)
+	if err != nil {
+		return "", nil, NewValidationError(err.Error())
+	}
+
+	e := event{}
+	err = em.envelope.Unmarshal(ctx, nil, partial, reflect.ValueOf(&e).Elem())
+	if err != nil {
+		if ve, ok := err.(*ValidationError); ok {
+			return "", nil, ve.Flatten()
+		}
+		return "", nil, err
+	}
+
+	return e.Type, e.Payload, nil
+}
+
+// Dispatch decodes an event frame and passes its payload to the handler
+// registered for its type.
+func (em *EventMapper) Dispatch(ctx Context, data []byte) error {
+	eventType, payload, err := em.Decode(ctx, data)
+	if err != nil {
+		return err
+	}
+
+	handler, ok := em.handlers[eventType]
+	if !ok {
+		return NewValidationError("no handler for event type: '%s'", eventType)
+	}
+
+	return handler(ctx, payload)
+}
+
+// Marshal encodes payload as an event frame. The event type is determined by
+// the type of the payload, so an error is returned if the payload is nil or of
+// a type which isn't registered.
+func (em *EventMapper) Marshal(ctx Context, payload interface{}) ([]byte, error) {
+	t := reflect.TypeOf(payload)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	eventType, ok := em.eventTypes[t]
+	if !ok {
+		return nil, fmt.Errorf("no event type registered for type: %T", payload)
+	}
+
+	data, err := em.envelope.Marshal(ctx, nil, reflect.ValueOf(event{
+		Type:    eventType,
+		Payload: payload,
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	return data.MarshalJSON()
+}
@@ -0,0 +1,88 @@
+package jsonmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+)
+
+type expansionContextKey struct{}
+
+// expansion tracks the position of a TypeMap within the set of fields to be
+// expanded.
+type expansion struct {
+	set      FieldSet
+	expanded bool
+}
+
+// WithExpand returns a Ctx which causes the Expandable fields selected by
+// expand to be marshaled in full. Nested fields are selected as with a sparse
+// FieldSet, so ParseFieldSet("owner,owner.friends") expands both an owner and
+// the friends of that owner.
+func WithExpand(ctx Context, expand FieldSet) *Ctx {
+	return NewCtx(ctx).With(expansionContextKey{}, expansion{set: expand})
+}
+
+func expansionOf(ctx Context) (expansion, bool) {
+	c, ok := ctx.(*Ctx)
+	if !ok {
+		return expansion{}, false
+	}
+
+	v, ok := c.Get(expansionContextKey{})
+	if !ok {
+		return expansion{}, false
+	}
+	return v.(expansion), true
+}
+
+// expansionContext returns the Context with which the TypeMap of the named
+// field should be invoked, according to any expansion specified by ctx.
+func expansionContext(ctx Context, name string) Context {
+	e, ok := expansionOf(ctx)
+	if !ok {
+		return ctx
+	}
+
+	sub, expanded := e.set[name]
+	return NewCtx(ctx).With(expansionContextKey{}, expansion{set: sub, expanded: expanded})
+}
+
+type expandableMap struct {
+	Full   TypeMap
+	IDOnly TypeMap
+}
+
+func (em *expandableMap) pick(ctx Context) TypeMap {
+	if e, ok := expansionOf(ctx); ok && e.expanded {
+		return em.Full
+	}
+	return em.IDOnly
+}
+
+func (em *expandableMap) Unmarshal(ctx Context, parent *reflect.Value, partial interface{}, dstValue reflect.Value) error {
+	return em.pick(ctx).Unmarshal(ctx, parent, partial, dstValue)
+}
+
+func (em *expandableMap) unmarshalStream(ctx Context, parent *reflect.Value, ts *tokenStream, dstValue reflect.Value) error {
+	return unmarshalStream(ctx, em.pick(ctx), parent, ts, dstValue)
+}
+
+func (em *expandableMap) Marshal(ctx Context, parent *reflect.Value, src reflect.Value) (json.Marshaler, error) {
+	return em.pick(ctx).Marshal(ctx, parent, src)
+}
+
+func (em *expandableMap) marshalTo(ctx Context, parent *reflect.Value, src reflect.Value, buf *bytes.Buffer) error {
+	return marshalTo(ctx, em.pick(ctx), parent, src, buf)
+}
+
+// Expandable returns a TypeMap for an embedded resource, which is marshaled
+// using full when the field is selected by the expand set given to
+// WithExpand, and using idOnly (typically a StructMap containing just its ID)
+// otherwise. Both TypeMaps must accept the same underlying type.
+func Expandable(full, idOnly TypeMap) TypeMap {
+	return &expandableMap{
+		Full:   full,
+		IDOnly: idOnly,
+	}
+}
@@ -0,0 +1,103 @@
+package jsonmap
+
+import (
+	"reflect"
+	"sync"
+)
+
+type fieldCacheKey struct {
+	t    reflect.Type
+	name string
+}
+
+// fieldIndexCache maps a struct type and field name to the index sequence
+// of the field, as used by reflect.Value.FieldByIndex(). Looking fields up by
+// name means searching the fields of the struct, and of any embedded structs,
+// on every call.
+var fieldIndexCache sync.Map
+
+func cachedFieldIndex(t reflect.Type, name string) ([]int, bool) {
+	key := fieldCacheKey{t, name}
+	if index, ok := fieldIndexCache.Load(key); ok {
+		return index.([]int), true
+	}
+
+	f, ok := t.FieldByName(name)
+	if !ok {
+		return nil, false
+	}
+
+	fieldIndexCache.Store(key, f.Index)
+	return f.Index, true
+}
+
+// fieldByName is equivalent to v.FieldByName(name), but caches the location
+// of the field.
+func fieldByName(v reflect.Value, name string) reflect.Value {
+	index, ok := cachedFieldIndex(v.Type(), name)
+	if !ok {
+		return reflect.Value{}
+	}
+	return v.FieldByIndex(index)
+}
+
+// warmFieldCache populates the field cache for every struct reachable from m,
+// so that lookups made while marshaling and unmarshaling never miss. As it is
+// called as each TypeMapper is built, it also rejects StructMaps with fields
+// that collide; see checkVersions.
+func warmFieldCache(m TypeMap, visited map[TypeMap]bool) {
+	// Pointer TypeMaps may be shared, and even recursive
+	if reflect.ValueOf(m).Kind() == reflect.Ptr {
+		if visited[m] {
+			return
+		}
+		visited[m] = true
+	}
+
+	switch tm := m.(type) {
+	case StructMap:
+		checkVersions(tm)
+		t := tm.GetUnderlyingType()
+		for _, field := range tm.Fields {
+			if field.StructFieldName != "" {
+				cachedFieldIndex(t, field.StructFieldName)
+			}
+			if field.Contains != nil {
+				warmFieldCache(field.Contains, visited)
+			}
+		}
+	case *StructMap:
+		warmFieldCache(*tm, visited)
+	case SliceMap:
+		warmFieldCache(tm.Contains, visited)
+	case *SliceMap:
+		warmFieldCache(tm.Contains, visited)
+	case MapMap:
+		warmFieldCache(tm.Contains, visited)
+	case *MapMap:
+		warmFieldCache(tm.Contains, visited)
+	case *Discriminator:
+		for _, option := range tm.Mapping {
+			warmFieldCache(option, visited)
+		}
+	case *uniqueSliceMap:
+		warmFieldCache(tm.Contains, visited)
+	case *optionalMap:
+		warmFieldCache(tm.Contains, visited)
+	case *orderedMapMap:
+		warmFieldCache(tm.Contains, visited)
+	case *nullableMap:
+		warmFieldCache(tm.Contains, visited)
+	case *JSONAPIMap:
+		warmFieldCache(tm.Map, visited)
+	case *expandableMap:
+		warmFieldCache(tm.Full, visited)
+		warmFieldCache(tm.IDOnly, visited)
+	case *EncryptedMap:
+		warmFieldCache(tm.Contains, visited)
+	case *LimitedMap:
+		warmFieldCache(tm.Contains, visited)
+	case *CachedMap:
+		warmFieldCache(tm.Contains, visited)
+	}
+}
@@ -0,0 +1,139 @@
+package jsonmap
+
+import (
+	"sort"
+	"strings"
+)
+
+// FieldSet selects a subset of the fields of a StructMap to be marshaled, by
+// their JSON field names. Each name may map to a nested FieldSet which
+// selects fields within it, or to nil in order to include the field whole.
+type FieldSet map[string]FieldSet
+
+// ParseFieldSet parses a comma separated list of field names, as typically
+// found in a `?fields=` query parameter. Nested fields are separated by dots,
+// for example "name,owner.name". An empty string yields a nil FieldSet, which
+// selects every field.
+func ParseFieldSet(s string) FieldSet {
+	var fs FieldSet
+	for _, path := range strings.Split(s, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+
+		if fs == nil {
+			fs = FieldSet{}
+		}
+
+		cur := fs
+		names := strings.Split(path, ".")
+		for i, name := range names {
+			sub, ok := cur[name]
+			if i == len(names)-1 {
+				// Requesting a field whole supersedes any of its sub-fields
+				cur[name] = nil
+				break
+			}
+
+			if ok && sub == nil {
+				// The field was already requested whole
+				break
+			}
+
+			if sub == nil {
+				sub = FieldSet{}
+				cur[name] = sub
+			}
+			cur = sub
+		}
+	}
+	return fs
+}
+
+type fieldSetContextKey struct{}
+
+// WithFieldSet returns a Ctx which restricts marshaling to the fields
+// selected by fs. A nil FieldSet selects every field.
+func WithFieldSet(ctx Context, fs FieldSet) *Ctx {
+	return NewCtx(ctx).With(fieldSetContextKey{}, fs)
+}
+
+func fieldSetOf(ctx Context) FieldSet {
+	c, ok := ctx.(*Ctx)
+	if !ok {
+		return nil
+	}
+
+	v, _ := c.Get(fieldSetContextKey{})
+	fs, _ := v.(FieldSet)
+	return fs
+}
+
+// projection returns the FieldSet specified by ctx, after checking that each
+// of the fields it selects exists in sm.
+func (sm StructMap) projection(ctx Context) (FieldSet, error) {
+	fs := fieldSetOf(ctx)
+	if fs == nil {
+		return nil, nil
+	}
+
+	names := make([]string, 0, len(fs))
+	for name := range fs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	errs := &ValidationError{}
+
+	for _, name := range names {
+		var found *MappedField
+		for i, field := range sm.Fields {
+			if field.JSONFieldName == name && field.activeFor(ctx) {
+				found = &sm.Fields[i]
+				break
+			}
+		}
+
+		if found == nil {
+			errs.AddError(NewValidationErrorWithField(name, "no such field"))
+		} else if fs[name] != nil && found.Contains == nil {
+			errs.AddError(NewValidationErrorWithField(name, "field has no sub-fields"))
+		}
+	}
+
+	if len(errs.NestedErrors) > 0 {
+		return nil, errs
+	}
+
+	return fs, nil
+}
+
+// selects reports whether the named field is selected by fs, and returns the
+// Context with which it should be marshaled.
+func (fs FieldSet) selects(ctx Context, name string) (Context, bool) {
+	if fs == nil {
+		return ctx, true
+	}
+
+	sub, ok := fs[name]
+	if !ok {
+		return nil, false
+	}
+	return WithFieldSet(ctx, sub), true
+}
+
+// projectionError attributes validation errors raised while marshaling the
+// sub-fields selected by fs to the named field.
+func projectionError(fs FieldSet, name string, err error) error {
+	if fs[name] == nil {
+		return err
+	}
+
+	if verr, ok := err.(*ValidationError); ok {
+		errs := &ValidationError{}
+		errs.AddError(fieldError(name, verr))
+		return errs
+	}
+	return err
+}
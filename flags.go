@@ -0,0 +1,132 @@
+package jsonmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// FlagsMap maps a list of flag names on the wire to a bitmask in an integer
+// struct field, in which each flag is a bit (or set of bits).
+type FlagsMap struct {
+	flags     map[string]uint64
+	names     []string
+	validator Validator
+}
+
+func bitmaskValue(v reflect.Value, which string) uint64 {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return uint64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint()
+	default:
+		panic(which + " field for jsonmap.Flags() is not an integer: " + v.Type().String())
+	}
+}
+
+func (m *FlagsMap) Unmarshal(ctx Context, parent *reflect.Value, partial interface{}, dstValue reflect.Value) error {
+	bitmaskValue(dstValue, "target")
+
+	data, ok := partial.([]interface{})
+	if !ok {
+		return NewValidationError("expected a list")
+	}
+
+	errs := &ValidationError{}
+
+	var mask uint64
+	for i, val := range data {
+		name, err := m.validator.Validate(val)
+		if err != nil {
+			errs.AddError(fieldError(strconv.Itoa(i), err))
+			continue
+		}
+		mask |= m.flags[name.(string)]
+	}
+
+	if len(errs.NestedErrors) != 0 {
+		return errs
+	}
+
+	if dstValue.Kind() >= reflect.Uint && dstValue.Kind() <= reflect.Uintptr {
+		if dstValue.OverflowUint(mask) {
+			return uintRangeError(dstValue.Type())
+		}
+		dstValue.SetUint(mask)
+	} else {
+		if mask > math.MaxInt64 || dstValue.OverflowInt(int64(mask)) {
+			return intRangeError(dstValue.Type(), false)
+		}
+		dstValue.SetInt(int64(mask))
+	}
+
+	return nil
+}
+
+func (m *FlagsMap) Marshal(ctx Context, parent *reflect.Value, src reflect.Value) (json.Marshaler, error) {
+	buf := &bytes.Buffer{}
+	err := m.marshalTo(ctx, parent, src, buf)
+	if err != nil {
+		return nil, err
+	}
+	return RawMessage{buf.Bytes()}, nil
+}
+
+func (m *FlagsMap) marshalTo(ctx Context, parent *reflect.Value, src reflect.Value, buf *bytes.Buffer) error {
+	mask := bitmaskValue(src, "source")
+
+	buf.WriteByte('[')
+
+	var covered uint64
+	written := 0
+	for _, name := range m.names {
+		flag := m.flags[name]
+		if mask&flag != flag {
+			continue
+		}
+
+		if written != 0 {
+			buf.WriteByte(',')
+		}
+		written++
+
+		marshalValueTo(name, buf)
+		covered |= flag
+	}
+
+	buf.WriteByte(']')
+
+	if covered != mask {
+		return fmt.Errorf("no flag names for bits: %#x", mask&^covered)
+	}
+
+	return nil
+}
+
+// Flags returns a TypeMap for integer bitmask fields, such as permissions,
+// which are represented on the wire as a list of the names of the flags which
+// are set. Flags are marshaled in order of their values.
+func Flags(flags map[string]uint64) TypeMap {
+	m := &FlagsMap{
+		flags: flags,
+	}
+
+	for name, flag := range flags {
+		if flag == 0 {
+			panic("flag has no bits set: " + name)
+		}
+		m.names = append(m.names, name)
+	}
+
+	sort.Slice(m.names, func(i, j int) bool {
+		return flags[m.names[i]] < flags[m.names[j]]
+	})
+	m.validator = OneOf(m.names...)
+
+	return m
+}
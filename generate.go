@@ -0,0 +1,335 @@
+package jsonmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// StaticTarget names a StructMap for which GenerateStatic should emit code.
+// Name must be the identifier of a package level variable holding Map, as
+// the generated code refers back to it for validators and for any fields it
+// can't handle statically.
+type StaticTarget struct {
+	Name string
+	Map  StructMap
+}
+
+type staticGenerator struct {
+	buf          bytes.Buffer
+	pkg          string
+	qualifier    string
+	targets      map[reflect.Type]StaticTarget
+	needsJSON    bool
+	needsReflect bool
+}
+
+// GenerateStatic writes Go source for package pkg which marshals and
+// unmarshals each target without reflection, and declares a StaticMap for
+// each, named after the target with a "Static" suffix. Registering these in
+// place of the original StructMaps lets hot types bypass reflection while
+// behaving identically.
+//
+// Fields holding primitive values and fields containing other targets are
+// handled statically. All other fields fall back to the original TypeMaps.
+// Because StructMaps are only available at runtime, GenerateStatic is
+// intended to be called from a small program run by go generate, which
+// imports the package declaring the StructMaps.
+func GenerateStatic(w io.Writer, pkg string, targets ...StaticTarget) error {
+	g := &staticGenerator{
+		pkg:     pkg,
+		targets: map[reflect.Type]StaticTarget{},
+	}
+	if pkg != "jsonmap" {
+		g.qualifier = "jsonmap."
+	}
+
+	for _, target := range targets {
+		g.targets[target.Map.GetUnderlyingType()] = target
+	}
+
+	body := &bytes.Buffer{}
+	for _, target := range targets {
+		err := g.generate(body, target)
+		if err != nil {
+			return err
+		}
+	}
+
+	g.printf("// Code generated by jsonmap.GenerateStatic. DO NOT EDIT.\n\n")
+	g.printf("package %s\n\n", pkg)
+	g.printf("import (\n\t\"bytes\"\n")
+	if g.needsJSON {
+		g.printf("\t\"encoding/json\"\n")
+	}
+	if g.needsReflect {
+		g.printf("\t\"reflect\"\n")
+	}
+	if g.qualifier != "" {
+		g.printf("\n\t\"github.com/russellhaering/jsonmap\"\n")
+	}
+	g.printf(")\n")
+	g.buf.Write(body.Bytes())
+
+	src, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(src)
+	return err
+}
+
+func (g *staticGenerator) printf(format string, a ...interface{}) {
+	fmt.Fprintf(&g.buf, format, a...)
+}
+
+// typeName returns the name by which t may be referred to from the generated
+// package, or false if it can't be.
+func (g *staticGenerator) typeName(t reflect.Type) (string, bool) {
+	name := strings.Replace(t.String(), g.pkg+".", "", -1)
+	if t.Name() == "" && t.Kind() != reflect.Slice && t.Kind() != reflect.Map && t.Kind() != reflect.Ptr {
+		return "", false
+	}
+	if strings.Contains(name, ".") {
+		return "", false
+	}
+	return name, true
+}
+
+func (g *staticGenerator) generate(w io.Writer, target StaticTarget) error {
+	t := target.Map.GetUnderlyingType()
+	typeName, ok := g.typeName(t)
+	if !ok || t.Kind() != reflect.Struct {
+		return fmt.Errorf("%s: %s is not a struct declared in package %s", target.Name, t, g.pkg)
+	}
+
+	// Which fields are active depends on the Context, which generated code
+	// doesn't consult
+	for _, field := range target.Map.Fields {
+		if field.SinceVersion != 0 || field.UntilVersion != 0 {
+			return fmt.Errorf("%s: field %s is versioned, which isn't supported", target.Name, field.JSONFieldName)
+		}
+	}
+
+	q := g.qualifier
+
+	// Marshaling
+	fmt.Fprintf(w, "\nfunc marshal%s(ctx %sContext, src interface{}, buf *bytes.Buffer) error {\n", target.Name, q)
+	fmt.Fprintf(w, "v := src.(*%s)\n", typeName)
+	if target.Map.ContextTransform != nil {
+		fmt.Fprintf(w, "ctx = %s.ContextTransform(ctx, v)\n", target.Name)
+	}
+	fmt.Fprintf(w, "buf.WriteByte('{')\n")
+
+	// Once a field may have been omitted, whether a comma is needed before
+	// the next is only known at runtime
+	omits := false
+	for _, field := range target.Map.Fields {
+		omits = omits || field.OmitEmpty
+	}
+	if omits {
+		fmt.Fprintf(w, "start := buf.Len()\n")
+	}
+
+	mayOmit := false
+	for i, field := range target.Map.Fields {
+		sf, isField := t.FieldByName(field.StructFieldName)
+		nested, isNested := g.nestedTarget(field, sf)
+
+		if field.OmitEmpty {
+			if !isField {
+				return fmt.Errorf("%s: field %s is OmitEmpty but not a struct field, which isn't supported", target.Name, field.JSONFieldName)
+			}
+			fmt.Fprintf(w, "if %s {\n", g.nonZero(sf))
+		}
+
+		key, err := json.Marshal(field.JSONFieldName)
+		if err != nil {
+			return err
+		}
+		if mayOmit {
+			fmt.Fprintf(w, "if buf.Len() != start {\nbuf.WriteByte(',')\n}\n")
+		} else if i != 0 {
+			key = append([]byte{','}, key...)
+		}
+		fmt.Fprintf(w, "buf.WriteString(%s)\n", strconv.Quote(string(key)+":"))
+
+		// Redaction depends on the Context, so is left to MarshalMappedField
+		if field.Redact {
+			isField = false
+		}
+
+		switch {
+		case isField && field.Contains == nil:
+			g.needsJSON = true
+			fmt.Fprintf(w, "if data, err := json.Marshal(v.%s); err == nil {\nbuf.Write(data)\n} else {\nreturn err\n}\n", field.StructFieldName)
+		case isField && isNested && sf.Type.Kind() == reflect.Ptr:
+			fmt.Fprintf(w, "if v.%s == nil {\nbuf.WriteString(\"null\")\n} else if err := marshal%s(ctx, v.%s, buf); err != nil {\nreturn err\n}\n",
+				field.StructFieldName, nested.Name, field.StructFieldName)
+		case isField && isNested:
+			fmt.Fprintf(w, "if err := marshal%s(ctx, &v.%s, buf); err != nil {\nreturn err\n}\n", nested.Name, field.StructFieldName)
+		default:
+			g.needsReflect = true
+			fmt.Fprintf(w, "if err := %sMarshalMappedField(ctx, %s, %d, reflect.ValueOf(v).Elem(), buf); err != nil {\nreturn err\n}\n", q, target.Name, i)
+		}
+
+		if field.OmitEmpty {
+			fmt.Fprintf(w, "}\n")
+		}
+		mayOmit = mayOmit || field.OmitEmpty
+	}
+
+	fmt.Fprintf(w, "buf.WriteByte('}')\nreturn nil\n}\n")
+
+	// Unmarshaling
+	fmt.Fprintf(w, "\nfunc unmarshal%s(ctx %sContext, data map[string]interface{}, dst interface{}) error {\n", target.Name, q)
+	fmt.Fprintf(w, "v := dst.(*%s)\n", typeName)
+	if reflect.PtrTo(t).Implements(defaulterType) {
+		g.needsReflect = true
+		fmt.Fprintf(w, "if reflect.ValueOf(v).Elem().IsZero() {\nv.SetDefaults(ctx)\n}\n")
+	}
+	fmt.Fprintf(w, "errs := &%sValidationError{}\n", q)
+
+	for i, field := range target.Map.Fields {
+		if field.ReadOnly {
+			continue
+		}
+
+		sf, isField := t.FieldByName(field.StructFieldName)
+		if !isField {
+			return fmt.Errorf("%s: no such underlying field: %s", target.Name, field.StructFieldName)
+		}
+
+		jsonName := strconv.Quote(field.JSONFieldName)
+		if field.Optional && !field.skipsNull() {
+			fmt.Fprintf(w, "if raw, ok := data[%s]; ok {\n", jsonName)
+		} else if field.Optional {
+			fmt.Fprintf(w, "if raw, ok := data[%s]; ok && raw != nil {\n", jsonName)
+		} else {
+			fmt.Fprintf(w, "if raw, ok := data[%s]; !ok {\n", jsonName)
+			fmt.Fprintf(w, "errs.AddError(%sNewValidationErrorWithField(%s, \"missing required field\"))\n", q, jsonName)
+			fmt.Fprintf(w, "} else {\n")
+		}
+
+		nested, isNested := g.nestedTarget(field, sf)
+		fieldTypeName, isNameable := g.typeName(sf.Type)
+
+		switch {
+		case field.Contains == nil && field.Validator != nil && (isNameable || sf.Type.Kind() == reflect.Interface):
+			if _, ok := field.Validator.(ContextValidator); ok {
+				fmt.Fprintf(w, "val, err := %sValidateWithContext(ctx, %s.Fields[%d].Validator, raw)\n", q, target.Name, i)
+			} else {
+				fmt.Fprintf(w, "val, err := %s.Fields[%d].Validator.Validate(raw)\n", target.Name, i)
+			}
+			fmt.Fprintf(w, "if err != nil {\n")
+			g.writeAddFieldError(w, jsonName)
+			if sf.Type.Kind() == reflect.Interface {
+				fmt.Fprintf(w, "} else {\nv.%s = val\n}\n", field.StructFieldName)
+			} else {
+				fmt.Fprintf(w, "} else {\nv.%s = val.(%s)\n}\n", field.StructFieldName, fieldTypeName)
+			}
+		case isNested:
+			fmt.Fprintf(w, "if obj, ok := raw.(map[string]interface{}); !ok {\n")
+			if sf.Type.Kind() == reflect.Ptr {
+				// Null is always acceptable for pointers
+				fmt.Fprintf(w, "if raw != nil {\n")
+				fmt.Fprintf(w, "errs.AddError(%sNewValidationErrorWithField(%s, \"expected an object\"))\n", q, jsonName)
+				fmt.Fprintf(w, "}\n")
+				fmt.Fprintf(w, "} else {\nv.%s = &%s{}\n", field.StructFieldName, strings.TrimPrefix(fieldTypeName, "*"))
+				fmt.Fprintf(w, "if err := unmarshal%s(ctx, obj, v.%s); err != nil {\n", nested.Name, field.StructFieldName)
+				g.writeAddFieldError(w, jsonName)
+				fmt.Fprintf(w, "}\n}\n")
+			} else {
+				fmt.Fprintf(w, "errs.AddError(%sNewValidationErrorWithField(%s, \"expected an object\"))\n", q, jsonName)
+				// As with a StructMap, the existing struct is unmarshaled into
+				fmt.Fprintf(w, "} else if err := unmarshal%s(ctx, obj, &v.%s); err != nil {\n", nested.Name, field.StructFieldName)
+				g.writeAddFieldError(w, jsonName)
+				fmt.Fprintf(w, "}\n")
+			}
+		default:
+			g.needsReflect = true
+			fmt.Fprintf(w, "if err := %sUnmarshalMappedField(ctx, %s, %d, reflect.ValueOf(v).Elem(), raw); err != nil {\n", q, target.Name, i)
+			fmt.Fprintf(w, "errs.AddError(err)\n}\n")
+		}
+
+		fmt.Fprintf(w, "}\n")
+
+		if field.Optional && field.InitIfAbsent {
+			g.needsReflect = true
+			fmt.Fprintf(w, "if _, ok := data[%s]; !ok {\n", jsonName)
+			fmt.Fprintf(w, "%sInitMappedField(ctx, %s, %d, reflect.ValueOf(v).Elem())\n}\n", q, target.Name, i)
+		}
+	}
+
+	fmt.Fprintf(w, "if len(errs.NestedErrors) != 0 {\nreturn errs\n}\nreturn nil\n}\n")
+
+	fmt.Fprintf(w, "\nvar %sStatic = %sStaticMap{\n", target.Name, q)
+	fmt.Fprintf(w, "UnderlyingType: %s{},\n", typeName)
+	fmt.Fprintf(w, "MarshalFunc: marshal%s,\n", target.Name)
+	fmt.Fprintf(w, "UnmarshalFunc: unmarshal%s,\n", target.Name)
+	fmt.Fprintf(w, "Map: %s,\n", target.Name)
+	fmt.Fprintf(w, "}\n")
+
+	return nil
+}
+
+// nonZero returns an expression which is true if the struct field sf of v
+// doesn't hold the zero value of its type.
+func (g *staticGenerator) nonZero(sf reflect.StructField) string {
+	switch sf.Type.Kind() {
+	case reflect.Bool:
+		return "v." + sf.Name
+	case reflect.String:
+		return "v." + sf.Name + ` != ""`
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return "v." + sf.Name + " != 0"
+	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface, reflect.Func, reflect.Chan:
+		return "v." + sf.Name + " != nil"
+	default:
+		g.needsReflect = true
+		return "!reflect.ValueOf(v." + sf.Name + ").IsZero()"
+	}
+}
+
+// nestedTarget returns the target for a field which contains another target,
+// either directly or through a pointer.
+func (g *staticGenerator) nestedTarget(field MappedField, sf reflect.StructField) (StaticTarget, bool) {
+	var contained StructMap
+	switch c := field.Contains.(type) {
+	case StructMap:
+		contained = c
+	case *StructMap:
+		contained = *c
+	default:
+		return StaticTarget{}, false
+	}
+
+	t := sf.Type
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	target, ok := g.targets[contained.GetUnderlyingType()]
+	if !ok || t != target.Map.GetUnderlyingType() {
+		return StaticTarget{}, false
+	}
+	if _, ok := g.typeName(sf.Type); !ok {
+		return StaticTarget{}, false
+	}
+	return target, true
+}
+
+func (g *staticGenerator) writeAddFieldError(w io.Writer, jsonName string) {
+	q := g.qualifier
+	fmt.Fprintf(w, "if ve, ok := err.(*%sValidationError); ok {\n", q)
+	fmt.Fprintf(w, "ve.SetField(%s)\nerrs.AddError(ve)\n", jsonName)
+	fmt.Fprintf(w, "} else {\nerrs.AddError(%sNewValidationErrorWithField(%s, err.Error()))\n}\n", q, jsonName)
+}
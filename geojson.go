@@ -0,0 +1,235 @@
+package jsonmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strconv"
+)
+
+// Position is a GeoJSON position, in degrees. Positions with an altitude
+// aren't supported.
+type Position struct {
+	Longitude float64
+	Latitude  float64
+}
+
+// Geometry is implemented by the GeoJSON geometries supported by GeoJSON().
+type Geometry interface {
+	// GeometryType returns the GeoJSON type of the geometry, such as "Point"
+	GeometryType() string
+}
+
+// Point is a GeoJSON Point geometry.
+type Point struct {
+	Coordinates Position
+}
+
+func (Point) GeometryType() string {
+	return "Point"
+}
+
+// Polygon is a GeoJSON Polygon geometry. The first ring is the exterior ring,
+// and any others are holes within it. Each ring is closed, ending with the
+// position with which it starts.
+type Polygon struct {
+	Rings [][]Position
+}
+
+func (Polygon) GeometryType() string {
+	return "Polygon"
+}
+
+var geometryType = reflect.TypeOf((*Geometry)(nil)).Elem()
+
+type GeoJSONMap struct{}
+
+func (m *GeoJSONMap) Unmarshal(ctx Context, parent *reflect.Value, partial interface{}, dstValue reflect.Value) error {
+	var want string
+	switch dstValue.Type() {
+	case reflect.TypeOf(Point{}):
+		want = "Point"
+	case reflect.TypeOf(Polygon{}):
+		want = "Polygon"
+	case geometryType:
+	default:
+		panic("target field for jsonmap.GeoJSON() is not a jsonmap.Point, jsonmap.Polygon or jsonmap.Geometry")
+	}
+
+	data, ok := partial.(map[string]interface{})
+	if !ok {
+		return NewValidationError("expected an object")
+	}
+
+	errs := &ValidationError{}
+
+	t, ok := data["type"].(string)
+	switch {
+	case !ok:
+		errs.AddError(NewValidationErrorWithField("type", "missing geometry type"))
+	case want != "" && t != want:
+		errs.AddError(NewValidationErrorWithField("type", "expected a "+want))
+	case t != "Point" && t != "Polygon":
+		errs.AddError(NewValidationErrorWithField("type", "unsupported geometry type: "+t))
+	}
+
+	coordinates, ok := data["coordinates"]
+	if !ok {
+		errs.AddError(NewValidationErrorWithField("coordinates", "missing required field"))
+	}
+
+	if len(errs.NestedErrors) != 0 {
+		return errs
+	}
+
+	var g Geometry
+	var err error
+	if t == "Point" {
+		var p Point
+		p.Coordinates, err = position(coordinates)
+		g = p
+	} else {
+		var p Polygon
+		p.Rings, err = rings(coordinates)
+		g = p
+	}
+
+	if err != nil {
+		errs.AddError(fieldError("coordinates", err))
+		return errs
+	}
+
+	dstValue.Set(reflect.ValueOf(g))
+	return nil
+}
+
+func position(partial interface{}) (Position, error) {
+	coords, ok := partial.([]interface{})
+	if !ok || len(coords) != 2 {
+		return Position{}, NewValidationError("expected a position of longitude and latitude")
+	}
+
+	lon, lonOK := coords[0].(float64)
+	lat, latOK := coords[1].(float64)
+
+	errs := &ValidationError{}
+	if !lonOK || lon < -180 || lon > 180 {
+		errs.AddError(NewValidationErrorWithField("0", "longitude must be a number between -180 and 180"))
+	}
+	if !latOK || lat < -90 || lat > 90 {
+		errs.AddError(NewValidationErrorWithField("1", "latitude must be a number between -90 and 90"))
+	}
+
+	if len(errs.NestedErrors) != 0 {
+		return Position{}, errs
+	}
+	return Position{Longitude: lon, Latitude: lat}, nil
+}
+
+func rings(partial interface{}) ([][]Position, error) {
+	list, ok := partial.([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, NewValidationError("expected a list of linear rings")
+	}
+
+	errs := &ValidationError{}
+	result := make([][]Position, len(list))
+
+	for i, r := range list {
+		positions, ok := r.([]interface{})
+		if !ok || len(positions) < 4 {
+			errs.AddError(NewValidationErrorWithField(strconv.Itoa(i), "a linear ring must have at least 4 positions"))
+			continue
+		}
+
+		ringErrs := &ValidationError{}
+		ring := make([]Position, len(positions))
+		for j, p := range positions {
+			pos, err := position(p)
+			if err != nil {
+				ringErrs.AddError(fieldError(strconv.Itoa(j), err))
+				continue
+			}
+			ring[j] = pos
+		}
+
+		if len(ringErrs.NestedErrors) == 0 && ring[0] != ring[len(ring)-1] {
+			ringErrs.Message = "a linear ring must end with the position with which it starts"
+		}
+
+		if ringErrs.Message != "" || len(ringErrs.NestedErrors) != 0 {
+			errs.AddError(fieldError(strconv.Itoa(i), ringErrs))
+			continue
+		}
+		result[i] = ring
+	}
+
+	if len(errs.NestedErrors) != 0 {
+		return nil, errs
+	}
+	return result, nil
+}
+
+func (m *GeoJSONMap) Marshal(ctx Context, parent *reflect.Value, src reflect.Value) (json.Marshaler, error) {
+	buf := &bytes.Buffer{}
+	err := m.marshalTo(ctx, parent, src, buf)
+	if err != nil {
+		return nil, err
+	}
+	return RawMessage{buf.Bytes()}, nil
+}
+
+func writePosition(p Position, buf *bytes.Buffer) {
+	buf.WriteByte('[')
+	buf.WriteString(strconv.FormatFloat(p.Longitude, 'g', -1, 64))
+	buf.WriteByte(',')
+	buf.WriteString(strconv.FormatFloat(p.Latitude, 'g', -1, 64))
+	buf.WriteByte(']')
+}
+
+func (m *GeoJSONMap) marshalTo(ctx Context, parent *reflect.Value, src reflect.Value, buf *bytes.Buffer) error {
+	if !src.Type().Implements(geometryType) {
+		panic("source field for jsonmap.GeoJSON() is not a jsonmap.Point, jsonmap.Polygon or jsonmap.Geometry")
+	}
+
+	// A nil Geometry interface yields a nil g, which is marshaled as null
+	g, _ := src.Interface().(Geometry)
+
+	switch tg := g.(type) {
+	case nil:
+		buf.Write(nullJSONValue)
+	case Point:
+		buf.WriteString(`{"type":"Point","coordinates":`)
+		writePosition(tg.Coordinates, buf)
+		buf.WriteByte('}')
+	case Polygon:
+		buf.WriteString(`{"type":"Polygon","coordinates":[`)
+		for i, ring := range tg.Rings {
+			if i != 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteByte('[')
+			for j, p := range ring {
+				if j != 0 {
+					buf.WriteByte(',')
+				}
+				writePosition(p, buf)
+			}
+			buf.WriteByte(']')
+		}
+		buf.WriteString(`]}`)
+	default:
+		panic("unsupported geometry for jsonmap.GeoJSON(): " + reflect.TypeOf(g).String())
+	}
+
+	return nil
+}
+
+// GeoJSON returns a TypeMap for GeoJSON (RFC 7946) geometries. The target
+// field may be a Point or a Polygon, which accept only geometries of that
+// type, or a Geometry, which accepts either. Longitudes must lie between -180
+// and 180 and latitudes between -90 and 90, and the rings of polygons must
+// be closed, with at least four positions.
+func GeoJSON() TypeMap {
+	return &GeoJSONMap{}
+}
@@ -1,8 +1,14 @@
 module github.com/russellhaering/jsonmap
 
-go 1.12
+go 1.18
 
 require (
 	github.com/rnd42/go-jsonpointer v0.0.0-20140520035338-0480215403db
 	github.com/stretchr/testify v1.4.0
+	gopkg.in/yaml.v2 v2.2.2
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
 )
@@ -0,0 +1,329 @@
+package jsonmap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var graphQLName = regexp.MustCompile(`^[_A-Za-z][_0-9A-Za-z]*$`)
+
+type graphQLGenerator struct {
+	structs   map[reflect.Type]StructMap
+	enums     map[string][]string
+	needsJSON bool
+}
+
+// GenerateGraphQL writes GraphQL SDL (schema definition language) describing
+// the StructMaps registered with tm, and those nested within them, so that a
+// GraphQL API can share its types with a JSON one. For each struct, an object
+// type named after it is written, along with an input type with an "Input"
+// suffix, which leaves out ReadOnly fields. Fields are named by their
+// JSONFieldName.
+//
+// Fields are given the type of the values they accept: String for strings,
+// times and durations; Int for integers within 32 bits, and Float for other
+// numbers; Boolean; lists for slices; and a JSON scalar, declared if used,
+// for maps and values whose type can't be determined. Fields validated by
+// OneOf() or containing an Enum() are given an enum type named after their
+// struct and field, if their values are valid GraphQL names. Input fields are
+// non-null unless Optional, and object fields unless they may be nil or
+// omitted.
+//
+// Arguments of the input types should be validated with
+// UnmarshalGraphQLArgs.
+func (tm *TypeMapper) GenerateGraphQL(w io.Writer) error {
+	g := &graphQLGenerator{
+		structs: map[reflect.Type]StructMap{},
+		enums:   map[string][]string{},
+	}
+
+	for _, t := range tm.RegisteredTypes() {
+		g.collect(tm.typeMaps[t])
+	}
+
+	types := make([]reflect.Type, 0, len(g.structs))
+	for t := range g.structs {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		return types[i].Name() < types[j].Name()
+	})
+
+	var defs []string
+	for _, t := range types {
+		for _, input := range []bool{false, true} {
+			def, err := g.structDef(g.structs[t], input)
+			if err != nil {
+				return err
+			}
+			defs = append(defs, def)
+		}
+	}
+
+	enums := make([]string, 0, len(g.enums))
+	for name := range g.enums {
+		enums = append(enums, name)
+	}
+	sort.Strings(enums)
+
+	for _, name := range enums {
+		defs = append(defs, "enum "+name+" {\n  "+strings.Join(g.enums[name], "\n  ")+"\n}\n")
+	}
+
+	if g.needsJSON {
+		defs = append([]string{"scalar JSON\n"}, defs...)
+	}
+
+	_, err := io.WriteString(w, strings.Join(defs, "\n"))
+	return err
+}
+
+// collect finds the StructMaps within m.
+func (g *graphQLGenerator) collect(m TypeMap) {
+	switch tm := m.(type) {
+	case StructMap:
+		g.collectStruct(tm)
+	case *StructMap:
+		g.collectStruct(*tm)
+	case *JSONAPIMap:
+		g.collectStruct(tm.Map)
+	case SliceMap:
+		g.collect(tm.Contains)
+	case *SliceMap:
+		g.collect(tm.Contains)
+	case *uniqueSliceMap:
+		g.collect(tm.Contains)
+	case *nullableMap:
+		g.collect(tm.Contains)
+	case *optionalMap:
+		g.collect(tm.Contains)
+	case *EncryptedMap:
+		g.collect(tm.Contains)
+	case *LimitedMap:
+		g.collect(tm.Contains)
+	case *CachedMap:
+		g.collect(tm.Contains)
+	}
+}
+
+func (g *graphQLGenerator) collectStruct(sm StructMap) {
+	t := sm.GetUnderlyingType()
+	if t == nil {
+		return
+	}
+
+	// Recursive types need only be collected once
+	if _, ok := g.structs[t]; ok {
+		return
+	}
+	g.structs[t] = sm
+
+	for _, field := range sm.Fields {
+		if field.Contains != nil {
+			g.collect(field.Contains)
+		}
+	}
+}
+
+func (g *graphQLGenerator) structDef(sm StructMap, input bool) (string, error) {
+	t := sm.GetUnderlyingType()
+	if t.Name() == "" {
+		return "", fmt.Errorf("jsonmap: %s has no name to give its GraphQL type", t)
+	}
+
+	b := &strings.Builder{}
+	if input {
+		fmt.Fprintf(b, "input %sInput {\n", t.Name())
+	} else {
+		fmt.Fprintf(b, "type %s {\n", t.Name())
+	}
+
+	for _, field := range sm.Fields {
+		if input && field.ReadOnly {
+			continue
+		}
+
+		if !graphQLName.MatchString(field.JSONFieldName) {
+			return "", fmt.Errorf("jsonmap: %s: %q is not a valid GraphQL field name", t, field.JSONFieldName)
+		}
+
+		name := lowerCamel(field.JSONFieldName)
+		enumName := t.Name() + strings.ToUpper(name[:1]) + name[1:]
+
+		var typ string
+		if field.Contains != nil {
+			typ = g.typeMapType(field.Contains, input, enumName)
+		} else {
+			typ = g.validatorType(field.Validator, enumName)
+		}
+
+		if g.nonNull(t, field, input) {
+			typ += "!"
+		}
+
+		fmt.Fprintf(b, "  %s: %s\n", field.JSONFieldName, typ)
+	}
+
+	b.WriteString("}\n")
+	return b.String(), nil
+}
+
+func graphQLTypeName(sm StructMap, input bool) string {
+	if input {
+		return sm.GetUnderlyingType().Name() + "Input"
+	}
+	return sm.GetUnderlyingType().Name()
+}
+
+// nonNull reports whether field always holds a value: when given as input if
+// input is set, or when marshaled otherwise.
+func (g *graphQLGenerator) nonNull(t reflect.Type, field MappedField, input bool) bool {
+	switch field.Contains.(type) {
+	case *nullableMap, *optionalMap:
+		return false
+	}
+
+	if input {
+		return !field.Optional
+	}
+
+	if field.OmitEmpty || field.StructFieldName == "" {
+		return false
+	}
+
+	sf, ok := t.FieldByName(field.StructFieldName)
+	if !ok {
+		return false
+	}
+
+	switch sf.Type.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+		return false
+	}
+	return true
+}
+
+// typeMapType returns the GraphQL type of the values mapped by m, naming any
+// enum it declares enumName.
+func (g *graphQLGenerator) typeMapType(m TypeMap, input bool, enumName string) string {
+	switch tm := m.(type) {
+	case StructMap:
+		return graphQLTypeName(tm, input)
+	case *StructMap:
+		return graphQLTypeName(*tm, input)
+	case *JSONAPIMap:
+		return graphQLTypeName(tm.Map, input)
+	case SliceMap:
+		return "[" + g.typeMapType(tm.Contains, input, enumName) + "]"
+	case *SliceMap:
+		return "[" + g.typeMapType(tm.Contains, input, enumName) + "]"
+	case *uniqueSliceMap:
+		return "[" + g.typeMapType(tm.Contains, input, enumName) + "]"
+	case *nullableMap:
+		return g.typeMapType(tm.Contains, input, enumName)
+	case *optionalMap:
+		return g.typeMapType(tm.Contains, input, enumName)
+	case *EncryptedMap:
+		return g.typeMapType(tm.Contains, input, enumName)
+	case *LimitedMap:
+		return g.typeMapType(tm.Contains, input, enumName)
+	case *CachedMap:
+		return g.typeMapType(tm.Contains, input, enumName)
+	case *PrimitiveMap:
+		return g.validatorType(tm.V, enumName)
+	case *EnumMap:
+		return g.validatorType(tm.validator, enumName)
+	case *DurationMap:
+		if tm.Format != DurationString {
+			return "Float"
+		}
+		return "String"
+	case *TimeMap, *DateMap, *UUIDMap, *URLMap, *IPMap, *IPNetMap, *BytesMap:
+		return "String"
+	}
+
+	g.needsJSON = true
+	return "JSON"
+}
+
+// validatorType returns the GraphQL type of the values accepted by v, naming
+// any enum it declares enumName.
+func (g *graphQLGenerator) validatorType(v Validator, enumName string) string {
+	c, ok := DescribeValidator(v)
+	if !ok {
+		g.needsJSON = true
+		return "JSON"
+	}
+
+	switch c.Kind {
+	case ConstraintString:
+		if g.enum(enumName, c.Enum) {
+			return enumName
+		}
+		return "String"
+	case ConstraintInteger:
+		if c.Min != nil && c.Max != nil && *c.Min >= math.MinInt32 && *c.Max <= math.MaxInt32 {
+			return "Int"
+		}
+		return "Float"
+	case ConstraintNumber:
+		return "Float"
+	case ConstraintBoolean:
+		return "Boolean"
+	}
+
+	g.needsJSON = true
+	return "JSON"
+}
+
+// enum declares an enum type with the given values, unless there are none,
+// or they aren't all valid GraphQL names.
+func (g *graphQLGenerator) enum(name string, values []interface{}) bool {
+	if len(values) == 0 {
+		return false
+	}
+
+	names := make([]string, len(values))
+	for i, value := range values {
+		s, ok := value.(string)
+		if !ok || !graphQLName.MatchString(s) || s == "true" || s == "false" || s == "null" {
+			return false
+		}
+		names[i] = s
+	}
+
+	g.enums[name] = names
+	return true
+}
+
+// UnmarshalGraphQLArgs validates args, the arguments of a GraphQL field as
+// given to its resolver, into dest, exactly as Unmarshal would validate a
+// JSON document holding them. Typically args holds the fields of an input
+// type written by GenerateGraphQL.
+func (tm *TypeMapper) UnmarshalGraphQLArgs(ctx Context, args map[string]interface{}, dest interface{}) error {
+	if dest == nil || reflect.TypeOf(dest).Kind() != reflect.Ptr {
+		panic("cannot unmarshal to non-pointer")
+	}
+	m := tm.getTypeMap(dest)
+
+	// GraphQL libraries give numbers as ints and floats of various sizes,
+	// which must be normalized as they would be decoded from JSON
+	data, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+
+	partial := map[string]interface{}{}
+	err = json.Unmarshal(data, &partial)
+	if err != nil {
+		return wrapJSONError(err)
+	}
+
+	return tm.unmarshalPartial(ctx, m, partial, dest)
+}
@@ -0,0 +1,130 @@
+package jsonmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"text/template"
+)
+
+// HALLink describes a link in a HAL "_links" section. Href is a template
+// which is rendered in the same way as a StringRenderer.
+type HALLink struct {
+	Rel   string
+	Href  string
+	Title string
+
+	// Templated indicates that the rendered href is itself a URI Template
+	// (RFC 6570) which clients must expand.
+	Templated bool
+}
+
+type halLinksMap struct {
+	links []HALLink
+	hrefs []*stringRenderer
+	rels  []string
+}
+
+// HALLinks returns a TypeMap which renders a HAL "_links" section. It is
+// typically used on a ReadOnly field named "_links", mapped from a field such
+// as the object's ID, with the rest of the object available to the templates
+// as .Parent. Links sharing a Rel are rendered as an array. funcs are made
+// available to the templates, along with those registered with
+// RegisterTemplateFuncs.
+func HALLinks(funcs template.FuncMap, links ...HALLink) TypeMap {
+	hm := &halLinksMap{
+		links: links,
+	}
+
+	seen := map[string]bool{}
+	for _, link := range links {
+		hm.hrefs = append(hm.hrefs, StringRendererFuncs(link.Href, funcs))
+		if !seen[link.Rel] {
+			seen[link.Rel] = true
+			hm.rels = append(hm.rels, link.Rel)
+		}
+	}
+
+	return hm
+}
+
+func (hm *halLinksMap) Unmarshal(ctx Context, parent *reflect.Value, partial interface{}, dstValue reflect.Value) error {
+	return nil
+}
+
+func (hm *halLinksMap) Marshal(ctx Context, parent *reflect.Value, src reflect.Value) (json.Marshaler, error) {
+	buf := &bytes.Buffer{}
+	err := hm.marshalTo(ctx, parent, src, buf)
+	if err != nil {
+		return nil, err
+	}
+	return RawMessage{buf.Bytes()}, nil
+}
+
+func (hm *halLinksMap) marshalTo(ctx Context, parent *reflect.Value, src reflect.Value, buf *bytes.Buffer) error {
+	rendered := make([][]byte, len(hm.links))
+	counts := map[string]int{}
+
+	for i, link := range hm.links {
+		href, err := hm.hrefs[i].render(ctx, parent, src)
+		if err != nil {
+			return err
+		}
+
+		obj := &bytes.Buffer{}
+		writeObjectKey(obj, "href")
+		marshalValueTo(href, obj)
+
+		if link.Templated {
+			writeObjectKey(obj, "templated")
+			obj.WriteString("true")
+		}
+
+		if link.Title != "" {
+			writeObjectKey(obj, "title")
+			marshalValueTo(link.Title, obj)
+		}
+
+		rendered[i] = obj.Bytes()
+		counts[link.Rel]++
+	}
+
+	buf.WriteByte('{')
+
+	for i, rel := range hm.rels {
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+
+		marshalValueTo(rel, buf)
+		buf.WriteByte(':')
+
+		if counts[rel] > 1 {
+			buf.WriteByte('[')
+		}
+
+		written := 0
+		for j, link := range hm.links {
+			if link.Rel != rel {
+				continue
+			}
+
+			if written != 0 {
+				buf.WriteByte(',')
+			}
+			written++
+
+			buf.WriteByte('{')
+			buf.Write(rendered[j])
+			buf.WriteByte('}')
+		}
+
+		if counts[rel] > 1 {
+			buf.WriteByte(']')
+		}
+	}
+
+	buf.WriteByte('}')
+
+	return nil
+}
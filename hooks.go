@@ -0,0 +1,74 @@
+package jsonmap
+
+import (
+	"reflect"
+)
+
+// PostUnmarshaler may be implemented by mapped types in order to check
+// invariants involving several fields, or to compute derived state. It is
+// called once all fields have been unmarshaled without error. Validation
+// errors it returns (typically created with NewValidationErrorWithField) are
+// reported alongside those of the fields; other errors are returned as-is.
+type PostUnmarshaler interface {
+	AfterUnmarshal(ctx Context) error
+}
+
+// afterUnmarshal calls the PostUnmarshaler of dstValue, if it implements one,
+// merging any validation errors into errs.
+func afterUnmarshal(ctx Context, dstValue reflect.Value, errs *ValidationError) error {
+	if len(errs.NestedErrors) != 0 || !dstValue.CanAddr() {
+		return nil
+	}
+
+	hook, ok := dstValue.Addr().Interface().(PostUnmarshaler)
+	if !ok {
+		return nil
+	}
+
+	err := hook.AfterUnmarshal(ctx)
+	if err == nil {
+		return nil
+	}
+
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		return err
+	}
+
+	if verr.Field != "" {
+		errs.AddError(verr)
+		return nil
+	}
+
+	errs.Message = verr.Message
+	for _, nested := range verr.NestedErrors {
+		errs.AddError(nested)
+	}
+	return nil
+}
+
+// PreMarshaler may be implemented by mapped types in order to compute derived
+// fields, or scrub state, immediately before they are marshaled. If the value
+// being marshaled isn't addressable, the hook is called on a copy of it.
+type PreMarshaler interface {
+	BeforeMarshal(ctx Context) error
+}
+
+var preMarshalerType = reflect.TypeOf((*PreMarshaler)(nil)).Elem()
+
+// beforeMarshal calls the PreMarshaler of the struct src, if it implements one,
+// returning the value which should then be marshaled.
+func beforeMarshal(ctx Context, src reflect.Value) (reflect.Value, error) {
+	if !reflect.PtrTo(src.Type()).Implements(preMarshalerType) {
+		return src, nil
+	}
+
+	if !src.CanAddr() {
+		ptr := reflect.New(src.Type())
+		ptr.Elem().Set(src)
+		src = ptr.Elem()
+	}
+
+	err := src.Addr().Interface().(PreMarshaler).BeforeMarshal(ctx)
+	return src, err
+}
@@ -0,0 +1,307 @@
+package jsonmap
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// QueryDecoder is implemented by both QueryMap and CompiledQueryMap.
+type QueryDecoder interface {
+	Decode(urlQuery map[string][]string, dst interface{}) error
+	DecodeHeader(headers http.Header, dst interface{}) error
+}
+
+// RequestBinder validates an entire HTTP request into a single struct. The
+// body is unmarshaled by the TypeMapper, while the query string, headers and
+// path parameters are each decoded by their own QueryDecoder. Any of these may
+// be left nil, in which case that part of the request is ignored.
+type RequestBinder struct {
+	TypeMapper *TypeMapper
+	Query      QueryDecoder
+	Header     QueryDecoder
+	Path       QueryDecoder
+
+	// PathParams extracts path parameters from a request. How this is done
+	// depends entirely on the router in use, so it must be provided if Path
+	// is set.
+	PathParams func(r *http.Request) map[string][]string
+
+	// SortErrors sorts the validation errors returned by Bind by path, so
+	// that they are ordered consistently regardless of the order of the
+	// document or of the sources. Array indices are compared numerically.
+	SortErrors bool
+
+	// MaxErrors, if non-zero, limits the number of validation errors
+	// returned by Bind across all sources. Those beyond the limit are
+	// replaced by a single error at the root path counting them, so that
+	// responses listing every error are bounded.
+	MaxErrors int
+}
+
+// Bind validates every part of the request into dst. Validation errors from
+// all sources are collected into a single MultiValidationError whose paths are
+// prefixed with the source they came from, e.g. "/body/name" or
+// "/query/limit".
+//
+// An empty body is ignored rather than being unmarshaled, so that requests
+// without one, such as a GET, may bind only their query, headers and path.
+func (b *RequestBinder) Bind(ctx Context, r *http.Request, dst interface{}) error {
+	errs := &MultiValidationError{}
+
+	if b.TypeMapper != nil {
+		data, err := readBody(r)
+		if err != nil {
+			return err
+		}
+
+		// Server requests always have a Body, even if it is empty, as is
+		// usual for a GET
+		if len(data) != 0 {
+			err = errs.addSourceErrors("body", b.TypeMapper.Unmarshal(ctx, data, dst))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if b.Query != nil {
+		err := errs.addSourceErrors("query", b.Query.Decode(r.URL.Query(), dst))
+		if err != nil {
+			return err
+		}
+	}
+
+	if b.Header != nil {
+		err := errs.addSourceErrors("header", b.Header.DecodeHeader(r.Header, dst))
+		if err != nil {
+			return err
+		}
+	}
+
+	if b.Path != nil {
+		if b.PathParams == nil {
+			panic("RequestBinder.Path requires PathParams to be set")
+		}
+
+		err := errs.addSourceErrors("path", b.Path.Decode(b.PathParams(r), dst))
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(errs.Errors()) == 0 {
+		return nil
+	}
+
+	if b.SortErrors {
+		sort.SliceStable(errs.NestedErrors, func(i, j int) bool {
+			return pathLess(errs.NestedErrors[i].Path, errs.NestedErrors[j].Path)
+		})
+	}
+
+	if b.MaxErrors > 0 && len(errs.NestedErrors) > b.MaxErrors {
+		more := moreErrors(len(errs.NestedErrors) - b.MaxErrors)
+		errs.NestedErrors = append(errs.NestedErrors[:b.MaxErrors:b.MaxErrors], NewFlattenedPathError("", more))
+	}
+
+	return errs
+}
+
+// pathLess reports whether the JSON pointer a sorts before b. Pointers are
+// compared token by token, with array indices compared numerically, such that
+// a pointer sorts before those it is a prefix of.
+func pathLess(a, b string) bool {
+	at := strings.Split(a, "/")
+	bt := strings.Split(b, "/")
+
+	for i := 0; i < len(at) && i < len(bt); i++ {
+		if at[i] == bt[i] {
+			continue
+		}
+
+		an, aerr := strconv.Atoi(at[i])
+		bn, berr := strconv.Atoi(bt[i])
+		if aerr == nil && berr == nil {
+			return an < bn
+		}
+		return at[i] < bt[i]
+	}
+
+	return len(at) < len(bt)
+}
+
+// addSourceErrors merges validation errors into e, prefixing their paths with
+// the given source. Errors which aren't validation errors are returned as-is.
+func (e *MultiValidationError) addSourceErrors(source string, err error) error {
+	switch ve := err.(type) {
+	case nil:
+		return nil
+	case *MultiValidationError:
+		for _, f := range ve.Errors() {
+			e.NestedErrors = append(e.NestedErrors, NewFlattenedPathError("/"+source+f.Path, f.Message))
+		}
+	case *ValidationError:
+		if ve.Field != "" {
+			e.AddError(ve, source)
+			break
+		}
+		if ve.Message != "" {
+			e.NestedErrors = append(e.NestedErrors, NewFlattenedPathError("/"+source, ve.Message))
+		}
+		for _, nested := range ve.NestedErrors {
+			e.AddError(nested, source)
+		}
+	default:
+		return err
+	}
+	return nil
+}
+
+// ProblemContentType is the media type used for validation error responses,
+// as described in RFC 7807.
+const ProblemContentType = "application/problem+json"
+
+type problemFieldError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+type problemDetails struct {
+	Type   string              `json:"type"`
+	Title  string              `json:"title"`
+	Status int                 `json:"status"`
+	Detail string              `json:"detail,omitempty"`
+	Errors []problemFieldError `json:"errors"`
+}
+
+// asMultiValidationError converts validation errors of either kind into a
+// MultiValidationError. A ValidationError's own message, if any, is reported
+// against the root path.
+func asMultiValidationError(err error) (*MultiValidationError, bool) {
+	switch e := err.(type) {
+	case *MultiValidationError:
+		return e, true
+	case *ValidationError:
+		me := &MultiValidationError{}
+		if e.Field != "" {
+			me.AddError(e)
+			return me, true
+		}
+		if e.Message != "" {
+			me.NestedErrors = append(me.NestedErrors, NewFlattenedPathError("", e.Message))
+		}
+		for _, nested := range e.NestedErrors {
+			me.AddError(nested)
+		}
+		return me, true
+	default:
+		return nil, false
+	}
+}
+
+// WriteValidationError writes a 400 application/problem+json response
+// describing err if it is a ValidationError or MultiValidationError, and
+// reports whether it did so. Other errors are left for the caller to handle.
+func WriteValidationError(w http.ResponseWriter, err error) bool {
+	me, ok := asMultiValidationError(err)
+	if !ok {
+		return false
+	}
+
+	problem := problemDetails{
+		Type:   "about:blank",
+		Title:  http.StatusText(http.StatusBadRequest),
+		Status: http.StatusBadRequest,
+		Errors: make([]problemFieldError, 0, len(me.Errors())),
+	}
+
+	for i, f := range me.Errors() {
+		if MaxReportedErrors > 0 && i >= MaxReportedErrors {
+			problem.Detail = moreErrors(len(me.Errors()) - i)
+			break
+		}
+		problem.Errors = append(problem.Errors, problemFieldError{
+			Path:    f.Path,
+			Message: f.Message,
+		})
+	}
+
+	data, merr := json.Marshal(problem)
+	if merr != nil {
+		// problemDetails contains only strings and ints
+		panic(merr)
+	}
+
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write(data)
+	return true
+}
+
+// HandlerFunc is an http.HandlerFunc which may fail.
+type HandlerFunc func(w http.ResponseWriter, r *http.Request) error
+
+// Middleware adapts a HandlerFunc to an http.Handler. Validation errors
+// returned by the handler are written as 400 problem responses, and any other
+// error is passed to onError. If onError is nil, other errors result in a
+// bare 500 response.
+func Middleware(h HandlerFunc, onError func(w http.ResponseWriter, r *http.Request, err error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := h(w, r)
+		if err == nil || WriteValidationError(w, err) {
+			return
+		}
+
+		if onError != nil {
+			onError(w, r, err)
+			return
+		}
+
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	})
+}
+
+// JSONContentType is the media type used for responses written by a
+// TypeMapper.
+const JSONContentType = "application/json"
+
+// WriteResponse marshals v and writes it to w with the given status code. The
+// Context is passed through to Marshal, so any context dependent rendering
+// applies as usual. Nothing is written if marshaling fails.
+func (tm *TypeMapper) WriteResponse(ctx Context, w http.ResponseWriter, status int, v interface{}) error {
+	data, err := tm.Marshal(ctx, v)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, status, data)
+}
+
+// WriteEnvelopedResponse is like WriteResponse, but wraps the marshaled value
+// in an envelope as described by MarshalEnveloped.
+func (tm *TypeMapper) WriteEnvelopedResponse(ctx Context, w http.ResponseWriter, status int, v interface{}, meta interface{}) error {
+	data, err := tm.MarshalEnveloped(ctx, v, meta)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, status, data)
+}
+
+func writeJSON(w http.ResponseWriter, status int, data []byte) error {
+	w.Header().Set("Content-Type", JSONContentType)
+	w.WriteHeader(status)
+	_, err := w.Write(data)
+	return err
+}
+
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+	return ioutil.ReadAll(r.Body)
+}
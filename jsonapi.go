@@ -0,0 +1,287 @@
+package jsonmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+)
+
+// JSONAPIContentType is the media type of a JSON:API document.
+const JSONAPIContentType = "application/vnd.api+json"
+
+// JSONAPIMap maps a struct to a JSON:API resource object. The mapped field
+// named "id" becomes the resource's ID, fields marked as a Relationship are
+// placed among its relationships, and all other fields among its attributes.
+// A FieldSet selects among the attributes and relationships, as a JSON:API
+// sparse fieldset does, while the ID is always included.
+type JSONAPIMap struct {
+	Type string
+	Map  StructMap
+}
+
+// JSONAPI returns a JSONAPIMap for resources of the given type.
+func JSONAPI(resourceType string, sm StructMap) *JSONAPIMap {
+	return &JSONAPIMap{
+		Type: resourceType,
+		Map:  sm,
+	}
+}
+
+func (jm *JSONAPIMap) GetUnderlyingType() reflect.Type {
+	return jm.Map.GetUnderlyingType()
+}
+
+func (jm *JSONAPIMap) Unmarshal(ctx Context, parent *reflect.Value, partial interface{}, dstValue reflect.Value) error {
+	data, ok := partial.(map[string]interface{})
+	if !ok {
+		return NewValidationError("expected an object")
+	}
+
+	if data["type"] != jm.Type {
+		errs := &ValidationError{}
+		errs.AddError(NewValidationErrorWithField("type", "expected resource type: "+jm.Type))
+		return errs
+	}
+
+	attributes, _ := data["attributes"].(map[string]interface{})
+	relationships, _ := data["relationships"].(map[string]interface{})
+
+	// Flatten the resource object into the shape expected by the StructMap
+	flat := map[string]interface{}{}
+	for _, field := range jm.Map.Fields {
+		var val interface{}
+		var ok bool
+		switch {
+		case field.JSONFieldName == "id":
+			val, ok = data["id"]
+		case field.Relationship:
+			var rel map[string]interface{}
+			rel, ok = relationships[field.JSONFieldName].(map[string]interface{})
+			if ok {
+				val, ok = rel["data"]
+			}
+		default:
+			val, ok = attributes[field.JSONFieldName]
+		}
+
+		if ok {
+			flat[field.JSONFieldName] = val
+		}
+	}
+
+	err := jm.Map.Unmarshal(ctx, parent, flat, dstValue)
+	if verr, ok := err.(*ValidationError); ok {
+		return jm.resourceErrors(verr)
+	}
+	return err
+}
+
+// resourceErrors moves the errors reported by the StructMap for each field to
+// the field's location within the resource object.
+func (jm *JSONAPIMap) resourceErrors(err *ValidationError) *ValidationError {
+	relationships := map[string]bool{}
+	for _, field := range jm.Map.Fields {
+		if field.Relationship {
+			relationships[field.JSONFieldName] = true
+		}
+	}
+
+	errs := &ValidationError{}
+	attributeErrs := NewValidationErrorWithField("attributes", "")
+	relationshipErrs := NewValidationErrorWithField("relationships", "")
+
+	for _, e := range err.NestedErrors {
+		switch {
+		case e.Field == "id":
+			errs.AddError(e)
+		case relationships[e.Field]:
+			relationshipErr := NewValidationErrorWithField(e.Field, "")
+			relationshipErr.AddError(fieldError("data", e))
+			relationshipErrs.AddError(relationshipErr)
+		default:
+			attributeErrs.AddError(e)
+		}
+	}
+
+	if len(attributeErrs.NestedErrors) > 0 {
+		errs.AddError(attributeErrs)
+	}
+	if len(relationshipErrs.NestedErrors) > 0 {
+		errs.AddError(relationshipErrs)
+	}
+	return errs
+}
+
+func (jm *JSONAPIMap) Marshal(ctx Context, parent *reflect.Value, src reflect.Value) (json.Marshaler, error) {
+	buf := &bytes.Buffer{}
+	err := jm.marshalTo(ctx, parent, src, buf)
+	if err != nil {
+		return nil, err
+	}
+	return RawMessage{buf.Bytes()}, nil
+}
+
+func (jm *JSONAPIMap) marshalTo(ctx Context, parent *reflect.Value, src reflect.Value, buf *bytes.Buffer) error {
+	orig := src
+
+	// An Interface's Elem() returns a Ptr whose Elem() returns the actual value
+	if src.Kind() == reflect.Interface || src.Kind() == reflect.Ptr {
+		if src.IsNil() {
+			buf.Write(nullJSONValue)
+			return nil
+		}
+		src = src.Elem()
+	}
+
+	if src.Kind() == reflect.Ptr {
+		if src.IsNil() {
+			buf.Write(nullJSONValue)
+			return nil
+		}
+		src = src.Elem()
+	}
+
+	sm := jm.Map
+
+	expectedType := reflect.TypeOf(sm.UnderlyingType)
+	if src.Type() != expectedType {
+		return marshalUnmapped(ctx, orig, &UnmappedTypeError{src.Type(), expectedType}, buf)
+	}
+
+	src, err := beforeMarshal(ctx, src)
+	if err != nil {
+		return err
+	}
+
+	fs, err := sm.projection(ctx)
+	if err != nil {
+		return err
+	}
+
+	ctx = sm.marshalContext(ctx, src)
+
+	attributes := &bytes.Buffer{}
+	relationships := &bytes.Buffer{}
+
+	buf.WriteString(`{"type":`)
+	err = marshalValueTo(jm.Type, buf)
+	if err != nil {
+		return err
+	}
+
+	for _, field := range sm.Fields {
+		if !field.activeFor(ctx) {
+			continue
+		}
+
+		fieldCtx, ok := fs.selects(ctx, field.JSONFieldName)
+		if !ok {
+			// The ID identifies the resource, so is never left out
+			if field.JSONFieldName != "id" {
+				continue
+			}
+			fieldCtx = WithFieldSet(ctx, nil)
+		}
+
+		srcField, err := sm.fieldValue(src, field)
+		if err != nil {
+			return err
+		}
+
+		if field.OmitEmpty && srcField.IsZero() {
+			continue
+		}
+
+		var dst *bytes.Buffer
+		switch {
+		case field.JSONFieldName == "id":
+			buf.WriteString(`,"id":`)
+			dst = buf
+		case field.Relationship:
+			writeObjectKey(relationships, field.JSONFieldName)
+			relationships.WriteString(`{"data":`)
+			dst = relationships
+		default:
+			writeObjectKey(attributes, field.JSONFieldName)
+			dst = attributes
+		}
+
+		err = sm.marshalFieldTo(fieldCtx, src, field, srcField, dst)
+		if err != nil {
+			return projectionError(fs, field.JSONFieldName, err)
+		}
+
+		if field.Relationship {
+			relationships.WriteByte('}')
+		}
+	}
+
+	if attributes.Len() > 0 {
+		buf.WriteString(`,"attributes":{`)
+		buf.Write(attributes.Bytes())
+		buf.WriteByte('}')
+	}
+
+	if relationships.Len() > 0 {
+		buf.WriteString(`,"relationships":{`)
+		buf.Write(relationships.Bytes())
+		buf.WriteByte('}')
+	}
+
+	buf.WriteByte('}')
+
+	return nil
+}
+
+// writeObjectKey writes key to buf, which holds the members of a JSON object
+// under construction, preceded by a comma if necessary.
+func writeObjectKey(buf *bytes.Buffer, key string) {
+	if buf.Len() > 0 {
+		buf.WriteByte(',')
+	}
+	marshalValueTo(key, buf)
+	buf.WriteByte(':')
+}
+
+// MarshalJSONAPI marshals src, which must be mapped by a JSONAPIMap (or be a
+// slice of such values), as the primary data of a JSON:API document.
+func (tm *TypeMapper) MarshalJSONAPI(ctx Context, src interface{}) ([]byte, error) {
+	data, err := tm.Marshal(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(`{"data":`)
+	buf.Write(data)
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSONAPI unmarshals the primary data of a JSON:API document into
+// dest, which must be mapped by a JSONAPIMap.
+func (tm *TypeMapper) UnmarshalJSONAPI(ctx Context, data []byte, dest interface{}) error {
+	doc := struct {
+		Data json.RawMessage `json:"data"`
+	}{}
+
+	err := json.Unmarshal(data, &doc)
+	if err != nil {
+		return wrapJSONError(err)
+	}
+
+	if doc.Data == nil {
+		return NewValidationError("missing primary data")
+	}
+
+	errs := &MultiValidationError{}
+	err = errs.addSourceErrors("data", tm.Unmarshal(ctx, doc.Data, dest))
+	if err != nil {
+		return err
+	}
+
+	if len(errs.Errors()) == 0 {
+		return nil
+	}
+	return errs
+}
@@ -5,9 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/rnd42/go-jsonpointer"
+	"math"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 )
@@ -37,6 +40,20 @@ func NewFlattenedPathError(path, message string) *FlattenedPathError {
 	}
 }
 
+// Limits on how much of a MultiValidationError is described, so that a
+// hostile document with many invalid values can't produce enormous log lines
+// or responses. Errors beyond the limits are summarized by a count, and a
+// limit of zero disables it. Errors() always returns every error.
+var (
+	// MaxReportedErrors limits the number of errors described by Error() and
+	// by WriteValidationError().
+	MaxReportedErrors = 100
+
+	// MaxErrorLength limits the length in bytes of the string returned by
+	// Error().
+	MaxErrorLength = 16 * 1024
+)
+
 type MultiValidationError struct {
 	NestedErrors []*FlattenedPathError
 }
@@ -48,12 +65,25 @@ func (e *MultiValidationError) Errors() []*FlattenedPathError {
 func (e *MultiValidationError) Error() string {
 	b := strings.Builder{}
 	b.WriteString("Validation Errors: \n")
-	for _, f := range e.NestedErrors {
-		b.WriteString(f.String())
+	for i, f := range e.NestedErrors {
+		line := f.String()
+		if (MaxReportedErrors > 0 && i >= MaxReportedErrors) || (MaxErrorLength > 0 && b.Len()+len(line) > MaxErrorLength) {
+			b.WriteString(moreErrors(len(e.NestedErrors) - i))
+			b.WriteString("\n")
+			break
+		}
+		b.WriteString(line)
 	}
 	return b.String()
 }
 
+func moreErrors(n int) string {
+	if n == 1 {
+		return "...and 1 more error"
+	}
+	return fmt.Sprintf("...and %d more errors", n)
+}
+
 func (e *MultiValidationError) AddError(err *ValidationError, path ...string) {
 	path = append(path, err.Field)
 	pointer := jsonpointer.NewJSONPointerFromTokens(&path)
@@ -139,11 +169,48 @@ type MappedField struct {
 	Validator        Validator
 	Optional         bool
 	ReadOnly         bool
+
+	// OmitEmpty leaves the field out when marshaling if its value is the zero
+	// value of its type.
+	OmitEmpty bool
+
+	// InitIfAbsent sets a nil pointer field to a new value, with defaults
+	// applied by its Defaulter, if the field is Optional and absent from the
+	// document, so that handlers needn't check for nil. Nested fields marked
+	// InitIfAbsent are initialized in turn.
+	InitIfAbsent bool
+
+	// SinceVersion and UntilVersion restrict the field to a range of API
+	// versions (inclusive), as specified by WithVersion. Zero leaves the range
+	// unbounded. When no version is specified the latest applies, so fields
+	// with an UntilVersion are inactive. Fields sharing a JSON name must have
+	// ranges which don't overlap.
+	SinceVersion int
+	UntilVersion int
+
+	// Relationship places the field among the relationships, rather than the
+	// attributes, of a JSON:API resource object.
+	Relationship bool
+
+	// Redact replaces the value of the field with RedactedValue when it is
+	// marshaled by MarshalForLogging, such as for passwords and tokens. It
+	// doesn't affect Marshal.
+	Redact bool
 }
 
 type StructMap struct {
 	UnderlyingType interface{}
 	Fields         []MappedField
+
+	// ContextTransform, if set, returns the Context with which the fields of
+	// each struct are marshaled, given a pointer to the struct, after any
+	// PreMarshaler has been called. This passes state specific to the struct
+	// on to the TypeMaps of its fields, and so to those of nested values, so
+	// that a StringRenderer template, for example, may use it through
+	// {{ .Context.Value "key" }}. The Context returned should normally be
+	// derived from ctx using NewCtx(ctx).With(), so that the state it carries
+	// is kept.
+	ContextTransform func(ctx Context, value interface{}) Context
 }
 
 type RawMessage struct {
@@ -177,76 +244,129 @@ func (sm StructMap) Unmarshal(ctx Context, parent *reflect.Value, partial interf
 	}
 
 	if dstValue.Kind() == reflect.Ptr {
-		dstValue.Set(reflect.New(reflect.TypeOf(sm.UnderlyingType)))
-		dstValue = dstValue.Elem()
+		dstValue = structDest(ctx, dstValue, reflect.TypeOf(sm.UnderlyingType))
 	}
 
+	applyDefaults(ctx, dstValue)
+	absent := newResetter(ctx, dstValue.Type())
+
 	errs := &ValidationError{}
 
 	for _, field := range sm.Fields {
-		if field.ReadOnly {
+		if field.ReadOnly || !field.activeFor(ctx) {
 			continue
 		}
 
 		// TODO: Setters
-		dstField := dstValue.FieldByName(field.StructFieldName)
+		dstField := fieldByName(dstValue, field.StructFieldName)
 		if !dstField.IsValid() {
 			panic("no such underlying field: " + field.StructFieldName)
 		}
 
-		val, ok := data[field.JSONFieldName]
+		val, name, ok := field.lookupField(ctx, data)
 		if !ok {
 			if field.Optional {
+				traceField(ctx, name, TraceFieldMissing, "optional")
+				absent.reset(field, dstField)
+				if field.InitIfAbsent {
+					initAbsent(ctx, field, dstField, map[reflect.Type]bool{})
+				}
 				continue
 			} else {
-				err := NewValidationErrorWithField(field.JSONFieldName, "missing required field")
+				traceField(ctx, name, TraceFieldMissing, "required")
+				err := NewValidationErrorWithField(name, "missing required field")
 				errs.AddError(err)
 				continue
 			}
 		}
 
-		if val == nil && field.Optional {
+		if val == nil && field.skipsNull() {
+			traceField(ctx, field.JSONFieldName, TraceFieldSkipped, "null")
+			absent.reset(field, dstField)
 			continue
 		}
 
-		var err error
-
-		if field.Contains != nil {
-			err = field.Contains.Unmarshal(ctx, &dstValue, val, dstField)
-		} else if field.Validator != nil {
-			val, err = field.Validator.Validate(val)
-			// Check reflect.ValueOf(val).IsValid() instead of err == nil if returning the invalid input in Validate
-			if err == nil {
-				dstField.Set(reflect.ValueOf(val))
-			}
-		} else {
-			panic("Field must have Contains or Validator: " + field.JSONFieldName)
-		}
-
+		err := sm.unmarshalField(ctx, &dstValue, field, val, dstField)
 		if err != nil {
-			switch e := err.(type) {
-			case *ValidationError:
-				e.SetField(field.JSONFieldName)
-				errs.AddError(e)
-			default:
-				ve := NewValidationErrorWithField(field.JSONFieldName, e.Error())
-				errs.AddError(ve)
-			}
+			errs.AddError(err)
 		}
 	}
 
-	if len(errs.NestedErrors) != 0 {
+	if traceOf(ctx) != nil {
+		sm.traceIgnored(ctx, data)
+	}
+
+	err := afterUnmarshal(ctx, dstValue, errs)
+	if err != nil {
+		return err
+	}
+
+	if len(errs.NestedErrors) != 0 || errs.Message != "" {
 		return errs
 	}
 
 	return nil
 }
 
+// unmarshalField validates val into dstField, returning any error attributed
+// to the field.
+func (sm StructMap) unmarshalField(ctx Context, parent *reflect.Value, field MappedField, val interface{}, dstField reflect.Value) *ValidationError {
+	var err error
+
+	ctx = pathContext(ctx, field.wireName(ctx))
+	trace(ctx, TraceFieldMatched, "%s", field.StructFieldName)
+
+	if field.Contains != nil {
+		err = field.Contains.Unmarshal(expansionContext(ctx, field.JSONFieldName), parent, val, dstField)
+	} else if field.Validator != nil {
+		input := val
+		val, err = ValidateWithContext(ctx, field.Validator, val)
+		traceValidated(ctx, input, val, err)
+		// Check reflect.ValueOf(val).IsValid() instead of err == nil if returning the invalid input in Validate
+		if err == nil {
+			dstField.Set(reflect.ValueOf(val))
+		}
+	} else {
+		panic("Field must have Contains or Validator: " + field.JSONFieldName)
+	}
+
+	if err != nil {
+		return fieldError(field.wireName(ctx), err)
+	}
+
+	return nil
+}
+
+// fieldError attributes err to the named field, converting it to a
+// ValidationError if necessary.
+func fieldError(field string, err error) *ValidationError {
+	switch e := err.(type) {
+	case *ValidationError:
+		e.SetField(field)
+		return e
+	default:
+		return NewValidationErrorWithField(field, e.Error())
+	}
+}
+
 func (sm StructMap) marshalField(ctx Context, parent reflect.Value, field MappedField, srcField reflect.Value) ([]byte, error) {
+	ctx = pathContext(ctx, field.wireName(ctx))
+	trace(ctx, TraceFieldMarshaled, "%s", field.sourceName())
+
+	if field.Redact && redacts(ctx) {
+		return json.Marshal(RedactedValue)
+	}
+
 	var val interface{}
 	if field.Contains != nil {
 		var err error
-		val, err = field.Contains.Marshal(ctx, &parent, srcField)
+		val, err = field.Contains.Marshal(expansionContext(ctx, field.JSONFieldName), &parent, srcField)
+		if err != nil {
+			return nil, err
+		}
+	} else if field.Validator != nil && validatesOnMarshal(ctx) {
+		var err error
+		val, err = validateForMarshal(ctx, field.Validator, srcField.Interface())
 		if err != nil {
 			return nil, err
 		}
@@ -254,12 +374,50 @@ func (sm StructMap) marshalField(ctx Context, parent reflect.Value, field Mapped
 		val = srcField.Interface()
 	}
 
-	return json.Marshal(val)
+	return json.Marshal(protoScalar(ctx, val))
+}
+
+// fieldValue resolves the value of a mapped field from a struct, either
+// directly or by calling its getter.
+func (sm StructMap) fieldValue(src reflect.Value, field MappedField) (reflect.Value, error) {
+	// TODO: Do validation ahead of time
+	if field.StructFieldName != "" {
+		srcField := fieldByName(src, field.StructFieldName)
+		if !srcField.IsValid() {
+			panic("no such underlying field: " + field.StructFieldName)
+		}
+		return srcField, nil
+	} else if field.StructGetterName != "" {
+		var srcGetter reflect.Value
+		if src.CanAddr() {
+			srcGetter = src.Addr().MethodByName(field.StructGetterName)
+		} else {
+			ptr := reflect.New(src.Type())
+			tmp := ptr.Elem()
+			tmp.Set(src)
+			srcGetter = ptr.MethodByName(field.StructGetterName)
+		}
+
+		if !srcGetter.IsValid() {
+			panic("no such underlying getter method: " + field.StructGetterName)
+		}
+		rets := srcGetter.Call([]reflect.Value{})
+		if len(rets) != 2 {
+			panic("invalid getter, should return (interface{}, error): " + field.StructGetterName)
+		}
+		if !rets[1].IsNil() {
+			return reflect.Value{}, rets[1].Interface().(error)
+		}
+		return rets[0], nil
+	} else {
+		panic("either StructFieldName or StructGetterName must be specified")
+	}
 }
 
 func (sm StructMap) Marshal(ctx Context, parent *reflect.Value, src reflect.Value) (json.Marshaler, error) {
 	buf := bytes.Buffer{}
 	isNil := false
+	orig := src
 
 	// An Interface's Elem() returns a Ptr whose Elem() returns the actual value
 	if src.Kind() == reflect.Interface {
@@ -277,63 +435,64 @@ func (sm StructMap) Marshal(ctx Context, parent *reflect.Value, src reflect.Valu
 	} else {
 		expectedType := reflect.TypeOf(sm.UnderlyingType)
 		if src.Type() != expectedType {
-			panic("wrong type: " + src.Type().String() + ", expected: " + expectedType.String())
+			return unmappedMarshaler(ctx, orig, &UnmappedTypeError{src.Type(), expectedType})
 		}
 
-		buf.WriteByte('{')
+		src, err := beforeMarshal(ctx, src)
+		if err != nil {
+			return nil, err
+		}
 
-		for i, field := range sm.Fields {
-			var srcField reflect.Value
+		fs, err := sm.projection(ctx)
+		if err != nil {
+			return nil, err
+		}
 
-			// TODO: Do validation ahead of time
-			if field.StructFieldName != "" {
-				srcField = src.FieldByName(field.StructFieldName)
-				if !srcField.IsValid() {
-					panic("no such underlying field: " + field.StructFieldName)
-				}
-			} else if field.StructGetterName != "" {
-				var srcGetter reflect.Value
-				if src.CanAddr() {
-					srcGetter = src.Addr().MethodByName(field.StructGetterName)
-				} else {
-					ptr := reflect.New(src.Type())
-					tmp := ptr.Elem()
-					tmp.Set(src)
-					srcGetter = ptr.MethodByName(field.StructGetterName)
-				}
+		ctx = sm.marshalContext(ctx, src)
 
-				if !srcGetter.IsValid() {
-					panic("no such underlying getter method: " + field.StructGetterName)
-				}
-				rets := srcGetter.Call([]reflect.Value{})
-				if len(rets) != 2 {
-					panic("invalid getter, should return (interface{}, error): " + field.StructGetterName)
-				}
-				if !rets[1].IsNil() {
-					return nil, rets[1].Interface().(error)
-				}
-				srcField = rets[0]
-			} else {
-				panic("either StructFieldName or StructGetterName must be specified")
+		buf.WriteByte('{')
+
+		written := 0
+		for _, field := range sm.Fields {
+			if !field.activeFor(ctx) {
+				traceField(ctx, field.JSONFieldName, TraceFieldSkipped, "not active for version")
+				continue
 			}
 
-			keybuf, err := json.Marshal(field.JSONFieldName)
+			fieldCtx, ok := fs.selects(ctx, field.JSONFieldName)
+			if !ok {
+				traceField(ctx, field.JSONFieldName, TraceFieldSkipped, "not selected")
+				continue
+			}
+
+			srcField, err := sm.fieldValue(src, field)
 			if err != nil {
 				return nil, err
 			}
 
-			valbuf, err := sm.marshalField(ctx, src, field, srcField)
+			if field.OmitEmpty && srcField.IsZero() {
+				traceField(ctx, field.JSONFieldName, TraceFieldSkipped, "empty")
+				continue
+			}
+
+			keybuf, err := json.Marshal(field.wireName(ctx))
 			if err != nil {
 				return nil, err
 			}
 
-			buf.Write(keybuf)
-			buf.WriteByte(':')
-			buf.Write(valbuf)
+			valbuf, err := sm.marshalField(fieldCtx, src, field, srcField)
+			if err != nil {
+				return nil, projectionError(fs, field.JSONFieldName, err)
+			}
 
-			if i != len(sm.Fields)-1 {
+			if written != 0 {
 				buf.WriteByte(',')
 			}
+			written++
+
+			buf.Write(keybuf)
+			buf.WriteByte(':')
+			buf.Write(valbuf)
 		}
 
 		buf.WriteByte('}')
@@ -346,36 +505,226 @@ type SliceMap struct {
 	Contains TypeMap
 	MinLen   *int
 	MaxLen   *int
+
+	// Nulls defines how null elements are unmarshaled.
+	Nulls NullElements
+}
+
+// NullElements defines how a SliceMap unmarshals null elements.
+type NullElements int
+
+const (
+	// NullElementsPassed passes null elements to the element TypeMap like any
+	// other value. A StructMap rejects them for struct elements, and leaves
+	// pointer elements nil.
+	NullElementsPassed NullElements = iota
+
+	// NullElementsRejected reports null elements as validation errors.
+	NullElementsRejected
+
+	// NullElementsSkipped leaves null elements out of the slice. They don't
+	// count towards its length.
+	NullElementsSkipped
+
+	// NullElementsZero appends the zero value of the element type for null
+	// elements.
+	NullElementsZero
+)
+
+// nullElement handles the null element at index i according to sm.Nulls,
+// returning the slice with any resulting element appended, and whether the
+// element was handled.
+func (sm *SliceMap) nullElement(i int, result reflect.Value, errs *ValidationError) (reflect.Value, bool) {
+	switch sm.Nulls {
+	case NullElementsRejected:
+		errs.AddError(NewValidationErrorWithField(strconv.Itoa(i), "may not be null"))
+	case NullElementsSkipped:
+	case NullElementsZero:
+		result = reflect.Append(result, reflect.Zero(result.Type().Elem()))
+	default:
+		return result, false
+	}
+	return result, true
+}
+
+// elementAllocator builds the result of unmarshaling a list, allocating the
+// destinations of its elements in bulk rather than one at a time. Elements
+// are unmarshaled in place within the result, or for pointers to structs
+// mapped by a StructMap, within a shared backing slice of structs.
+type elementAllocator struct {
+	result reflect.Value
+
+	// structType is the type of struct pointed to by elements allocated from
+	// backing, or nil
+	structType reflect.Type
+	backing    reflect.Value
+	used       int
+}
+
+// newElementAllocator returns an elementAllocator for the slice dst with room
+// for n more elements, which may be zero if the number isn't known. Unless
+// the MergeMode requires dst to be replaced, the result starts with the
+// existing elements of dst.
+func newElementAllocator(ctx Context, sm *SliceMap, dst reflect.Value, n int) *elementAllocator {
+	t := dst.Type()
+	a := &elementAllocator{
+		result: reflect.Zero(t),
+	}
+
+	existing := 0
+	if appendsSlices(ctx) {
+		existing = dst.Len()
+	}
+
+	if existing+n != 0 {
+		a.result = reflect.MakeSlice(t, 0, existing+n)
+	}
+	if existing != 0 {
+		a.result = reflect.AppendSlice(a.result, dst)
+	}
+
+	elem := t.Elem()
+	if elem.Kind() == reflect.Ptr && elem.Elem().Kind() == reflect.Struct {
+		switch sm.Contains.(type) {
+		case StructMap, *StructMap:
+			a.structType = elem.Elem()
+			if n != 0 {
+				a.backing = reflect.MakeSlice(reflect.SliceOf(a.structType), n, n)
+			}
+		}
+	}
+
+	return a
+}
+
+// next returns the destination into which the next element is unmarshaled,
+// given whether it is null.
+func (a *elementAllocator) next(isNull bool) reflect.Value {
+	// Null pointers are left nil, as a StructMap would
+	if a.structType != nil && !isNull {
+		if !a.backing.IsValid() || a.used == a.backing.Len() {
+			// Allocate in chunks when the number of elements isn't known
+			size := a.result.Len() + 1
+			if size > 1024 {
+				size = 1024
+			}
+			a.backing = reflect.MakeSlice(reflect.SliceOf(a.structType), size, size)
+			a.used = 0
+		}
+		a.used++
+		return a.backing.Index(a.used - 1)
+	}
+
+	a.result = reflect.Append(a.result, reflect.Zero(a.result.Type().Elem()))
+	return a.result.Index(a.result.Len() - 1)
+}
+
+// keep adds dst, as returned by next, to the result.
+func (a *elementAllocator) keep(dst reflect.Value) {
+	if dst.Type() != a.result.Type().Elem() {
+		a.result = reflect.Append(a.result, dst.Addr())
+	}
+}
+
+// discard abandons dst, as returned by next, after it failed to unmarshal.
+func (a *elementAllocator) discard(dst reflect.Value) {
+	if dst.Type() == a.result.Type().Elem() {
+		a.result = a.result.Slice(0, a.result.Len()-1)
+	}
+}
+
+// slice returns the result, which is nil if it has no elements.
+func (a *elementAllocator) slice() reflect.Value {
+	if a.result.Len() == 0 {
+		return reflect.Zero(a.result.Type())
+	}
+	return a.result
+}
+
+// isIndirect reports whether dstValue is an interface{} or a pointer, into
+// which a container is unmarshaled by unmarshalIndirect.
+func isIndirect(dstValue reflect.Value) bool {
+	return dstValue.Kind() == reflect.Interface || dstValue.Kind() == reflect.Ptr
+}
+
+// unmarshalIndirect unmarshals a container into a new value for the
+// interface{} or pointer dstValue, which is set only if unmarshal succeeds.
+// An interface{} receives a value of type dynamic, such as []interface{}.
+func unmarshalIndirect(dstValue reflect.Value, dynamic reflect.Type, unmarshal func(dst reflect.Value) error) error {
+	t := dynamic
+	if dstValue.Kind() == reflect.Ptr {
+		t = dstValue.Type().Elem()
+	}
+
+	v := reflect.New(t)
+	err := unmarshal(v.Elem())
+	if err != nil {
+		return err
+	}
+
+	if dstValue.Kind() == reflect.Ptr {
+		dstValue.Set(v)
+	} else {
+		dstValue.Set(v.Elem())
+	}
+	return nil
 }
 
 func (sm SliceMap) Unmarshal(ctx Context, parent *reflect.Value, partial interface{}, dstValue reflect.Value) error {
+	// As with a StructMap, null leaves an interface{} or pointer nil
+	if partial == nil && isIndirect(dstValue) {
+		return nil
+	}
+
 	data, ok := partial.([]interface{})
 	if !ok {
 		return NewValidationError("expected a list")
 	}
 
-	err := sm.validateSliceWithinRange(data)
+	// An interface{} receives a []interface{}
+	if isIndirect(dstValue) {
+		return unmarshalIndirect(dstValue, interfaceSliceType, func(dst reflect.Value) error {
+			return sm.Unmarshal(ctx, parent, partial, dst)
+		})
+	}
+
+	n := len(data)
+	if sm.Nulls == NullElementsSkipped {
+		for _, val := range data {
+			if val == nil {
+				n--
+			}
+		}
+	}
+
+	err := sm.validateSliceWithinRange(n)
 	if err != nil {
 		return err
 	}
 
 	// Appending to a reflect.Value returns a new reflect.Value despite the
-	// indirection. So we'll keep a reference to the original one, and Set()
-	// it when we're done constructing the desired Value.
-	result := dstValue
-
-	elementType := dstValue.Type().Elem()
+	// indirection. So we'll build the slice up separately, starting with any
+	// existing elements, and Set() it when we're done constructing the
+	// desired Value.
+	a := newElementAllocator(ctx, &sm, dstValue, n)
 
 	errs := &ValidationError{}
 
 	for i, val := range data {
-		// Note: reflect.New() returns a pointer Value, so we have to take its
-		// Elem() before putting it to use
-		dstElem := reflect.New(elementType).Elem()
+		if val == nil {
+			var handled bool
+			a.result, handled = sm.nullElement(i, a.result, errs)
+			if handled {
+				continue
+			}
+		}
+
+		dstElem := a.next(val == nil)
 
-		err := sm.Contains.Unmarshal(ctx, &dstValue, val, dstElem)
+		err := sm.Contains.Unmarshal(elementContext(ctx, i), &dstValue, val, dstElem)
 
 		if err != nil {
+			a.discard(dstElem)
 
 			switch e := err.(type) {
 			case *ValidationError:
@@ -389,7 +738,7 @@ func (sm SliceMap) Unmarshal(ctx Context, parent *reflect.Value, partial interfa
 			continue
 		}
 
-		result = reflect.Append(result, dstElem)
+		a.keep(dstElem)
 	}
 
 	if len(errs.NestedErrors) != 0 {
@@ -399,24 +748,21 @@ func (sm SliceMap) Unmarshal(ctx Context, parent *reflect.Value, partial interfa
 	// Note: this actually works with a reflect.Value of a slice, even though it
 	// wouldn't work with an actual slice because of the second level of
 	// indirection.
-	dstValue.Set(result)
+	dstValue.Set(a.slice())
 
 	return nil
 }
 
 func (sm SliceMap) Marshal(ctx Context, parent *reflect.Value, src reflect.Value) (json.Marshaler, error) {
-	if src.Kind() == reflect.Ptr {
-		src = src.Elem()
-	}
-
-	if src.IsNil() {
+	src, ok := containerSource(src)
+	if !ok || src.IsNil() {
 		return nullRawMessage, nil
 	}
 
 	result := make([]interface{}, src.Len())
 
 	for i := 0; i < src.Len(); i++ {
-		data, err := sm.Contains.Marshal(ctx, &src, src.Index(i))
+		data, err := sm.Contains.Marshal(elementContext(ctx, i), &src, src.Index(i))
 		if err != nil {
 			return nil, err
 		}
@@ -445,6 +791,15 @@ func SliceOfMax(elem TypeMap, max int) TypeMap {
 	}
 }
 
+// SliceOfNulls returns a TypeMap for slices whose null elements are handled
+// as specified. See NullElements.
+func SliceOfNulls(elem TypeMap, nulls NullElements) TypeMap {
+	return SliceMap{
+		Contains: elem,
+		Nulls:    nulls,
+	}
+}
+
 func SliceOfMin(elem TypeMap, min int) TypeMap {
 	return SliceMap{
 		Contains: elem,
@@ -460,22 +815,22 @@ func SliceOfRange(elem TypeMap, min, max int) TypeMap {
 	}
 }
 
-func (sm *SliceMap) validateSliceWithinRange(data []interface{}) error {
+func (sm *SliceMap) validateSliceWithinRange(n int) error {
 	if sm.MaxLen == nil && sm.MinLen == nil {
 		return nil
 	} else if sm.MaxLen == nil {
-		if len(data) < *sm.MinLen {
+		if n < *sm.MinLen {
 			return NewValidationError("must have at least %d elements", *sm.MinLen)
 		}
 	} else if sm.MinLen == nil {
-		if len(data) > *sm.MaxLen {
+		if n > *sm.MaxLen {
 			return NewValidationError("must have at most %d elements", *sm.MaxLen)
 		}
 	} else if *sm.MaxLen == *sm.MinLen {
-		if len(data) != *sm.MaxLen {
+		if n != *sm.MaxLen {
 			return NewValidationError("must have %d elements", *sm.MaxLen)
 		}
-	} else if len(data) > *sm.MaxLen || len(data) < *sm.MinLen {
+	} else if n > *sm.MaxLen || n < *sm.MinLen {
 		return NewValidationError("must have between %d and %d elements", *sm.MinLen, *sm.MaxLen)
 	}
 
@@ -484,27 +839,70 @@ func (sm *SliceMap) validateSliceWithinRange(data []interface{}) error {
 
 type MapMap struct {
 	Contains TypeMap
+
+	// EmptyIfNil marshals nil maps as {} rather than null, for clients which
+	// can't handle null objects. See also TypeMapper.EmptyNilMaps.
+	EmptyIfNil bool
+}
+
+type emptyNilMapsContextKey struct{}
+
+var emptyJSONObject = []byte("{}")
+
+// nilValue returns the JSON value to which a nil map is marshaled.
+func (mm MapMap) nilValue(ctx Context) []byte {
+	if mm.EmptyIfNil {
+		return emptyJSONObject
+	}
+
+	if c, ok := ctx.(*Ctx); ok {
+		if _, ok := c.Get(emptyNilMapsContextKey{}); ok {
+			return emptyJSONObject
+		}
+	}
+	return nullJSONValue
 }
 
 func (mm MapMap) Unmarshal(ctx Context, parent *reflect.Value, partial interface{}, dstValue reflect.Value) error {
+	// As with a StructMap, null leaves an interface{} or pointer nil
+	if partial == nil && isIndirect(dstValue) {
+		return nil
+	}
+
 	data, ok := partial.(map[string]interface{})
 	if !ok {
 		return NewValidationError("expected a map")
 	}
 
+	// An interface{} receives a map[string]interface{}
+	if isIndirect(dstValue) {
+		return unmarshalIndirect(dstValue, dynamicMapType, func(dst reflect.Value) error {
+			return mm.Unmarshal(ctx, parent, partial, dst)
+		})
+	}
+
 	errs := &ValidationError{}
 
 	// Maps default to nil, so we need to make() one
 	dstValue.Set(reflect.MakeMap(dstValue.Type()))
 
 	elementType := dstValue.Type().Elem()
+	keyType := dstValue.Type().Key()
+
+	// Visit the keys in order, so that errors are reported in a consistent
+	// order rather than that in which Go happens to iterate over the map
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
 
-	for key, val := range data {
+	for _, key := range keys {
 		// Note: reflect.New() returns a pointer Value, so we have to take its
 		// Elem() before putting it to use
 		dstElem := reflect.New(elementType).Elem()
 
-		err := mm.Contains.Unmarshal(ctx, &dstValue, val, dstElem)
+		err := mm.Contains.Unmarshal(pathContext(ctx, key), &dstValue, data[key], dstElem)
 
 		if err != nil {
 			switch e := err.(type) {
@@ -519,7 +917,7 @@ func (mm MapMap) Unmarshal(ctx Context, parent *reflect.Value, partial interface
 			continue
 		}
 
-		dstValue.SetMapIndex(reflect.ValueOf(key), dstElem)
+		dstValue.SetMapIndex(reflect.ValueOf(key).Convert(keyType), dstElem)
 	}
 	if len(errs.NestedErrors) != 0 {
 		return errs
@@ -529,23 +927,19 @@ func (mm MapMap) Unmarshal(ctx Context, parent *reflect.Value, partial interface
 }
 
 func (mm MapMap) Marshal(ctx Context, parent *reflect.Value, src reflect.Value) (json.Marshaler, error) {
-	if src.Kind() == reflect.Ptr {
-		src = src.Elem()
+	src, ok := containerSource(src)
+	if !ok {
+		return nullRawMessage, nil
 	}
 
 	if src.IsNil() {
-		return nullRawMessage, nil
+		return RawMessage{mm.nilValue(ctx)}, nil
 	}
 
 	result := make(map[string]interface{})
-	keys := src.MapKeys()
-
-	if src.Type().Key().Kind() != reflect.String {
-		panic("key must be a string")
-	}
 
-	for _, key := range keys {
-		data, err := mm.Contains.Marshal(ctx, &src, src.MapIndex(key))
+	for _, key := range sortedMapKeys(src) {
+		data, err := mm.Contains.Marshal(pathContext(ctx, key.String()), &src, src.MapIndex(key))
 		if err != nil {
 			return nil, err
 		}
@@ -561,6 +955,9 @@ func (mm MapMap) Marshal(ctx Context, parent *reflect.Value, src reflect.Value)
 	return RawMessage{data}, nil
 }
 
+// MapOf returns a TypeMap for maps with string keys, whose values are mapped
+// by elem. Keys are marshaled in sorted order, and errors are reported in the
+// order of their keys; use OrderedMapOf() where the order of keys matters.
 func MapOf(elem TypeMap) TypeMap {
 	return &MapMap{
 		Contains: elem,
@@ -581,8 +978,8 @@ type Discriminator struct {
 	Mapping      map[string]TypeMap
 }
 
-func (vt *Discriminator) pickTypeMap(parent *reflect.Value) (TypeMap, error) {
-	typeKeyField := parent.FieldByName(vt.PropertyName)
+func (vt *Discriminator) pickTypeMap(ctx Context, parent *reflect.Value) (TypeMap, error) {
+	typeKeyField := fieldByName(*parent, vt.PropertyName)
 	if !typeKeyField.IsValid() {
 		panic("no such underlying field: " + vt.PropertyName)
 	}
@@ -602,6 +999,8 @@ func (vt *Discriminator) pickTypeMap(parent *reflect.Value) (TypeMap, error) {
 	typeMap, ok := vt.Mapping[keyString]
 
 	if !ok {
+		trace(ctx, TraceVariantSelected, "no variant for %s %q", vt.PropertyName, keyString)
+
 		// NOTE: This error message isn't great because we don't have a way to know
 		// the JSON field name uponw which we're switching.
 		//TODO: include JSON field name uponw which we're switching to other error messages
@@ -620,11 +1019,12 @@ func (vt *Discriminator) pickTypeMap(parent *reflect.Value) (TypeMap, error) {
 		return nil, NewValidationError("invalid type identifier")
 	}
 
+	trace(ctx, TraceVariantSelected, "%q, from %s", keyString, vt.PropertyName)
 	return typeMap, nil
 }
 
 func (vt *Discriminator) Unmarshal(ctx Context, parent *reflect.Value, partial interface{}, dstValue reflect.Value) error {
-	tm, err := vt.pickTypeMap(parent)
+	tm, err := vt.pickTypeMap(ctx, parent)
 	if err != nil {
 		return err
 	}
@@ -632,14 +1032,31 @@ func (vt *Discriminator) Unmarshal(ctx Context, parent *reflect.Value, partial i
 	return tm.Unmarshal(ctx, parent, partial, dstValue)
 }
 
+// unmarshalStream streams the value to the TypeMap for its type, which must
+// already be known, so that TypeMaps such as OrderedMapOf see the value as it
+// was written. StructMaps call it once the rest of the object has been
+// unmarshaled.
+func (vt *Discriminator) unmarshalStream(ctx Context, parent *reflect.Value, ts *tokenStream, dstValue reflect.Value) error {
+	tm, err := vt.pickTypeMap(ctx, parent)
+	if err != nil {
+		serr := ts.skipValue()
+		if serr != nil {
+			return serr
+		}
+		return err
+	}
+
+	return unmarshalStream(ctx, tm, parent, ts, dstValue)
+}
+
 func (vt *Discriminator) Marshal(ctx Context, parent *reflect.Value, src reflect.Value) (json.Marshaler, error) {
 	if src.IsZero() {
 		return nullRawMessage, nil
 	}
 
-	tm, err := vt.pickTypeMap(parent)
+	tm, err := vt.pickTypeMap(ctx, parent)
 	if err != nil {
-		panic("variable type serialization error: " + err.Error())
+		return unmappedMarshaler(ctx, src, variableTypeError(err))
 	}
 
 	return tm.Marshal(ctx, parent, src)
@@ -667,18 +1084,102 @@ func (sr *stringRenderer) Unmarshal(ctx Context, parent *reflect.Value, partial
 }
 
 func (sr *stringRenderer) Marshal(ctx Context, parent *reflect.Value, src reflect.Value) (json.Marshaler, error) {
+	rendered, err := sr.render(ctx, parent, src)
+	if err != nil {
+		return nil, err
+	}
+
+	marshalled, err := json.Marshal(rendered)
+	if err != nil {
+		return nil, err
+	}
+
+	return RawMessage{marshalled}, nil
+}
+
+func (sr *stringRenderer) render(ctx Context, parent *reflect.Value, src reflect.Value) (string, error) {
 	buf := bytes.Buffer{}
 	err := sr.template.Execute(&buf, RenderInfo{
 		Context: ctx,
 		Parent:  parent.Interface(),
 		Value:   src.Interface(),
 	})
+	return buf.String(), err
+}
+
+// templateFuncs are made available to every template parsed by the
+// StringRenderer family of functions.
+var (
+	templateFuncsMu sync.RWMutex
+	templateFuncs   = template.FuncMap{}
+)
+
+// RegisterTemplateFuncs makes funcs available to all StringRenderer templates.
+// Templates are parsed when their renderer is constructed, so only those
+// constructed afterwards see the funcs. Renderers declared in package level
+// variables are constructed before any init function runs, so should be
+// given their funcs with StringRendererFuncs instead.
+func RegisterTemplateFuncs(funcs template.FuncMap) {
+	templateFuncsMu.Lock()
+	defer templateFuncsMu.Unlock()
+
+	for name, f := range funcs {
+		templateFuncs[name] = f
+	}
+}
+
+// ParseStringRenderer parses a StringRenderer template, returning an error
+// rather than panicking if it is invalid. funcs are made available to the
+// template in addition to those registered with RegisterTemplateFuncs.
+func ParseStringRenderer(text string, funcs template.FuncMap) (*stringRenderer, error) {
+	templateFuncsMu.RLock()
+	t := template.New("").Funcs(templateFuncs)
+	templateFuncsMu.RUnlock()
+
+	t, err := t.Funcs(funcs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stringRenderer{
+		template: t,
+	}, nil
+}
+
+// StringRendererFuncs is like StringRenderer, but makes funcs available to the
+// template.
+func StringRendererFuncs(text string, funcs template.FuncMap) *stringRenderer {
+	sr, err := ParseStringRenderer(text, funcs)
+	if err != nil {
+		panic(err)
+	}
+	return sr
+}
+
+func StringRenderer(text string) *stringRenderer {
+	return StringRendererFuncs(text, nil)
+}
+
+type valueRenderer struct {
+	render func(RenderInfo) (interface{}, error)
+}
+
+func (vr *valueRenderer) Unmarshal(ctx Context, parent *reflect.Value, partial interface{}, dstValue reflect.Value) error {
+	return nil
+}
+
+func (vr *valueRenderer) Marshal(ctx Context, parent *reflect.Value, src reflect.Value) (json.Marshaler, error) {
+	val, err := vr.render(RenderInfo{
+		Context: ctx,
+		Parent:  parent.Interface(),
+		Value:   src.Interface(),
+	})
 
 	if err != nil {
 		return nil, err
 	}
 
-	marshalled, err := json.Marshal(buf.String())
+	marshalled, err := json.Marshal(val)
 	if err != nil {
 		return nil, err
 	}
@@ -686,16 +1187,61 @@ func (sr *stringRenderer) Marshal(ctx Context, parent *reflect.Value, src reflec
 	return RawMessage{marshalled}, nil
 }
 
-func StringRenderer(text string) *stringRenderer {
-	return &stringRenderer{
-		template: template.Must(template.New("").Parse(text)),
+// ValueRenderer is like StringRenderer, but rather than rendering a template
+// to a string it renders any value computed by the given function, which is
+// then marshaled using encoding/json. This is useful for fields such as
+// "_links" objects. As with StringRenderer, the field is ignored on unmarshal.
+func ValueRenderer(render func(info RenderInfo) (interface{}, error)) *valueRenderer {
+	return &valueRenderer{
+		render: render,
 	}
 }
 
 type passthroughMarshaler struct{}
 
+type validateOnMarshalContextKey struct{}
+
+func validatesOnMarshal(ctx Context) bool {
+	c, ok := ctx.(*Ctx)
+	if !ok {
+		return false
+	}
+
+	_, ok = c.Get(validateOnMarshalContextKey{})
+	return ok
+}
+
+// validateForMarshal validates value with v, as if it had been unmarshaled,
+// returning the value to be marshaled in its place. Errors are attributed to
+// the path of the value.
+func validateForMarshal(ctx Context, v Validator, value interface{}) (interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var partial interface{}
+	err = json.Unmarshal(data, &partial)
+	if err != nil {
+		return nil, err
+	}
+
+	// Whether null is acceptable is a matter for the field, not its Validator
+	if partial == nil {
+		return nil, nil
+	}
+
+	result, err := ValidateWithContext(ctx, v, partial)
+	if err != nil {
+		errs := &ValidationError{}
+		errs.AddError(pathError(pathOf(ctx), err))
+		return nil, errs.Flatten()
+	}
+	return result, nil
+}
+
 func (m *passthroughMarshaler) Marshal(ctx Context, parent *reflect.Value, field reflect.Value) (json.Marshaler, error) {
-	data, err := json.Marshal(field.Interface())
+	data, err := json.Marshal(protoScalar(ctx, field.Interface()))
 	if err != nil {
 		return nil, err
 	}
@@ -708,18 +1254,104 @@ type PrimitiveMap struct {
 	V Validator
 }
 
+func (m *PrimitiveMap) Marshal(ctx Context, parent *reflect.Value, src reflect.Value) (json.Marshaler, error) {
+	if !validatesOnMarshal(ctx) {
+		return m.passthroughMarshaler.Marshal(ctx, parent, src)
+	}
+
+	buf := &bytes.Buffer{}
+	err := m.marshalTo(ctx, parent, src, buf)
+	if err != nil {
+		return nil, err
+	}
+	return RawMessage{buf.Bytes()}, nil
+}
+
+func (m *PrimitiveMap) marshalTo(ctx Context, parent *reflect.Value, src reflect.Value, buf *bytes.Buffer) error {
+	if !validatesOnMarshal(ctx) {
+		return m.passthroughMarshaler.marshalTo(ctx, parent, src, buf)
+	}
+
+	val, err := validateForMarshal(ctx, m.V, src.Interface())
+	if err != nil {
+		return err
+	}
+	return marshalValueTo(protoScalar(ctx, val), buf)
+}
+
 func (m *PrimitiveMap) Unmarshal(ctx Context, parent *reflect.Value, partial interface{}, dstValue reflect.Value) error {
-	val, err := m.V.Validate(partial)
+	val, err := ValidateWithContext(ctx, m.V, partial)
 	if err != nil {
 		return err
 	}
 
 	if val != nil {
-		dstValue.Set(reflect.ValueOf(val))
+		converted, err := convertScalar(reflect.ValueOf(val), dstValue.Type())
+		if err != nil {
+			return err
+		}
+		dstValue.Set(converted)
 	}
 	return nil
 }
 
+// convertScalar converts integers and floats to other types of the same
+// family, such as the int64 produced by an IntegerValidator to an int. Values
+// which don't fit in t are rejected. Other values are returned as-is.
+func convertScalar(v reflect.Value, t reflect.Type) (reflect.Value, error) {
+	if v.Type() == t {
+		return v, nil
+	}
+
+	dst := reflect.New(t).Elem()
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if dst.OverflowInt(v.Int()) {
+				return v, intRangeError(t, v.Int() < 0)
+			}
+			return v.Convert(t), nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		switch t.Kind() {
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if dst.OverflowUint(v.Uint()) {
+				return v, uintRangeError(t)
+			}
+			return v.Convert(t), nil
+		}
+	case reflect.Float32, reflect.Float64:
+		switch t.Kind() {
+		case reflect.Float32, reflect.Float64:
+			if dst.OverflowFloat(v.Float()) {
+				if v.Float() < 0 {
+					return v, NewValidationError("too small, must be at least %s", strconv.FormatFloat(-math.MaxFloat32, 'g', -1, 32))
+				}
+				return v, NewValidationError("too large, may not be larger than %s", strconv.FormatFloat(math.MaxFloat32, 'g', -1, 32))
+			}
+			return v.Convert(t), nil
+		}
+	}
+	return v, nil
+}
+
+// intRangeError returns the error for a number which doesn't fit in the
+// signed integer type t, being below its minimum if negative.
+func intRangeError(t reflect.Type, negative bool) *ValidationError {
+	if negative {
+		return NewValidationError("too small, must be at least %d", int64(-1)<<(t.Bits()-1))
+	}
+	return NewValidationError("too large, may not be larger than %d", int64(^uint64(0)>>(65-t.Bits())))
+}
+
+// uintRangeError returns the error for a number which doesn't fit in the
+// unsigned integer type t.
+func uintRangeError(t reflect.Type) *ValidationError {
+	return NewValidationError("too large, may not be larger than %d", ^uint64(0)>>(64-t.Bits()))
+}
+
 func NewPrimitiveMap(v Validator) TypeMap {
 	return &PrimitiveMap{
 		V: v,
@@ -728,6 +1360,10 @@ func NewPrimitiveMap(v Validator) TypeMap {
 
 type TimeMap struct {
 	passthroughMarshaler
+
+	// Precision, if non-zero, is the duration to which times are truncated
+	// when marshaling, such as time.Second or time.Millisecond.
+	Precision time.Duration
 }
 
 func (m *TimeMap) Unmarshal(ctx Context, parent *reflect.Value, partial interface{}, dstValue reflect.Value) error {
@@ -745,7 +1381,15 @@ func (m *TimeMap) Unmarshal(ctx Context, parent *reflect.Value, partial interfac
 	t, err := time.Parse(time.RFC3339, tstring)
 
 	if err != nil {
-		return NewValidationError("not a valid RFC 3339 time value")
+		l, ok := localeOf(ctx)
+		if !ok {
+			return NewValidationError("not a valid RFC 3339 time value")
+		}
+
+		t, ok = l.parseTime(tstring)
+		if !ok {
+			return NewValidationError("not a valid RFC 3339 time value")
+		}
 	}
 
 	dstValue.Set(reflect.ValueOf(t))
@@ -753,30 +1397,161 @@ func (m *TimeMap) Unmarshal(ctx Context, parent *reflect.Value, partial interfac
 	return nil
 }
 
+func (m *TimeMap) Marshal(ctx Context, parent *reflect.Value, src reflect.Value) (json.Marshaler, error) {
+	return m.passthroughMarshaler.Marshal(ctx, parent, m.wireValue(ctx, src))
+}
+
+func (m *TimeMap) marshalTo(ctx Context, parent *reflect.Value, src reflect.Value, buf *bytes.Buffer) error {
+	return m.passthroughMarshaler.marshalTo(ctx, parent, m.wireValue(ctx, src), buf)
+}
+
+// wireValue returns the value to marshal in place of src, which in ProtoJSON
+// mode is the time formatted as a google.protobuf.Timestamp.
+func (m *TimeMap) wireValue(ctx Context, src reflect.Value) reflect.Value {
+	src = m.truncate(src)
+	if !isProtoJSON(ctx) {
+		return src
+	}
+
+	t, ok := src.Interface().(time.Time)
+	if !ok {
+		panic("source field for jsonmap.Time() is not a time.Time")
+	}
+	return reflect.ValueOf(protoTimestamp(t))
+}
+
+func (m *TimeMap) truncate(src reflect.Value) reflect.Value {
+	if m.Precision == 0 {
+		return src
+	}
+
+	t, ok := src.Interface().(time.Time)
+	if !ok {
+		panic("source field for jsonmap.Time() is not a time.Time")
+	}
+	return reflect.ValueOf(t.Truncate(m.Precision))
+}
+
 func Time() TypeMap {
 	return &TimeMap{}
 }
 
+// TimeWithPrecision is like Time, but truncates times to the given precision
+// (such as time.Second or time.Millisecond) when marshaling.
+func TimeWithPrecision(precision time.Duration) TypeMap {
+	return &TimeMap{
+		Precision: precision,
+	}
+}
+
+// TypeMapper marshals and unmarshals values of the types for which TypeMaps
+// are registered. It is safe for concurrent use: the TypeMaps it holds are
+// never modified once constructed, and the caches they share are
+// synchronized, so a single TypeMapper may serve every request. Its options
+// must be set before it is put to use; to vary them, or the TypeMaps used,
+// derive another TypeMapper with Override. The Register functions of this
+// package, such as RegisterValidators, may be called at any time, though
+// templates and schema definitions only see what was registered before they
+// were parsed.
 type TypeMapper struct {
 	typeMaps map[reflect.Type]TypeMap
+
+	// FailFast stops unmarshaling at the first validation error, rather than
+	// reporting every error in the document.
+	FailFast bool
+
+	// EmptyNilMaps marshals nil maps mapped by MapOf() as {} rather than
+	// null. See also MapMap.EmptyIfNil.
+	EmptyNilMaps bool
+
+	// MergeMode defines how unmarshaling into an already populated value
+	// treats the values it holds.
+	MergeMode MergeMode
+
+	// ValidateOnMarshal validates the values of PrimitiveMaps and of fields
+	// with Validators when marshaling, as they would be when unmarshaling, so
+	// that invariants broken by modifying a struct directly are reported as
+	// errors rather than written out. The values returned by the Validators
+	// are marshaled in place of the originals.
+	ValidateOnMarshal bool
+
+	// UnmappedTypes defines how marshaling treats values which their TypeMaps
+	// can't marshal. By default it panics.
+	UnmappedTypes UnmappedTypes
+
+	// LegacyMarshal restores the original marshaling implementation, in which
+	// each TypeMap produced an intermediate value that was then re-encoded by
+	// its container. Output is identical, but some error messages differ.
+	LegacyMarshal bool
 }
 
 func NewTypeMapper(maps ...RegisterableTypeMap) *TypeMapper {
 	t := &TypeMapper{
 		typeMaps: make(map[reflect.Type]TypeMap),
 	}
+	visited := map[TypeMap]bool{}
 	for _, m := range maps {
 		t.typeMaps[m.GetUnderlyingType()] = m
+		warmFieldCache(m, visited)
 	}
 	return t
 }
 
 func (tm *TypeMapper) getTypeMap(obj interface{}) TypeMap {
+	m, ok := tm.lookupTypeMap(obj)
+	if !ok {
+		t := reflect.TypeOf(obj)
+		if t.Kind() == reflect.Ptr && isContainer(t.Elem()) {
+			t = t.Elem()
+		}
+		if isContainer(t) {
+			t = t.Elem()
+		}
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		panic("no TypeMap registered for type: " + t.String())
+	}
+	return m
+}
+
+// isContainer reports whether values of type t are mapped using the TypeMap
+// registered for the type of their elements: slices, and maps with string
+// keys.
+func isContainer(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice || (t.Kind() == reflect.Map && t.Key().Kind() == reflect.String)
+}
+
+// RegisteredTypes returns the types for which TypeMaps are registered, in no
+// particular order.
+func (tm *TypeMapper) RegisteredTypes() []reflect.Type {
+	types := make([]reflect.Type, 0, len(tm.typeMaps))
+	for t := range tm.typeMaps {
+		types = append(types, t)
+	}
+	return types
+}
+
+// TypeMap returns the TypeMap which would be used to marshal or unmarshal obj,
+// if any.
+func (tm *TypeMapper) TypeMap(obj interface{}) (TypeMap, bool) {
+	return tm.lookupTypeMap(obj)
+}
+
+// lookupTypeMap returns the TypeMap registered for the type of obj, if any.
+// Slices and maps with string keys, or pointers to them, are mapped with
+// SliceOf() or MapOf() the TypeMap registered for their elements.
+func (tm *TypeMapper) lookupTypeMap(obj interface{}) (TypeMap, bool) {
 	t := reflect.TypeOf(obj)
-	isSlice := false
 
-	if t.Kind() == reflect.Slice {
-		isSlice = true
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	// Map types may be registered in their own right, slice types may not
+	container := reflect.Invalid
+	if t.Kind() == reflect.Slice || (isContainer(t) && tm.typeMaps[t] == nil) {
+		container = t.Kind()
 		t = t.Elem()
 	}
 
@@ -787,46 +1562,51 @@ func (tm *TypeMapper) getTypeMap(obj interface{}) TypeMap {
 	m, ok := tm.typeMaps[t]
 
 	if !ok {
-		panic("no TypeMap registered for type: " + t.String())
+		return nil, false
 	}
 
-	if isSlice {
+	switch container {
+	case reflect.Slice:
 		m = SliceOf(m)
+	case reflect.Map:
+		m = MapOf(m)
 	}
 
-	return m
+	return m, true
 }
 
 func (tm *TypeMapper) Unmarshal(ctx Context, data []byte, dest interface{}) error {
-	if reflect.TypeOf(dest).Kind() != reflect.Ptr || dest == nil {
-		panic("cannot unmarshal to non-pointer")
+	return tm.UnmarshalReader(ctx, bytes.NewReader(data), dest)
+}
+
+// wrapJSONError converts errors returned by json.Unmarshal() into validation
+// errors where they may have been caused by invalid input.
+func wrapJSONError(err error) error {
+	// We attempt to wrap json parse/unmarshal errors that can be caused by invalid input by
+	// a validation error here. This is somewhat fragile and dependent on go's json impl.
+	switch e := err.(type) {
+	case *json.InvalidUnmarshalError:
+		panic(e)
+	case *json.SyntaxError:
+		return NewValidationError(e.Error())
+	case *json.UnmarshalTypeError:
+		return NewValidationError("json: cannot unmarshal, not an object")
+	default:
+		// These are exported errors, but deprecated according to documentation.
+		//case *json.InvalidUTF8Error:
+		//case *json.UnmarshalFieldError:
+		// These are exported errors, but only used for Marshal(). They are listed here for completeness.
+		//case *json.MarshalerError:
+		//case *json.UnsupportedTypeError:
+		//case *json.UnsupportedValueError:
+		return e
 	}
-	m := tm.getTypeMap(dest)
-	partial := map[string]interface{}{}
+}
 
-	err := json.Unmarshal(data, &partial)
-	if err != nil {
-		// We attempt to wrap json parse/unmarshal errors that can be caused by invalid input by
-		// a validation error here. This is somewhat fragile and dependent on go's json impl.
-		switch e := err.(type) {
-		case *json.InvalidUnmarshalError:
-			panic(e)
-		case *json.SyntaxError:
-			return NewValidationError(e.Error())
-		case *json.UnmarshalTypeError:
-			return NewValidationError("json: cannot unmarshal, not an object")
-		default:
-			// These are exported errors, but deprecated according to documentation.
-			//case *json.InvalidUTF8Error:
-			//case *json.UnmarshalFieldError:
-			// These are exported errors, but only used for Marshal(). They are listed here for completeness.
-			//case *json.MarshalerError:
-			//case *json.UnsupportedTypeError:
-			//case *json.UnsupportedValueError:
-			return e
-		}
-	}
-	err = m.Unmarshal(ctx, nil, partial, reflect.ValueOf(dest).Elem())
+// unmarshalPartial validates an already decoded document into dest, flattening
+// any resulting ValidationError.
+func (tm *TypeMapper) unmarshalPartial(ctx Context, m TypeMap, partial interface{}, dest interface{}) error {
+	err := m.Unmarshal(withMergeMode(ctx, tm.MergeMode), nil, partial, reflect.ValueOf(dest).Elem())
 	if err != nil {
 		if e, ok := err.(*ValidationError); ok {
 			return e.Flatten()
@@ -838,11 +1618,31 @@ func (tm *TypeMapper) Unmarshal(ctx Context, data []byte, dest interface{}) erro
 
 func (tm *TypeMapper) Marshal(ctx Context, src interface{}) ([]byte, error) {
 	m := tm.getTypeMap(src)
-	data, err := m.Marshal(ctx, nil, reflect.ValueOf(src))
+
+	if tm.EmptyNilMaps {
+		ctx = NewCtx(ctx).With(emptyNilMapsContextKey{}, true)
+	}
+
+	if tm.ValidateOnMarshal {
+		ctx = NewCtx(ctx).With(validateOnMarshalContextKey{}, true)
+	}
+
+	ctx = withUnmappedTypes(ctx, tm.UnmappedTypes)
+
+	if tm.LegacyMarshal {
+		data, err := m.Marshal(ctx, nil, reflect.ValueOf(src))
+		if err != nil {
+			return nil, err
+		}
+		return data.MarshalJSON()
+	}
+
+	buf := &bytes.Buffer{}
+	err := marshalTo(ctx, m, nil, reflect.ValueOf(src), buf)
 	if err != nil {
 		return nil, err
 	}
-	return data.MarshalJSON()
+	return buf.Bytes(), nil
 }
 
 func (tm *TypeMapper) MarshalIndent(ctx Context, src interface{}, prefix, indent string) ([]byte, error) {
@@ -1,13 +1,27 @@
 package jsonmap
 
 import (
+	"bytes"
+	"crypto/ed25519"
+	"database/sql"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
 	"reflect"
 	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"text/template"
 	"time"
 	"unicode/utf8"
 
@@ -166,8 +180,8 @@ type ThingWithEnumerableInterface struct {
 }
 
 var InnerThingTypeMap = StructMap{
-	InnerThing{},
-	[]MappedField{
+	UnderlyingType: InnerThing{},
+	Fields: []MappedField{
 		{
 			StructFieldName: "Foo",
 			JSONFieldName:   "foo",
@@ -190,8 +204,8 @@ var InnerThingTypeMap = StructMap{
 }
 
 var AnotherInnerThingTypeMap = StructMap{
-	AnotherInnerThing{},
-	[]MappedField{
+	UnderlyingType: AnotherInnerThing{},
+	Fields: []MappedField{
 		{
 			StructFieldName: "Foo",
 			JSONFieldName:   "foo",
@@ -226,8 +240,8 @@ var AnotherInnerThingTypeMap = StructMap{
 }
 
 var OuterThingTypeMap = StructMap{
-	OuterThing{},
-	[]MappedField{
+	UnderlyingType: OuterThing{},
+	Fields: []MappedField{
 		{
 			StructFieldName: "InnerThing",
 			JSONFieldName:   "inner_thing",
@@ -237,8 +251,8 @@ var OuterThingTypeMap = StructMap{
 }
 
 var AnotherOuterThingTypeMap = StructMap{
-	AnotherOuterThing{},
-	[]MappedField{
+	UnderlyingType: AnotherOuterThing{},
+	Fields: []MappedField{
 		{
 			StructFieldName: "InnerThing",
 			JSONFieldName:   "another/inner/thing",
@@ -248,8 +262,8 @@ var AnotherOuterThingTypeMap = StructMap{
 }
 
 var MapOfInnerThingTypeMap = StructMap{
-	OuterInnerThingMap{},
-	[]MappedField{
+	UnderlyingType: OuterInnerThingMap{},
+	Fields: []MappedField{
 		{
 			StructFieldName: "InnerThingMap",
 			JSONFieldName:   "inner_thing_map",
@@ -259,8 +273,8 @@ var MapOfInnerThingTypeMap = StructMap{
 }
 
 var OuterPointerThingTypeMap = StructMap{
-	OuterPointerThing{},
-	[]MappedField{
+	UnderlyingType: OuterPointerThing{},
+	Fields: []MappedField{
 		{
 			StructFieldName: "InnerThing",
 			JSONFieldName:   "inner_thing",
@@ -270,8 +284,8 @@ var OuterPointerThingTypeMap = StructMap{
 }
 
 var OuterInterfaceThingTypeMap = StructMap{
-	OuterInterfaceThing{},
-	[]MappedField{
+	UnderlyingType: OuterInterfaceThing{},
+	Fields: []MappedField{
 		{
 			StructFieldName: "InnerThing",
 			JSONFieldName:   "inner_thing",
@@ -281,8 +295,8 @@ var OuterInterfaceThingTypeMap = StructMap{
 }
 
 var OuterSliceThingTypeMap = StructMap{
-	OuterSliceThing{},
-	[]MappedField{
+	UnderlyingType: OuterSliceThing{},
+	Fields: []MappedField{
 		{
 			StructFieldName: "InnerThings",
 			JSONFieldName:   "inner_things",
@@ -292,8 +306,8 @@ var OuterSliceThingTypeMap = StructMap{
 }
 
 var Outer2DSliceThingTypeMap = StructMap{
-	Outer2DSliceThing{},
-	[]MappedField{
+	UnderlyingType: Outer2DSliceThing{},
+	Fields: []MappedField{
 		{
 			StructFieldName: "InnerThings",
 			JSONFieldName:   "inner_things",
@@ -303,8 +317,8 @@ var Outer2DSliceThingTypeMap = StructMap{
 }
 
 var ContainsMaxSliceSizeTypeMap = StructMap{
-	OuterMaxSliceThing{},
-	[]MappedField{
+	UnderlyingType: OuterMaxSliceThing{},
+	Fields: []MappedField{
 		{
 			StructFieldName: "InnerThings",
 			JSONFieldName:   "inner_things",
@@ -314,8 +328,8 @@ var ContainsMaxSliceSizeTypeMap = StructMap{
 }
 
 var ContainsMinSliceSizeTypeMap = StructMap{
-	OuterMinSliceThing{},
-	[]MappedField{
+	UnderlyingType: OuterMinSliceThing{},
+	Fields: []MappedField{
 		{
 			StructFieldName: "InnerThings",
 			JSONFieldName:   "inner_things",
@@ -325,8 +339,8 @@ var ContainsMinSliceSizeTypeMap = StructMap{
 }
 
 var ContainsRangeSliceSizeTypeMap = StructMap{
-	OuterRangeSliceThing{},
-	[]MappedField{
+	UnderlyingType: OuterRangeSliceThing{},
+	Fields: []MappedField{
 		{
 			StructFieldName: "InnerThings",
 			JSONFieldName:   "inner_things",
@@ -336,8 +350,8 @@ var ContainsRangeSliceSizeTypeMap = StructMap{
 }
 
 var OuterPointerSliceThingTypeMap = StructMap{
-	OuterPointerSliceThing{},
-	[]MappedField{
+	UnderlyingType: OuterPointerSliceThing{},
+	Fields: []MappedField{
 		{
 			StructFieldName: "InnerThings",
 			JSONFieldName:   "inner_things",
@@ -347,8 +361,8 @@ var OuterPointerSliceThingTypeMap = StructMap{
 }
 
 var OuterPointerToSliceThingTypeMap = StructMap{
-	OuterPointerToSliceThing{},
-	[]MappedField{
+	UnderlyingType: OuterPointerToSliceThing{},
+	Fields: []MappedField{
 		{
 			StructFieldName: "InnerThings",
 			JSONFieldName:   "inner_things",
@@ -358,8 +372,8 @@ var OuterPointerToSliceThingTypeMap = StructMap{
 }
 
 var OtherInnerThingTypeMap = StructMap{
-	OtherInnerThing{},
-	[]MappedField{
+	UnderlyingType: OtherInnerThing{},
+	Fields: []MappedField{
 		{
 			StructFieldName: "Bar",
 			JSONFieldName:   "bar",
@@ -370,8 +384,8 @@ var OtherInnerThingTypeMap = StructMap{
 }
 
 var OuterVariableThingTypeMap = StructMap{
-	OuterVariableThing{},
-	[]MappedField{
+	UnderlyingType: OuterVariableThing{},
+	Fields: []MappedField{
 		{
 			StructFieldName: "InnerType",
 			JSONFieldName:   "inner_type",
@@ -389,8 +403,8 @@ var OuterVariableThingTypeMap = StructMap{
 }
 
 var OuterVariableThingWithOneOfInnerTypeMap = StructMap{
-	OuterVariableThingInnerTypeOneOf{},
-	[]MappedField{
+	UnderlyingType: OuterVariableThingInnerTypeOneOf{},
+	Fields: []MappedField{
 		{
 			StructFieldName: "InnerType",
 			JSONFieldName:   "inner_type",
@@ -411,8 +425,8 @@ var OuterVariableThingWithOneOfInnerTypeMap = StructMap{
 }
 
 var OuterVariableThingWithInnerTypeNoJsonTagTypeMap = StructMap{
-	OuterVariableThingInnerTypeNoJsonTag{},
-	[]MappedField{
+	UnderlyingType: OuterVariableThingInnerTypeNoJsonTag{},
+	Fields: []MappedField{
 		{
 			StructFieldName: "InnerType",
 			JSONFieldName:   "inner_type",
@@ -430,8 +444,8 @@ var OuterVariableThingWithInnerTypeNoJsonTagTypeMap = StructMap{
 }
 
 var OuterVariableThingWithInnerTypeIgnoredJsonTagTypeMap = StructMap{
-	OuterVariableThingInnerTypeIgnoredJsonTag{},
-	[]MappedField{
+	UnderlyingType: OuterVariableThingInnerTypeIgnoredJsonTag{},
+	Fields: []MappedField{
 		{
 			StructFieldName: "InnerType",
 			JSONFieldName:   "inner_type",
@@ -449,8 +463,8 @@ var OuterVariableThingWithInnerTypeIgnoredJsonTagTypeMap = StructMap{
 }
 
 var BrokenOuterVariableThingTypeMap = StructMap{
-	OtherOuterVariableThing{},
-	[]MappedField{
+	UnderlyingType: OtherOuterVariableThing{},
+	Fields: []MappedField{
 		{
 			StructFieldName: "InnerType",
 			JSONFieldName:   "inner_type",
@@ -468,8 +482,8 @@ var BrokenOuterVariableThingTypeMap = StructMap{
 }
 
 var ReadOnlyThingTypeMap = StructMap{
-	ReadOnlyThing{},
-	[]MappedField{
+	UnderlyingType: ReadOnlyThing{},
+	Fields: []MappedField{
 		{
 			StructFieldName: "PrimaryKey",
 			JSONFieldName:   "primary_key",
@@ -479,8 +493,8 @@ var ReadOnlyThingTypeMap = StructMap{
 }
 
 var TypoedThingTypeMap = StructMap{
-	TypoedThing{},
-	[]MappedField{
+	UnderlyingType: TypoedThing{},
+	Fields: []MappedField{
 		{
 			StructFieldName: "Incorrect",
 			JSONFieldName:   "correct",
@@ -490,8 +504,8 @@ var TypoedThingTypeMap = StructMap{
 }
 
 var BrokenThingTypeMap = StructMap{
-	BrokenThing{},
-	[]MappedField{
+	UnderlyingType: BrokenThing{},
+	Fields: []MappedField{
 		{
 			StructFieldName: "Invalid",
 			JSONFieldName:   "invalid",
@@ -501,8 +515,8 @@ var BrokenThingTypeMap = StructMap{
 }
 
 var TemplatableThingTypeMap = StructMap{
-	TemplatableThing{},
-	[]MappedField{
+	UnderlyingType: TemplatableThing{},
+	Fields: []MappedField{
 		{
 			StructFieldName: "SomeField",
 			JSONFieldName:   "some_field",
@@ -512,8 +526,8 @@ var TemplatableThingTypeMap = StructMap{
 }
 
 var InnerNonMarshalableThingTypeMap = StructMap{
-	InnerNonMarshalableThing{},
-	[]MappedField{
+	UnderlyingType: InnerNonMarshalableThing{},
+	Fields: []MappedField{
 		{
 			StructFieldName: "Oops",
 			JSONFieldName:   "oops",
@@ -522,8 +536,8 @@ var InnerNonMarshalableThingTypeMap = StructMap{
 }
 
 var OuterNonMarshalableThingTypeMap = StructMap{
-	OuterNonMarshalableThing{},
-	[]MappedField{
+	UnderlyingType: OuterNonMarshalableThing{},
+	Fields: []MappedField{
 		{
 			StructFieldName: "InnerThing",
 			JSONFieldName:   "inner_thing",
@@ -533,8 +547,8 @@ var OuterNonMarshalableThingTypeMap = StructMap{
 }
 
 var ThingWithSliceOfPrimitivesTypeMap = StructMap{
-	ThingWithSliceOfPrimitives{},
-	[]MappedField{
+	UnderlyingType: ThingWithSliceOfPrimitives{},
+	Fields: []MappedField{
 		{
 			StructFieldName: "Strings",
 			JSONFieldName:   "strings",
@@ -543,8 +557,8 @@ var ThingWithSliceOfPrimitivesTypeMap = StructMap{
 	},
 }
 var ThingWithInnerMapTypeMap = StructMap{
-	OuterMapThing{},
-	[]MappedField{
+	UnderlyingType: OuterMapThing{},
+	Fields: []MappedField{
 		{
 			StructFieldName: "InnerMap",
 			JSONFieldName:   "inner_map",
@@ -554,8 +568,8 @@ var ThingWithInnerMapTypeMap = StructMap{
 }
 
 var ThingWithMapOfInterfacesTypeMap = StructMap{
-	ThingWithMapOfInterfaces{},
-	[]MappedField{
+	UnderlyingType: ThingWithMapOfInterfaces{},
+	Fields: []MappedField{
 		{
 			StructFieldName: "Interfaces",
 			JSONFieldName:   "interfaces",
@@ -565,8 +579,8 @@ var ThingWithMapOfInterfacesTypeMap = StructMap{
 }
 
 var ThingWithMapOfStringsTypeMap = StructMap{
-	ThingWithMapOfStrings{},
-	[]MappedField{
+	UnderlyingType: ThingWithMapOfStrings{},
+	Fields: []MappedField{
 		{
 			StructFieldName: "Strings",
 			JSONFieldName:   "strings",
@@ -576,8 +590,8 @@ var ThingWithMapOfStringsTypeMap = StructMap{
 }
 
 var ThingWithTimeSchema = StructMap{
-	ThingWithTime{},
-	[]MappedField{
+	UnderlyingType: ThingWithTime{},
+	Fields: []MappedField{
 		{
 			StructFieldName: "HappenedAt",
 			JSONFieldName:   "happened_at",
@@ -587,8 +601,8 @@ var ThingWithTimeSchema = StructMap{
 }
 
 var ThingWithEnumerableInterfaceSchema = StructMap{
-	ThingWithEnumerableInterface{},
-	[]MappedField{
+	UnderlyingType: ThingWithEnumerableInterface{},
+	Fields: []MappedField{
 		{
 			StructFieldName: "ThanksGo",
 			JSONFieldName:   "thanks",
@@ -1894,3 +1908,5981 @@ func TestHeaderMap(t *testing.T) {
 	err = dogParamMap.EncodeHeader(dog, newHeader)
 	require.NoError(t, err)
 }
+
+type authHeaders struct {
+	Token string
+	Basic BasicCredentials
+}
+
+var bearerHeaderMap = QueryMap{
+	UnderlyingType: authHeaders{},
+	ParameterMaps: []ParameterMap{
+		{
+			StructFieldName: "Token",
+			ParameterName:   "Authorization",
+			Mapper:          BearerQueryParameterMapper{Required: true},
+		},
+	},
+}
+
+var basicHeaderMap = QueryMap{
+	UnderlyingType: authHeaders{},
+	ParameterMaps: []ParameterMap{
+		{
+			StructFieldName: "Basic",
+			ParameterName:   "Authorization",
+			Mapper:          BasicQueryParameterMapper{},
+		},
+	},
+}
+
+func TestAuthorizationHeaderMap(t *testing.T) {
+	header := http.Header{}
+	header.Set("Authorization", "bearer abc.def-ghi")
+
+	auth := authHeaders{}
+	err := bearerHeaderMap.DecodeHeader(header, &auth)
+	require.NoError(t, err)
+	require.Equal(t, "abc.def-ghi", auth.Token)
+
+	newHeader := http.Header{}
+	err = bearerHeaderMap.EncodeHeader(auth, newHeader)
+	require.NoError(t, err)
+	require.Equal(t, "Bearer abc.def-ghi", newHeader.Get("Authorization"))
+
+	err = bearerHeaderMap.DecodeHeader(http.Header{}, &auth)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "/Authorization: error ocurred while reading value ([REDACTED]) into param Token: missing credentials")
+
+	header.Set("Authorization", "Bearer secret token")
+	err = bearerHeaderMap.DecodeHeader(header, &auth)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "malformed Bearer credentials")
+	require.NotContains(t, err.Error(), "secret")
+
+	header.Set("Authorization", "Basic c2VjcmV0")
+	err = bearerHeaderMap.DecodeHeader(header, &auth)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "expected Bearer credentials")
+	require.NotContains(t, err.Error(), "c2VjcmV0")
+
+	auth = authHeaders{Basic: BasicCredentials{Username: "alice", Password: "open:sesame"}}
+	newHeader = http.Header{}
+	err = basicHeaderMap.EncodeHeader(auth, newHeader)
+	require.NoError(t, err)
+
+	decoded := authHeaders{}
+	err = basicHeaderMap.DecodeHeader(newHeader, &decoded)
+	require.NoError(t, err)
+	require.Equal(t, auth, decoded)
+
+	decoded = authHeaders{}
+	err = basicHeaderMap.DecodeHeader(http.Header{}, &decoded)
+	require.NoError(t, err)
+	require.Equal(t, BasicCredentials{}, decoded.Basic)
+
+	header.Set("Authorization", "Basic bm9jb2xvbg==")
+	err = basicHeaderMap.DecodeHeader(header, &decoded)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "malformed Basic credentials")
+}
+
+type localeHeaders struct {
+	Languages []string
+}
+
+var localeHeaderMap = QueryMap{
+	UnderlyingType: localeHeaders{},
+	ParameterMaps: []ParameterMap{
+		{
+			StructFieldName: "Languages",
+			ParameterName:   "Accept-Language",
+			Mapper:          AcceptLanguageQueryParameterMapper{Supported: []string{"en", "fr-CA", "de"}},
+		},
+	},
+}
+
+func TestAcceptLanguageHeaderMap(t *testing.T) {
+	header := http.Header{}
+	header.Add("Accept-Language", "ja;q=0.9, de;q=0.5, FR-ca;q=0.7")
+	header.Add("Accept-Language", "en-GB, en;q=0.8, *;q=0.1, es;q=0")
+
+	locale := localeHeaders{}
+	err := localeHeaderMap.DecodeHeader(header, &locale)
+	require.NoError(t, err)
+	require.Equal(t, []string{"en", "fr-CA", "de"}, locale.Languages)
+
+	newHeader := http.Header{}
+	err = localeHeaderMap.EncodeHeader(locale, newHeader)
+	require.NoError(t, err)
+	require.Equal(t, "en, fr-CA;q=0.9, de;q=0.8", newHeader.Get("Accept-Language"))
+
+	locale = localeHeaders{}
+	err = localeHeaderMap.DecodeHeader(http.Header{}, &locale)
+	require.NoError(t, err)
+	require.Empty(t, locale.Languages)
+
+	header.Set("Accept-Language", "en;q=2")
+	err = localeHeaderMap.DecodeHeader(header, &locale)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid quality value: 2")
+
+	header.Set("Accept-Language", "en_US")
+	err = localeHeaderMap.DecodeHeader(header, &locale)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid language range: en_US")
+}
+
+type preconditionHeaders struct {
+	ETag        ETag
+	IfMatch     ETagList
+	IfNoneMatch ETagList
+}
+
+var preconditionHeaderMap = QueryMap{
+	UnderlyingType: preconditionHeaders{},
+	ParameterMaps: []ParameterMap{
+		{
+			StructFieldName: "ETag",
+			ParameterName:   "ETag",
+			Mapper:          ETagQueryParameterMapper{},
+			OmitEmpty:       true,
+		},
+		{
+			StructFieldName: "IfMatch",
+			ParameterName:   "If-Match",
+			Mapper:          ETagListQueryParameterMapper{},
+			OmitEmpty:       true,
+		},
+		{
+			StructFieldName: "IfNoneMatch",
+			ParameterName:   "If-None-Match",
+			Mapper:          ETagListQueryParameterMapper{},
+			OmitEmpty:       true,
+		},
+	},
+}
+
+func TestETagHeaderMap(t *testing.T) {
+	header := http.Header{}
+	header.Set("ETag", `W/"v2"`)
+	header.Add("If-Match", `"v1", W/"v2"`)
+	header.Add("If-Match", `"v3"`)
+	header.Set("If-None-Match", "*")
+
+	pre := preconditionHeaders{}
+	err := preconditionHeaderMap.DecodeHeader(header, &pre)
+	require.NoError(t, err)
+	require.Equal(t, ETag{Tag: "v2", Weak: true}, pre.ETag)
+	require.Equal(t, []ETag{{Tag: "v1"}, {Tag: "v2", Weak: true}, {Tag: "v3"}}, pre.IfMatch.Tags)
+	require.True(t, pre.IfNoneMatch.Any)
+
+	require.True(t, pre.IfMatch.StrongMatch(ETag{Tag: "v3"}))
+	require.False(t, pre.IfMatch.StrongMatch(ETag{Tag: "v2"}))
+	require.True(t, pre.IfMatch.WeakMatch(ETag{Tag: "v2"}))
+	require.True(t, pre.IfNoneMatch.WeakMatch(ETag{Tag: "v4"}))
+
+	newHeader := http.Header{}
+	err = preconditionHeaderMap.EncodeHeader(pre, newHeader)
+	require.NoError(t, err)
+	require.Equal(t, `W/"v2"`, newHeader.Get("ETag"))
+	require.Equal(t, `"v1", W/"v2", "v3"`, newHeader.Get("If-Match"))
+	require.Equal(t, "*", newHeader.Get("If-None-Match"))
+
+	pre = preconditionHeaders{}
+	err = preconditionHeaderMap.DecodeHeader(http.Header{}, &pre)
+	require.NoError(t, err)
+	require.True(t, pre.IfMatch.IsZero())
+	require.False(t, pre.IfMatch.WeakMatch(ETag{Tag: "v1"}))
+
+	header = http.Header{}
+	header.Set("If-Match", `v1`)
+	err = preconditionHeaderMap.DecodeHeader(header, &pre)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "entity tag must be quoted")
+
+	header.Set("If-Match", `"v1" "v2"`)
+	err = preconditionHeaderMap.DecodeHeader(header, &pre)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "entity tags must be separated by commas")
+
+	header.Set("If-Match", `"v1"`)
+	header.Add("If-Match", "*")
+	err = preconditionHeaderMap.DecodeHeader(header, &pre)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "* may not be combined with entity tags")
+
+	_, err = ETagListQueryParameterMapper{Required: true}.Decode()
+	require.EqualError(t, err, "missing precondition")
+}
+
+type downloadHeaders struct {
+	Range *ByteRange
+}
+
+var downloadHeaderMap = QueryMap{
+	UnderlyingType: downloadHeaders{},
+	ParameterMaps: []ParameterMap{
+		{
+			StructFieldName: "Range",
+			ParameterName:   "Range",
+			Mapper:          RangeQueryParameterMapper{MaxLength: 1024},
+			OmitEmpty:       true,
+		},
+	},
+}
+
+func TestRangeHeaderMap(t *testing.T) {
+	header := http.Header{}
+	header.Set("Range", "bytes=0-1023")
+
+	dl := downloadHeaders{}
+	err := downloadHeaderMap.DecodeHeader(header, &dl)
+	require.NoError(t, err)
+	require.Equal(t, &ByteRange{Start: 0, End: 1023}, dl.Range)
+
+	resolved, err := dl.Range.Resolve(500)
+	require.NoError(t, err)
+	require.Equal(t, ByteRange{Start: 0, End: 499, Size: 500}, resolved)
+	require.EqualValues(t, 500, resolved.Length())
+
+	newHeader := http.Header{}
+	err = downloadHeaderMap.EncodeHeader(downloadHeaders{Range: &resolved}, newHeader)
+	require.NoError(t, err)
+	require.Equal(t, "bytes 0-499/500", newHeader.Get("Range"))
+
+	err = downloadHeaderMap.EncodeHeader(dl, newHeader)
+	require.NoError(t, err)
+	require.Equal(t, "bytes 0-1023/*", newHeader.Get("Range"))
+
+	header.Set("Range", "bytes=-100")
+	err = downloadHeaderMap.DecodeHeader(header, &dl)
+	require.NoError(t, err)
+	err = downloadHeaderMap.EncodeHeader(dl, newHeader)
+	require.EqualError(t, err, "error in encoding struct: byte range must be resolved before encoding")
+	resolved, err = dl.Range.Resolve(500)
+	require.NoError(t, err)
+	require.Equal(t, ByteRange{Start: 400, End: 499, Size: 500}, resolved)
+
+	header.Set("Range", "bytes=600-")
+	err = downloadHeaderMap.DecodeHeader(header, &dl)
+	require.NoError(t, err)
+	_, err = dl.Range.Resolve(500)
+	require.EqualError(t, err, "range not satisfiable")
+
+	dl = downloadHeaders{}
+	err = downloadHeaderMap.DecodeHeader(http.Header{}, &dl)
+	require.NoError(t, err)
+	require.Nil(t, dl.Range)
+
+	for value, msg := range map[string]string{
+		"bytes=0-1024":  "range may not exceed 1024 bytes",
+		"bytes=10-5":    "range ends before it starts",
+		"bytes=0-1,5-6": "multiple ranges are not supported",
+		"items=0-1":     "unsupported range unit",
+		"bytes=+1-2":    "invalid byte offset: +1",
+		"bytes=-0":      "range not satisfiable",
+		"bytes=10":      "invalid byte range: 10",
+	} {
+		header.Set("Range", value)
+		err = downloadHeaderMap.DecodeHeader(header, &dl)
+		require.Error(t, err, value)
+		require.Contains(t, err.Error(), msg, value)
+	}
+}
+
+type paginationParams struct {
+	Limit  int
+	Cursor string
+}
+
+type pagedDogFilter struct {
+	paginationParams
+	Name string
+}
+
+var paginationParamMap = QueryMap{
+	ParameterMaps: []ParameterMap{
+		{
+			StructFieldName: "Limit",
+			ParameterName:   "limit",
+			Mapper:          IntQueryParameterMapper{},
+			OmitEmpty:       true,
+		},
+		{
+			StructFieldName: "Cursor",
+			ParameterName:   "cursor",
+			Mapper:          StringQueryParameterMapper{},
+			OmitEmpty:       true,
+		},
+	},
+}
+
+var pagedDogFilterParamMap = ComposeQueryMaps(
+	QueryMap{
+		UnderlyingType: pagedDogFilter{},
+		ParameterMaps: []ParameterMap{
+			{
+				StructFieldName: "Name",
+				ParameterName:   "name",
+				Mapper:          StringQueryParameterMapper{},
+			},
+		},
+	},
+	paginationParamMap,
+)
+
+func TestComposeQueryMaps(t *testing.T) {
+	urlQuery, _ := url.ParseQuery("name=spot&limit=20&cursor=abc")
+	filter := pagedDogFilter{}
+	err := pagedDogFilterParamMap.Decode(urlQuery, &filter)
+	require.NoError(t, err)
+	require.Equal(t, "spot", filter.Name)
+	require.Equal(t, 20, filter.Limit)
+	require.Equal(t, "abc", filter.Cursor)
+
+	newQuery := make(map[string][]string)
+	err = pagedDogFilterParamMap.Encode(filter, newQuery)
+	require.NoError(t, err)
+	require.EqualValues(t, urlQuery, newQuery)
+}
+
+func TestComposeQueryMapsDuplicateParameter(t *testing.T) {
+	require.Panics(t, func() {
+		ComposeQueryMaps(paginationParamMap, paginationParamMap)
+	})
+}
+
+func TestComposeQueryMapsMismatchedTypes(t *testing.T) {
+	require.Panics(t, func() {
+		ComposeQueryMaps(dogParamMap, requestFilterMapping)
+	})
+}
+
+func TestCompiledQueryMap(t *testing.T) {
+	cqm, err := dogParamMap.Compile()
+	require.NoError(t, err)
+
+	urlQuery, _ := url.ParseQuery(`location=barcelona&owners=Alice&name=Spot&owners=Bob&age=10&is_dead=false`)
+	dog := dogStruct{}
+	err = cqm.Decode(urlQuery, &dog)
+	require.NoError(t, err)
+	require.Equal(t, 10, dog.Age)
+	require.Equal(t, "Spot", dog.Name)
+	require.EqualValues(t, []string{"Alice", "Bob"}, dog.Owners)
+	require.Equal(t, "barcelona", *dog.Location)
+
+	header := http.Header{}
+	err = cqm.EncodeHeader(dog, header)
+	require.NoError(t, err)
+	require.Equal(t, "Spot", header.Get("Name"))
+
+	urlQuery, _ = url.ParseQuery("age=1000")
+	err = cqm.Decode(urlQuery, &dog)
+	require.Error(t, err)
+
+	err = cqm.Decode(urlQuery, &requestFilter{})
+	require.EqualError(t, err, "attempting to decode into mismatched struct: expected jsonmap.dogStruct but got *jsonmap.requestFilter")
+
+	err = cqm.Decode(urlQuery, nil)
+	require.EqualError(t, err, "attempting to decode into mismatched struct: expected jsonmap.dogStruct but got <nil>")
+
+	err = cqm.Decode(urlQuery, (*dogStruct)(nil))
+	require.EqualError(t, err, "attempting to decode into nil *jsonmap.dogStruct")
+}
+
+type taggedDogs struct {
+	IDs []string
+}
+
+func TestIndexedQueryParameterMapper(t *testing.T) {
+	qm := QueryMap{
+		UnderlyingType: taggedDogs{},
+		ParameterMaps: []ParameterMap{
+			{
+				StructFieldName: "IDs",
+				ParameterName:   "ids",
+				Mapper: IndexedQueryParameterMapper{
+					UnderlyingQueryParameterMapper: StrSliceQueryParameterMapper{
+						UnderlyingQueryParameterMapper: StringQueryParameterMapper{},
+					},
+				},
+			},
+		},
+	}
+	cqm := qm.MustCompile()
+
+	for _, decoder := range []QueryDecoder{qm, cqm} {
+		decode := func(query string) (taggedDogs, error) {
+			urlQuery, err := url.ParseQuery(query)
+			require.NoError(t, err)
+			dst := taggedDogs{}
+			err = decoder.Decode(urlQuery, &dst)
+			return dst, err
+		}
+
+		dogs, err := decode("ids[1]=b&ids[0]=a&ids[2]=c")
+		require.NoError(t, err)
+		require.Equal(t, []string{"a", "b", "c"}, dogs.IDs)
+
+		dogs, err = decode("ids=a&ids=b")
+		require.NoError(t, err)
+		require.Equal(t, []string{"a", "b"}, dogs.IDs)
+
+		_, err = decode("ids[0]=a&ids[2]=c")
+		require.EqualError(t, err, "Validation Errors: \n/ids: missing index 1\n")
+
+		_, err = decode("ids[0]=a&ids[0]=b")
+		require.EqualError(t, err, "Validation Errors: \n/ids: too many values for index 0\n")
+
+		_, err = decode("ids[01]=a")
+		require.EqualError(t, err, "Validation Errors: \n/ids: invalid index: ids[01]\n")
+
+		_, err = decode("ids=a&ids[0]=b")
+		require.EqualError(t, err, "Validation Errors: \n/ids: values may not be given both with and without indices\n")
+	}
+
+	urlQuery := map[string][]string{}
+	require.NoError(t, qm.Encode(taggedDogs{IDs: []string{"a", "b"}}, urlQuery))
+	require.Equal(t, map[string][]string{"ids": {"a", "b"}}, urlQuery)
+}
+
+func TestHeaderListQueryParameterMapper(t *testing.T) {
+	mapper := HeaderListQueryParameterMapper{
+		UnderlyingQueryParameterMapper: StrSliceQueryParameterMapper{
+			UnderlyingQueryParameterMapper: StringQueryParameterMapper{},
+		},
+	}
+	qm := QueryMap{
+		UnderlyingType: taggedDogs{},
+		ParameterMaps: []ParameterMap{
+			{
+				StructFieldName: "IDs",
+				ParameterName:   "X-Dog-Ids",
+				Mapper:          mapper,
+			},
+		},
+	}
+
+	for _, decoder := range []QueryDecoder{qm, qm.MustCompile()} {
+		header := http.Header{}
+		header.Add("X-Dog-Ids", `a, b,,"c, d"`)
+		header.Add("X-Dog-Ids", `e`)
+
+		dogs := taggedDogs{}
+		require.NoError(t, decoder.DecodeHeader(header, &dogs))
+		require.Equal(t, []string{"a", "b", `"c, d"`, "e"}, dogs.IDs)
+
+		// Query strings aren't split
+		dogs = taggedDogs{}
+		require.NoError(t, decoder.Decode(map[string][]string{"X-Dog-Ids": {"a,b"}}, &dogs))
+		require.Equal(t, []string{"a,b"}, dogs.IDs)
+	}
+
+	header := http.Header{}
+	require.NoError(t, qm.EncodeHeader(taggedDogs{IDs: []string{"a", "b"}}, header))
+	require.Equal(t, []string{"a", "b"}, header["X-Dog-Ids"])
+
+	mapper.Join = true
+	qm.ParameterMaps[0].Mapper = mapper
+	for _, encoder := range []interface {
+		EncodeHeader(interface{}, http.Header) error
+	}{qm, qm.MustCompile()} {
+		header = http.Header{}
+		require.NoError(t, encoder.EncodeHeader(taggedDogs{IDs: []string{"a", "b"}}, header))
+		require.Equal(t, []string{"a, b"}, header["X-Dog-Ids"])
+	}
+
+	require.Equal(t, []string{`"a\", b"`, "c"}, splitHeaderList(`"a\", b", c`))
+	require.Equal(t, []string{`"a\`}, splitHeaderList(`"a\`))
+}
+
+func TestTimeQueryParameterMapperLayouts(t *testing.T) {
+	berlin := time.FixedZone("CEST", 2*60*60)
+	mapper := TimeQueryParameterMapper{
+		Layouts:            []string{time.RFC3339, "2006-01-02 15:04", "2006-01-02"},
+		Location:           berlin,
+		AcceptEpochSeconds: true,
+		Layout:             "2006-01-02 15:04 MST",
+	}
+
+	v, err := mapper.Decode("2021-06-01 12:30")
+	require.NoError(t, err)
+	require.True(t, time.Date(2021, 6, 1, 10, 30, 0, 0, time.UTC).Equal(v.(time.Time)))
+
+	v, err = mapper.Decode("2021-06-01T12:30:00Z")
+	require.NoError(t, err)
+	require.True(t, time.Date(2021, 6, 1, 12, 30, 0, 0, time.UTC).Equal(v.(time.Time)))
+
+	v, err = mapper.Decode("1622550600")
+	require.NoError(t, err)
+	require.True(t, time.Date(2021, 6, 1, 12, 30, 0, 0, time.UTC).Equal(v.(time.Time)))
+
+	_, err = mapper.Decode("June 1st")
+	require.Error(t, err)
+
+	vals, err := mapper.Encode(reflect.ValueOf(time.Date(2021, 6, 1, 10, 30, 0, 0, time.UTC)))
+	require.NoError(t, err)
+	require.Equal(t, []string{"2021-06-01 12:30 CEST"}, vals)
+
+	// Epoch seconds aren't accepted unless asked for
+	_, err = TimeQueryParameterMapper{}.Decode("1622550600")
+	require.Error(t, err)
+}
+
+func TestNumberQueryParameterMapper(t *testing.T) {
+	for _, tc := range []struct {
+		kind     reflect.Kind
+		src      string
+		expected interface{}
+		err      string
+	}{
+		{kind: reflect.Int, src: "-12", expected: int(-12)},
+		{kind: reflect.Int8, src: "127", expected: int8(127)},
+		{kind: reflect.Int8, src: "128", err: "128 is out of range for int8"},
+		{kind: reflect.Int64, src: "9223372036854775808", err: "9223372036854775808 is out of range for int64"},
+		{kind: reflect.Int32, src: "1.5", err: `param could not be converted to integer: strconv.ParseInt: parsing "1.5": invalid syntax`},
+		{kind: reflect.Uint16, src: "65535", expected: uint16(65535)},
+		{kind: reflect.Uint16, src: "65536", err: "65536 is out of range for uint16"},
+		{kind: reflect.Uint, src: "-1", err: `param could not be converted to unsigned integer: strconv.ParseUint: parsing "-1": invalid syntax`},
+		{kind: reflect.Float32, src: "1.5", expected: float32(1.5)},
+		{kind: reflect.Float32, src: "1e39", err: "1e39 is out of range for float32"},
+		{kind: reflect.Float64, src: "NaN", err: "param could not be converted to a finite number"},
+	} {
+		mapper := NumberQueryParameterMapper{Kind: tc.kind}
+		v, err := mapper.Decode(tc.src)
+		if tc.err != "" {
+			require.EqualError(t, err, tc.err)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, v)
+
+		vals, err := mapper.Encode(reflect.ValueOf(v))
+		require.NoError(t, err)
+		require.Equal(t, []string{tc.src}, vals)
+	}
+
+	v, err := NumberQueryParameterMapper{Kind: reflect.Uint8}.Decode()
+	require.NoError(t, err)
+	require.Equal(t, uint8(0), v)
+
+	_, err = NumberQueryParameterMapper{Kind: reflect.Int}.Encode(reflect.ValueOf(int64(1)))
+	require.EqualError(t, err, "expected int but got: int64")
+
+	require.Panics(t, func() {
+		NumberQueryParameterMapper{}.Decode()
+	})
+
+	_, err = QueryMap{
+		UnderlyingType: dogStruct{},
+		ParameterMaps: []ParameterMap{
+			{StructFieldName: "Age", ParameterName: "age", Mapper: NumberQueryParameterMapper{}},
+		},
+	}.Compile()
+	require.EqualError(t, err, "param age has a NumberQueryParameterMapper whose Kind is not a number kind: invalid")
+}
+
+func TestQueryMapDecodeMap(t *testing.T) {
+	urlQuery, _ := url.ParseQuery("owners=Alice&name=Spot&owners=Bob&age=10&is_dead=true")
+
+	for _, decoder := range []QueryDecoder{dogParamMap, dogParamMap.MustCompile()} {
+		params := map[string]interface{}{}
+		err := decoder.Decode(urlQuery, params)
+		require.NoError(t, err)
+		require.Equal(t, 10, params["age"])
+		require.Equal(t, "Spot", params["name"])
+		require.Equal(t, []string{"Alice", "Bob"}, params["owners"])
+		require.Equal(t, true, params["is_dead"])
+		require.Equal(t, time.Time{}, params["birthday"])
+
+		var allocated map[string]interface{}
+		err = decoder.Decode(map[string][]string{"age": {"1000"}}, &allocated)
+		require.Error(t, err)
+		require.NotContains(t, allocated, "age")
+		require.Contains(t, allocated, "name")
+
+		header := http.Header{}
+		header.Set("Name", "Rex")
+		params = map[string]interface{}{}
+		require.NoError(t, decoder.DecodeHeader(header, params))
+		require.Equal(t, "Rex", params["name"])
+	}
+}
+
+func TestQueryMapDecodeTracked(t *testing.T) {
+	for _, decoder := range []interface {
+		DecodeTracked(map[string][]string, interface{}) ([]string, error)
+	}{dogParamMap, dogParamMap.MustCompile()} {
+		urlQuery, _ := url.ParseQuery("name=Spot&age=0&location=")
+		dog := dogStruct{}
+		present, err := decoder.DecodeTracked(urlQuery, &dog)
+		require.NoError(t, err)
+		require.Equal(t, []string{"age", "name", "location"}, present)
+		require.Equal(t, 0, dog.Age)
+
+		present, err = decoder.DecodeTracked(map[string][]string{}, &dog)
+		require.NoError(t, err)
+		require.Empty(t, present)
+
+		present, err = decoder.DecodeTracked(map[string][]string{"age": {"1000"}}, &dog)
+		require.Error(t, err)
+		require.Nil(t, present)
+	}
+}
+
+func TestCompileQueryMapNoSuchField(t *testing.T) {
+	_, err := QueryMap{
+		UnderlyingType: dogStruct{},
+		ParameterMaps: []ParameterMap{
+			{
+				StructFieldName: "Color",
+				ParameterName:   "color",
+				Mapper:          StringQueryParameterMapper{},
+			},
+		},
+	}.Compile()
+	require.EqualError(t, err, "no such underlying field: Color")
+}
+
+func TestCompileQueryMapTypeMismatch(t *testing.T) {
+	_, err := QueryMap{
+		UnderlyingType: dogStruct{},
+		ParameterMaps: []ParameterMap{
+			{
+				StructFieldName: "Name",
+				ParameterName:   "name",
+				Mapper:          IntQueryParameterMapper{},
+			},
+		},
+	}.Compile()
+	require.EqualError(t, err, "param name decodes to int, which cannot be assigned to field Name of type string")
+}
+
+type boundDogRequest struct {
+	ID    string
+	Name  string
+	Age   int64
+	Limit int
+	Trace string
+}
+
+var boundDogRequestTypeMap = StructMap{
+	UnderlyingType: boundDogRequest{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "Name",
+			JSONFieldName:   "name",
+			Validator:       String(1, 10),
+		},
+		{
+			StructFieldName: "Age",
+			JSONFieldName:   "age",
+			Validator:       Integer(0, 30),
+			Optional:        true,
+		},
+	},
+}
+
+var dogRequestBinder = &RequestBinder{
+	TypeMapper: NewTypeMapper(boundDogRequestTypeMap),
+	Query: QueryMap{
+		UnderlyingType: boundDogRequest{},
+		ParameterMaps: []ParameterMap{
+			{
+				StructFieldName: "Limit",
+				ParameterName:   "limit",
+				Mapper: IntQueryParameterMapper{
+					Validators: []func(int64) bool{
+						intRangeFactory(1, 100),
+					},
+				},
+			},
+		},
+	},
+	Header: QueryMap{
+		UnderlyingType: boundDogRequest{},
+		ParameterMaps: []ParameterMap{
+			{
+				StructFieldName: "Trace",
+				ParameterName:   "X-Trace-Id",
+				Mapper:          StringQueryParameterMapper{},
+			},
+		},
+	},
+	Path: QueryMap{
+		UnderlyingType: boundDogRequest{},
+		ParameterMaps: []ParameterMap{
+			{
+				StructFieldName: "ID",
+				ParameterName:   "id",
+				Mapper:          StringQueryParameterMapper{},
+			},
+		},
+	},
+	PathParams: func(r *http.Request) map[string][]string {
+		return map[string][]string{"id": {"dog-1"}}
+	},
+}
+
+func TestRequestBinderBind(t *testing.T) {
+	r := httptest.NewRequest("POST", "/dogs/dog-1?limit=5", strings.NewReader(`{"name": "Spot", "age": 4}`))
+	r.Header.Set("X-Trace-Id", "abc")
+
+	dst := &boundDogRequest{}
+	err := dogRequestBinder.Bind(EmptyContext, r, dst)
+	require.NoError(t, err)
+	require.Equal(t, boundDogRequest{
+		ID:    "dog-1",
+		Name:  "Spot",
+		Age:   4,
+		Limit: 5,
+		Trace: "abc",
+	}, *dst)
+}
+
+func TestRequestBinderBindWithoutBody(t *testing.T) {
+	for _, method := range []string{"GET", "POST"} {
+		r := httptest.NewRequest(method, "/dogs/dog-1?limit=5", nil)
+
+		dst := &boundDogRequest{}
+		err := dogRequestBinder.Bind(EmptyContext, r, dst)
+		require.NoError(t, err)
+		require.Equal(t, boundDogRequest{ID: "dog-1", Limit: 5}, *dst)
+	}
+}
+
+func TestRequestBinderBindErrors(t *testing.T) {
+	r := httptest.NewRequest("POST", "/dogs/dog-1?limit=500", strings.NewReader(`{"age": 40}`))
+
+	err := dogRequestBinder.Bind(EmptyContext, r, &boundDogRequest{})
+	require.IsType(t, &MultiValidationError{}, err)
+
+	paths := []string{}
+	for _, e := range err.(*MultiValidationError).Errors() {
+		paths = append(paths, e.Path)
+	}
+	require.Equal(t, []string{"/body/name", "/body/age", "/query/limit"}, paths)
+}
+
+func TestRequestBinderSortErrors(t *testing.T) {
+	binder := *dogRequestBinder
+	binder.SortErrors = true
+
+	r := httptest.NewRequest("POST", "/dogs/dog-1?limit=500", strings.NewReader(`{"age": 40}`))
+	err := binder.Bind(EmptyContext, r, &boundDogRequest{})
+	require.EqualError(t, err, "Validation Errors: \n"+
+		"/body/age: too large, may not be larger than 30\n"+
+		"/body/name: missing required field\n"+
+		"/query/limit: error ocurred while reading value ([500]) into param Limit: a validation test failed\n")
+
+	binder.MaxErrors = 2
+	r = httptest.NewRequest("POST", "/dogs/dog-1?limit=500", strings.NewReader(`{"age": 40}`))
+	err = binder.Bind(EmptyContext, r, &boundDogRequest{})
+	require.EqualError(t, err, "Validation Errors: \n"+
+		"/body/age: too large, may not be larger than 30\n"+
+		"/body/name: missing required field\n"+
+		": ...and 1 more error\n")
+
+	require.True(t, pathLess("/body/items/2", "/body/items/10"))
+	require.True(t, pathLess("/body", "/body/items"))
+	require.True(t, pathLess("", "/body"))
+	require.False(t, pathLess("/query", "/body/items"))
+}
+
+func TestRequestBinderBindInvalidJSON(t *testing.T) {
+	r := httptest.NewRequest("POST", "/dogs/dog-1?limit=5", strings.NewReader(`{"name": `))
+
+	err := dogRequestBinder.Bind(EmptyContext, r, &boundDogRequest{})
+	require.EqualError(t, err, "Validation Errors: \n/body: unexpected end of JSON input\n")
+}
+
+func TestWriteValidationError(t *testing.T) {
+	w := httptest.NewRecorder()
+	err := TestTypeMapper.Unmarshal(EmptyContext, []byte(`{"foo": "foozzzy", "an~int": 11}`), &AnotherInnerThing{})
+	require.True(t, WriteValidationError(w, err))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Equal(t, ProblemContentType, w.Header().Get("Content-Type"))
+	require.JSONEq(t, `{
+		"type": "about:blank",
+		"title": "Bad Request",
+		"status": 400,
+		"errors": [
+			{"path": "/foo", "message": "too long, may not be more than 5 characters"},
+			{"path": "/an~0int", "message": "too large, may not be larger than 10"}
+		]
+	}`, w.Body.String())
+
+	require.False(t, WriteValidationError(httptest.NewRecorder(), errors.New("oops")))
+}
+
+func TestMiddleware(t *testing.T) {
+	h := Middleware(func(w http.ResponseWriter, r *http.Request) error {
+		return dogRequestBinder.Bind(EmptyContext, r, &boundDogRequest{})
+	}, nil)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/dogs/dog-1?limit=5", strings.NewReader(`{}`)))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.JSONEq(t, `{
+		"type": "about:blank",
+		"title": "Bad Request",
+		"status": 400,
+		"errors": [{"path": "/body/name", "message": "missing required field"}]
+	}`, w.Body.String())
+
+	h = Middleware(func(w http.ResponseWriter, r *http.Request) error {
+		return errors.New("oops")
+	}, nil)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestWriteResponse(t *testing.T) {
+	w := httptest.NewRecorder()
+	err := TestTypeMapper.WriteResponse(EmptyContext, w, http.StatusCreated, &InnerThing{Foo: "fooz"})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, w.Code)
+	require.Equal(t, JSONContentType, w.Header().Get("Content-Type"))
+	require.JSONEq(t, `{"foo": "fooz", "an_int": 0, "a_bool": false}`, w.Body.String())
+}
+
+func TestWriteEnvelopedResponse(t *testing.T) {
+	w := httptest.NewRecorder()
+	err := TestTypeMapper.WriteEnvelopedResponse(EmptyContext, w, http.StatusOK, []InnerThing{{Foo: "fooz"}}, map[string]int{"count": 1})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"data": [{"foo": "fooz", "an_int": 0, "a_bool": false}], "meta": {"count": 1}}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	err = TestTypeMapper.WriteEnvelopedResponse(EmptyContext, w, http.StatusOK, &InnerThing{}, nil)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"data": {"foo": "", "an_int": 0, "a_bool": false}}`, w.Body.String())
+}
+
+// prefixedJSONCodec is a stand-in for a real alternative wire format
+type prefixedJSONCodec struct{}
+
+func (c prefixedJSONCodec) ContentType() string {
+	return "application/x-prefixed-json"
+}
+
+func (c prefixedJSONCodec) Encode(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte("prefix:"), data...), nil
+}
+
+func (c prefixedJSONCodec) Decode(data []byte) (interface{}, error) {
+	var partial interface{}
+	err := json.Unmarshal(bytes.TrimPrefix(data, []byte("prefix:")), &partial)
+	return partial, err
+}
+
+var testNegotiator = NewNegotiator(TestTypeMapper, prefixedJSONCodec{})
+
+func TestNegotiateMarshal(t *testing.T) {
+	cases := []struct {
+		Accept       string
+		ContentType  string
+		ExpectedBody string
+	}{
+		{"", "application/json", `{"foo":"fooz","an_int":0,"a_bool":false}`},
+		{"application/x-prefixed-json", "application/x-prefixed-json", `prefix:{"a_bool":false,"an_int":0,"foo":"fooz"}`},
+		{"application/json;q=0.5, application/x-prefixed-json", "application/x-prefixed-json", `prefix:{"a_bool":false,"an_int":0,"foo":"fooz"}`},
+		{"text/html, application/*;q=0.8", "application/json", `{"foo":"fooz","an_int":0,"a_bool":false}`},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept", c.Accept)
+		w := httptest.NewRecorder()
+		err := testNegotiator.NegotiateMarshal(EmptyContext, r, w, http.StatusOK, &InnerThing{Foo: "fooz"})
+		require.NoError(t, err)
+		require.Equal(t, c.ContentType, w.Header().Get("Content-Type"))
+		require.Equal(t, c.ExpectedBody, w.Body.String())
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept", "text/html")
+	err := testNegotiator.NegotiateMarshal(EmptyContext, r, httptest.NewRecorder(), http.StatusOK, &InnerThing{})
+	require.Equal(t, ErrNotAcceptable, err)
+}
+
+func TestNegotiateUnmarshal(t *testing.T) {
+	r := httptest.NewRequest("POST", "/", strings.NewReader(`prefix:{"foo": "fooz"}`))
+	r.Header.Set("Content-Type", "application/x-prefixed-json")
+	v := &InnerThing{}
+	err := testNegotiator.NegotiateUnmarshal(EmptyContext, r, v)
+	require.NoError(t, err)
+	require.Equal(t, "fooz", v.Foo)
+
+	r = httptest.NewRequest("POST", "/", strings.NewReader(`prefix:{"foo": "fooziswaytoolooong"}`))
+	r.Header.Set("Content-Type", "application/x-prefixed-json")
+	err = testNegotiator.NegotiateUnmarshal(EmptyContext, r, v)
+	require.EqualError(t, err, "Validation Errors: \n/foo: too long, may not be more than 12 characters\n")
+
+	r = httptest.NewRequest("POST", "/", strings.NewReader(`{"foo": "fooz"}`))
+	r.Header.Set("Content-Type", "application/json; charset=utf-8")
+	err = testNegotiator.NegotiateUnmarshal(EmptyContext, r, v)
+	require.NoError(t, err)
+
+	r = httptest.NewRequest("POST", "/", strings.NewReader(`foo=fooz`))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	err = testNegotiator.NegotiateUnmarshal(EmptyContext, r, v)
+	require.Equal(t, ErrUnsupportedMediaType, err)
+}
+
+// ginBinding mirrors gin's binding.Binding interface
+type ginBinding interface {
+	Name() string
+	Bind(*http.Request, interface{}) error
+}
+
+func TestRouterBinding(t *testing.T) {
+	var b ginBinding = NewRouterBinding(EmptyContext, dogRequestBinder)
+	require.Equal(t, "jsonmap", b.Name())
+
+	r := httptest.NewRequest("POST", "/dogs/dog-1?limit=5", strings.NewReader(`{"name": "Spot"}`))
+	dst := &boundDogRequest{}
+	require.NoError(t, b.Bind(r, dst))
+	require.Equal(t, "Spot", dst.Name)
+
+	r = httptest.NewRequest("POST", "/dogs/dog-1?limit=5", strings.NewReader(`{}`))
+	require.IsType(t, &MultiValidationError{}, b.Bind(r, dst))
+}
+
+func TestBindFrom(t *testing.T) {
+	r := httptest.NewRequest("POST", "/dogs/dog-1?limit=5", strings.NewReader(`{"name": "Spot"}`))
+	dst := &boundDogRequest{}
+	err := dogRequestBinder.BindFrom(EmptyContext, func() *http.Request { return r }, dst)
+	require.NoError(t, err)
+	require.Equal(t, 5, dst.Limit)
+}
+
+func TestPathParamsFunc(t *testing.T) {
+	urlParam := func(r *http.Request, name string) string {
+		if name == "id" {
+			return strings.TrimPrefix(r.URL.Path, "/dogs/")
+		}
+		return ""
+	}
+
+	params := PathParamsFunc(urlParam, "id", "owner")(httptest.NewRequest("GET", "/dogs/dog-2", nil))
+	require.Equal(t, map[string][]string{"id": {"dog-2"}}, params)
+}
+
+func TestEventMapper(t *testing.T) {
+	em := NewEventMapper()
+
+	var received *InnerThing
+	em.Register("inner", InnerThingTypeMap, func(ctx Context, payload interface{}) error {
+		received = payload.(*InnerThing)
+		return nil
+	})
+	em.Register("other", OtherInnerThingTypeMap, nil)
+
+	err := em.Dispatch(EmptyContext, []byte(`{"type": "inner", "payload": {"foo": "fooz"}}`))
+	require.NoError(t, err)
+	require.Equal(t, "fooz", received.Foo)
+
+	eventType, payload, err := em.Decode(EmptyContext, []byte(`{"type": "other", "payload": {"bar": "barz"}}`))
+	require.NoError(t, err)
+	require.Equal(t, "other", eventType)
+	require.Equal(t, &OtherInnerThing{Bar: "barz"}, payload)
+
+	err = em.Dispatch(EmptyContext, []byte(`{"type": "other", "payload": {"bar": "barz"}}`))
+	require.EqualError(t, err, "no handler for event type: 'other'")
+
+	err = em.Dispatch(EmptyContext, []byte(`{"type": "inner", "payload": {"foo": "fooziswaytoolooong"}}`))
+	require.EqualError(t, err, "Validation Errors: \n/payload/foo: too long, may not be more than 12 characters\n")
+
+	_, _, err = em.Decode(EmptyContext, []byte(`{"type": "unknown", "payload": {}}`))
+	require.Error(t, err)
+
+	data, err := em.Marshal(EmptyContext, &InnerThing{Foo: "fooz"})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"type": "inner", "payload": {"foo": "fooz", "an_int": 0, "a_bool": false}}`, string(data))
+
+	_, err = em.Marshal(EmptyContext, nil)
+	require.EqualError(t, err, "no event type registered for type: <nil>")
+
+	_, err = em.Marshal(EmptyContext, &OuterThing{})
+	require.EqualError(t, err, "no event type registered for type: *jsonmap.OuterThing")
+}
+
+func TestApplyMergePatch(t *testing.T) {
+	v := &OuterPointerThing{
+		InnerThing: &InnerThing{
+			Foo:   "fooz",
+			AnInt: 3,
+			ABool: true,
+		},
+	}
+
+	err := TestTypeMapper.ApplyMergePatch(EmptyContext, []byte(`{"inner_thing": {"an_int": 5, "foo": null}}`), v)
+	require.NoError(t, err)
+	require.Equal(t, &InnerThing{AnInt: 5, ABool: true}, v.InnerThing)
+
+	err = TestTypeMapper.ApplyMergePatch(EmptyContext, []byte(`{"inner_thing": {"an_int": 11}}`), v)
+	require.EqualError(t, err, "Validation Errors: \n/inner_thing/an_int: too large, may not be larger than 10\n")
+
+	require.PanicsWithValue(t, "cannot apply patch to non-pointer", func() {
+		TestTypeMapper.ApplyMergePatch(EmptyContext, []byte(`{}`), nil)
+	})
+}
+
+func TestApplyMergePatchMap(t *testing.T) {
+	v := &ThingWithMapOfStrings{Strings: map[string]string{"x": "1", "y": "2"}}
+
+	err := TestTypeMapper.ApplyMergePatch(EmptyContext, []byte(`{"strings": {"x": null, "z": "3"}}`), v)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"y": "2", "z": "3"}, v.Strings)
+
+	// The map is left alone if any member of the patch is invalid
+	err = TestTypeMapper.ApplyMergePatch(EmptyContext, []byte(`{"strings": {"y": null, "z": 4}}`), v)
+	require.EqualError(t, err, "Validation Errors: \n/strings/z: not a string\n")
+	require.Equal(t, map[string]string{"y": "2", "z": "3"}, v.Strings)
+
+	v = &ThingWithMapOfStrings{}
+	err = TestTypeMapper.ApplyMergePatch(EmptyContext, []byte(`{"strings": {"a": "1"}}`), v)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"a": "1"}, v.Strings)
+}
+
+func TestApplyMergePatchRequiredField(t *testing.T) {
+	v := &OuterThing{}
+	err := TestTypeMapper.ApplyMergePatch(EmptyContext, []byte(`{"inner_thing": null}`), v)
+	require.EqualError(t, err, "Validation Errors: \n/inner_thing: cannot remove required field\n")
+}
+
+func TestApplyMergePatchReadOnlyField(t *testing.T) {
+	v := &ReadOnlyThing{PrimaryKey: "foo"}
+	err := TestTypeMapper.ApplyMergePatch(EmptyContext, []byte(`{"primary_key": "bar"}`), v)
+	require.NoError(t, err)
+	require.Equal(t, "foo", v.PrimaryKey)
+}
+
+func TestDiff(t *testing.T) {
+	old := &OuterSliceThing{
+		InnerThings: []InnerThing{
+			{Foo: "fooz", AnInt: 1},
+			{Foo: "barz"},
+			{Foo: "bazz"},
+		},
+	}
+	new := &OuterSliceThing{
+		InnerThings: []InnerThing{
+			{Foo: "fooz", AnInt: 2, ABool: true},
+		},
+	}
+
+	cs, err := TestTypeMapper.Diff(EmptyContext, old, new)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"/inner_things/0/a_bool",
+		"/inner_things/0/an_int",
+		"/inner_things/2",
+		"/inner_things/1",
+	}, cs.Paths)
+
+	patch, err := cs.JSONPatch()
+	require.NoError(t, err)
+	require.JSONEq(t, `[
+		{"op": "replace", "path": "/inner_things/0/a_bool", "value": true},
+		{"op": "replace", "path": "/inner_things/0/an_int", "value": 2},
+		{"op": "remove", "path": "/inner_things/2"},
+		{"op": "remove", "path": "/inner_things/1"}
+	]`, string(patch))
+
+	cs, err = TestTypeMapper.Diff(EmptyContext, new, old)
+	require.NoError(t, err)
+	require.Equal(t, "add", cs.Operations[2].Op)
+	require.JSONEq(t, `{"foo": "barz", "an_int": 0, "a_bool": false}`, string(cs.Operations[2].Value))
+}
+
+func TestDiffNoChanges(t *testing.T) {
+	v := &OuterPointerThing{}
+	cs, err := TestTypeMapper.Diff(EmptyContext, v, v)
+	require.NoError(t, err)
+	require.Empty(t, cs.Paths)
+
+	patch, err := cs.JSONPatch()
+	require.NoError(t, err)
+	require.Equal(t, "[]", string(patch))
+}
+
+func TestUnmarshalTracked(t *testing.T) {
+	v := &AnotherOuterThing{}
+	present, err := TestTypeMapper.UnmarshalTracked(EmptyContext, []byte(`{"another/inner/thing": {"foo": "fooz", "an~int": 4, "unmapped": true}}`), v)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"/another~1inner~1thing",
+		"/another~1inner~1thing/foo",
+		"/another~1inner~1thing/an~0int",
+	}, present)
+
+	present, err = TestTypeMapper.UnmarshalTracked(EmptyContext, []byte(`{"inner_things": [{"foo": "fooz"}]}`), &OuterSliceThing{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"/inner_things"}, present)
+
+	present, err = TestTypeMapper.UnmarshalTracked(EmptyContext, []byte(`{"primary_key": "foo"}`), &ReadOnlyThing{})
+	require.NoError(t, err)
+	require.Empty(t, present)
+
+	_, err = TestTypeMapper.UnmarshalTracked(EmptyContext, []byte(`{"inner_things": 1}`), &OuterSliceThing{})
+	require.Error(t, err)
+}
+
+func TestLegacyMarshal(t *testing.T) {
+	legacy := NewTypeMapper()
+	legacy.typeMaps = TestTypeMapper.typeMaps
+	legacy.LegacyMarshal = true
+
+	ctx := struct {
+		Foo string
+	}{
+		Foo: "foo",
+	}
+
+	values := []interface{}{
+		&OuterSliceThing{InnerThings: []InnerThing{{Foo: "<fooz>"}, {AnInt: 3}}},
+		&OuterPointerThing{},
+		&ThingWithMapOfInterfaces{Interfaces: map[string]interface{}{"b": 1, "a": []string{"x"}}},
+		&OuterVariableThing{InnerType: "foo", InnerValue: &InnerThing{Foo: "fooz"}},
+		&TemplatableThing{SomeField: "bar"},
+		[]*InnerThing{{Foo: "fooz"}, nil},
+	}
+
+	for _, v := range values {
+		expected, err := legacy.Marshal(ctx, v)
+		require.NoError(t, err)
+
+		actual, err := TestTypeMapper.Marshal(ctx, v)
+		require.NoError(t, err)
+		require.Equal(t, string(expected), string(actual))
+	}
+}
+
+func TestUnmarshalReader(t *testing.T) {
+	v := &OuterSliceThing{}
+	err := TestTypeMapper.UnmarshalReader(EmptyContext, strings.NewReader(`{"unknown": {"a": [1, {}]}, "inner_things": [{"foo": "fooz"}, {"an_int": 2}]}`), v)
+	require.NoError(t, err)
+	require.Equal(t, []InnerThing{{Foo: "fooz"}, {AnInt: 2}}, v.InnerThings)
+}
+
+func TestUnmarshalVariableTypeBeforeTypeIdentifier(t *testing.T) {
+	v := &OuterVariableThing{}
+	err := TestTypeMapper.Unmarshal(EmptyContext, []byte(`{"inner_thing": {"bar": "barz"}, "inner_type": "bar"}`), v)
+	require.NoError(t, err)
+	require.Equal(t, &OtherInnerThing{Bar: "barz"}, v.InnerValue)
+}
+
+func TestUnmarshalTrailingData(t *testing.T) {
+	err := TestTypeMapper.Unmarshal(EmptyContext, []byte(`{"foo": "fooz"} {}`), &InnerThing{})
+	require.EqualError(t, err, "invalid character after top-level value")
+}
+
+func TestUnmarshalFailFast(t *testing.T) {
+	tm := NewTypeMapper(InnerThingTypeMap, OuterSliceThingTypeMap)
+	tm.FailFast = true
+
+	err := tm.Unmarshal(EmptyContext, []byte(`{"inner_things": [{"foo": "fooziswaytoolooong"}, {"foo": "fooziswaytoolooong2"}, {"this is": "definitely invalid JSON]`), &OuterSliceThing{})
+	require.EqualError(t, err, "Validation Errors: \n/inner_things/0/foo: too long, may not be more than 12 characters\n")
+}
+
+func TestUnmarshalSliceOverMaxSkipsElements(t *testing.T) {
+	v := &OuterMaxSliceThing{}
+	err := TestTypeMapper.Unmarshal(EmptyContext, []byte(`{"inner_things": [{}, {}, {"foo": 1}, {"foo": 2}]}`), v)
+	require.EqualError(t, err, "Validation Errors: \n/inner_things: must have at most 2 elements\n")
+}
+
+func TestFieldCacheWarmedByNewTypeMapper(t *testing.T) {
+	NewTypeMapper(OuterThingTypeMap)
+	index, ok := fieldIndexCache.Load(fieldCacheKey{reflect.TypeOf(InnerThing{}), "AnInt"})
+	require.True(t, ok)
+	require.Equal(t, []int{1}, index)
+}
+
+func TestFieldCacheEmbeddedField(t *testing.T) {
+	v := reflect.ValueOf(pagedDogFilter{paginationParams: paginationParams{Limit: 5}})
+	require.Equal(t, 5, int(fieldByName(v, "Limit").Int()))
+	require.False(t, fieldByName(v, "Nope").IsValid())
+}
+
+type nestedBenchThing struct {
+	Name   string
+	Child  *nestedBenchThing
+	Things []InnerThing
+}
+
+var nestedBenchThingTypeMap = &StructMap{
+	UnderlyingType: nestedBenchThing{},
+}
+
+func init() {
+	nestedBenchThingTypeMap.Fields = []MappedField{
+		{
+			StructFieldName: "Name",
+			JSONFieldName:   "name",
+			Validator:       String(0, 64),
+		},
+		{
+			StructFieldName: "Child",
+			JSONFieldName:   "child",
+			Contains:        nestedBenchThingTypeMap,
+			Optional:        true,
+		},
+		{
+			StructFieldName: "Things",
+			JSONFieldName:   "things",
+			Contains:        SliceOf(InnerThingTypeMap),
+		},
+	}
+}
+
+func newNestedBenchThing(depth int) *nestedBenchThing {
+	v := &nestedBenchThing{
+		Name:   "level",
+		Things: []InnerThing{{Foo: "fooz", AnInt: 1}, {Foo: "barz", ABool: true}},
+	}
+	if depth > 0 {
+		v.Child = newNestedBenchThing(depth - 1)
+	}
+	return v
+}
+
+var benchTypeMapper = NewTypeMapper(nestedBenchThingTypeMap)
+
+func BenchmarkMarshalNested(b *testing.B) {
+	v := newNestedBenchThing(20)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, err := benchTypeMapper.Marshal(EmptyContext, v)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshalNested(b *testing.B) {
+	data, err := benchTypeMapper.Marshal(EmptyContext, newNestedBenchThing(20))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		err := benchTypeMapper.Unmarshal(EmptyContext, data, &nestedBenchThing{})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFieldByName(b *testing.B) {
+	v := reflect.ValueOf(pagedDogFilter{})
+	for i := 0; i < b.N; i++ {
+		v.FieldByName("Cursor")
+	}
+}
+
+func BenchmarkCachedFieldByName(b *testing.B) {
+	v := reflect.ValueOf(pagedDogFilter{})
+	for i := 0; i < b.N; i++ {
+		fieldByName(v, "Cursor")
+	}
+}
+
+var staticTestTargets = []StaticTarget{
+	{"InnerThingTypeMap", InnerThingTypeMap},
+	{"AnotherInnerThingTypeMap", AnotherInnerThingTypeMap},
+	{"OuterThingTypeMap", OuterThingTypeMap},
+	{"OuterPointerThingTypeMap", OuterPointerThingTypeMap},
+	{"SessionClaimsTypeMap", SessionClaimsTypeMap},
+	{"backoffPolicyTypeMap", backoffPolicyTypeMap},
+	{"retryPolicyTypeMap", retryPolicyTypeMap},
+	{"jobConfigTypeMap", jobConfigTypeMap},
+	{"MeasurementTypeMap", MeasurementTypeMap},
+}
+
+// static_gen_test.go is generated from staticTestTargets, and is regenerated
+// by running the tests with JSONMAP_UPDATE_GOLDEN=1
+func TestGenerateStatic(t *testing.T) {
+	buf := &bytes.Buffer{}
+	err := GenerateStatic(buf, "jsonmap", staticTestTargets...)
+	require.NoError(t, err)
+
+	if os.Getenv("JSONMAP_UPDATE_GOLDEN") != "" {
+		require.NoError(t, ioutil.WriteFile("static_gen_test.go", buf.Bytes(), 0644))
+	}
+
+	expected, err := ioutil.ReadFile("static_gen_test.go")
+	require.NoError(t, err)
+	require.Equal(t, string(expected), buf.String())
+}
+
+func TestGenerateStaticNotInPackage(t *testing.T) {
+	err := GenerateStatic(&bytes.Buffer{}, "other", StaticTarget{"InnerThingTypeMap", InnerThingTypeMap})
+	require.EqualError(t, err, "InnerThingTypeMap: jsonmap.InnerThing is not a struct declared in package other")
+}
+
+var staticTypeMapper = NewTypeMapper(
+	InnerThingTypeMapStatic,
+	AnotherInnerThingTypeMapStatic,
+	OuterThingTypeMapStatic,
+	OuterPointerThingTypeMapStatic,
+)
+
+func TestStaticMap(t *testing.T) {
+	inputs := []struct {
+		Input string
+		Into  interface{}
+	}{
+		{`{"foo": "fooz", "an_int": 4}`, &InnerThing{}},
+		{`{"foo": "foozzzy", "an~int": 11, "happened_at": "hi", "thanks": "baz"}`, &AnotherInnerThing{}},
+		{`{"foo": "fooz", "happened_at": "2015-01-01T00:00:00Z", "thanks": "foo"}`, &AnotherInnerThing{}},
+		{`{"inner_thing": {"foo": "fooz", "an_int": 11}}`, &OuterThing{}},
+		{`{"inner_thing": {"a_bool": true}}`, &OuterPointerThing{}},
+		{`{"inner_thing": null}`, &OuterPointerThing{}},
+		{`{"inner_thing": 12}`, &OuterThing{}},
+		{`{}`, &OuterThing{}},
+	}
+
+	for _, in := range inputs {
+		expected := reflect.New(reflect.TypeOf(in.Into).Elem()).Interface()
+		expectedErr := TestTypeMapper.Unmarshal(EmptyContext, []byte(in.Input), expected)
+
+		actual := reflect.New(reflect.TypeOf(in.Into).Elem()).Interface()
+		actualErr := staticTypeMapper.Unmarshal(EmptyContext, []byte(in.Input), actual)
+
+		require.Equal(t, expectedErr, actualErr, in.Input)
+		require.Equal(t, expected, actual, in.Input)
+
+		if expectedErr == nil {
+			expectedData, err := TestTypeMapper.Marshal(EmptyContext, expected)
+			require.NoError(t, err)
+
+			actualData, err := staticTypeMapper.Marshal(EmptyContext, actual)
+			require.NoError(t, err)
+			require.Equal(t, string(expectedData), string(actualData))
+		}
+	}
+}
+
+func TestStaticMapMergeMode(t *testing.T) {
+	doc := []byte(`{"inner_thing": {"foo": "new"}}`)
+
+	for _, mode := range []MergeMode{MergeNone, MergePreserve, MergeReset} {
+		reflective := NewTypeMapper(OuterPointerThingTypeMap)
+		reflective.MergeMode = mode
+		static := NewTypeMapper(OuterPointerThingTypeMapStatic)
+		static.MergeMode = mode
+
+		expected := &OuterPointerThing{InnerThing: &InnerThing{Foo: "old", AnInt: 3}}
+		require.NoError(t, reflective.Unmarshal(EmptyContext, doc, expected))
+
+		actual := &OuterPointerThing{InnerThing: &InnerThing{Foo: "old", AnInt: 3}}
+		require.NoError(t, static.Unmarshal(EmptyContext, doc, actual))
+
+		require.Equal(t, expected, actual, mode)
+	}
+}
+
+func TestStaticMapFieldSet(t *testing.T) {
+	v := &OuterThing{InnerThing: InnerThing{Foo: "fooz", AnInt: 3, ABool: true}}
+	ctx := WithFieldSet(EmptyContext, ParseFieldSet("inner_thing.foo"))
+
+	expected, err := TestTypeMapper.Marshal(ctx, v)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"inner_thing": {"foo": "fooz"}}`, string(expected))
+
+	actual, err := staticTypeMapper.Marshal(ctx, v)
+	require.NoError(t, err)
+	require.Equal(t, string(expected), string(actual))
+}
+
+type ThingWithDeferredField struct {
+	Name    string
+	Details DeferredValue
+}
+
+var ThingWithDeferredFieldTypeMap = StructMap{
+	UnderlyingType: ThingWithDeferredField{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "Name",
+			JSONFieldName:   "name",
+			Validator:       String(1, 12),
+		},
+		{
+			StructFieldName: "Details",
+			JSONFieldName:   "details",
+			Contains:        Deferred(InnerThingTypeMap),
+			Optional:        true,
+		},
+	},
+}
+
+func TestDeferred(t *testing.T) {
+	tm := NewTypeMapper(ThingWithDeferredFieldTypeMap)
+
+	v := &ThingWithDeferredField{}
+	err := tm.Unmarshal(EmptyContext, []byte(`{"name": "fooz", "details": {"foo": "fooziswaytoolooong", "an_int": 3}}`), v)
+	require.NoError(t, err)
+	require.True(t, v.Details.IsSet())
+	require.JSONEq(t, `{"foo": "fooziswaytoolooong", "an_int": 3}`, string(v.Details.Raw()))
+
+	inner := &InnerThing{}
+	err = v.Details.Decode(inner)
+	require.EqualError(t, err, "Validation Errors: \n/foo: too long, may not be more than 12 characters\n")
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"name": "fooz", "details": {"foo": "fooz", "an_int": 3}}`), v)
+	require.NoError(t, err)
+	err = v.Details.Decode(inner)
+	require.NoError(t, err)
+	require.Equal(t, &InnerThing{Foo: "fooz", AnInt: 3}, inner)
+
+	data, err := tm.Marshal(EmptyContext, v)
+	require.NoError(t, err)
+	require.Equal(t, `{"name":"fooz","details":{"foo":"fooz","an_int":3}}`, string(data))
+
+	for _, input := range []string{`{"name": "fooz"}`, `{"name": "fooz", "details": null}`} {
+		v = &ThingWithDeferredField{}
+		err = tm.Unmarshal(EmptyContext, []byte(input), v)
+		require.NoError(t, err)
+		require.False(t, v.Details.IsSet())
+		require.Error(t, v.Details.Decode(inner))
+	}
+}
+
+func init() {
+	RegisterTemplateFuncs(template.FuncMap{
+		"shout": strings.ToUpper,
+	})
+}
+
+func TestStringRendererFuncs(t *testing.T) {
+	tm := NewTypeMapper(StructMap{
+		UnderlyingType: TemplatableThing{},
+		Fields: []MappedField{
+			{
+				StructFieldName: "SomeField",
+				JSONFieldName:   "some_field",
+				Contains: StringRendererFuncs("{{shout .Value}}/{{path .Value}}", template.FuncMap{
+					"path": url.PathEscape,
+				}),
+			},
+		},
+	})
+
+	data, err := tm.Marshal(EmptyContext, &TemplatableThing{SomeField: "a b"})
+	require.NoError(t, err)
+	require.Equal(t, `{"some_field":"A B/a%20b"}`, string(data))
+}
+
+func TestParseStringRendererError(t *testing.T) {
+	_, err := ParseStringRenderer("{{nope .Value}}", nil)
+	require.EqualError(t, err, `template: :1: function "nope" not defined`)
+
+	require.Panics(t, func() {
+		StringRenderer("{{.Value")
+	})
+}
+
+func TestValueRenderer(t *testing.T) {
+	tm := NewTypeMapper(StructMap{
+		UnderlyingType: TemplatableThing{},
+		Fields: []MappedField{
+			{
+				StructFieldName: "SomeField",
+				JSONFieldName:   "_links",
+				Contains: ValueRenderer(func(info RenderInfo) (interface{}, error) {
+					return map[string]interface{}{
+						"self": map[string]string{
+							"href": info.Context.(string) + "/things/" + info.Value.(string),
+						},
+						"count": len(info.Parent.(TemplatableThing).SomeField),
+					}, nil
+				}),
+			},
+		},
+	})
+
+	data, err := tm.Marshal("https://example.com", &TemplatableThing{SomeField: "abc"})
+	require.NoError(t, err)
+	require.Equal(t, `{"_links":{"count":3,"self":{"href":"https://example.com/things/abc"}}}`, string(data))
+
+	v := &TemplatableThing{}
+	err = tm.Unmarshal(EmptyContext, []byte(`{"_links": {"self": "ignored"}}`), v)
+	require.NoError(t, err)
+	require.Equal(t, "", v.SomeField)
+}
+
+func TestCtx(t *testing.T) {
+	base := NewCtx("request")
+	require.Equal(t, base, NewCtx(base))
+
+	child := base.With("user", "alice").With("count", 3)
+	require.Equal(t, "request", child.Base())
+	require.Equal(t, "alice", child.Value("user"))
+
+	_, ok := base.Get("user")
+	require.False(t, ok)
+
+	var count int
+	require.True(t, child.Lookup("count", &count))
+	require.Equal(t, 3, count)
+
+	var user int
+	require.False(t, child.Lookup("user", &user))
+	require.False(t, child.Lookup("missing", &user))
+}
+
+type ThingWithContextualChild struct {
+	Name  string
+	Child TemplatableThing
+}
+
+func TestWithParentContext(t *testing.T) {
+	tm := NewTypeMapper(StructMap{
+		UnderlyingType: ThingWithContextualChild{},
+		Fields: []MappedField{
+			{
+				StructFieldName: "Name",
+				JSONFieldName:   "name",
+				Validator:       String(1, 12),
+			},
+			{
+				StructFieldName: "Child",
+				JSONFieldName:   "child",
+				Contains: WithParentContext(StructMap{
+					UnderlyingType: TemplatableThing{},
+					Fields: []MappedField{
+						{
+							StructFieldName: "SomeField",
+							JSONFieldName:   "some_field",
+							Contains: ValueRenderer(func(info RenderInfo) (interface{}, error) {
+								parent, _ := ParentOf(info.Context)
+								return info.Context.(*Ctx).Base().(string) + ":" + parent.(*ThingWithContextualChild).Name, nil
+							}),
+						},
+					},
+				}),
+			},
+		},
+	})
+
+	data, err := tm.Marshal("ctx", &ThingWithContextualChild{Name: "outer"})
+	require.NoError(t, err)
+	require.Equal(t, `{"name":"outer","child":{"some_field":"ctx:outer"}}`, string(data))
+}
+
+var versionedInnerThingTypeMap = StructMap{
+	UnderlyingType: InnerThing{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "Foo",
+			JSONFieldName:   "foo",
+			Validator:       String(1, 3),
+			UntilVersion:    1,
+		},
+		{
+			StructFieldName: "Foo",
+			JSONFieldName:   "foo",
+			Validator:       String(1, 12),
+			SinceVersion:    2,
+		},
+		{
+			StructFieldName: "AnInt",
+			JSONFieldName:   "an_int",
+			Validator:       Integer(0, 10),
+			SinceVersion:    2,
+		},
+	},
+}
+
+func TestVersionedFields(t *testing.T) {
+	tm := NewTypeMapper(versionedInnerThingTypeMap)
+
+	v := &InnerThing{}
+	err := tm.Unmarshal(WithVersion(EmptyContext, 1), []byte(`{"foo": "fooz", "an_int": 3}`), v)
+	require.EqualError(t, err, "Validation Errors: \n/foo: too long, may not be more than 3 characters\n")
+
+	err = tm.Unmarshal(WithVersion(EmptyContext, 1), []byte(`{"foo": "foo", "an_int": 3}`), v)
+	require.NoError(t, err)
+	require.Equal(t, &InnerThing{Foo: "foo"}, v)
+
+	v = &InnerThing{}
+	err = tm.Unmarshal(WithVersion(EmptyContext, 2), []byte(`{"foo": "fooz", "an_int": 3}`), v)
+	require.NoError(t, err)
+	require.Equal(t, &InnerThing{Foo: "fooz", AnInt: 3}, v)
+
+	data, err := tm.Marshal(WithVersion(EmptyContext, 1), v)
+	require.NoError(t, err)
+	require.Equal(t, `{"foo":"fooz"}`, string(data))
+
+	data, err = tm.Marshal(WithVersion(EmptyContext, 3), v)
+	require.NoError(t, err)
+	require.Equal(t, `{"foo":"fooz","an_int":3}`, string(data))
+
+	version, ok := VersionOf(WithVersion(EmptyContext, 3))
+	require.True(t, ok)
+	require.Equal(t, 3, version)
+
+	_, ok = VersionOf(EmptyContext)
+	require.False(t, ok)
+
+	// Without a version, the latest applies
+	data, err = tm.Marshal(EmptyContext, v)
+	require.NoError(t, err)
+	require.Equal(t, `{"foo":"fooz","an_int":3}`, string(data))
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"foo": "fooz", "an_int": 3}`), &InnerThing{})
+	require.NoError(t, err)
+
+	err = GenerateStatic(&bytes.Buffer{}, "jsonmap", StaticTarget{"versionedInnerThingTypeMap", versionedInnerThingTypeMap})
+	require.EqualError(t, err, "versionedInnerThingTypeMap: field foo is versioned, which isn't supported")
+
+	overlapping := versionedInnerThingTypeMap
+	overlapping.Fields = append([]MappedField{}, overlapping.Fields...)
+	overlapping.Fields[0].UntilVersion = 2
+	require.PanicsWithValue(t, "fields share JSON name in overlapping versions: foo", func() {
+		NewTypeMapper(overlapping)
+	})
+}
+
+func TestParseFieldSet(t *testing.T) {
+	require.Nil(t, ParseFieldSet(""))
+	require.Equal(t, FieldSet{
+		"foo":  nil,
+		"bar":  FieldSet{"baz": nil, "qux": nil},
+		"quux": nil,
+	}, ParseFieldSet("foo, bar.baz,bar.qux,,quux.a,quux"))
+	require.Equal(t, FieldSet{"foo": nil}, ParseFieldSet("foo,foo.bar"))
+}
+
+func TestMarshalFieldSet(t *testing.T) {
+	v := &OuterThing{InnerThing: InnerThing{Foo: "fooz", AnInt: 3, ABool: true}}
+
+	for _, legacy := range []bool{false, true} {
+		tm := NewTypeMapper(OuterThingTypeMap)
+		tm.LegacyMarshal = legacy
+
+		data, err := tm.Marshal(WithFieldSet(EmptyContext, ParseFieldSet("inner_thing.foo,inner_thing.a_bool")), v)
+		require.NoError(t, err)
+		require.Equal(t, `{"inner_thing":{"foo":"fooz","a_bool":true}}`, string(data))
+
+		data, err = tm.Marshal(WithFieldSet(EmptyContext, ParseFieldSet("inner_thing")), v)
+		require.NoError(t, err)
+		require.Equal(t, `{"inner_thing":{"foo":"fooz","an_int":3,"a_bool":true}}`, string(data))
+
+		_, err = tm.Marshal(WithFieldSet(EmptyContext, ParseFieldSet("inner_thing.bogus,nope")), v)
+		require.Error(t, err)
+		verr, ok := err.(*ValidationError)
+		require.True(t, ok)
+		require.Len(t, verr.Flatten().Errors(), 1)
+		require.Equal(t, "/nope", verr.Flatten().Errors()[0].Path)
+		require.Equal(t, "no such field", verr.Flatten().Errors()[0].Message)
+
+		_, err = tm.Marshal(WithFieldSet(EmptyContext, ParseFieldSet("inner_thing.bogus")), v)
+		require.Error(t, err)
+		require.Equal(t, "/inner_thing/bogus", err.(*ValidationError).Flatten().Errors()[0].Path)
+	}
+}
+
+type ExpandablePerson struct {
+	ID      string
+	Name    string
+	Friends []ExpandablePerson
+}
+
+type ThingWithOwner struct {
+	Owner ExpandablePerson
+}
+
+var ExpandablePersonIDTypeMap = StructMap{
+	UnderlyingType: ExpandablePerson{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "ID",
+			JSONFieldName:   "id",
+			Validator:       String(1, 12),
+		},
+	},
+}
+
+var ExpandableFriendTypeMap = StructMap{
+	UnderlyingType: ExpandablePerson{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "ID",
+			JSONFieldName:   "id",
+			Validator:       String(1, 12),
+		},
+		{
+			StructFieldName: "Name",
+			JSONFieldName:   "name",
+			Validator:       String(1, 12),
+		},
+	},
+}
+
+var ExpandablePersonTypeMap = StructMap{
+	UnderlyingType: ExpandablePerson{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "ID",
+			JSONFieldName:   "id",
+			Validator:       String(1, 12),
+		},
+		{
+			StructFieldName: "Name",
+			JSONFieldName:   "name",
+			Validator:       String(1, 12),
+		},
+		{
+			StructFieldName: "Friends",
+			JSONFieldName:   "friends",
+			Contains:        SliceOf(Expandable(ExpandableFriendTypeMap, ExpandablePersonIDTypeMap)),
+			Optional:        true,
+		},
+	},
+}
+
+var ThingWithOwnerTypeMap = StructMap{
+	UnderlyingType: ThingWithOwner{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "Owner",
+			JSONFieldName:   "owner",
+			Contains:        Expandable(ExpandablePersonTypeMap, ExpandablePersonIDTypeMap),
+		},
+	},
+}
+
+func TestExpandable(t *testing.T) {
+	v := &ThingWithOwner{
+		Owner: ExpandablePerson{
+			ID:      "alice",
+			Name:    "Alice",
+			Friends: []ExpandablePerson{{ID: "bob", Name: "Bob"}},
+		},
+	}
+
+	for _, legacy := range []bool{false, true} {
+		tm := NewTypeMapper(ThingWithOwnerTypeMap)
+		tm.LegacyMarshal = legacy
+
+		data, err := tm.Marshal(EmptyContext, v)
+		require.NoError(t, err)
+		require.Equal(t, `{"owner":{"id":"alice"}}`, string(data))
+
+		data, err = tm.Marshal(WithExpand(EmptyContext, ParseFieldSet("owner")), v)
+		require.NoError(t, err)
+		require.Equal(t, `{"owner":{"id":"alice","name":"Alice","friends":[{"id":"bob"}]}}`, string(data))
+
+		data, err = tm.Marshal(WithExpand(EmptyContext, ParseFieldSet("owner.friends")), v)
+		require.NoError(t, err)
+		require.Equal(t, `{"owner":{"id":"alice","name":"Alice","friends":[{"id":"bob","name":"Bob"}]}}`, string(data))
+	}
+
+	tm := NewTypeMapper(ThingWithOwnerTypeMap)
+
+	u := &ThingWithOwner{}
+	err := tm.Unmarshal(EmptyContext, []byte(`{"owner": {"id": "alice"}}`), u)
+	require.NoError(t, err)
+	require.Equal(t, &ThingWithOwner{Owner: ExpandablePerson{ID: "alice"}}, u)
+
+	u = &ThingWithOwner{}
+	err = tm.Unmarshal(WithExpand(EmptyContext, ParseFieldSet("owner")), []byte(`{"owner": {"id": "alice", "name": "Alice"}}`), u)
+	require.NoError(t, err)
+	require.Equal(t, &ThingWithOwner{Owner: ExpandablePerson{ID: "alice", Name: "Alice"}}, u)
+}
+
+type Article struct {
+	ID     string
+	Title  string
+	Author ExpandablePerson
+}
+
+var ArticleJSONAPIMap = JSONAPI("articles", StructMap{
+	UnderlyingType: Article{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "ID",
+			JSONFieldName:   "id",
+			Validator:       String(1, 12),
+		},
+		{
+			StructFieldName: "Title",
+			JSONFieldName:   "title",
+			Validator:       String(1, 12),
+		},
+		{
+			StructFieldName: "Author",
+			JSONFieldName:   "author",
+			Contains:        JSONAPI("people", ExpandablePersonIDTypeMap),
+			Relationship:    true,
+		},
+	},
+})
+
+func TestJSONAPI(t *testing.T) {
+	tm := NewTypeMapper(ArticleJSONAPIMap)
+
+	v := &Article{ID: "1", Title: "Hello", Author: ExpandablePerson{ID: "alice"}}
+
+	data, err := tm.MarshalJSONAPI(EmptyContext, v)
+	require.NoError(t, err)
+	require.Equal(t, `{"data":{"type":"articles","id":"1","attributes":{"title":"Hello"},"relationships":{"author":{"data":{"type":"people","id":"alice"}}}}}`, string(data))
+
+	data, err = tm.MarshalJSONAPI(EmptyContext, []Article{*v})
+	require.NoError(t, err)
+	require.Equal(t, `{"data":[{"type":"articles","id":"1","attributes":{"title":"Hello"},"relationships":{"author":{"data":{"type":"people","id":"alice"}}}}]}`, string(data))
+
+	u := &Article{}
+	err = tm.UnmarshalJSONAPI(EmptyContext, []byte(`{"data": {"type": "articles", "id": "1", "attributes": {"title": "Hello"}, "relationships": {"author": {"data": {"type": "people", "id": "alice"}}}}}`), u)
+	require.NoError(t, err)
+	require.Equal(t, v, u)
+
+	err = tm.UnmarshalJSONAPI(EmptyContext, []byte(`{"data": {"type": "comments", "id": "1"}}`), u)
+	require.EqualError(t, err, "Validation Errors: \n/data/type: expected resource type: articles\n")
+
+	err = tm.UnmarshalJSONAPI(EmptyContext, []byte(`{"data": {"type": "articles", "attributes": {"title": "Hello, World!"}, "relationships": {"author": {"data": {"type": "people", "id": ""}}}}}`), u)
+	require.Error(t, err)
+	paths := []string{}
+	for _, e := range err.(*MultiValidationError).Errors() {
+		paths = append(paths, e.Path)
+	}
+	require.Equal(t, []string{"/data/id", "/data/attributes/title", "/data/relationships/author/data/id"}, paths)
+}
+
+func TestJSONAPIFieldSet(t *testing.T) {
+	tm := NewTypeMapper(ArticleJSONAPIMap)
+
+	v := &Article{ID: "1", Title: "Hello", Author: ExpandablePerson{ID: "alice"}}
+
+	data, err := tm.MarshalJSONAPI(WithFieldSet(EmptyContext, ParseFieldSet("title")), v)
+	require.NoError(t, err)
+	require.Equal(t, `{"data":{"type":"articles","id":"1","attributes":{"title":"Hello"}}}`, string(data))
+
+	data, err = tm.MarshalJSONAPI(WithFieldSet(EmptyContext, ParseFieldSet("author")), v)
+	require.NoError(t, err)
+	require.Equal(t, `{"data":{"type":"articles","id":"1","relationships":{"author":{"data":{"type":"people","id":"alice"}}}}}`, string(data))
+
+	_, err = tm.MarshalJSONAPI(WithFieldSet(EmptyContext, ParseFieldSet("bogus")), v)
+	verr, ok := err.(*ValidationError)
+	require.True(t, ok)
+	require.Equal(t, "/bogus", verr.Flatten().Errors()[0].Path)
+	require.Equal(t, "no such field", verr.Flatten().Errors()[0].Message)
+}
+
+func TestJSONAPIContextTransform(t *testing.T) {
+	tm := NewTypeMapper(JSONAPI("measurements", MeasurementTypeMap))
+
+	data, err := tm.MarshalJSONAPI(EmptyContext, Measurement{Unit: "cm", Value: 3})
+	require.NoError(t, err)
+	require.Equal(t, `{"data":{"type":"measurements","attributes":{"value":"3cm"}}}`, string(data))
+}
+
+var HALPersonTypeMap = StructMap{
+	UnderlyingType: ExpandablePerson{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "ID",
+			JSONFieldName:   "_links",
+			Contains: HALLinks(template.FuncMap{"lower": strings.ToLower},
+				HALLink{Rel: "self", Href: "/people/{{.Value}}"},
+				HALLink{Rel: "friends", Href: "/people/{{.Value}}/friends{?page}", Templated: true},
+				HALLink{Rel: "alternate", Href: "/people/{{lower .Parent.Name}}", Title: "By name"},
+				HALLink{Rel: "alternate", Href: "{{.Context.Foo}}/people/{{.Value}}"},
+			),
+			ReadOnly: true,
+		},
+		{
+			StructFieldName: "Name",
+			JSONFieldName:   "name",
+			Validator:       String(1, 12),
+		},
+	},
+}
+
+func TestHALLinks(t *testing.T) {
+	v := &ExpandablePerson{ID: "1", Name: "Alice"}
+	ctx := struct{ Foo string }{"https://example.com"}
+
+	for _, legacy := range []bool{false, true} {
+		tm := NewTypeMapper(HALPersonTypeMap)
+		tm.LegacyMarshal = legacy
+
+		data, err := tm.Marshal(ctx, v)
+		require.NoError(t, err)
+		require.Equal(t, `{"_links":{"self":{"href":"/people/1"},"friends":{"href":"/people/1/friends{?page}","templated":true},"alternate":[{"href":"/people/alice","title":"By name"},{"href":"https://example.com/people/1"}]},"name":"Alice"}`, string(data))
+	}
+
+	tm := NewTypeMapper(HALPersonTypeMap)
+	u := &ExpandablePerson{}
+	err := tm.Unmarshal(EmptyContext, []byte(`{"_links": {"self": {"href": "/people/2"}}, "name": "Bob"}`), u)
+	require.NoError(t, err)
+	require.Equal(t, &ExpandablePerson{Name: "Bob"}, u)
+}
+
+type PageMeta struct {
+	Total      int
+	NextCursor string
+}
+
+var PageMetaTypeMap = StructMap{
+	UnderlyingType: PageMeta{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "Total",
+			JSONFieldName:   "total",
+			Validator:       Integer(0, 1000),
+		},
+		{
+			StructFieldName: "NextCursor",
+			JSONFieldName:   "next_cursor",
+			Validator:       String(0, 64),
+		},
+	},
+}
+
+func TestMarshalEnveloped(t *testing.T) {
+	tm := NewTypeMapper(InnerThingTypeMap, PageMetaTypeMap)
+
+	data, err := tm.MarshalEnveloped(EmptyContext, []InnerThing{{Foo: "fooz"}}, &PageMeta{Total: 1, NextCursor: "abc"})
+	require.NoError(t, err)
+	require.Equal(t, `{"data":[{"foo":"fooz","an_int":0,"a_bool":false}],"meta":{"total":1,"next_cursor":"abc"}}`, string(data))
+
+	data, err = tm.MarshalEnveloped(EmptyContext, InnerThing{Foo: "fooz"}, map[string]int{"count": 1})
+	require.NoError(t, err)
+	require.Equal(t, `{"data":{"foo":"fooz","an_int":0,"a_bool":false},"meta":{"count":1}}`, string(data))
+
+	data, err = tm.MarshalEnveloped(EmptyContext, InnerThing{}, nil)
+	require.NoError(t, err)
+	require.Equal(t, `{"data":{"foo":"","an_int":0,"a_bool":false}}`, string(data))
+}
+
+type pagedThingRequest struct {
+	PageRequest
+	Foo string
+}
+
+var pagedThingRequestParamMap = ComposeQueryMaps(
+	QueryMap{
+		UnderlyingType: pagedThingRequest{},
+		ParameterMaps: []ParameterMap{
+			{
+				StructFieldName: "Foo",
+				ParameterName:   "foo",
+				Mapper:          StringQueryParameterMapper{},
+				OmitEmpty:       true,
+			},
+		},
+	},
+	PageQueryMap(100, StringRegexValidator(regexp.MustCompile(`^[a-z0-9]+$`))),
+)
+
+func TestPageQueryMap(t *testing.T) {
+	urlQuery, _ := url.ParseQuery("foo=bar&limit=20&cursor=abc123")
+	req := pagedThingRequest{}
+	err := pagedThingRequestParamMap.Decode(urlQuery, &req)
+	require.NoError(t, err)
+	require.Equal(t, pagedThingRequest{PageRequest: PageRequest{Limit: 20, Cursor: "abc123"}, Foo: "bar"}, req)
+
+	urlQuery, _ = url.ParseQuery("foo=bar")
+	req = pagedThingRequest{}
+	err = pagedThingRequestParamMap.Decode(urlQuery, &req)
+	require.NoError(t, err)
+	require.Equal(t, 0, req.Limit)
+
+	urlQuery, _ = url.ParseQuery("limit=101&cursor=A!")
+	err = pagedThingRequestParamMap.Decode(urlQuery, &req)
+	require.Error(t, err)
+	paths := []string{}
+	for _, e := range err.(*MultiValidationError).Errors() {
+		paths = append(paths, e.Path)
+	}
+	require.Equal(t, []string{"/limit", "/cursor"}, paths)
+}
+
+func TestMarshalPage(t *testing.T) {
+	tm := NewTypeMapper(InnerThingTypeMap)
+
+	data, err := tm.MarshalPage(EmptyContext, Page[InnerThing]{
+		Items:      []InnerThing{{Foo: "fooz"}},
+		Total:      2,
+		NextCursor: "abc",
+	})
+	require.NoError(t, err)
+	require.Equal(t, `{"items":[{"foo":"fooz","an_int":0,"a_bool":false}],"total":2,"next_cursor":"abc"}`, string(data))
+
+	data, err = tm.MarshalPage(EmptyContext, Page[*InnerThing]{})
+	require.NoError(t, err)
+	require.Equal(t, `{"items":[],"total":0}`, string(data))
+}
+
+type ThingWithDates struct {
+	Born    time.Time
+	Renewed CivilDate
+}
+
+var ThingWithDatesTypeMap = StructMap{
+	UnderlyingType: ThingWithDates{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "Born",
+			JSONFieldName:   "born",
+			Contains:        Date(),
+		},
+		{
+			StructFieldName: "Renewed",
+			JSONFieldName:   "renewed",
+			Contains:        Date(),
+		},
+	},
+}
+
+func TestDate(t *testing.T) {
+	for _, legacy := range []bool{false, true} {
+		tm := NewTypeMapper(ThingWithDatesTypeMap)
+		tm.LegacyMarshal = legacy
+
+		v := &ThingWithDates{}
+		err := tm.Unmarshal(EmptyContext, []byte(`{"born": "2015-06-09", "renewed": "2020-02-29"}`), v)
+		require.NoError(t, err)
+		require.Equal(t, time.Date(2015, 6, 9, 0, 0, 0, 0, time.UTC), v.Born)
+		require.Equal(t, CivilDate{2020, time.February, 29}, v.Renewed)
+
+		data, err := tm.Marshal(EmptyContext, v)
+		require.NoError(t, err)
+		require.Equal(t, `{"born":"2015-06-09","renewed":"2020-02-29"}`, string(data))
+	}
+
+	tm := NewTypeMapper(ThingWithDatesTypeMap)
+	err := tm.Unmarshal(EmptyContext, []byte(`{"born": "2015-06-09T00:00:00Z", "renewed": "2021-02-29"}`), &ThingWithDates{})
+	require.EqualError(t, err, "Validation Errors: \n/born: not a valid date, expected YYYY-MM-DD\n/renewed: not a valid date, expected YYYY-MM-DD\n")
+
+	require.Equal(t, "0999-01-02", CivilDate{999, time.January, 2}.String())
+	require.Equal(t, time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC), CivilDate{2020, time.February, 29}.In(time.UTC))
+}
+
+func TestTimeWithPrecision(t *testing.T) {
+	happenedAt := time.Date(2015, 6, 9, 10, 11, 12, 123456789, time.UTC)
+
+	for _, legacy := range []bool{false, true} {
+		for precision, expected := range map[time.Duration]string{
+			0:                `{"happened_at":"2015-06-09T10:11:12.123456789Z"}`,
+			time.Millisecond: `{"happened_at":"2015-06-09T10:11:12.123Z"}`,
+			time.Second:      `{"happened_at":"2015-06-09T10:11:12Z"}`,
+		} {
+			tm := NewTypeMapper(StructMap{
+				UnderlyingType: ThingWithTime{},
+				Fields: []MappedField{
+					{
+						StructFieldName: "HappenedAt",
+						JSONFieldName:   "happened_at",
+						Contains:        TimeWithPrecision(precision),
+					},
+				},
+			})
+			tm.LegacyMarshal = legacy
+
+			data, err := tm.Marshal(EmptyContext, &ThingWithTime{HappenedAt: happenedAt})
+			require.NoError(t, err)
+			require.Equal(t, expected, string(data))
+		}
+	}
+}
+
+type ThingWithDuration struct {
+	Timeout time.Duration
+}
+
+func TestDuration(t *testing.T) {
+	for format, expected := range map[DurationFormat]string{
+		DurationString:       `{"timeout":"1m30.5s"}`,
+		DurationSeconds:      `{"timeout":90.5}`,
+		DurationMilliseconds: `{"timeout":90500}`,
+	} {
+		for _, legacy := range []bool{false, true} {
+			tm := NewTypeMapper(StructMap{
+				UnderlyingType: ThingWithDuration{},
+				Fields: []MappedField{
+					{
+						StructFieldName: "Timeout",
+						JSONFieldName:   "timeout",
+						Contains:        Duration(format),
+					},
+				},
+			})
+			tm.LegacyMarshal = legacy
+
+			data, err := tm.Marshal(EmptyContext, &ThingWithDuration{Timeout: 90500 * time.Millisecond})
+			require.NoError(t, err)
+			require.Equal(t, expected, string(data))
+
+			v := &ThingWithDuration{}
+			err = tm.Unmarshal(EmptyContext, []byte(`{"timeout": "1h"}`), v)
+			require.NoError(t, err)
+			require.Equal(t, time.Hour, v.Timeout)
+		}
+	}
+
+	tm := NewTypeMapper(StructMap{
+		UnderlyingType: ThingWithDuration{},
+		Fields: []MappedField{
+			{
+				StructFieldName: "Timeout",
+				JSONFieldName:   "timeout",
+				Contains:        Duration(DurationSeconds),
+			},
+		},
+	})
+
+	err := tm.Unmarshal(EmptyContext, []byte(`{"timeout": "soon"}`), &ThingWithDuration{})
+	require.EqualError(t, err, "Validation Errors: \n/timeout: not a valid duration\n")
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"timeout": 1e300}`), &ThingWithDuration{})
+	require.EqualError(t, err, "Validation Errors: \n/timeout: duration out of range\n")
+
+	// 2^63 nanoseconds
+	err = tm.Unmarshal(EmptyContext, []byte(`{"timeout": 9223372036.854775808}`), &ThingWithDuration{})
+	require.EqualError(t, err, "Validation Errors: \n/timeout: duration out of range\n")
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"timeout": true}`), &ThingWithDuration{})
+	require.EqualError(t, err, "Validation Errors: \n/timeout: not a string or number\n")
+}
+
+type ThingWithNetworks struct {
+	Addr    net.IP
+	Subnet  net.IPNet
+	Allowed *net.IPNet
+}
+
+var ThingWithNetworksTypeMap = StructMap{
+	UnderlyingType: ThingWithNetworks{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "Addr",
+			JSONFieldName:   "addr",
+			Contains:        IP(),
+		},
+		{
+			StructFieldName: "Subnet",
+			JSONFieldName:   "subnet",
+			Contains:        IPNet(),
+		},
+		{
+			StructFieldName: "Allowed",
+			JSONFieldName:   "allowed",
+			Contains:        IPNet(),
+			Optional:        true,
+		},
+	},
+}
+
+func TestIPAndIPNet(t *testing.T) {
+	for _, legacy := range []bool{false, true} {
+		tm := NewTypeMapper(ThingWithNetworksTypeMap)
+		tm.LegacyMarshal = legacy
+
+		v := &ThingWithNetworks{}
+		err := tm.Unmarshal(EmptyContext, []byte(`{"addr": "192.0.2.1", "subnet": "2001:db8:0::1/32", "allowed": "10.1.2.3/8"}`), v)
+		require.NoError(t, err)
+		require.Equal(t, net.IP{192, 0, 2, 1}, v.Addr)
+		require.Equal(t, "2001:db8::/32", v.Subnet.String())
+		require.Equal(t, "10.0.0.0/8", v.Allowed.String())
+
+		data, err := tm.Marshal(EmptyContext, v)
+		require.NoError(t, err)
+		require.Equal(t, `{"addr":"192.0.2.1","subnet":"2001:db8::/32","allowed":"10.0.0.0/8"}`, string(data))
+
+		data, err = tm.Marshal(EmptyContext, &ThingWithNetworks{})
+		require.NoError(t, err)
+		require.Equal(t, `{"addr":null,"subnet":null,"allowed":null}`, string(data))
+	}
+
+	tm := NewTypeMapper(ThingWithNetworksTypeMap)
+	err := tm.Unmarshal(EmptyContext, []byte(`{"addr": "192.0.2.300", "subnet": "192.0.2.0"}`), &ThingWithNetworks{})
+	require.EqualError(t, err, "Validation Errors: \n/addr: not a valid IP address\n/subnet: not a valid CIDR\n")
+}
+
+type ThingWithURLs struct {
+	Homepage *url.URL
+	Callback url.URL
+}
+
+var ThingWithURLsTypeMap = StructMap{
+	UnderlyingType: ThingWithURLs{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "Homepage",
+			JSONFieldName:   "homepage",
+			Contains:        URL("http", "https"),
+			Optional:        true,
+		},
+		{
+			StructFieldName: "Callback",
+			JSONFieldName:   "callback",
+			Contains: &URLMap{
+				Schemes: []string{"https"},
+				Hosts:   []string{"example.com", "example.com:8443"},
+			},
+		},
+	},
+}
+
+func TestURL(t *testing.T) {
+	for _, legacy := range []bool{false, true} {
+		tm := NewTypeMapper(ThingWithURLsTypeMap)
+		tm.LegacyMarshal = legacy
+
+		v := &ThingWithURLs{}
+		err := tm.Unmarshal(EmptyContext, []byte(`{"homepage": "HTTP://example.org/~alice?x=1", "callback": "https://example.com:8443/cb"}`), v)
+		require.NoError(t, err)
+		require.Equal(t, "example.org", v.Homepage.Host)
+		require.Equal(t, "/cb", v.Callback.Path)
+
+		data, err := tm.Marshal(EmptyContext, v)
+		require.NoError(t, err)
+		require.Equal(t, `{"homepage":"http://example.org/~alice?x=1","callback":"https://example.com:8443/cb"}`, string(data))
+	}
+
+	tm := NewTypeMapper(ThingWithURLsTypeMap)
+	err := tm.Unmarshal(EmptyContext, []byte(`{"homepage": "/relative", "callback": "http://example.com/cb"}`), &ThingWithURLs{})
+	require.EqualError(t, err, "Validation Errors: \n/homepage: not an absolute URL\n/callback: URL scheme must be one of: https\n")
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"homepage": "ftp://example.org", "callback": "https://evil.com/cb"}`), &ThingWithURLs{})
+	require.EqualError(t, err, "Validation Errors: \n/homepage: URL scheme must be one of: http, https\n/callback: URL host is not permitted\n")
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"callback": "https://exa mple.com/cb"}`), &ThingWithURLs{})
+	require.EqualError(t, err, "Validation Errors: \n/callback: not a valid URL\n")
+}
+
+// testUUID has the same underlying type as the UUID types of popular UUID
+// packages
+type testUUID [16]byte
+
+type ThingWithUUID struct {
+	ID testUUID
+}
+
+var ThingWithUUIDTypeMap = StructMap{
+	UnderlyingType: ThingWithUUID{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "ID",
+			JSONFieldName:   "id",
+			Contains:        UUID(),
+		},
+	},
+}
+
+func TestUUID(t *testing.T) {
+	for _, legacy := range []bool{false, true} {
+		tm := NewTypeMapper(ThingWithUUIDTypeMap)
+		tm.LegacyMarshal = legacy
+
+		v := &ThingWithUUID{}
+		err := tm.Unmarshal(EmptyContext, []byte(`{"id": "6BA7B810-9DAD-11D1-80B4-00C04FD430C8"}`), v)
+		require.NoError(t, err)
+		require.Equal(t, testUUID{0x6b, 0xa7, 0xb8, 0x10, 0x9d, 0xad, 0x11, 0xd1, 0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8}, v.ID)
+
+		data, err := tm.Marshal(EmptyContext, v)
+		require.NoError(t, err)
+		require.Equal(t, `{"id":"6ba7b810-9dad-11d1-80b4-00c04fd430c8"}`, string(data))
+	}
+
+	tm := NewTypeMapper(ThingWithUUIDTypeMap)
+	err := tm.Unmarshal(EmptyContext, []byte(`{"id": "6ba7b810"}`), &ThingWithUUID{})
+	require.EqualError(t, err, "Validation Errors: \n/id: not a valid UUID\n")
+}
+
+// textLevel is a custom scalar type implementing encoding.TextMarshaler and
+// encoding.TextUnmarshaler
+type textLevel int
+
+func (l textLevel) MarshalText() ([]byte, error) {
+	return []byte(strings.Repeat("*", int(l))), nil
+}
+
+func (l *textLevel) UnmarshalText(text []byte) error {
+	if strings.Trim(string(text), "*") != "" {
+		return errors.New("expected stars")
+	}
+	*l = textLevel(len(text))
+	return nil
+}
+
+type ThingWithTextValues struct {
+	Level    textLevel
+	MaxLevel *textLevel
+	Addr     net.IP
+}
+
+var ThingWithTextValuesTypeMap = StructMap{
+	UnderlyingType: ThingWithTextValues{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "Level",
+			JSONFieldName:   "level",
+			Contains:        TextValue(),
+		},
+		{
+			StructFieldName: "MaxLevel",
+			JSONFieldName:   "max_level",
+			Contains:        TextValue(),
+			Optional:        true,
+		},
+		{
+			StructFieldName: "Addr",
+			JSONFieldName:   "addr",
+			Contains:        TextValue(),
+		},
+	},
+}
+
+func TestTextValue(t *testing.T) {
+	for _, legacy := range []bool{false, true} {
+		tm := NewTypeMapper(ThingWithTextValuesTypeMap)
+		tm.LegacyMarshal = legacy
+
+		v := &ThingWithTextValues{}
+		err := tm.Unmarshal(EmptyContext, []byte(`{"level": "**", "max_level": "*****", "addr": "::1"}`), v)
+		require.NoError(t, err)
+		require.Equal(t, textLevel(2), v.Level)
+		require.Equal(t, textLevel(5), *v.MaxLevel)
+		require.Equal(t, net.ParseIP("::1"), v.Addr)
+
+		data, err := tm.Marshal(EmptyContext, v)
+		require.NoError(t, err)
+		require.Equal(t, `{"level":"**","max_level":"*****","addr":"::1"}`, string(data))
+
+		data, err = tm.Marshal(EmptyContext, ThingWithTextValues{Level: 1, Addr: net.IPv4(192, 0, 2, 1)})
+		require.NoError(t, err)
+		require.Equal(t, `{"level":"*","max_level":null,"addr":"192.0.2.1"}`, string(data))
+	}
+
+	tm := NewTypeMapper(ThingWithTextValuesTypeMap)
+	err := tm.Unmarshal(EmptyContext, []byte(`{"level": "*?", "addr": 1}`), &ThingWithTextValues{})
+	require.EqualError(t, err, "Validation Errors: \n/level: not a valid value: expected stars\n/addr: not a string\n")
+}
+
+type ThingWithBytes struct {
+	Data  []byte
+	Token []byte
+}
+
+var ThingWithBytesTypeMap = StructMap{
+	UnderlyingType: ThingWithBytes{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "Data",
+			JSONFieldName:   "data",
+			Contains:        Bytes(base64.StdEncoding, 0, 8),
+		},
+		{
+			StructFieldName: "Token",
+			JSONFieldName:   "token",
+			Contains:        Bytes(base64.RawURLEncoding, 2, 4),
+			Optional:        true,
+		},
+	},
+}
+
+func TestBytes(t *testing.T) {
+	for _, legacy := range []bool{false, true} {
+		tm := NewTypeMapper(ThingWithBytesTypeMap)
+		tm.LegacyMarshal = legacy
+
+		v := &ThingWithBytes{}
+		err := tm.Unmarshal(EmptyContext, []byte(`{"data": "+/8A", "token": "-_8"}`), v)
+		require.NoError(t, err)
+		require.Equal(t, []byte{0xfb, 0xff, 0x00}, v.Data)
+		require.Equal(t, []byte{0xfb, 0xff}, v.Token)
+
+		data, err := tm.Marshal(EmptyContext, v)
+		require.NoError(t, err)
+		require.Equal(t, `{"data":"+/8A","token":"-_8"}`, string(data))
+
+		data, err = tm.Marshal(EmptyContext, &ThingWithBytes{Data: []byte{}})
+		require.NoError(t, err)
+		require.Equal(t, `{"data":"","token":null}`, string(data))
+	}
+
+	tm := NewTypeMapper(ThingWithBytesTypeMap)
+	err := tm.Unmarshal(EmptyContext, []byte(`{"data": "-_8A", "token": "AA"}`), &ThingWithBytes{})
+	require.EqualError(t, err, "Validation Errors: \n/data: not valid base64\n/token: too short, must be at least 2 bytes\n")
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"data": "AAAAAAAAAAAA", "token": "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"}`), &ThingWithBytes{})
+	require.EqualError(t, err, "Validation Errors: \n/data: too long, may not be more than 8 bytes\n/token: too long, may not be more than 4 bytes\n")
+}
+
+type ThingWithNulls struct {
+	Name      sql.NullString
+	Count     sql.NullInt64
+	DeletedAt sql.NullTime
+	Limit     *int
+	Nickname  *string
+}
+
+var ThingWithNullsTypeMap = StructMap{
+	UnderlyingType: ThingWithNulls{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "Name",
+			JSONFieldName:   "name",
+			Contains:        Nullable(NewPrimitiveMap(String(1, 12))),
+		},
+		{
+			StructFieldName: "Count",
+			JSONFieldName:   "count",
+			Contains:        Nullable(NewPrimitiveMap(Integer(0, 10))),
+		},
+		{
+			StructFieldName: "DeletedAt",
+			JSONFieldName:   "deleted_at",
+			Contains:        Nullable(Time()),
+		},
+		{
+			StructFieldName: "Limit",
+			JSONFieldName:   "limit",
+			Contains:        Nullable(NewPrimitiveMap(Integer(1, 100))),
+		},
+		{
+			StructFieldName: "Nickname",
+			JSONFieldName:   "nickname",
+			Contains:        Nullable(NewPrimitiveMap(String(1, 12))),
+			Optional:        true,
+		},
+	},
+}
+
+func TestNullable(t *testing.T) {
+	deletedAt := time.Date(2015, 6, 9, 10, 11, 12, 0, time.UTC)
+
+	for _, legacy := range []bool{false, true} {
+		tm := NewTypeMapper(ThingWithNullsTypeMap)
+		tm.LegacyMarshal = legacy
+
+		v := &ThingWithNulls{}
+		err := tm.Unmarshal(EmptyContext, []byte(`{"name": "fido", "count": 3, "deleted_at": "2015-06-09T10:11:12Z", "limit": 20, "nickname": "fi"}`), v)
+		require.NoError(t, err)
+		limit, nickname := 20, "fi"
+		require.Equal(t, &ThingWithNulls{
+			Name:      sql.NullString{String: "fido", Valid: true},
+			Count:     sql.NullInt64{Int64: 3, Valid: true},
+			DeletedAt: sql.NullTime{Time: deletedAt, Valid: true},
+			Limit:     &limit,
+			Nickname:  &nickname,
+		}, v)
+
+		data, err := tm.Marshal(EmptyContext, v)
+		require.NoError(t, err)
+		require.Equal(t, `{"name":"fido","count":3,"deleted_at":"2015-06-09T10:11:12Z","limit":20,"nickname":"fi"}`, string(data))
+
+		err = tm.Unmarshal(EmptyContext, []byte(`{"name": null, "count": null, "deleted_at": null, "limit": null}`), v)
+		require.NoError(t, err)
+		require.Equal(t, &ThingWithNulls{Nickname: &nickname}, v)
+
+		data, err = tm.Marshal(EmptyContext, &ThingWithNulls{})
+		require.NoError(t, err)
+		require.Equal(t, `{"name":null,"count":null,"deleted_at":null,"limit":null,"nickname":null}`, string(data))
+	}
+
+	tm := NewTypeMapper(ThingWithNullsTypeMap)
+	err := tm.Unmarshal(EmptyContext, []byte(`{"name": "", "count": 11, "deleted_at": null, "limit": 0}`), &ThingWithNulls{})
+	require.EqualError(t, err, "Validation Errors: \n/name: too short, must be at least 1 characters\n/count: too large, may not be larger than 10\n/limit: too small, must be at least 1\n")
+
+	// Values are checked against the size of the field as well as the validator
+	type small struct {
+		Level *int8
+		Count uint8
+		Ratio float32
+	}
+	tm = NewTypeMapper(StructMap{
+		UnderlyingType: small{},
+		Fields: []MappedField{
+			{
+				StructFieldName: "Level",
+				JSONFieldName:   "level",
+				Contains:        Nullable(NewPrimitiveMap(Integer(-1000, 1000))),
+			},
+			{
+				StructFieldName: "Count",
+				JSONFieldName:   "count",
+				Contains:        NewPrimitiveMap(&LossyUint64Validator{MaxVal: 1000}),
+			},
+			{
+				StructFieldName: "Ratio",
+				JSONFieldName:   "ratio",
+				Contains:        NewPrimitiveMap(Float(-1e39, 1e39)),
+			},
+		},
+	})
+	err = tm.Unmarshal(EmptyContext, []byte(`{"level": -200, "count": 256, "ratio": 1e39}`), &small{})
+	require.EqualError(t, err, "Validation Errors: \n/level: too small, must be at least -128\n/count: too large, may not be larger than 255\n/ratio: too large, may not be larger than 3.4028235e+38\n")
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"level": 200, "count": 255, "ratio": -1e39}`), &small{})
+	require.EqualError(t, err, "Validation Errors: \n/level: too large, may not be larger than 127\n/ratio: too small, must be at least -3.4028235e+38\n")
+}
+
+type ThingWithOptionals struct {
+	Nickname Optional[string]
+	Age      Optional[int64]
+}
+
+var ThingWithOptionalsTypeMap = StructMap{
+	UnderlyingType: ThingWithOptionals{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "Nickname",
+			JSONFieldName:   "nickname",
+			Contains:        OptionalOf(NewPrimitiveMap(String(1, 12))),
+			Optional:        true,
+		},
+		{
+			StructFieldName: "Age",
+			JSONFieldName:   "age",
+			Contains:        OptionalOf(NewPrimitiveMap(Integer(0, 200))),
+			Optional:        true,
+		},
+	},
+}
+
+func TestOptional(t *testing.T) {
+	for _, legacy := range []bool{false, true} {
+		tm := NewTypeMapper(ThingWithOptionalsTypeMap)
+		tm.LegacyMarshal = legacy
+
+		v := &ThingWithOptionals{}
+		err := tm.Unmarshal(EmptyContext, []byte(`{"nickname": null}`), v)
+		require.NoError(t, err)
+		require.Equal(t, &ThingWithOptionals{Nickname: Null[string]()}, v)
+		require.True(t, v.Nickname.IsNull())
+		require.True(t, v.Age.IsAbsent())
+
+		err = tm.Unmarshal(EmptyContext, []byte(`{"nickname": "fi", "age": 3}`), v)
+		require.NoError(t, err)
+		require.Equal(t, &ThingWithOptionals{Nickname: Some("fi"), Age: Some(int64(3))}, v)
+
+		age, ok := v.Age.Get()
+		require.True(t, ok)
+		require.Equal(t, int64(3), age)
+
+		data, err := tm.Marshal(EmptyContext, v)
+		require.NoError(t, err)
+		require.Equal(t, `{"nickname":"fi","age":3}`, string(data))
+
+		data, err = tm.Marshal(EmptyContext, &ThingWithOptionals{Nickname: Null[string]()})
+		require.NoError(t, err)
+		require.Equal(t, `{"nickname":null,"age":null}`, string(data))
+
+		err = tm.ApplyMergePatch(EmptyContext, []byte(`{"nickname": null}`), v)
+		require.NoError(t, err)
+		require.Equal(t, &ThingWithOptionals{Nickname: Null[string](), Age: Some(int64(3))}, v)
+
+		err = tm.Unmarshal(EmptyContext, []byte(`{"nickname": "", "age": 201}`), v)
+		require.EqualError(t, err, "Validation Errors: \n/nickname: too short, must be at least 1 characters\n/age: too large, may not be larger than 200\n")
+	}
+}
+
+type testColor int
+
+const (
+	testColorRed testColor = iota
+	testColorGreen
+	testColorBlue
+)
+
+type ThingWithEnum struct {
+	Color testColor
+}
+
+var ThingWithEnumTypeMap = StructMap{
+	UnderlyingType: ThingWithEnum{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "Color",
+			JSONFieldName:   "color",
+			Contains: Enum(map[string]int{
+				"red":   int(testColorRed),
+				"green": int(testColorGreen),
+				"blue":  int(testColorBlue),
+			}),
+		},
+	},
+}
+
+func TestEnum(t *testing.T) {
+	for _, legacy := range []bool{false, true} {
+		tm := NewTypeMapper(ThingWithEnumTypeMap)
+		tm.LegacyMarshal = legacy
+
+		v := &ThingWithEnum{}
+		err := tm.Unmarshal(EmptyContext, []byte(`{"color": "blue"}`), v)
+		require.NoError(t, err)
+		require.Equal(t, testColorBlue, v.Color)
+
+		data, err := tm.Marshal(EmptyContext, &ThingWithEnum{Color: testColorGreen})
+		require.NoError(t, err)
+		require.Equal(t, `{"color":"green"}`, string(data))
+
+		_, err = tm.Marshal(EmptyContext, &ThingWithEnum{Color: 7})
+		require.EqualError(t, err, "no name for enum value: 7")
+	}
+
+	tm := NewTypeMapper(ThingWithEnumTypeMap)
+	err := tm.Unmarshal(EmptyContext, []byte(`{"color": "mauve"}`), &ThingWithEnum{})
+	require.EqualError(t, err, "Validation Errors: \n/color: Value must be one of: [\"red\",\"green\",\"blue\"]\n")
+
+	require.Panics(t, func() {
+		Enum(map[string]int{"red": 0, "crimson": 0})
+	})
+
+	type smallEnum struct {
+		Level int8
+	}
+	tm = NewTypeMapper(StructMap{
+		UnderlyingType: smallEnum{},
+		Fields: []MappedField{
+			{
+				StructFieldName: "Level",
+				JSONFieldName:   "level",
+				Contains:        Enum(map[string]int{"low": -1, "high": 300}),
+			},
+		},
+	})
+	err = tm.Unmarshal(EmptyContext, []byte(`{"level": "high"}`), &smallEnum{})
+	require.EqualError(t, err, "Validation Errors: \n/level: too large, may not be larger than 127\n")
+}
+
+type testPermission uint8
+
+const (
+	testPermissionRead testPermission = 1 << iota
+	testPermissionWrite
+	testPermissionAdmin
+)
+
+type ThingWithFlags struct {
+	Permissions testPermission
+}
+
+var ThingWithFlagsTypeMap = StructMap{
+	UnderlyingType: ThingWithFlags{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "Permissions",
+			JSONFieldName:   "permissions",
+			Contains: Flags(map[string]uint64{
+				"read":  uint64(testPermissionRead),
+				"write": uint64(testPermissionWrite),
+				"admin": uint64(testPermissionAdmin),
+			}),
+		},
+	},
+}
+
+func TestFlags(t *testing.T) {
+	for _, legacy := range []bool{false, true} {
+		tm := NewTypeMapper(ThingWithFlagsTypeMap)
+		tm.LegacyMarshal = legacy
+
+		v := &ThingWithFlags{}
+		err := tm.Unmarshal(EmptyContext, []byte(`{"permissions": ["admin", "read", "read"]}`), v)
+		require.NoError(t, err)
+		require.Equal(t, testPermissionRead|testPermissionAdmin, v.Permissions)
+
+		data, err := tm.Marshal(EmptyContext, v)
+		require.NoError(t, err)
+		require.Equal(t, `{"permissions":["read","admin"]}`, string(data))
+
+		data, err = tm.Marshal(EmptyContext, &ThingWithFlags{})
+		require.NoError(t, err)
+		require.Equal(t, `{"permissions":[]}`, string(data))
+
+		_, err = tm.Marshal(EmptyContext, &ThingWithFlags{Permissions: testPermissionWrite | 0x10})
+		require.EqualError(t, err, "no flag names for bits: 0x10")
+	}
+
+	tm := NewTypeMapper(ThingWithFlagsTypeMap)
+	err := tm.Unmarshal(EmptyContext, []byte(`{"permissions": ["read", "sudo", 1]}`), &ThingWithFlags{})
+	require.EqualError(t, err, "Validation Errors: \n/permissions/1: Value must be one of: [\"read\",\"write\",\"admin\"]\n/permissions/2: not a string\n")
+
+	tm = NewTypeMapper(StructMap{
+		UnderlyingType: ThingWithFlags{},
+		Fields: []MappedField{
+			{
+				StructFieldName: "Permissions",
+				JSONFieldName:   "permissions",
+				Contains:        Flags(map[string]uint64{"read": 1, "audit": 1 << 8}),
+			},
+		},
+	})
+	err = tm.Unmarshal(EmptyContext, []byte(`{"permissions": ["read", "audit"]}`), &ThingWithFlags{})
+	require.EqualError(t, err, "Validation Errors: \n/permissions: too large, may not be larger than 255\n")
+}
+
+type DateRange struct {
+	Start int64
+	End   int64
+}
+
+func (r *DateRange) AfterUnmarshal(ctx Context) error {
+	if r.End < r.Start {
+		return NewValidationErrorWithField("end", "must not be before start")
+	}
+	if r.Start == 13 {
+		return errors.New("unlucky")
+	}
+	return nil
+}
+
+type ThingWithDateRange struct {
+	Range DateRange
+}
+
+var DateRangeTypeMap = StructMap{
+	UnderlyingType: DateRange{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "Start",
+			JSONFieldName:   "start",
+			Validator:       Integer(0, 100),
+		},
+		{
+			StructFieldName: "End",
+			JSONFieldName:   "end",
+			Validator:       Integer(0, 100),
+		},
+	},
+}
+
+var ThingWithDateRangeTypeMap = StructMap{
+	UnderlyingType: ThingWithDateRange{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "Range",
+			JSONFieldName:   "range",
+			Contains:        DateRangeTypeMap,
+		},
+	},
+}
+
+func TestPostUnmarshaler(t *testing.T) {
+	tm := NewTypeMapper(DateRangeTypeMap, ThingWithDateRangeTypeMap)
+
+	v := &DateRange{}
+	err := tm.Unmarshal(EmptyContext, []byte(`{"start": 2, "end": 1}`), v)
+	require.EqualError(t, err, "Validation Errors: \n/end: must not be before start\n")
+
+	// The hook isn't called if any field is invalid
+	err = tm.Unmarshal(EmptyContext, []byte(`{"start": 200, "end": 1}`), v)
+	require.EqualError(t, err, "Validation Errors: \n/start: too large, may not be larger than 100\n")
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"start": 13, "end": 14}`), v)
+	require.EqualError(t, err, "unlucky")
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"range": {"start": 2, "end": 1}}`), &ThingWithDateRange{})
+	require.EqualError(t, err, "Validation Errors: \n/range/end: must not be before start\n")
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"range": {"start": 13, "end": 14}}`), &ThingWithDateRange{})
+	require.EqualError(t, err, "Validation Errors: \n/range: unlucky\n")
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"start": 1, "end": 2}`), v)
+	require.NoError(t, err)
+
+	err = tm.ApplyMergePatch(EmptyContext, []byte(`{"end": 0}`), v)
+	require.EqualError(t, err, "Validation Errors: \n/end: must not be before start\n")
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"start": 1, "end": 2}`), v)
+	require.NoError(t, err)
+	require.Equal(t, &DateRange{Start: 1, End: 2}, v)
+}
+
+type Rectangle struct {
+	Width  int64
+	Height int64
+	Area   int64
+	Secret string
+}
+
+func (r *Rectangle) BeforeMarshal(ctx Context) error {
+	if r.Width < 0 {
+		return errors.New("negative width")
+	}
+	r.Area = r.Width * r.Height
+	r.Secret = ""
+	return nil
+}
+
+var RectangleTypeMap = StructMap{
+	UnderlyingType: Rectangle{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "Width",
+			JSONFieldName:   "width",
+			Validator:       Integer(0, 100),
+		},
+		{
+			StructFieldName: "Height",
+			JSONFieldName:   "height",
+			Validator:       Integer(0, 100),
+		},
+		{
+			StructFieldName: "Area",
+			JSONFieldName:   "area",
+			ReadOnly:        true,
+		},
+		{
+			StructFieldName: "Secret",
+			JSONFieldName:   "secret",
+			Validator:       String(0, 12),
+			Optional:        true,
+		},
+	},
+}
+
+func TestPreMarshaler(t *testing.T) {
+	for _, legacy := range []bool{false, true} {
+		tm := NewTypeMapper(RectangleTypeMap)
+		tm.LegacyMarshal = legacy
+
+		r := &Rectangle{Width: 2, Height: 3, Secret: "hunter2"}
+		data, err := tm.Marshal(EmptyContext, r)
+		require.NoError(t, err)
+		require.Equal(t, `{"width":2,"height":3,"area":6,"secret":""}`, string(data))
+		require.Equal(t, int64(6), r.Area)
+
+		// Values which aren't addressable are copied
+		v := Rectangle{Width: 4, Height: 3, Secret: "hunter2"}
+		data, err = tm.Marshal(EmptyContext, []Rectangle{v})
+		require.NoError(t, err)
+		require.Equal(t, `[{"width":4,"height":3,"area":12,"secret":""}]`, string(data))
+		require.Equal(t, "hunter2", v.Secret)
+
+		_, err = tm.Marshal(EmptyContext, &Rectangle{Width: -1})
+		require.EqualError(t, err, "negative width")
+	}
+}
+
+type Rule struct {
+	Name   string
+	Action string
+}
+
+type ThingWithRules struct {
+	Rules []Rule
+}
+
+var RuleTypeMap = StructMap{
+	UnderlyingType: Rule{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "Name",
+			JSONFieldName:   "name",
+			Validator:       String(1, 12),
+		},
+		{
+			StructFieldName: "Action",
+			JSONFieldName:   "action",
+			Validator:       OneOf("allow", "deny"),
+		},
+	},
+}
+
+var ThingWithRulesTypeMap = StructMap{
+	UnderlyingType: ThingWithRules{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "Rules",
+			JSONFieldName:   "rules",
+			Contains:        SliceOfUniqueBy(RuleTypeMap, "name"),
+		},
+	},
+}
+
+func TestSliceOfUniqueBy(t *testing.T) {
+	tm := NewTypeMapper(ThingWithRulesTypeMap)
+
+	v := &ThingWithRules{}
+	err := tm.Unmarshal(EmptyContext, []byte(`{"rules": [{"name": "a", "action": "allow"}, {"name": "b", "action": "deny"}]}`), v)
+	require.NoError(t, err)
+	require.Len(t, v.Rules, 2)
+
+	data, err := tm.Marshal(EmptyContext, v)
+	require.NoError(t, err)
+	require.Equal(t, `{"rules":[{"name":"a","action":"allow"},{"name":"b","action":"deny"}]}`, string(data))
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"rules": [{"name": "a", "action": "allow"}, {"name": "b", "action": "deny"}, {"name": "a", "action": "deny"}]}`), &ThingWithRules{})
+	require.EqualError(t, err, "Validation Errors: \n/rules/2/name: duplicate value, already used at index 0\n")
+
+	// Duplicates are only checked once elements are otherwise valid
+	err = tm.Unmarshal(EmptyContext, []byte(`{"rules": [{"name": "a", "action": "allow"}, {"name": "a", "action": "maybe"}]}`), &ThingWithRules{})
+	require.EqualError(t, err, "Validation Errors: \n/rules/1/action: Value must be one of: [\"allow\",\"deny\"]\n")
+
+	require.Panics(t, func() {
+		SliceOfUniqueBy(RuleTypeMap, "nope")
+	})
+}
+
+type userRepositoryKey struct{}
+
+type Membership struct {
+	UserID string
+}
+
+var MembershipTypeMap = StructMap{
+	UnderlyingType: Membership{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "UserID",
+			JSONFieldName:   "user_id",
+			Validator: AllOf(String(1, 36), ExistsIn(func(ctx Context, value interface{}) (bool, error) {
+				var users map[string]bool
+				if c, ok := ctx.(*Ctx); !ok || !c.Lookup(userRepositoryKey{}, &users) {
+					return false, errors.New("no user repository")
+				}
+				return users[value.(string)], nil
+			})),
+		},
+	},
+}
+
+func TestExistsIn(t *testing.T) {
+	tm := NewTypeMapper(MembershipTypeMap)
+	ctx := NewCtx(EmptyContext).With(userRepositoryKey{}, map[string]bool{"u1": true})
+
+	v := &Membership{}
+	err := tm.Unmarshal(ctx, []byte(`{"user_id": "u1"}`), v)
+	require.NoError(t, err)
+	require.Equal(t, "u1", v.UserID)
+
+	err = tm.Unmarshal(ctx, []byte(`{"user_id": "u2"}`), &Membership{})
+	require.EqualError(t, err, "Validation Errors: \n/user_id: does not exist\n")
+
+	// The format is checked before the lookup is made
+	err = tm.Unmarshal(EmptyContext, []byte(`{"user_id": ""}`), &Membership{})
+	require.EqualError(t, err, "Validation Errors: \n/user_id: too short, must be at least 1 characters\n")
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"user_id": "u1"}`), &Membership{})
+	require.EqualError(t, err, "Validation Errors: \n/user_id: no user repository\n")
+}
+
+type Invitation struct {
+	Emails []string
+	Owner  string
+}
+
+var invitationChecks int
+
+var unregisteredEmail = Batched(func(ctx Context, values []interface{}) ([]error, error) {
+	invitationChecks++
+	errs := make([]error, len(values))
+	for i, v := range values {
+		if v.(string) == "taken@example.com" {
+			errs[i] = NewValidationError("already registered")
+		}
+	}
+	return errs, nil
+})
+
+var InvitationTypeMap = StructMap{
+	UnderlyingType: Invitation{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "Emails",
+			JSONFieldName:   "emails",
+			Contains:        SliceOf(NewPrimitiveMap(unregisteredEmail)),
+		},
+		{
+			StructFieldName: "Owner",
+			JSONFieldName:   "owner",
+			Validator:       AllOf(String(1, 64), unregisteredEmail),
+		},
+	},
+}
+
+func TestUnmarshalBatched(t *testing.T) {
+	tm := NewTypeMapper(InvitationTypeMap)
+
+	invitationChecks = 0
+	v := &Invitation{}
+	err := tm.UnmarshalBatched(EmptyContext, []byte(`{"emails": ["a@example.com", "b@example.com"], "owner": "c@example.com"}`), v)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a@example.com", "b@example.com"}, v.Emails)
+	require.Equal(t, 1, invitationChecks)
+
+	invitationChecks = 0
+	err = tm.UnmarshalBatched(EmptyContext, []byte(`{"emails": ["a@example.com", "taken@example.com"], "owner": "taken@example.com"}`), &Invitation{})
+	require.EqualError(t, err, "Validation Errors: \n/emails/1: already registered\n/owner: already registered\n")
+	require.Equal(t, 1, invitationChecks)
+
+	// Failures are merged with those found during unmarshalling
+	err = tm.UnmarshalBatched(EmptyContext, []byte(`{"emails": ["taken@example.com"], "owner": ""}`), &Invitation{})
+	require.EqualError(t, err, "Validation Errors: \n/owner: too short, must be at least 1 characters\n/emails/0: already registered\n")
+
+	// Without batching each value is checked as it is validated
+	invitationChecks = 0
+	err = tm.Unmarshal(EmptyContext, []byte(`{"emails": ["a@example.com", "taken@example.com"], "owner": "c@example.com"}`), &Invitation{})
+	require.EqualError(t, err, "Validation Errors: \n/emails/1: already registered\n")
+	require.Equal(t, 3, invitationChecks)
+}
+
+func TestErrorTruncation(t *testing.T) {
+	errs := &MultiValidationError{}
+	for i := 0; i < 10000; i++ {
+		errs.NestedErrors = append(errs.NestedErrors, NewFlattenedPathError("/items/"+strconv.Itoa(i), "expected a string"))
+	}
+
+	msg := errs.Error()
+	require.Equal(t, 100, strings.Count(msg, "expected a string"))
+	require.True(t, strings.HasSuffix(msg, "/items/99: expected a string\n...and 9900 more errors\n"))
+	require.Len(t, errs.Errors(), 10000)
+
+	w := httptest.NewRecorder()
+	require.True(t, WriteValidationError(w, errs))
+	problem := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+	require.Len(t, problem["errors"], 100)
+	require.Equal(t, "...and 9900 more errors", problem["detail"])
+
+	// Long messages are cut off by length rather than count
+	errs = &MultiValidationError{}
+	for i := 0; i < 3; i++ {
+		errs.NestedErrors = append(errs.NestedErrors, NewFlattenedPathError("/name", strings.Repeat("x", 8*1024)))
+	}
+	msg = errs.Error()
+	require.True(t, strings.HasSuffix(msg, "\n...and 2 more errors\n"))
+	require.Less(t, len(msg), MaxErrorLength)
+
+	defer func(n int) { MaxReportedErrors = n }(MaxReportedErrors)
+	MaxReportedErrors = 0
+	errs = &MultiValidationError{}
+	for i := 0; i < 200; i++ {
+		errs.NestedErrors = append(errs.NestedErrors, NewFlattenedPathError("/name", "expected a string"))
+	}
+	require.Equal(t, 200, strings.Count(errs.Error(), "expected a string"))
+}
+
+type encryptionKeyKey struct{}
+
+type Patient struct {
+	Name    string
+	Contact InnerThing
+}
+
+var patientCipher = AESGCM(func(ctx Context) ([]byte, error) {
+	var key []byte
+	if c, ok := ctx.(*Ctx); !ok || !c.Lookup(encryptionKeyKey{}, &key) {
+		return nil, errors.New("no encryption key")
+	}
+	return key, nil
+})
+
+var PatientTypeMap = StructMap{
+	UnderlyingType: Patient{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "Name",
+			JSONFieldName:   "name",
+			Contains:        Encrypted(NewPrimitiveMap(String(1, 5)), patientCipher),
+		},
+		{
+			StructFieldName: "Contact",
+			JSONFieldName:   "contact",
+			Contains:        Encrypted(InnerThingTypeMap, patientCipher),
+		},
+	},
+}
+
+// PatientContext holds the key with which PatientTypeMap encrypts fields.
+var PatientContext = NewCtx(EmptyContext).With(encryptionKeyKey{}, []byte("0123456789abcdef0123456789abcdef"))
+
+func TestEncrypted(t *testing.T) {
+	ctx := PatientContext
+
+	for _, legacy := range []bool{false, true} {
+		tm := NewTypeMapper(PatientTypeMap)
+		tm.LegacyMarshal = legacy
+
+		v := &Patient{Name: "Ann", Contact: InnerThing{Foo: "bar", AnInt: 3, ABool: true}}
+		data, err := tm.Marshal(ctx, v)
+		require.NoError(t, err)
+		require.NotContains(t, string(data), "Ann")
+		require.NotContains(t, string(data), "bar")
+
+		// Values are validated once decrypted
+		long, err := tm.Marshal(ctx, &Patient{Name: "Annabel"})
+		require.NoError(t, err)
+		err = tm.Unmarshal(ctx, long, &Patient{})
+		require.EqualError(t, err, "Validation Errors: \n/name: too long, may not be more than 5 characters\n/contact/foo: too short, must be at least 1 characters\n")
+
+		err = tm.Unmarshal(ctx, []byte(`{"name": "QW5u", "contact": 5}`), &Patient{})
+		require.EqualError(t, err, "Validation Errors: \n/name: could not be decrypted\n/contact: expected an encrypted value\n")
+
+		err = tm.Unmarshal(NewCtx(EmptyContext).With(encryptionKeyKey{}, []byte("fedcba9876543210fedcba9876543210")), data, &Patient{})
+		require.EqualError(t, err, "Validation Errors: \n/name: could not be decrypted\n/contact: could not be decrypted\n")
+
+		_, err = tm.Marshal(EmptyContext, v)
+		require.EqualError(t, err, "no encryption key")
+	}
+}
+
+func TestMarshalSigned(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	for _, signer := range []Signer{HMACSHA256([]byte("secret")), Ed25519(private, public), Ed25519(private, nil)} {
+		v := &InnerThing{Foo: "bar", AnInt: 3, ABool: true}
+		data, err := TestTypeMapper.MarshalSigned(EmptyContext, v, signer, "sig")
+		require.NoError(t, err)
+
+		result := &InnerThing{}
+		err = TestTypeMapper.UnmarshalSigned(EmptyContext, data, result, signer, "sig")
+		require.NoError(t, err)
+		require.Equal(t, v, result)
+
+		// The signature survives reformatting of the document
+		doc := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal(data, &doc))
+		indented, err := json.MarshalIndent(doc, "", "  ")
+		require.NoError(t, err)
+		err = TestTypeMapper.UnmarshalSigned(EmptyContext, indented, &InnerThing{}, signer, "sig")
+		require.NoError(t, err)
+
+		doc["foo"] = "baz"
+		tampered, err := json.Marshal(doc)
+		require.NoError(t, err)
+		err = TestTypeMapper.UnmarshalSigned(EmptyContext, tampered, &InnerThing{}, signer, "sig")
+		require.EqualError(t, err, "Validation Errors: \n/sig: invalid signature\n")
+
+		err = TestTypeMapper.UnmarshalSigned(EmptyContext, []byte(`{"foo": "bar", "an_int": 3, "a_bool": true}`), &InnerThing{}, signer, "sig")
+		require.EqualError(t, err, "Validation Errors: \n/sig: missing signature\n")
+	}
+
+	_, err = TestTypeMapper.MarshalSigned(EmptyContext, &InnerThing{}, Ed25519(nil, public), "sig")
+	require.EqualError(t, err, "no private key to sign with")
+
+	_, err = TestTypeMapper.MarshalSigned(EmptyContext, &InnerThing{}, HMACSHA256([]byte("secret")), "foo")
+	require.EqualError(t, err, "signature field is already mapped: foo")
+}
+
+type SessionClaims struct {
+	RegisteredClaims
+	Scope string
+}
+
+var SessionClaimsTypeMap = ClaimsMap(SessionClaims{}, time.Minute, MappedField{
+	StructFieldName: "Scope",
+	JSONFieldName:   "scope",
+	Validator:       OneOf("read", "write"),
+})
+
+func TestClaimsMap(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+
+	for _, legacy := range []bool{false, true} {
+		tm := NewTypeMapper(SessionClaimsTypeMap)
+		tm.LegacyMarshal = legacy
+
+		claims := &SessionClaims{
+			RegisteredClaims: RegisteredClaims{
+				Subject:   "user-1",
+				Audience:  []string{"api"},
+				ExpiresAt: now.Add(time.Hour),
+				IssuedAt:  now,
+			},
+			Scope: "read",
+		}
+
+		data, err := tm.Marshal(EmptyContext, claims)
+		require.NoError(t, err)
+		require.JSONEq(t, fmt.Sprintf(`{"sub": "user-1", "aud": "api", "exp": %d, "iat": %d, "scope": "read"}`, now.Add(time.Hour).Unix(), now.Unix()), string(data))
+	}
+
+	tm := NewTypeMapper(SessionClaimsTypeMap)
+
+	result := &SessionClaims{}
+	err := tm.Unmarshal(EmptyContext, []byte(fmt.Sprintf(`{"aud": ["a", "b"], "exp": %d.5, "scope": "write"}`, now.Add(-30*time.Second).Unix())), result)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b"}, result.Audience)
+	require.Equal(t, now.Add(-30*time.Second).Add(500*time.Millisecond), result.ExpiresAt)
+
+	err = tm.Unmarshal(EmptyContext, []byte(fmt.Sprintf(`{"exp": %d, "nbf": %d, "scope": "admin"}`, now.Add(-time.Hour).Unix(), now.Add(time.Hour).Unix())), &SessionClaims{})
+	require.EqualError(t, err, "Validation Errors: \n/exp: token has expired\n/nbf: token is not yet valid\n/scope: Value must be one of: [\"read\",\"write\"]\n")
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"exp": "tomorrow", "aud": [1], "scope": "read"}`), &SessionClaims{})
+	require.EqualError(t, err, "Validation Errors: \n/aud/0: not a string\n/exp: not a number\n")
+
+	require.Panics(t, func() {
+		ClaimsMap(InnerThing{}, 0)
+	})
+
+	// Generated code omits empty fields in the same way
+	static := NewTypeMapper(SessionClaimsTypeMapStatic)
+	for _, claims := range []*SessionClaims{
+		{},
+		{Scope: "read"},
+		{RegisteredClaims: RegisteredClaims{Issuer: "me", IssuedAt: now}},
+		{RegisteredClaims: RegisteredClaims{Audience: []string{"api"}, ID: "1"}, Scope: "write"},
+	} {
+		expected, err := tm.Marshal(EmptyContext, claims)
+		require.NoError(t, err)
+		data, err := static.Marshal(EmptyContext, claims)
+		require.NoError(t, err)
+		require.Equal(t, string(expected), string(data))
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	strictInnerThingTypeMap := StructMap{
+		UnderlyingType: InnerThing{},
+		Fields: []MappedField{
+			{
+				StructFieldName: "Foo",
+				JSONFieldName:   "foo",
+				Validator:       String(1, 3),
+			},
+		},
+	}
+
+	r := NewRegistry(TestTypeMapper)
+	strict := r.Override("acme", strictInnerThingTypeMap)
+	require.Same(t, strict, r.Get("acme"))
+	require.Same(t, TestTypeMapper, r.Get("other"))
+	require.Same(t, TestTypeMapper, r.Resolve(EmptyContext))
+
+	ctx := WithMapperName(EmptyContext, "acme")
+	err := r.Resolve(ctx).Unmarshal(ctx, []byte(`{"foo": "fooz"}`), &InnerThing{})
+	require.EqualError(t, err, "Validation Errors: \n/foo: too long, may not be more than 3 characters\n")
+
+	// The base TypeMapper, and its other TypeMaps, are unaffected
+	err = TestTypeMapper.Unmarshal(EmptyContext, []byte(`{"foo": "fooz"}`), &InnerThing{})
+	require.NoError(t, err)
+
+	data, err := r.Resolve(ctx).Marshal(ctx, &AnotherInnerThing{Foo: "bar"})
+	require.NoError(t, err)
+	expected, err := TestTypeMapper.Marshal(EmptyContext, &AnotherInnerThing{Foo: "bar"})
+	require.NoError(t, err)
+	require.Equal(t, string(expected), string(data))
+
+	r.Register("acme", TestTypeMapper)
+	require.Same(t, TestTypeMapper, r.Resolve(ctx))
+}
+
+type SchemaDog struct {
+	Name  string
+	Age   int
+	Tags  []string
+	Owner map[string]interface{}
+}
+
+const dogSchema = `
+types:
+  Dog:
+    fields:
+      - {name: name, field: Name, type: string, min: 1, max: 10}
+      - {name: age, field: Age, type: integer, min: 0, max: 30, optional: true}
+      - {name: tags, field: Tags, type: list, max: 2, items: {type: string, enum: [good, loud]}}
+      - {name: owner, field: Owner, type: Person}
+  Person:
+    fields:
+      - {name: email, type: string, pattern: "^[^@]+@[^@]+$"}
+      - {name: pets, type: list, optional: true, items: {type: Pet}}
+  Pet:
+    fields:
+      - {name: name, type: string}
+`
+
+func TestParseSchema(t *testing.T) {
+	s, err := ParseSchema([]byte(dogSchema), map[string]interface{}{"Dog": SchemaDog{}})
+	require.NoError(t, err)
+
+	tm, err := s.TypeMapper("Dog")
+	require.NoError(t, err)
+
+	v := &SchemaDog{}
+	err = tm.Unmarshal(EmptyContext, []byte(`{"name": "Spot", "age": 3, "tags": ["good"], "owner": {"email": "a@example.com", "pets": [{"name": "Rex"}]}}`), v)
+	require.NoError(t, err)
+	require.Equal(t, &SchemaDog{
+		Name: "Spot",
+		Age:  3,
+		Tags: []string{"good"},
+		Owner: map[string]interface{}{
+			"email": "a@example.com",
+			"pets":  []interface{}{map[string]interface{}{"name": "Rex"}},
+		},
+	}, v)
+
+	data, err := tm.Marshal(EmptyContext, v)
+	require.NoError(t, err)
+	require.Equal(t, `{"name":"Spot","age":3,"tags":["good"],"owner":{"email":"a@example.com","pets":[{"name":"Rex"}]}}`, string(data))
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"name": "", "age": 31, "tags": ["bad"], "owner": {"email": "nope", "pets": [{}]}}`), &SchemaDog{})
+	require.EqualError(t, err, "Validation Errors: \n"+
+		"/name: too short, must be at least 1 characters\n"+
+		"/age: too large, may not be larger than 30\n"+
+		"/tags/0: Value must be one of: [\"good\",\"loud\"]\n"+
+		"/owner/email: must match regular expression: ^[^@]+@[^@]+$\n"+
+		"/owner/pets/0/name: missing required field\n")
+
+	// Types not bound to Go types are mapped to map[string]interface{}
+	tm, err = s.TypeMapper("Person")
+	require.NoError(t, err)
+	person := map[string]interface{}{}
+	err = tm.Unmarshal(EmptyContext, []byte(`{"email": "b@example.com", "extra": true}`), &person)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"email": "b@example.com"}, person)
+
+	_, err = ParseSchema([]byte(`{"types": {"Dog": {"fields": [{"name": "name", "field": "Nope", "type": "string"}]}}}`), map[string]interface{}{"Dog": SchemaDog{}})
+	require.EqualError(t, err, "type Dog: field name: no such struct field: Nope")
+
+	// Mismatched types are reported when parsing rather than when unmarshaling
+	_, err = ParseSchema([]byte(`{"types": {"Dog": {"fields": [{"name": "age", "field": "Age", "type": "string"}]}}}`), map[string]interface{}{"Dog": SchemaDog{}})
+	require.EqualError(t, err, "type Dog: field age: field of type int can't hold string produced by its validator")
+
+	_, err = ParseSchema([]byte(`{"types": {"Dog": {"fields": [{"name": "tags", "field": "Tags", "type": "list", "items": {"type": "integer"}}]}}}`), map[string]interface{}{"Dog": SchemaDog{}})
+	require.EqualError(t, err, "type Dog: field tags: field of type string can't hold int64 produced by its validator")
+
+	_, err = ParseSchema([]byte(`{"types": {"Dog": {"fields": [{"name": "name", "field": "Name", "type": "Person"}]}, "Person": {"fields": []}}}`), map[string]interface{}{"Dog": SchemaDog{}})
+	require.EqualError(t, err, "type Dog: field name: field of type string can't hold map[string]interface {}")
+
+	_, err = ParseSchema([]byte(`{"types": {"Dog": {"fields": [{"name": "age", "field": "Age", "type": "string", "validators": ["test-slug"]}]}}}`), map[string]interface{}{"Dog": SchemaDog{}})
+	require.EqualError(t, err, "type Dog: field age: field of type int can't hold string produced by its validator")
+
+	_, err = ParseSchema([]byte(`{"types": {"Dog": {"fields": [{"name": "owner", "type": "Cat"}]}}}`), nil)
+	require.EqualError(t, err, "type Dog: field owner: unknown type: Cat")
+
+	_, err = ParseSchema([]byte(`{"types": {"Dog": {"fields": [{"name": "owner", "kind": "Cat"}]}}}`), nil)
+	require.Error(t, err)
+}
+
+func init() {
+	RegisterValidators(map[string]Validator{
+		"test-slug": String(1, 20).Regex(regexp.MustCompile(`^[a-z0-9-]+$`)),
+		"test-even": evenValidator{},
+	})
+}
+
+type evenValidator struct{}
+
+func (evenValidator) Validate(value interface{}) (interface{}, error) {
+	if value.(int64)%2 != 0 {
+		return nil, NewValidationError("must be even")
+	}
+	return value, nil
+}
+
+func TestParseSchemaNamedValidators(t *testing.T) {
+	s, err := ParseSchema([]byte(`
+types:
+  Dog:
+    fields:
+      - {name: name, type: string, max: 10, validators: [test-slug]}
+      - {name: legs, type: integer, min: 0, validators: [test-even]}
+`), nil)
+	require.NoError(t, err)
+
+	tm, err := s.TypeMapper("Dog")
+	require.NoError(t, err)
+
+	dog := map[string]interface{}{}
+	err = tm.Unmarshal(EmptyContext, []byte(`{"name": "spot-2", "legs": 4}`), &dog)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"name": "spot-2", "legs": int64(4)}, dog)
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"name": "Spot", "legs": -3}`), &dog)
+	require.EqualError(t, err, "Validation Errors: \n"+
+		"/name: must match regular expression: ^[a-z0-9-]+$\n"+
+		"/legs: too small, must be at least 0\n")
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"name": "spot", "legs": 3}`), &dog)
+	require.EqualError(t, err, "Validation Errors: \n/legs: must be even\n")
+
+	_, err = ParseSchema([]byte(`{"types": {"Dog": {"fields": [{"name": "name", "type": "string", "validators": ["nope"]}]}}}`), nil)
+	require.EqualError(t, err, "type Dog: field name: unknown validator: nope")
+
+	_, err = ParseSchema([]byte(`{"types": {"Dog": {"fields": [{"name": "tags", "type": "list", "items": {"type": "string"}, "validators": ["test-slug"]}]}}}`), nil)
+	require.EqualError(t, err, "type Dog: field tags: validators may only be applied to primitive types")
+}
+
+func init() {
+	RegisterSchemaTypes(map[string]SchemaTypeBuilder{
+		"test-money": func(options map[string]interface{}) (TypeMap, error) {
+			currency, _ := options["currency"].(string)
+			if currency == "" {
+				return nil, errors.New("currency required")
+			}
+			return NewPrimitiveMap(AllOf(String(1, 20), OneOf("1.00 "+currency, "2.00 "+currency))), nil
+		},
+	})
+}
+
+func TestParseSchemaRegisteredTypes(t *testing.T) {
+	require.Contains(t, RegisteredSchemaTypes(), "test-money")
+
+	s, err := ParseSchema([]byte(`
+types:
+  Order:
+    fields:
+      - {name: price, type: test-money, options: {currency: EUR}}
+      - {name: prices, type: list, items: {type: test-money, options: {currency: USD}}}
+`), nil)
+	require.NoError(t, err)
+
+	tm, err := s.TypeMapper("Order")
+	require.NoError(t, err)
+
+	order := map[string]interface{}{}
+	err = tm.Unmarshal(EmptyContext, []byte(`{"price": "1.00 EUR", "prices": ["2.00 USD"]}`), &order)
+	require.NoError(t, err)
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"price": "1.00 USD", "prices": []}`), &order)
+	require.EqualError(t, err, "Validation Errors: \n/price: Value must be one of: [\"1.00 EUR\",\"2.00 EUR\"]\n")
+
+	_, err = ParseSchema([]byte(`{"types": {"Order": {"fields": [{"name": "price", "type": "test-money"}]}}}`), nil)
+	require.EqualError(t, err, "type Order: field price: currency required")
+
+	_, err = ParseSchema([]byte(`{"types": {"Order": {"fields": [{"name": "price", "type": "string", "options": {"currency": "EUR"}}]}}}`), nil)
+	require.EqualError(t, err, "type Order: field price: options may only be given for registered types")
+
+	require.Panics(t, func() {
+		RegisterSchemaTypes(map[string]SchemaTypeBuilder{"string": nil})
+	})
+}
+
+func TestWithTrace(t *testing.T) {
+	var events []string
+	ctx := WithTrace(EmptyContext, TraceFunc(func(e TraceEvent) {
+		events = append(events, e.String())
+	}))
+
+	v := &OuterVariableThing{}
+	err := TestTypeMapper.Unmarshal(ctx, []byte(`{"inner_type": "foo", "inner_thing": {"foo": "a", "an_int": 20, "extra": 1, "a_bool": null}, "typo": 1}`), v)
+	require.Error(t, err)
+	require.Equal(t, []string{
+		`/inner_type: field matched: InnerType`,
+		`/inner_type: validator run: "foo" accepted as "foo"`,
+		`/typo: field ignored`,
+		`/inner_thing: field matched: InnerValue`,
+		`/inner_thing: variant selected: "foo", from InnerType`,
+		`/inner_thing/foo: field matched: Foo`,
+		`/inner_thing/foo: validator run: "a" accepted as "a"`,
+		`/inner_thing/an_int: field matched: AnInt`,
+		`/inner_thing/an_int: validator run: 20 rejected: too large, may not be larger than 10`,
+		`/inner_thing/extra: field ignored`,
+		`/inner_thing/a_bool: field skipped: null`,
+	}, events)
+
+	events = nil
+	_, err = TestTypeMapper.Marshal(ctx, &OuterVariableThing{InnerType: "bar", InnerValue: &OtherInnerThing{}})
+	require.NoError(t, err)
+	require.Equal(t, `/inner_thing: variant selected: "bar", from InnerType`, events[2])
+	require.Equal(t, []string{
+		`/inner_type: field marshaled: InnerType`,
+		`/inner_thing: field marshaled: InnerValue`,
+	}, []string{events[0], events[1]})
+
+	events = nil
+	err = TestTypeMapper.Unmarshal(ctx, []byte(`{"inner_type": "baz", "inner_thing": {}}`), &OuterVariableThing{})
+	require.Error(t, err)
+	require.Contains(t, events, `/inner_thing: variant selected: no variant for InnerType "baz"`)
+}
+
+type pipeline struct {
+	Stages OrderedMap[int]
+}
+
+var pipelineTypeMap = StructMap{
+	UnderlyingType: pipeline{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "Stages",
+			JSONFieldName:   "stages",
+			Contains:        OrderedMapOf(NewPrimitiveMap(Integer(0, 100))),
+		},
+	},
+}
+
+func TestOrderedMap(t *testing.T) {
+	tm := NewTypeMapper(pipelineTypeMap)
+
+	p := pipeline{}
+	err := tm.Unmarshal(EmptyContext, []byte(`{"stages": {"lint": 1, "build": 2, "test": 3, "deploy": 4}}`), &p)
+	require.NoError(t, err)
+	require.Equal(t, []string{"lint", "build", "test", "deploy"}, p.Stages.Keys())
+
+	v, ok := p.Stages.Get("test")
+	require.True(t, ok)
+	require.Equal(t, 3, v)
+
+	p.Stages.Delete("test")
+	p.Stages.Set("build", 5)
+	p.Stages.Set("audit", 6)
+	require.Equal(t, 4, p.Stages.Len())
+
+	data, err := tm.Marshal(EmptyContext, p)
+	require.NoError(t, err)
+	require.Equal(t, `{"stages":{"lint":1,"build":5,"deploy":4,"audit":6}}`, string(data))
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"stages": {"lint": 1, "build": 200}}`), &p)
+	require.EqualError(t, err, "Validation Errors: \n/stages/build: too large, may not be larger than 100\n")
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"stages": []}`), &p)
+	require.EqualError(t, err, "Validation Errors: \n/stages: expected a map\n")
+
+	// Without the token stream, keys are sorted
+	err = pipelineTypeMap.Unmarshal(EmptyContext, nil, map[string]interface{}{
+		"stages": map[string]interface{}{"b": float64(1), "a": float64(2)},
+	}, reflect.ValueOf(&p).Elem())
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b"}, p.Stages.Keys())
+}
+
+type wrappedPipeline struct {
+	Kind      string
+	Nullable  *OrderedMap[int]
+	Optional  Optional[OrderedMap[int]]
+	Encrypted OrderedMap[int]
+	Variant   interface{}
+}
+
+func TestOrderedMapBehindWrappers(t *testing.T) {
+	stages := OrderedMapOf(NewPrimitiveMap(Integer(0, 100)))
+	tm := NewTypeMapper(StructMap{
+		UnderlyingType: wrappedPipeline{},
+		Fields: []MappedField{
+			{StructFieldName: "Kind", JSONFieldName: "kind", Validator: String(1, 20)},
+			{StructFieldName: "Nullable", JSONFieldName: "nullable", Contains: Nullable(stages)},
+			{StructFieldName: "Optional", JSONFieldName: "optional", Contains: OptionalOf(stages)},
+			{StructFieldName: "Encrypted", JSONFieldName: "encrypted", Contains: Encrypted(stages, patientCipher)},
+			{StructFieldName: "Variant", JSONFieldName: "variant", Contains: VariableType("Kind", map[string]TypeMap{
+				"pipeline": pipelineTypeMap,
+			})},
+		},
+	})
+
+	key := []byte("0123456789abcdef0123456789abcdef")
+	ctx := NewCtx(EmptyContext).With(encryptionKeyKey{}, key)
+
+	src := wrappedPipeline{Kind: "pipeline", Variant: &pipeline{}}
+	ordered := OrderedMap[int]{}
+	for i, k := range []string{"z", "a", "m"} {
+		ordered.Set(k, i)
+	}
+	src.Nullable = &ordered
+	src.Optional = Some(ordered)
+	src.Encrypted = ordered
+	src.Variant.(*pipeline).Stages = ordered
+
+	data, err := tm.Marshal(ctx, src)
+	require.NoError(t, err)
+
+	// The variant precedes the field it is switched on
+	data = bytes.Replace(data, []byte(`"kind":"pipeline",`), nil, 1)
+	data = append(data[:len(data)-1], []byte(`,"kind":"pipeline"}`)...)
+
+	dst := wrappedPipeline{}
+	err = tm.Unmarshal(ctx, data, &dst)
+	require.NoError(t, err)
+
+	keys := []string{"z", "a", "m"}
+	require.Equal(t, keys, dst.Nullable.Keys())
+	v, _ := dst.Optional.Get()
+	require.Equal(t, keys, v.Keys())
+	require.Equal(t, keys, dst.Encrypted.Keys())
+	require.Equal(t, keys, dst.Variant.(*pipeline).Stages.Keys())
+}
+
+func TestMarshalNilMapAsEmpty(t *testing.T) {
+	tm := NewTypeMapper(ThingWithMapOfStringsTypeMap)
+
+	data, err := tm.Marshal(EmptyContext, ThingWithMapOfStrings{})
+	require.NoError(t, err)
+	require.Equal(t, `{"strings":null}`, string(data))
+
+	tm.EmptyNilMaps = true
+	for _, legacy := range []bool{false, true} {
+		tm.LegacyMarshal = legacy
+		data, err = tm.Marshal(EmptyContext, ThingWithMapOfStrings{})
+		require.NoError(t, err)
+		require.Equal(t, `{"strings":{}}`, string(data))
+	}
+
+	tm = NewTypeMapper(StructMap{
+		UnderlyingType: ThingWithMapOfStrings{},
+		Fields: []MappedField{
+			{
+				StructFieldName: "Strings",
+				JSONFieldName:   "strings",
+				Contains:        &MapMap{Contains: NewPrimitiveMap(String(0, 5)), EmptyIfNil: true},
+			},
+		},
+	})
+
+	data, err = tm.Marshal(EmptyContext, ThingWithMapOfStrings{})
+	require.NoError(t, err)
+	require.Equal(t, `{"strings":{}}`, string(data))
+}
+
+type retryPolicy struct {
+	Attempts int64
+	Backoff  *backoffPolicy
+}
+
+func (p *retryPolicy) SetDefaults(ctx Context) {
+	p.Attempts = 3
+}
+
+type backoffPolicy struct {
+	Seconds int64
+}
+
+func (p *backoffPolicy) SetDefaults(ctx Context) {
+	p.Seconds = 10
+}
+
+type jobConfig struct {
+	Name  string
+	Retry *retryPolicy
+}
+
+var backoffPolicyTypeMap = StructMap{
+	UnderlyingType: backoffPolicy{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "Seconds",
+			JSONFieldName:   "seconds",
+			Validator:       Integer(1, 60),
+			Optional:        true,
+		},
+	},
+}
+
+var retryPolicyTypeMap = StructMap{
+	UnderlyingType: retryPolicy{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "Attempts",
+			JSONFieldName:   "attempts",
+			Validator:       Integer(1, 10),
+			Optional:        true,
+		},
+		{
+			StructFieldName: "Backoff",
+			JSONFieldName:   "backoff",
+			Contains:        backoffPolicyTypeMap,
+			Optional:        true,
+			InitIfAbsent:    true,
+		},
+	},
+}
+
+var jobConfigTypeMap = StructMap{
+	UnderlyingType: jobConfig{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "Name",
+			JSONFieldName:   "name",
+			Validator:       String(1, 20),
+		},
+		{
+			StructFieldName: "Retry",
+			JSONFieldName:   "retry",
+			Contains:        retryPolicyTypeMap,
+			Optional:        true,
+			InitIfAbsent:    true,
+		},
+	},
+}
+
+func TestInitIfAbsent(t *testing.T) {
+	for _, tm := range []*TypeMapper{
+		NewTypeMapper(jobConfigTypeMap),
+		// Generated code applies defaults in the same way
+		NewTypeMapper(jobConfigTypeMapStatic),
+	} {
+		job := jobConfig{}
+		err := tm.Unmarshal(EmptyContext, []byte(`{"name": "build"}`), &job)
+		require.NoError(t, err)
+		require.Equal(t, &retryPolicy{Attempts: 3, Backoff: &backoffPolicy{Seconds: 10}}, job.Retry)
+
+		job = jobConfig{}
+		err = tm.Unmarshal(EmptyContext, []byte(`{"name": "build", "retry": {"backoff": {}}}`), &job)
+		require.NoError(t, err)
+		require.Equal(t, &retryPolicy{Attempts: 3, Backoff: &backoffPolicy{Seconds: 10}}, job.Retry)
+
+		job = jobConfig{}
+		err = tm.Unmarshal(EmptyContext, []byte(`{"name": "build", "retry": {"attempts": 5}}`), &job)
+		require.NoError(t, err)
+		require.Equal(t, &retryPolicy{Attempts: 5, Backoff: &backoffPolicy{Seconds: 10}}, job.Retry)
+
+		// Null is left as-is
+		job = jobConfig{}
+		err = tm.Unmarshal(EmptyContext, []byte(`{"name": "build", "retry": null}`), &job)
+		require.NoError(t, err)
+		require.Nil(t, job.Retry)
+	}
+
+	job := jobConfig{}
+	err := jobConfigTypeMap.Unmarshal(EmptyContext, nil, map[string]interface{}{"name": "build"}, reflect.ValueOf(&job).Elem())
+	require.NoError(t, err)
+	require.Equal(t, &retryPolicy{Attempts: 3, Backoff: &backoffPolicy{Seconds: 10}}, job.Retry)
+}
+
+type lintedThing struct {
+	Count    int
+	Inner    *InnerThing
+	Kind     string
+	Variant  interface{}
+	Children []lintedThing
+}
+
+func TestLint(t *testing.T) {
+	tm := NewTypeMapper(
+		InnerThingTypeMap,
+		OuterThingTypeMap,
+		OuterSliceThingTypeMap,
+		ThingWithOptionalsTypeMap,
+		ExpandablePersonTypeMap,
+		jobConfigTypeMap,
+		pipelineTypeMap,
+	)
+	require.NoError(t, tm.Lint().Err())
+
+	lintedThingTypeMap := StructMap{
+		UnderlyingType: lintedThing{},
+	}
+	lintedThingTypeMap.Fields = []MappedField{
+		{
+			StructFieldName: "Count",
+			JSONFieldName:   "count",
+			Validator:       Integer(0, 10),
+		},
+		{
+			StructFieldName: "Inner",
+			JSONFieldName:   "inner",
+			Contains:        OuterThingTypeMap,
+		},
+		{
+			StructFieldName: "Variant",
+			JSONFieldName:   "variant",
+			Contains: VariableType("Kind", map[string]TypeMap{
+				"inner": InnerThingTypeMap,
+				"other": nil,
+			}),
+		},
+		{
+			StructFieldName: "Missing",
+			JSONFieldName:   "missing",
+			Validator:       String(0, 10),
+		},
+		{
+			StructFieldName: "Kind",
+			JSONFieldName:   "kind",
+		},
+		{
+			StructFieldName: "Children",
+			JSONFieldName:   "children",
+			Contains:        SliceOf(&lintedThingTypeMap),
+		},
+	}
+
+	tm = NewTypeMapper(
+		&lintedThingTypeMap,
+		StructMap{UnderlyingType: &InnerThing{}},
+	)
+
+	report := tm.Lint()
+	require.Equal(t, []LintIssue{
+		{
+			Kind:    LintUnreachable,
+			Type:    reflect.TypeOf(&InnerThing{}),
+			Message: "registered under *jsonmap.InnerThing, which is never looked up",
+		},
+		{
+			Kind:    LintTypeMismatch,
+			Type:    reflect.TypeOf(lintedThing{}),
+			Path:    "/count",
+			Message: "field of type int can't hold int64 produced by its validator",
+		},
+		{
+			Kind:    LintTypeMismatch,
+			Type:    reflect.TypeOf(lintedThing{}),
+			Path:    "/inner",
+			Message: "field of type jsonmap.InnerThing can't hold jsonmap.OuterThing",
+		},
+		{
+			Kind:    LintMissingJSONTag,
+			Type:    reflect.TypeOf(lintedThing{}),
+			Path:    "/variant",
+			Message: "field switched on has no json tag: Kind",
+		},
+		{
+			Kind:    LintInvalidField,
+			Type:    reflect.TypeOf(lintedThing{}),
+			Path:    "/variant",
+			Message: "no TypeMap for variant other",
+		},
+		{
+			Kind:    LintInvalidField,
+			Type:    reflect.TypeOf(lintedThing{}),
+			Path:    "/missing",
+			Message: "no such struct field: Missing",
+		},
+		{
+			Kind:    LintInvalidField,
+			Type:    reflect.TypeOf(lintedThing{}),
+			Path:    "/kind",
+			Message: "field has neither Contains nor Validator",
+		},
+	}, report.Issues)
+
+	require.EqualError(t, report.Err(), "jsonmap lint: \n"+
+		"*jsonmap.InnerThing: unreachable: registered under *jsonmap.InnerThing, which is never looked up\n"+
+		"jsonmap.lintedThing /count: type mismatch: field of type int can't hold int64 produced by its validator\n"+
+		"jsonmap.lintedThing /inner: type mismatch: field of type jsonmap.InnerThing can't hold jsonmap.OuterThing\n"+
+		"jsonmap.lintedThing /variant: missing json tag: field switched on has no json tag: Kind\n"+
+		"jsonmap.lintedThing /variant: invalid field: no TypeMap for variant other\n"+
+		"jsonmap.lintedThing /missing: invalid field: no such struct field: Missing\n"+
+		"jsonmap.lintedThing /kind: invalid field: field has neither Contains nor Validator")
+}
+
+func TestValidateOnMarshal(t *testing.T) {
+	tm := NewTypeMapper(ThingWithMapOfStringsTypeMap, RectangleTypeMap)
+	thing := ThingWithMapOfStrings{Strings: map[string]string{"a": "short", "b": "too long"}}
+
+	data, err := tm.Marshal(EmptyContext, thing)
+	require.NoError(t, err)
+	require.Equal(t, `{"strings":{"a":"short","b":"too long"}}`, string(data))
+
+	tm.ValidateOnMarshal = true
+	for _, legacy := range []bool{false, true} {
+		tm.LegacyMarshal = legacy
+
+		_, err = tm.Marshal(EmptyContext, thing)
+		require.EqualError(t, err, "Validation Errors: \n/strings/b: too long, may not be more than 5 characters\n")
+
+		thing.Strings["b"] = "ok"
+		data, err = tm.Marshal(EmptyContext, thing)
+		require.NoError(t, err)
+		require.Equal(t, `{"strings":{"a":"short","b":"ok"}}`, string(data))
+		thing.Strings["b"] = "too long"
+
+		_, err = tm.Marshal(EmptyContext, Rectangle{Width: 101, Height: 2})
+		require.EqualError(t, err, "Validation Errors: \n/width: too large, may not be larger than 100\n")
+	}
+
+	// StaticMaps validate through the StructMaps they were generated from
+	static := NewTypeMapper(InnerThingTypeMapStatic)
+	static.ValidateOnMarshal = true
+	_, err = static.Marshal(EmptyContext, InnerThing{Foo: "far too long, really"})
+	require.EqualError(t, err, "Validation Errors: \n/foo: too long, may not be more than 12 characters\n")
+}
+
+type mergeInner struct {
+	A string
+	B string
+}
+
+type mergeThing struct {
+	Name  string
+	Note  string
+	Tags  []string
+	Inner *mergeInner
+}
+
+func (m *mergeThing) SetDefaults(ctx Context) {
+	m.Note = "default"
+}
+
+var mergeInnerTypeMap = StructMap{
+	UnderlyingType: mergeInner{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "A",
+			JSONFieldName:   "a",
+			Validator:       String(0, 10),
+			Optional:        true,
+		},
+		{
+			StructFieldName: "B",
+			JSONFieldName:   "b",
+			Validator:       String(0, 10),
+			Optional:        true,
+		},
+	},
+}
+
+var mergeThingTypeMap = StructMap{
+	UnderlyingType: mergeThing{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "Name",
+			JSONFieldName:   "name",
+			Validator:       String(0, 10),
+			Optional:        true,
+		},
+		{
+			StructFieldName: "Note",
+			JSONFieldName:   "note",
+			Validator:       String(0, 10),
+			Optional:        true,
+		},
+		{
+			StructFieldName: "Tags",
+			JSONFieldName:   "tags",
+			Contains:        SliceOf(NewPrimitiveMap(String(0, 10))),
+			Optional:        true,
+		},
+		{
+			StructFieldName: "Inner",
+			JSONFieldName:   "inner",
+			Contains:        mergeInnerTypeMap,
+			Optional:        true,
+		},
+	},
+}
+
+func TestMergeMode(t *testing.T) {
+	existing := func() mergeThing {
+		return mergeThing{
+			Name:  "old",
+			Note:  "kept",
+			Tags:  []string{"x"},
+			Inner: &mergeInner{A: "a", B: "b"},
+		}
+	}
+
+	tm := NewTypeMapper(mergeThingTypeMap)
+	doc := []byte(`{"name": "new", "note": null, "tags": ["y"], "inner": {"a": "A"}}`)
+
+	unmarshal := func(reader bool, thing *mergeThing) error {
+		if reader {
+			return tm.UnmarshalReader(EmptyContext, bytes.NewReader(doc), thing)
+		}
+		partial := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal(doc, &partial))
+		return tm.unmarshalPartial(EmptyContext, mergeThingTypeMap, partial, thing)
+	}
+
+	for _, reader := range []bool{false, true} {
+		// By default structs pointed to are replaced, and slices appended to
+		tm.MergeMode = MergeNone
+		thing := existing()
+		inner := thing.Inner
+		err := unmarshal(reader, &thing)
+		require.NoError(t, err)
+		require.Equal(t, mergeThing{
+			Name:  "new",
+			Note:  "kept",
+			Tags:  []string{"x", "y"},
+			Inner: &mergeInner{A: "A"},
+		}, thing)
+		require.Equal(t, &mergeInner{A: "a", B: "b"}, inner)
+
+		tm.MergeMode = MergePreserve
+		thing = existing()
+		inner = thing.Inner
+		err = unmarshal(reader, &thing)
+		require.NoError(t, err)
+		require.Equal(t, mergeThing{
+			Name:  "new",
+			Note:  "kept",
+			Tags:  []string{"y"},
+			Inner: &mergeInner{A: "A", B: "b"},
+		}, thing)
+		require.True(t, inner == thing.Inner)
+
+		tm.MergeMode = MergeReset
+		thing = existing()
+		err = unmarshal(reader, &thing)
+		require.NoError(t, err)
+		require.Equal(t, mergeThing{
+			Name:  "new",
+			Note:  "default",
+			Tags:  []string{"y"},
+			Inner: &mergeInner{A: "A"},
+		}, thing)
+	}
+
+	thing := existing()
+	err := tm.Unmarshal(EmptyContext, []byte(`{}`), &thing)
+	require.NoError(t, err)
+	require.Equal(t, mergeThing{Note: "default"}, thing)
+}
+
+type ThingWithNullElements struct {
+	Things   []InnerThing
+	Pointers []*InnerThing
+}
+
+func TestSliceOfNulls(t *testing.T) {
+	typeMap := func(things, pointers NullElements) StructMap {
+		return StructMap{
+			UnderlyingType: ThingWithNullElements{},
+			Fields: []MappedField{
+				{
+					StructFieldName: "Things",
+					JSONFieldName:   "things",
+					Contains:        SliceOfNulls(InnerThingTypeMap, things),
+					Optional:        true,
+				},
+				{
+					StructFieldName: "Pointers",
+					JSONFieldName:   "pointers",
+					Contains:        SliceOfNulls(InnerThingTypeMap, pointers),
+					Optional:        true,
+				},
+			},
+		}
+	}
+
+	doc := []byte(`{"things": [{"foo": "a"}, null], "pointers": [null, {"foo": "b"}]}`)
+
+	for _, stream := range []bool{false, true} {
+		unmarshal := func(sm StructMap, dest *ThingWithNullElements) error {
+			if stream {
+				return NewTypeMapper(sm).Unmarshal(EmptyContext, doc, dest)
+			}
+			partial := map[string]interface{}{}
+			require.NoError(t, json.Unmarshal(doc, &partial))
+			err := sm.Unmarshal(EmptyContext, nil, partial, reflect.ValueOf(dest).Elem())
+			if verr, ok := err.(*ValidationError); ok {
+				return verr.Flatten()
+			}
+			return err
+		}
+
+		thing := ThingWithNullElements{}
+		err := unmarshal(typeMap(NullElementsPassed, NullElementsPassed), &thing)
+		require.EqualError(t, err, "Validation Errors: \n/things/1: expected an object\n")
+
+		thing = ThingWithNullElements{}
+		err = unmarshal(typeMap(NullElementsRejected, NullElementsSkipped), &thing)
+		require.EqualError(t, err, "Validation Errors: \n/things/1: may not be null\n")
+
+		thing = ThingWithNullElements{}
+		err = unmarshal(typeMap(NullElementsZero, NullElementsSkipped), &thing)
+		require.NoError(t, err)
+		require.Equal(t, []InnerThing{{Foo: "a"}, {}}, thing.Things)
+		require.Equal(t, []*InnerThing{{Foo: "b"}}, thing.Pointers)
+
+		thing = ThingWithNullElements{}
+		err = unmarshal(typeMap(NullElementsSkipped, NullElementsPassed), &thing)
+		require.NoError(t, err)
+		require.Equal(t, []InnerThing{{Foo: "a"}}, thing.Things)
+		require.Equal(t, []*InnerThing{nil, {Foo: "b"}}, thing.Pointers)
+	}
+}
+
+type ThingWithMetadata struct {
+	Name     string
+	Metadata map[string]interface{}
+}
+
+func TestLimited(t *testing.T) {
+	sm := StructMap{
+		UnderlyingType: ThingWithMetadata{},
+		Fields: []MappedField{
+			{
+				StructFieldName: "Name",
+				JSONFieldName:   "name",
+				Validator:       String(0, 32),
+				Optional:        true,
+			},
+			{
+				StructFieldName: "Metadata",
+				JSONFieldName:   "metadata",
+				Contains:        Limited(MapOf(NewPrimitiveMap(Interface())), 3, 5),
+				Optional:        true,
+			},
+		},
+	}
+
+	for _, stream := range []bool{false, true} {
+		unmarshal := func(doc string, dest *ThingWithMetadata) error {
+			if stream {
+				return NewTypeMapper(sm).Unmarshal(EmptyContext, []byte(doc), dest)
+			}
+			partial := map[string]interface{}{}
+			require.NoError(t, json.Unmarshal([]byte(doc), &partial))
+			err := sm.Unmarshal(EmptyContext, nil, partial, reflect.ValueOf(dest).Elem())
+			if verr, ok := err.(*ValidationError); ok {
+				return verr.Flatten()
+			}
+			return err
+		}
+
+		thing := ThingWithMetadata{}
+		err := unmarshal(`{"name": "a", "metadata": {"a": {"b": {"c": 1}}, "d": [true]}}`, &thing)
+		require.NoError(t, err)
+		require.Equal(t, map[string]interface{}{
+			"a": map[string]interface{}{"b": map[string]interface{}{"c": float64(1)}},
+			"d": []interface{}{true},
+		}, thing.Metadata)
+
+		thing = ThingWithMetadata{}
+		err = unmarshal(`{"name": "a", "metadata": {"a": {"b": {"c": [1]}}}}`, &thing)
+		require.EqualError(t, err, "Validation Errors: \n/metadata: exceeds maximum depth of 3\n")
+		require.Nil(t, thing.Metadata)
+
+		thing = ThingWithMetadata{}
+		err = unmarshal(`{"name": "a", "metadata": {"a": [1, 2, 3, 4, 5]}}`, &thing)
+		require.EqualError(t, err, "Validation Errors: \n/metadata: exceeds maximum of 5 keys\n")
+
+		// Only the limited field is affected
+		thing = ThingWithMetadata{}
+		err = unmarshal(`{"name": "a", "metadata": {"a": [1, 2, 3, 4]}}`, &thing)
+		require.NoError(t, err)
+	}
+
+	data, err := NewTypeMapper(sm).Marshal(EmptyContext, ThingWithMetadata{Name: "a", Metadata: map[string]interface{}{"x": "y"}})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"name": "a", "metadata": {"x": "y"}}`, string(data))
+}
+
+func TestUnmarshalArrayStream(t *testing.T) {
+	type result struct {
+		I    int
+		Elem interface{}
+		Err  string
+	}
+
+	unmarshal := func(tm *TypeMapper, doc string, stopAt int) ([]result, error) {
+		var results []result
+		err := tm.UnmarshalArrayStream(EmptyContext, bytes.NewReader([]byte(doc)), reflect.TypeOf(InnerThing{}), func(i int, elem interface{}, err error) bool {
+			r := result{I: i, Elem: elem}
+			if err != nil {
+				r.Err = err.Error()
+			}
+			results = append(results, r)
+			return i != stopAt
+		})
+		return results, err
+	}
+
+	doc := `[{"foo": "a"}, {"foo": ""}, {"foo": "c", "an_int": 3}]`
+
+	results, err := unmarshal(TestTypeMapper, doc, -1)
+	require.NoError(t, err)
+	require.Equal(t, []result{
+		{I: 0, Elem: &InnerThing{Foo: "a"}},
+		{I: 1, Err: "Validation Errors: \n/1/foo: too short, must be at least 1 characters\n"},
+		{I: 2, Elem: &InnerThing{Foo: "c", AnInt: 3}},
+	}, results)
+
+	results, err = unmarshal(TestTypeMapper, doc, 0)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+
+	failFast := NewTypeMapper(InnerThingTypeMap)
+	failFast.FailFast = true
+	results, err = unmarshal(failFast, doc, -1)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+
+	results, err = unmarshal(TestTypeMapper, `null`, -1)
+	require.NoError(t, err)
+	require.Empty(t, results)
+
+	_, err = unmarshal(TestTypeMapper, `{"foo": "a"}`, -1)
+	require.EqualError(t, err, "json: cannot unmarshal, not an array")
+
+	results, err = unmarshal(TestTypeMapper, `[{"foo": "a"}, {"foo": `, -1)
+	require.EqualError(t, err, "unexpected end of JSON input")
+	require.Len(t, results, 1)
+
+	_, err = unmarshal(TestTypeMapper, `[] []`, -1)
+	require.EqualError(t, err, "invalid character after top-level value")
+}
+
+func TestUnmarshalTopLevelSliceAndMap(t *testing.T) {
+	things := []InnerThing{{Foo: "old"}}
+	err := TestTypeMapper.Unmarshal(EmptyContext, []byte(`[{"foo": "a"}, {"foo": "b", "an_int": 2}]`), &things)
+	require.NoError(t, err)
+	require.Equal(t, []InnerThing{{Foo: "a"}, {Foo: "b", AnInt: 2}}, things)
+
+	pointers := []*InnerThing{}
+	err = TestTypeMapper.Unmarshal(EmptyContext, []byte(`[{"foo": "a"}]`), &pointers)
+	require.NoError(t, err)
+	require.Equal(t, []*InnerThing{{Foo: "a"}}, pointers)
+
+	err = TestTypeMapper.Unmarshal(EmptyContext, []byte(`[{"foo": "a"}, {"foo": ""}]`), &things)
+	require.EqualError(t, err, "Validation Errors: \n/1/foo: too short, must be at least 1 characters\n")
+
+	err = TestTypeMapper.Unmarshal(EmptyContext, []byte(`{"foo": "a"}`), &things)
+	require.EqualError(t, err, "json: cannot unmarshal, not an array")
+
+	err = TestTypeMapper.Unmarshal(EmptyContext, []byte(`null`), &things)
+	require.NoError(t, err)
+	require.Nil(t, things)
+
+	byName := map[string]InnerThing{}
+	err = TestTypeMapper.Unmarshal(EmptyContext, []byte(`{"x": {"foo": "a"}, "y": {"a_bool": true}}`), &byName)
+	require.NoError(t, err)
+	require.Equal(t, map[string]InnerThing{"x": {Foo: "a"}, "y": {ABool: true}}, byName)
+
+	err = TestTypeMapper.Unmarshal(EmptyContext, []byte(`{"x": {"foo": ""}}`), &byName)
+	require.EqualError(t, err, "Validation Errors: \n/x/foo: too short, must be at least 1 characters\n")
+
+	err = TestTypeMapper.Unmarshal(EmptyContext, []byte(`[]`), &byName)
+	require.EqualError(t, err, "json: cannot unmarshal, not an object")
+
+	// Marshaling is symmetric
+	data, err := TestTypeMapper.Marshal(EmptyContext, map[string]InnerThing{"x": {Foo: "a"}})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"x": {"foo": "a", "an_int": 0, "a_bool": false}}`, string(data))
+
+	data, err = TestTypeMapper.Marshal(EmptyContext, []InnerThing{{Foo: "a"}})
+	require.NoError(t, err)
+	require.JSONEq(t, `[{"foo": "a", "an_int": 0, "a_bool": false}]`, string(data))
+}
+
+type Measurement struct {
+	Unit  string
+	Value int64
+}
+
+type unitContextKey struct{}
+
+type Measurements struct {
+	Readings []Measurement
+}
+
+var MeasurementTypeMap = StructMap{
+	UnderlyingType: Measurement{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "Value",
+			JSONFieldName:   "value",
+			Contains: ValueRenderer(func(info RenderInfo) (interface{}, error) {
+				unit, _ := info.Context.(*Ctx).Value(unitContextKey{}).(string)
+				return strconv.FormatInt(info.Value.(int64), 10) + unit, nil
+			}),
+		},
+	},
+	ContextTransform: func(ctx Context, value interface{}) Context {
+		return NewCtx(ctx).With(unitContextKey{}, value.(*Measurement).Unit)
+	},
+}
+
+var MeasurementsTypeMap = StructMap{
+	UnderlyingType: Measurements{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "Readings",
+			JSONFieldName:   "readings",
+			Contains:        SliceOf(MeasurementTypeMap),
+		},
+	},
+}
+
+func TestContextTransform(t *testing.T) {
+	for _, legacy := range []bool{false, true} {
+		tm := NewTypeMapper(MeasurementsTypeMap)
+		tm.LegacyMarshal = legacy
+
+		data, err := tm.Marshal(EmptyContext, Measurements{
+			Readings: []Measurement{{Unit: "cm", Value: 3}, {Unit: "kg", Value: 4}},
+		})
+		require.NoError(t, err)
+		require.Equal(t, `{"readings":[{"value":"3cm"},{"value":"4kg"}]}`, string(data))
+	}
+
+	// Generated code applies the transform in the same way
+	data, err := NewTypeMapper(MeasurementTypeMapStatic).Marshal(EmptyContext, Measurement{Unit: "cm", Value: 3})
+	require.NoError(t, err)
+	require.Equal(t, `{"value":"3cm"}`, string(data))
+}
+
+type ThingWithEnums struct {
+	Priority int
+	Enabled  bool
+	Scale    interface{}
+}
+
+var ThingWithEnumsTypeMap = StructMap{
+	UnderlyingType: ThingWithEnums{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "Priority",
+			JSONFieldName:   "priority",
+			Validator:       OneOfValues(1, 2, 3),
+		},
+		{
+			StructFieldName: "Enabled",
+			JSONFieldName:   "enabled",
+			Validator:       OneOfValues(true),
+			Optional:        true,
+		},
+		{
+			StructFieldName: "Scale",
+			JSONFieldName:   "scale",
+			Validator:       OneOfValues("auto", 0.5, 2),
+			Optional:        true,
+		},
+	},
+}
+
+func TestOneOfValues(t *testing.T) {
+	tm := NewTypeMapper(ThingWithEnumsTypeMap)
+	require.NoError(t, tm.Lint().Err())
+
+	v := &ThingWithEnums{}
+	err := tm.Unmarshal(EmptyContext, []byte(`{"priority": 2, "enabled": true, "scale": 0.5}`), v)
+	require.NoError(t, err)
+	require.Equal(t, &ThingWithEnums{Priority: 2, Enabled: true, Scale: 0.5}, v)
+
+	v = &ThingWithEnums{}
+	err = tm.Unmarshal(EmptyContext, []byte(`{"priority": 3, "scale": "auto"}`), v)
+	require.NoError(t, err)
+	require.Equal(t, &ThingWithEnums{Priority: 3, Scale: "auto"}, v)
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"priority": 4}`), v)
+	require.EqualError(t, err, "Validation Errors: \n/priority: Value must be one of: [1,2,3]\n")
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"priority": "1", "enabled": false, "scale": [2]}`), v)
+	require.EqualError(t, err, "Validation Errors: \n"+
+		"/priority: Value must be one of: [1,2,3]\n"+
+		"/enabled: Value must be one of: [true]\n"+
+		"/scale: Value must be one of: [\"auto\",0.5,2]\n")
+
+	data, err := tm.Marshal(EmptyContext, &ThingWithEnums{Priority: 1, Scale: 2})
+	require.NoError(t, err)
+	require.Equal(t, `{"priority":1,"enabled":false,"scale":2}`, string(data))
+}
+
+type ThingWithFlag struct {
+	Flag bool
+}
+
+func TestBooleanStrings(t *testing.T) {
+	tm := NewTypeMapper(StructMap{
+		UnderlyingType: ThingWithFlag{},
+		Fields: []MappedField{
+			{
+				StructFieldName: "Flag",
+				JSONFieldName:   "flag",
+				Validator:       BooleanStrings([]string{"yes", "1"}, []string{"no", "0"}),
+			},
+		},
+	})
+	require.NoError(t, tm.Lint().Err())
+
+	for doc, expected := range map[string]bool{
+		`{"flag": true}`:  true,
+		`{"flag": false}`: false,
+		`{"flag": "Yes"}`: true,
+		`{"flag": "no"}`:  false,
+		`{"flag": 1}`:     true,
+		`{"flag": "0"}`:   false,
+	} {
+		v := &ThingWithFlag{Flag: !expected}
+		err := tm.Unmarshal(EmptyContext, []byte(doc), v)
+		require.NoError(t, err, doc)
+		require.Equal(t, expected, v.Flag, doc)
+	}
+
+	for _, doc := range []string{`{"flag": "maybe"}`, `{"flag": 2}`, `{"flag": null}`} {
+		err := tm.Unmarshal(EmptyContext, []byte(doc), &ThingWithFlag{})
+		require.EqualError(t, err, "Validation Errors: \n/flag: not a boolean\n", doc)
+	}
+
+	data, err := tm.Marshal(EmptyContext, &ThingWithFlag{Flag: true})
+	require.NoError(t, err)
+	require.Equal(t, `{"flag":true}`, string(data))
+}
+
+type Invoice struct {
+	Total    MonetaryAmount
+	Discount MonetaryAmount
+}
+
+func TestMoney(t *testing.T) {
+	tm := NewTypeMapper(StructMap{
+		UnderlyingType: Invoice{},
+		Fields: []MappedField{
+			{
+				StructFieldName: "Total",
+				JSONFieldName:   "total",
+				Contains:        Money(),
+			},
+			{
+				StructFieldName: "Discount",
+				JSONFieldName:   "discount",
+				Contains:        MoneyMinorUnits(),
+				Optional:        true,
+			},
+		},
+	})
+
+	v := &Invoice{}
+	err := tm.Unmarshal(EmptyContext, []byte(`{"total": {"amount": "12.3", "currency": "USD"}, "discount": {"amount": 150, "currency": "JPY"}}`), v)
+	require.NoError(t, err)
+	require.Equal(t, &Invoice{
+		Total:    MonetaryAmount{Currency: "USD", Minor: 1230},
+		Discount: MonetaryAmount{Currency: "JPY", Minor: 150},
+	}, v)
+	require.Equal(t, "12.30 USD", v.Total.String())
+
+	data, err := tm.Marshal(EmptyContext, v)
+	require.NoError(t, err)
+	require.Equal(t, `{"total":{"amount":"12.30","currency":"USD"},"discount":{"amount":150,"currency":"JPY"}}`, string(data))
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"total": {"amount": "1.234", "currency": "USD"}, "discount": {"amount": 1.5, "currency": "usd"}}`), v)
+	require.EqualError(t, err, "Validation Errors: \n"+
+		"/total/amount: too many decimal places, may not have more than 2\n"+
+		"/discount/currency: not a valid ISO 4217 currency code\n")
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"total": {"amount": 12.34, "currency": "USD"}, "discount": {"amount": 1.5, "currency": "USD"}}`), v)
+	require.EqualError(t, err, "Validation Errors: \n"+
+		"/total/amount: not a string\n"+
+		"/discount/amount: not an integer\n")
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"total": {"amount": "1.234", "currency": "KWD"}, "discount": {}}`), v)
+	require.EqualError(t, err, "Validation Errors: \n"+
+		"/discount/currency: missing required field\n"+
+		"/discount/amount: missing required field\n")
+	require.Equal(t, MonetaryAmount{Currency: "KWD", Minor: 1234}, v.Total)
+
+	for minor, amount := range map[int64]string{5: "0.05", -5: "-0.05", -1234: "-12.34", 0: "0.00"} {
+		require.Equal(t, amount, MonetaryAmount{Currency: "EUR", Minor: minor}.Amount())
+	}
+	require.Equal(t, "1.005", MonetaryAmount{Currency: "BHD", Minor: 1005}.Amount())
+	require.Equal(t, "-7", MonetaryAmount{Currency: "JPY", Minor: -7}.Amount())
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"total": {"amount": "1e3", "currency": "USD"}}`), v)
+	require.EqualError(t, err, "Validation Errors: \n/total/amount: not a valid decimal amount\n")
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"total": {"amount": "99999999999999999999", "currency": "USD"}}`), v)
+	require.EqualError(t, err, "Validation Errors: \n/total/amount: amount out of range\n")
+}
+
+type Site struct {
+	Location Point
+	Boundary Polygon
+	Feature  Geometry
+}
+
+func TestGeoJSON(t *testing.T) {
+	tm := NewTypeMapper(StructMap{
+		UnderlyingType: Site{},
+		Fields: []MappedField{
+			{
+				StructFieldName: "Location",
+				JSONFieldName:   "location",
+				Contains:        GeoJSON(),
+			},
+			{
+				StructFieldName: "Boundary",
+				JSONFieldName:   "boundary",
+				Contains:        GeoJSON(),
+			},
+			{
+				StructFieldName: "Feature",
+				JSONFieldName:   "feature",
+				Contains:        GeoJSON(),
+				Optional:        true,
+			},
+		},
+	})
+
+	v := &Site{}
+	err := tm.Unmarshal(EmptyContext, []byte(`{
+		"location": {"type": "Point", "coordinates": [-122.4, 37.8]},
+		"boundary": {"type": "Polygon", "coordinates": [[[0, 0], [1, 0], [1, 1], [0, 0]]]},
+		"feature": {"type": "Point", "coordinates": [1.5, -2]}
+	}`), v)
+	require.NoError(t, err)
+	require.Equal(t, &Site{
+		Location: Point{Position{-122.4, 37.8}},
+		Boundary: Polygon{[][]Position{{{0, 0}, {1, 0}, {1, 1}, {0, 0}}}},
+		Feature:  Point{Position{1.5, -2}},
+	}, v)
+
+	data, err := tm.Marshal(EmptyContext, v)
+	require.NoError(t, err)
+	require.Equal(t, `{"location":{"type":"Point","coordinates":[-122.4,37.8]},`+
+		`"boundary":{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,0]]]},`+
+		`"feature":{"type":"Point","coordinates":[1.5,-2]}}`, string(data))
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{
+		"location": {"type": "Point", "coordinates": [181, -91]},
+		"boundary": {"type": "Polygon", "coordinates": [[[0, 0], [1, 0], [1, 1], [0, 1]], [[0, 0], [1, 1]]]},
+		"feature": {"type": "Polygon", "coordinates": [[[0, 0], [1, 0], [1, "a"], [0, 0]]]}
+	}`), v)
+	require.EqualError(t, err, "Validation Errors: \n"+
+		"/location/coordinates/0: longitude must be a number between -180 and 180\n"+
+		"/location/coordinates/1: latitude must be a number between -90 and 90\n"+
+		"/boundary/coordinates/0: a linear ring must end with the position with which it starts\n"+
+		"/boundary/coordinates/1: a linear ring must have at least 4 positions\n"+
+		"/feature/coordinates/0/2/1: latitude must be a number between -90 and 90\n")
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{
+		"location": {"type": "Polygon", "coordinates": []},
+		"boundary": {"coordinates": []},
+		"feature": {"type": "LineString", "coordinates": []}
+	}`), v)
+	require.EqualError(t, err, "Validation Errors: \n"+
+		"/location/type: expected a Point\n"+
+		"/boundary/type: missing geometry type\n"+
+		"/feature/type: unsupported geometry type: LineString\n")
+
+	v = &Site{Location: Point{Position{1, 2}}}
+	data, err = tm.Marshal(EmptyContext, v)
+	require.NoError(t, err)
+	require.Equal(t, `{"location":{"type":"Point","coordinates":[1,2]},"boundary":{"type":"Polygon","coordinates":[]},"feature":null}`, string(data))
+}
+
+func TestPointerAccess(t *testing.T) {
+	tm := NewTypeMapper(OuterThingTypeMap, OuterSliceThingTypeMap, MapOfInnerThingTypeMap, AnotherOuterThingTypeMap, ReadOnlyThingTypeMap)
+
+	v := &OuterThing{InnerThing{Foo: "bar", AnInt: 3}}
+	foo, err := tm.GetByPointer(v, "/inner_thing/foo")
+	require.NoError(t, err)
+	require.Equal(t, "bar", foo)
+
+	inner, err := tm.GetByPointer(*v, "/inner_thing")
+	require.NoError(t, err)
+	require.Equal(t, InnerThing{Foo: "bar", AnInt: 3}, inner)
+
+	root, err := tm.GetByPointer(v, "")
+	require.NoError(t, err)
+	require.Equal(t, v, root)
+
+	_, err = tm.GetByPointer(v, "/inner_thing/Foo")
+	require.EqualError(t, err, "no value at /inner_thing/Foo")
+
+	_, err = tm.GetByPointer(v, "/inner_thing/foo/0")
+	require.EqualError(t, err, "no value at /inner_thing/foo/0")
+
+	err = tm.SetByPointer(EmptyContext, v, "/inner_thing/an_int", []byte(`7`))
+	require.NoError(t, err)
+	require.Equal(t, int64(7), v.InnerThing.AnInt)
+
+	err = tm.SetByPointer(EmptyContext, v, "/inner_thing/an_int", []byte(`11`))
+	require.EqualError(t, err, "Validation Errors: \n/inner_thing/an_int: too large, may not be larger than 10\n")
+
+	err = tm.SetByPointer(EmptyContext, v, "/inner_thing", []byte(`{"foo": ""}`))
+	require.EqualError(t, err, "Validation Errors: \n/inner_thing/foo: too short, must be at least 1 characters\n")
+
+	err = tm.SetByPointer(EmptyContext, v, "/inner_thing/foo", []byte(`null`))
+	require.NoError(t, err)
+	require.Equal(t, InnerThing{AnInt: 7}, v.InnerThing)
+
+	// Escaped tokens are resolved against the JSON field names
+	another := &AnotherOuterThing{AnotherInnerThing{Foo: "a"}}
+	foo, err = tm.GetByPointer(another, "/another~1inner~1thing/foo")
+	require.NoError(t, err)
+	require.Equal(t, "a", foo)
+
+	s := &OuterSliceThing{[]InnerThing{{Foo: "a"}, {Foo: "b"}}}
+	foo, err = tm.GetByPointer(s, "/inner_things/1/foo")
+	require.NoError(t, err)
+	require.Equal(t, "b", foo)
+
+	_, err = tm.GetByPointer(s, "/inner_things/01")
+	require.EqualError(t, err, "no value at /inner_things/01")
+
+	_, err = tm.GetByPointer(s, "/inner_things/2/foo")
+	require.EqualError(t, err, "no value at /inner_things/2")
+
+	err = tm.SetByPointer(EmptyContext, s, "/inner_things/0", []byte(`{"a_bool": "yes"}`))
+	require.EqualError(t, err, "Validation Errors: \n/inner_things/0/a_bool: not a boolean\n")
+
+	err = tm.SetByPointer(EmptyContext, s, "/inner_things/0/foo", []byte(`"c"`))
+	require.NoError(t, err)
+	require.Equal(t, "c", s.InnerThings[0].Foo)
+
+	m := &OuterInnerThingMap{}
+	err = tm.SetByPointer(EmptyContext, m, "/inner_thing_map/x", []byte(`{"foo": "d"}`))
+	require.NoError(t, err)
+	require.Equal(t, map[string]InnerThing{"x": {Foo: "d"}}, m.InnerThingMap)
+
+	// Elements of maps are updated in place
+	err = tm.SetByPointer(EmptyContext, m, "/inner_thing_map/x/an_int", []byte(`2`))
+	require.NoError(t, err)
+	require.Equal(t, map[string]InnerThing{"x": {Foo: "d", AnInt: 2}}, m.InnerThingMap)
+
+	_, err = tm.GetByPointer(m, "/inner_thing_map/y")
+	require.EqualError(t, err, "no value at /inner_thing_map/y")
+
+	err = tm.SetByPointer(EmptyContext, &ReadOnlyThing{}, "/primary_key", []byte(`"k"`))
+	require.EqualError(t, err, "Validation Errors: \n/primary_key: cannot set read-only field\n")
+
+	_, err = tm.GetByPointer(v, "inner_thing")
+	require.Error(t, err)
+}
+
+type Account struct {
+	Name     string
+	Password string
+	APIKey   string
+	Owner    InnerThing
+}
+
+func TestMarshalForLogging(t *testing.T) {
+	accountTypeMap := StructMap{
+		UnderlyingType: Account{},
+		Fields: []MappedField{
+			{
+				StructFieldName: "Name",
+				JSONFieldName:   "name",
+				Validator:       String(1, 20),
+			},
+			{
+				StructFieldName: "Password",
+				JSONFieldName:   "password",
+				Validator:       String(1, 20),
+				Redact:          true,
+			},
+			{
+				StructFieldName: "APIKey",
+				JSONFieldName:   "api_key",
+				Validator:       String(1, 20),
+				OmitEmpty:       true,
+				Redact:          true,
+			},
+			{
+				StructFieldName: "Owner",
+				JSONFieldName:   "owner",
+				Contains: StructMap{
+					UnderlyingType: InnerThing{},
+					Fields: []MappedField{
+						{
+							StructFieldName: "Foo",
+							JSONFieldName:   "foo",
+							Validator:       String(1, 12),
+							Redact:          true,
+						},
+					},
+				},
+			},
+		},
+	}
+	tm := NewTypeMapper(accountTypeMap)
+
+	v := &Account{Name: "alice", Password: "open:sesame", Owner: InnerThing{Foo: "secret"}}
+
+	data, err := tm.MarshalForLogging(EmptyContext, v)
+	require.NoError(t, err)
+	require.Equal(t, `{"name":"alice","password":"[REDACTED]","owner":{"foo":"[REDACTED]"}}`, string(data))
+
+	data, err = tm.Marshal(EmptyContext, v)
+	require.NoError(t, err)
+	require.Equal(t, `{"name":"alice","password":"open:sesame","owner":{"foo":"secret"}}`, string(data))
+
+	v.APIKey = "key"
+	tm.LegacyMarshal = true
+	data, err = tm.MarshalForLogging(EmptyContext, v)
+	require.NoError(t, err)
+	require.Equal(t, `{"name":"alice","password":"[REDACTED]","api_key":"[REDACTED]","owner":{"foo":"[REDACTED]"}}`, string(data))
+
+	// Generated code leaves redacted fields to MarshalMappedField
+	buf := &bytes.Buffer{}
+	err = GenerateStatic(buf, "jsonmap", StaticTarget{"accountTypeMap", accountTypeMap})
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "MarshalMappedField(ctx, accountTypeMap, 1, ")
+	require.NotContains(t, buf.String(), "json.Marshal(v.Password)")
+}
+
+func TestMarshalForLoggingDynamicMap(t *testing.T) {
+	s, err := ParseSchema([]byte(`
+types:
+  Login:
+    fields:
+      - {name: user, type: string}
+      - {name: password, type: string, redact: true}
+`), nil)
+	require.NoError(t, err)
+
+	tm, err := s.TypeMapper("Login")
+	require.NoError(t, err)
+
+	v := map[string]interface{}{"user": "bob", "password": "hunter2"}
+
+	data, err := tm.MarshalForLogging(EmptyContext, v)
+	require.NoError(t, err)
+	require.Equal(t, `{"user":"bob","password":"[REDACTED]"}`, string(data))
+
+	tm.LegacyMarshal = true
+	data, err = tm.MarshalForLogging(EmptyContext, v)
+	require.NoError(t, err)
+	require.Equal(t, `{"user":"bob","password":"[REDACTED]"}`, string(data))
+
+	data, err = tm.Marshal(EmptyContext, v)
+	require.NoError(t, err)
+	require.Equal(t, `{"user":"bob","password":"hunter2"}`, string(data))
+}
+
+func TestCached(t *testing.T) {
+	cached := Cached(InnerThingTypeMap, func(ctx Context, src interface{}) (string, bool) {
+		v := src.(*InnerThing)
+		return v.Foo, v.Foo != ""
+	})
+	tm := NewTypeMapper(cached)
+
+	v := &InnerThing{Foo: "usd", AnInt: 2}
+	data, err := tm.Marshal(EmptyContext, v)
+	require.NoError(t, err)
+	require.Equal(t, `{"foo":"usd","an_int":2,"a_bool":false}`, string(data))
+
+	// The cached representation is used until it is invalidated
+	v.AnInt = 3
+	data, err = tm.Marshal(EmptyContext, v)
+	require.NoError(t, err)
+	require.Equal(t, `{"foo":"usd","an_int":2,"a_bool":false}`, string(data))
+
+	data, err = tm.Marshal(EmptyContext, []*InnerThing{v, v})
+	require.NoError(t, err)
+	require.Equal(t, `[{"foo":"usd","an_int":2,"a_bool":false},{"foo":"usd","an_int":2,"a_bool":false}]`, string(data))
+
+	// Other versions and projections are marshaled afresh
+	data, err = tm.Marshal(WithVersion(EmptyContext, 2), v)
+	require.NoError(t, err)
+	require.Equal(t, `{"foo":"usd","an_int":3,"a_bool":false}`, string(data))
+
+	data, err = tm.Marshal(WithFieldSet(EmptyContext, ParseFieldSet("an_int")), v)
+	require.NoError(t, err)
+	require.Equal(t, `{"an_int":3}`, string(data))
+
+	data, err = tm.Marshal(WithProtoJSON(EmptyContext), v)
+	require.NoError(t, err)
+	require.Equal(t, `{"foo":"usd","anInt":"3","aBool":false}`, string(data))
+
+	cached.Invalidate("usd")
+	data, err = tm.Marshal(EmptyContext, v)
+	require.NoError(t, err)
+	require.Equal(t, `{"foo":"usd","an_int":3,"a_bool":false}`, string(data))
+
+	v.AnInt = 4
+	tm.LegacyMarshal = true
+	data, err = tm.Marshal(EmptyContext, v)
+	require.NoError(t, err)
+	require.Equal(t, `{"foo":"usd","an_int":3,"a_bool":false}`, string(data))
+
+	cached.Purge()
+	data, err = tm.Marshal(EmptyContext, v)
+	require.NoError(t, err)
+	require.Equal(t, `{"foo":"usd","an_int":4,"a_bool":false}`, string(data))
+
+	// Values without a key aren't cached
+	u := &InnerThing{AnInt: 1}
+	_, err = tm.Marshal(EmptyContext, u)
+	require.NoError(t, err)
+	u.AnInt = 5
+	data, err = tm.Marshal(EmptyContext, u)
+	require.NoError(t, err)
+	require.Equal(t, `{"foo":"","an_int":5,"a_bool":false}`, string(data))
+
+	dst := &InnerThing{}
+	err = tm.Unmarshal(EmptyContext, []byte(`{"foo": "eur"}`), dst)
+	require.NoError(t, err)
+	require.Equal(t, &InnerThing{Foo: "eur"}, dst)
+}
+
+func TestSliceElementAllocation(t *testing.T) {
+	tm := NewTypeMapper(OuterSliceThingTypeMap, OuterPointerSliceThingTypeMap)
+
+	data := []byte(`{"inner_things": [{"foo": "a"}, null, {"foo": "b", "an_int": 2}, {"foo": "c"}]}`)
+	unmarshalers := map[string]func(dst interface{}) error{
+		"Unmarshal": func(dst interface{}) error {
+			return tm.Unmarshal(EmptyContext, data, dst)
+		},
+		"UnmarshalReader": func(dst interface{}) error {
+			return tm.UnmarshalReader(EmptyContext, bytes.NewReader(data), dst)
+		},
+	}
+
+	for name, unmarshal := range unmarshalers {
+		v := &OuterPointerSliceThing{}
+		err := unmarshal(v)
+		require.NoError(t, err, name)
+		require.Equal(t, []*InnerThing{{Foo: "a"}, nil, {Foo: "b", AnInt: 2}, {Foo: "c"}}, v.InnerThings, name)
+
+		// Elements share a backing slice but must not alias one another
+		v.InnerThings[0].Foo = "z"
+		require.Equal(t, "b", v.InnerThings[2].Foo, name)
+
+		err = unmarshal(&OuterSliceThing{})
+		require.EqualError(t, err, "Validation Errors: \n/inner_things/1: expected an object\n", name)
+	}
+
+	v := &OuterSliceThing{}
+	err := tm.UnmarshalReader(EmptyContext, strings.NewReader(`{"inner_things": []}`), v)
+	require.NoError(t, err)
+	require.Nil(t, v.InnerThings)
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"inner_things": [{"foo": "a"}, {"an_int": 11}, {"foo": "c"}]}`), v)
+	require.EqualError(t, err, "Validation Errors: \n/inner_things/1/an_int: too large, may not be larger than 10\n")
+}
+
+// newSliceBenchData returns a document holding a list of n InnerThings
+func newSliceBenchData(b *testing.B, n int) []byte {
+	v := &OuterSliceThing{InnerThings: make([]InnerThing, n)}
+	for i := range v.InnerThings {
+		v.InnerThings[i] = InnerThing{Foo: "foo", AnInt: int64(i % 10), ABool: i%2 == 0}
+	}
+
+	data, err := NewTypeMapper(OuterSliceThingTypeMap).Marshal(EmptyContext, v)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return data
+}
+
+var sliceBenchTypeMapper = NewTypeMapper(OuterSliceThingTypeMap, OuterPointerSliceThingTypeMap)
+
+func BenchmarkUnmarshalLargeSlice(b *testing.B) {
+	data := newSliceBenchData(b, 10000)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		err := sliceBenchTypeMapper.Unmarshal(EmptyContext, data, &OuterSliceThing{})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshalLargePointerSlice(b *testing.B) {
+	data := newSliceBenchData(b, 10000)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		err := sliceBenchTypeMapper.Unmarshal(EmptyContext, data, &OuterPointerSliceThing{})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshalReaderLargePointerSlice(b *testing.B) {
+	data := newSliceBenchData(b, 10000)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		err := sliceBenchTypeMapper.UnmarshalReader(EmptyContext, bytes.NewReader(data), &OuterPointerSliceThing{})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestUnmappedTypes(t *testing.T) {
+	tm := NewTypeMapper(OuterVariableThingTypeMap)
+
+	wrongType := &OuterVariableThing{InnerType: "foo", InnerValue: &OtherInnerThing{Bar: "x"}}
+	wrongIdentifier := &OuterVariableThing{InnerType: "wrong", InnerValue: &InnerThing{Foo: "test"}}
+
+	require.PanicsWithValue(t, "wrong type: jsonmap.OtherInnerThing, expected: jsonmap.InnerThing", func() {
+		tm.Marshal(EmptyContext, wrongType)
+	})
+
+	for _, legacy := range []bool{false, true} {
+		tm.LegacyMarshal = legacy
+
+		tm.UnmappedTypes = UnmappedTypesError
+		_, err := tm.Marshal(EmptyContext, wrongType)
+		require.EqualError(t, err, "wrong type: jsonmap.OtherInnerThing, expected: jsonmap.InnerThing")
+		var ute *UnmappedTypeError
+		require.True(t, errors.As(err, &ute))
+		require.Equal(t, reflect.TypeOf(InnerThing{}), ute.Expected)
+
+		_, err = tm.Marshal(EmptyContext, wrongIdentifier)
+		require.EqualError(t, err, "variable type serialization error: invalid type identifier: 'wrong'")
+
+		tm.UnmappedTypes = UnmappedTypesEncodingJSON
+		data, err := tm.Marshal(EmptyContext, wrongType)
+		require.NoError(t, err)
+		require.Equal(t, `{"inner_type":"foo","inner_thing":{"Bar":"x"}}`, string(data))
+
+		data, err = tm.Marshal(EmptyContext, wrongIdentifier)
+		require.NoError(t, err)
+		require.Equal(t, `{"inner_type":"wrong","inner_thing":{"Foo":"test","AnInt":0,"ABool":false}}`, string(data))
+	}
+
+	resources := NewTypeMapper(StructMap{
+		UnderlyingType: ThingWithMapOfInterfaces{},
+		Fields: []MappedField{
+			{
+				StructFieldName: "Interfaces",
+				JSONFieldName:   "resources",
+				Contains:        MapOf(JSONAPI("inner", InnerThingTypeMap)),
+			},
+		},
+	})
+	wrongResource := &ThingWithMapOfInterfaces{Interfaces: map[string]interface{}{"a": &OtherInnerThing{Bar: "x"}}}
+
+	require.PanicsWithValue(t, "wrong type: jsonmap.OtherInnerThing, expected: jsonmap.InnerThing", func() {
+		resources.Marshal(EmptyContext, wrongResource)
+	})
+
+	for _, legacy := range []bool{false, true} {
+		resources.LegacyMarshal = legacy
+
+		resources.UnmappedTypes = UnmappedTypesError
+		_, err := resources.Marshal(EmptyContext, wrongResource)
+		require.EqualError(t, err, "wrong type: jsonmap.OtherInnerThing, expected: jsonmap.InnerThing")
+
+		resources.UnmappedTypes = UnmappedTypesEncodingJSON
+		data, err := resources.Marshal(EmptyContext, wrongResource)
+		require.NoError(t, err)
+		require.Equal(t, `{"resources":{"a":{"Bar":"x"}}}`, string(data))
+	}
+}
+
+type NestedContainerThing struct {
+	SlicesByKey      map[string][]InnerThing
+	MapsInSlice      []map[string]*InnerThing
+	PointerToSlice   *[]InnerThing
+	PointerToMap     *map[string]InnerThing
+	SlicePointers    map[string]*[]InnerThing
+	NamedKeys        map[probeKey][][]InnerThing
+	Dynamic          interface{}
+	MapsInSliceByKey map[string][]map[string]InnerThing
+}
+
+type probeKey string
+
+var NestedContainerThingTypeMap = StructMap{
+	UnderlyingType: NestedContainerThing{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "SlicesByKey",
+			JSONFieldName:   "slices_by_key",
+			Contains:        MapOf(SliceOf(InnerThingTypeMap)),
+			Optional:        true,
+		},
+		{
+			StructFieldName: "MapsInSlice",
+			JSONFieldName:   "maps_in_slice",
+			Contains:        SliceOf(MapOf(InnerThingTypeMap)),
+			Optional:        true,
+		},
+		{
+			StructFieldName: "PointerToSlice",
+			JSONFieldName:   "pointer_to_slice",
+			Contains:        SliceOf(InnerThingTypeMap),
+			Optional:        true,
+		},
+		{
+			StructFieldName: "PointerToMap",
+			JSONFieldName:   "pointer_to_map",
+			Contains:        MapOf(InnerThingTypeMap),
+			Optional:        true,
+		},
+		{
+			StructFieldName: "SlicePointers",
+			JSONFieldName:   "slice_pointers",
+			Contains:        MapOf(SliceOf(InnerThingTypeMap)),
+			Optional:        true,
+		},
+		{
+			StructFieldName: "NamedKeys",
+			JSONFieldName:   "named_keys",
+			Contains:        MapOf(SliceOf(SliceOf(InnerThingTypeMap))),
+			Optional:        true,
+		},
+		{
+			StructFieldName: "Dynamic",
+			JSONFieldName:   "dynamic",
+			Contains:        MapOf(SliceOf(NewPrimitiveMap(Integer(0, 5)))),
+			Optional:        true,
+		},
+		{
+			StructFieldName: "MapsInSliceByKey",
+			JSONFieldName:   "maps_in_slice_by_key",
+			Contains:        MapOf(SliceOf(MapOf(InnerThingTypeMap))),
+			Optional:        true,
+		},
+	},
+}
+
+func TestNestedContainers(t *testing.T) {
+	tm := NewTypeMapper(NestedContainerThingTypeMap)
+	require.NoError(t, tm.Lint().Err())
+
+	data := []byte(`{` +
+		`"slices_by_key":{"a":[{"foo":"a0"},{"foo":"a1"}]},` +
+		`"maps_in_slice":[{"k":{"foo":"k"}},{"n":null}],` +
+		`"pointer_to_slice":[{"foo":"p"}],` +
+		`"pointer_to_map":{"m":{"foo":"m"}},` +
+		`"slice_pointers":{"s":[{"foo":"s"}],"t":null},` +
+		`"named_keys":{"x":[[{"foo":"x"}]]},` +
+		`"dynamic":{"d":[1,2]},` +
+		`"maps_in_slice_by_key":{"q":[{"r":{"foo":"r"}}]}}`)
+
+	expected := &NestedContainerThing{
+		SlicesByKey:      map[string][]InnerThing{"a": {{Foo: "a0"}, {Foo: "a1"}}},
+		MapsInSlice:      []map[string]*InnerThing{{"k": {Foo: "k"}}, {"n": nil}},
+		PointerToSlice:   &[]InnerThing{{Foo: "p"}},
+		PointerToMap:     &map[string]InnerThing{"m": {Foo: "m"}},
+		SlicePointers:    map[string]*[]InnerThing{"s": {{Foo: "s"}}, "t": nil},
+		NamedKeys:        map[probeKey][][]InnerThing{"x": {{{Foo: "x"}}}},
+		Dynamic:          map[string]interface{}{"d": []interface{}{int64(1), int64(2)}},
+		MapsInSliceByKey: map[string][]map[string]InnerThing{"q": {{"r": {Foo: "r"}}}},
+	}
+
+	v := &NestedContainerThing{}
+	err := tm.UnmarshalReader(EmptyContext, bytes.NewReader(data), v)
+	require.NoError(t, err)
+	require.Equal(t, expected, v)
+
+	partial := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(data, &partial))
+	v = &NestedContainerThing{}
+	err = NestedContainerThingTypeMap.Unmarshal(EmptyContext, nil, partial, reflect.ValueOf(v).Elem())
+	require.NoError(t, err)
+	require.Equal(t, expected, v)
+
+	out, err := tm.Marshal(EmptyContext, &NestedContainerThing{})
+	require.NoError(t, err)
+	require.Equal(t, `{"slices_by_key":null,"maps_in_slice":null,"pointer_to_slice":null,"pointer_to_map":null,`+
+		`"slice_pointers":null,"named_keys":null,"dynamic":null,"maps_in_slice_by_key":null}`, string(out))
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{`+
+		`"slices_by_key":{"a":[{"foo":"a0"},{"an_int":11}]},`+
+		`"maps_in_slice":[{"k":{"foo":""}}],`+
+		`"pointer_to_map":{"m":[]},`+
+		`"named_keys":{"x":[[{"foo":"x"}],{}]},`+
+		`"dynamic":{"d":[1,9]},`+
+		`"maps_in_slice_by_key":{"q":[{"r":{"a_bool":1}}]}}`), &NestedContainerThing{})
+	require.EqualError(t, err, "Validation Errors: \n"+
+		"/slices_by_key/a/1/an_int: too large, may not be larger than 10\n"+
+		"/maps_in_slice/0/k/foo: too short, must be at least 1 characters\n"+
+		"/pointer_to_map/m: expected an object\n"+
+		"/named_keys/x/1: expected a list\n"+
+		"/dynamic/d/1: too large, may not be larger than 5\n"+
+		"/maps_in_slice_by_key/q/0/r/a_bool: not a boolean\n")
+
+	for pointer, value := range map[string]interface{}{
+		"/slices_by_key/a/1/foo":          "a1",
+		"/maps_in_slice/0/k/foo":          "k",
+		"/pointer_to_slice/0/foo":         "p",
+		"/pointer_to_map/m/foo":           "m",
+		"/slice_pointers/s/0/foo":         "s",
+		"/named_keys/x/0/0/foo":           "x",
+		"/dynamic/d/1":                    int64(2),
+		"/maps_in_slice_by_key/q/0/r/foo": "r",
+	} {
+		got, err := tm.GetByPointer(v, pointer)
+		require.NoError(t, err, pointer)
+		require.Equal(t, value, got, pointer)
+	}
+
+	err = tm.SetByPointer(EmptyContext, v, "/maps_in_slice_by_key/q/0/r/an_int", []byte(`12`))
+	require.EqualError(t, err, "Validation Errors: \n/maps_in_slice_by_key/q/0/r/an_int: too large, may not be larger than 10\n")
+
+	err = tm.SetByPointer(EmptyContext, v, "/maps_in_slice_by_key/q/0/r/an_int", []byte(`2`))
+	require.NoError(t, err)
+	require.Equal(t, int64(2), v.MapsInSliceByKey["q"][0]["r"].AnInt)
+}
+
+func TestMapOfKeyOrder(t *testing.T) {
+	expected := "Validation Errors: \n" +
+		"/strings/a: too long, may not be more than 5 characters\n" +
+		"/strings/c: too long, may not be more than 5 characters\n" +
+		"/strings/e: too long, may not be more than 5 characters\n" +
+		"/strings/g: too long, may not be more than 5 characters\n"
+	original := `{"strings":{"g":"tooooolong","e":"tooooolong","c":"tooooolong","a":"tooooolong","b":"ok"}}`
+
+	tm := NewTypeMapper(ThingWithMapOfStringsTypeMap)
+	thing := ThingWithMapOfStrings{Strings: map[string]string{}}
+	for _, key := range []string{"g", "f", "e", "d", "c", "b", "a"} {
+		thing.Strings[key] = "tooooolong"
+	}
+
+	// Go randomizes the order of iteration over maps, so repeat each
+	// operation to give any dependence on it a chance to show
+	for i := 0; i < 20; i++ {
+		v := &ThingWithMapOfStrings{}
+		err := tm.Unmarshal(EmptyContext, []byte(original), v)
+		require.EqualError(t, err, expected)
+
+		var partial interface{}
+		require.NoError(t, json.Unmarshal([]byte(original), &partial))
+		err = ThingWithMapOfStringsTypeMap.Unmarshal(EmptyContext, nil, partial, reflect.ValueOf(v).Elem())
+		require.EqualError(t, err.(*ValidationError).Flatten(), expected)
+
+		tm.ValidateOnMarshal = true
+		for _, legacy := range []bool{false, true} {
+			tm.LegacyMarshal = legacy
+			_, err = tm.Marshal(EmptyContext, thing)
+			require.EqualError(t, err, "Validation Errors: \n/strings/a: too long, may not be more than 5 characters\n")
+		}
+
+		tm.ValidateOnMarshal = false
+		for _, legacy := range []bool{false, true} {
+			tm.LegacyMarshal = legacy
+			data, err := tm.Marshal(EmptyContext, thing)
+			require.NoError(t, err)
+			require.Equal(t, `{"strings":{"a":"tooooolong","b":"tooooolong","c":"tooooolong","d":"tooooolong","e":"tooooolong","f":"tooooolong","g":"tooooolong"}}`, string(data))
+		}
+	}
+}
+
+type localizedThing struct {
+	Count    int
+	Ratio    float64
+	Size     uint64
+	At       time.Time
+	Due      CivilDate
+	Comments string
+}
+
+var localizedThingTypeMap = StructMap{
+	UnderlyingType: localizedThing{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "Count",
+			JSONFieldName:   "count",
+			Contains:        NewPrimitiveMap(Integer(0, 1000000)),
+			Optional:        true,
+		},
+		{
+			StructFieldName: "Ratio",
+			JSONFieldName:   "ratio",
+			Contains:        NewPrimitiveMap(Float(-10, 10)),
+			Optional:        true,
+		},
+		{
+			StructFieldName: "Size",
+			JSONFieldName:   "size",
+			Contains:        NewPrimitiveMap(LossyUint64()),
+			Optional:        true,
+		},
+		{
+			StructFieldName: "At",
+			JSONFieldName:   "at",
+			Contains:        Time(),
+			Optional:        true,
+		},
+		{
+			StructFieldName: "Due",
+			JSONFieldName:   "due",
+			Contains:        Date(),
+			Optional:        true,
+		},
+		{
+			StructFieldName: "Comments",
+			JSONFieldName:   "comments",
+			Validator:       String(0, 100),
+			Optional:        true,
+		},
+	},
+}
+
+func TestWithLocale(t *testing.T) {
+	tm := NewTypeMapper(localizedThingTypeMap)
+	doc := []byte(`{"count": "1.000", "ratio": "-1,5", "size": "12.345.678", "at": "9.6.2015 13:45", "due": "29.2.2020", "comments": "1,5"}`)
+
+	v := &localizedThing{}
+	err := tm.Unmarshal(EmptyContext, doc, v)
+	require.EqualError(t, err, "Validation Errors: \n"+
+		"/count: not an integer\n"+
+		"/ratio: not a number\n"+
+		"/size: not an integer\n"+
+		"/at: not a valid RFC 3339 time value\n"+
+		"/due: not a valid date, expected YYYY-MM-DD\n")
+
+	for _, tag := range []string{"de", "de-AT", "DE-de"} {
+		v = &localizedThing{}
+		err = tm.Unmarshal(WithLocale(EmptyContext, tag), doc, v)
+		require.NoError(t, err)
+		require.Equal(t, localizedThing{
+			Count:    1000,
+			Ratio:    -1.5,
+			Size:     12345678,
+			At:       time.Date(2015, 6, 9, 13, 45, 0, 0, time.UTC),
+			Due:      CivilDate{2020, time.February, 29},
+			Comments: "1,5",
+		}, *v)
+
+		data, err := tm.Marshal(EmptyContext, v)
+		require.NoError(t, err)
+		require.Equal(t, `{"count":1000,"ratio":-1.5,"size":12345678,"at":"2015-06-09T13:45:00Z","due":"2020-02-29","comments":"1,5"}`, string(data))
+	}
+
+	// Canonical values are still accepted
+	v = &localizedThing{}
+	err = tm.Unmarshal(WithLocale(EmptyContext, "de"), []byte(`{"count": 3, "ratio": 0.25, "at": "2015-06-09T13:45:00+02:00", "due": "2020-02-29"}`), v)
+	require.NoError(t, err)
+	require.Equal(t, 3, v.Count)
+	require.Equal(t, 0.25, v.Ratio)
+	require.Equal(t, time.Date(2015, 6, 9, 11, 45, 0, 0, time.UTC), v.At.UTC())
+	require.Equal(t, CivilDate{2020, time.February, 29}, v.Due)
+
+	v = &localizedThing{}
+	err = tm.Unmarshal(WithLocale(EmptyContext, "en-US"), []byte(`{"count": "12,000", "ratio": "2.5", "at": "2015-06-09 13:45:00", "due": "2/29/2020"}`), v)
+	require.NoError(t, err)
+	require.Equal(t, 12000, v.Count)
+	require.Equal(t, 2.5, v.Ratio)
+	require.Equal(t, time.Date(2015, 6, 9, 13, 45, 0, 0, time.UTC), v.At)
+	require.Equal(t, CivilDate{2020, time.February, 29}, v.Due)
+
+	v = &localizedThing{}
+	err = tm.Unmarshal(WithLocale(EmptyContext, "fr"), []byte(`{"count": "12 000", "ratio": "2,5"}`), v)
+	require.NoError(t, err)
+	require.Equal(t, 12000, v.Count)
+	require.Equal(t, 2.5, v.Ratio)
+
+	// Misplaced group separators, values out of range and unknown locales
+	// are still rejected
+	err = tm.Unmarshal(WithLocale(EmptyContext, "de"), []byte(`{"count": "1.5", "ratio": "12,5", "due": "2/29/2020"}`), &localizedThing{})
+	require.EqualError(t, err, "Validation Errors: \n"+
+		"/count: not an integer\n"+
+		"/ratio: too large, may not be larger than 10\n"+
+		"/due: not a valid date, expected YYYY-MM-DD\n")
+
+	err = tm.Unmarshal(WithLocale(EmptyContext, "xx"), []byte(`{"ratio": "1,5"}`), &localizedThing{})
+	require.EqualError(t, err, "Validation Errors: \n/ratio: not a number\n")
+
+	RegisterLocales(map[string]Locale{"xx": {DecimalSeparator: ","}})
+	defer delete(locales, "xx")
+
+	v = &localizedThing{}
+	err = tm.Unmarshal(WithLocale(EmptyContext, "xx"), []byte(`{"ratio": "1,5"}`), v)
+	require.NoError(t, err)
+	require.Equal(t, 1.5, v.Ratio)
+}
+
+func TestDescribeValidator(t *testing.T) {
+	f := func(f float64) *float64 { return &f }
+
+	c, ok := DescribeValidator(String(1, 10).Regex(regexp.MustCompile(`^[a-z]+$`)))
+	require.True(t, ok)
+	require.Equal(t, Constraint{Kind: ConstraintString, Min: f(1), Max: f(10), Pattern: `^[a-z]+$`}, c)
+
+	c, ok = DescribeValidator(Integer(-5, 5))
+	require.True(t, ok)
+	require.Equal(t, Constraint{Kind: ConstraintInteger, Min: f(-5), Max: f(5)}, c)
+
+	c, ok = DescribeValidator(Float(0, 1.5))
+	require.True(t, ok)
+	require.Equal(t, Constraint{Kind: ConstraintNumber, Min: f(0), Max: f(1.5)}, c)
+
+	c, ok = DescribeValidator(UUIDString())
+	require.True(t, ok)
+	require.Equal(t, Constraint{Kind: ConstraintString, Format: "uuid"}, c)
+
+	c, ok = DescribeValidator(BooleanStrings([]string{"yes"}, []string{"no"}))
+	require.True(t, ok)
+	require.Equal(t, Constraint{Kind: ConstraintBoolean}, c)
+
+	c, ok = DescribeValidator(Interface())
+	require.True(t, ok)
+	require.Equal(t, Constraint{Kind: ConstraintAny}, c)
+
+	c, ok = DescribeValidator(OneOf("a", "b"))
+	require.True(t, ok)
+	require.Equal(t, Constraint{Kind: ConstraintString, Enum: []interface{}{"a", "b"}}, c)
+
+	c, ok = DescribeValidator(OneOfValues(1, 2))
+	require.True(t, ok)
+	require.Equal(t, Constraint{Kind: ConstraintInteger, Enum: []interface{}{1, 2}}, c)
+
+	c, ok = DescribeValidator(OneOfValues(1, "two"))
+	require.True(t, ok)
+	require.Equal(t, ConstraintAny, c.Kind)
+
+	c, ok = DescribeValidator(AllOf(String(0, 10), String(2, 20), OneOf("ab", "cd"), ExistsIn(nil)))
+	require.True(t, ok)
+	require.Equal(t, Constraint{Kind: ConstraintString, Min: f(2), Max: f(10), Enum: []interface{}{"ab", "cd"}}, c)
+
+	_, ok = DescribeValidator(ExistsIn(nil))
+	require.False(t, ok)
+}
+
+type floatThing struct {
+	Ratio float32
+	Score float64
+}
+
+var floatThingTypeMap = StructMap{
+	UnderlyingType: floatThing{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "Ratio",
+			JSONFieldName:   "ratio",
+			Contains:        NewPrimitiveMap(Float(0, 1)),
+		},
+		{
+			StructFieldName: "Score",
+			JSONFieldName:   "score",
+			Contains:        NewPrimitiveMap(Float(-2.5, 1000000)),
+		},
+	},
+}
+
+func TestFloat(t *testing.T) {
+	tm := NewTypeMapper(floatThingTypeMap)
+	require.NoError(t, tm.Lint().Err())
+
+	v := &floatThing{}
+	err := tm.Unmarshal(EmptyContext, []byte(`{"ratio": 0.25, "score": -2.5}`), v)
+	require.NoError(t, err)
+	require.Equal(t, floatThing{Ratio: 0.25, Score: -2.5}, *v)
+
+	data, err := tm.Marshal(EmptyContext, v)
+	require.NoError(t, err)
+	require.Equal(t, `{"ratio":0.25,"score":-2.5}`, string(data))
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"ratio": 1, "score": 1000000}`), v)
+	require.NoError(t, err)
+	require.Equal(t, floatThing{Ratio: 1, Score: 1000000}, *v)
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"ratio": 1.5, "score": -3}`), v)
+	require.EqualError(t, err, "Validation Errors: \n"+
+		"/ratio: too large, may not be larger than 1\n"+
+		"/score: too small, must be at least -2.5\n")
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"ratio": "0.5", "score": 1000000.5}`), v)
+	require.EqualError(t, err, "Validation Errors: \n"+
+		"/ratio: not a number\n"+
+		"/score: too large, may not be larger than 1000000\n")
+}
+
+// TestConcurrentUse shares a TypeMapper, and the TypeMaps within it, between
+// goroutines. It is intended to be run with the race detector:
+//
+//	go test -race -run TestConcurrentUse
+func TestConcurrentUse(t *testing.T) {
+	cached := Cached(InnerThingTypeMap, func(ctx Context, src interface{}) (string, bool) {
+		v := src.(*InnerThing)
+		return v.Foo, v.Foo != ""
+	})
+	tm := NewTypeMapper(OuterSliceThingTypeMap, ThingWithMapOfStringsTypeMap, localizedThingTypeMap, cached)
+	r := NewRegistry(tm)
+
+	doc := `{"inner_things":[{"foo":"a","an_int":1,"a_bool":true},{"foo":"b","an_int":2,"a_bool":false}]}`
+	expected := OuterSliceThing{InnerThings: []InnerThing{{"a", 1, true}, {"b", 2, false}}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 50; j++ {
+				v := &OuterSliceThing{}
+				if err := tm.Unmarshal(EmptyContext, []byte(doc), v); err != nil || !reflect.DeepEqual(expected, *v) {
+					t.Errorf("unexpected result of Unmarshal: %v, %v", err, v)
+					return
+				}
+
+				data, err := tm.Marshal(WithFieldSet(EmptyContext, ParseFieldSet("inner_things.foo")), v)
+				if err != nil || string(data) != `{"inner_things":[{"foo":"a"},{"foo":"b"}]}` {
+					t.Errorf("unexpected result of Marshal: %v, %s", err, data)
+					return
+				}
+
+				// Every goroutine shares the same cache entries
+				key := strconv.Itoa(j % 5)
+				data, err = tm.Marshal(EmptyContext, &InnerThing{Foo: key, AnInt: int64(j % 5)})
+				if err != nil || string(data) != `{"foo":"`+key+`","an_int":`+key+`,"a_bool":false}` {
+					t.Errorf("unexpected result of cached Marshal: %v, %s", err, data)
+					return
+				}
+				if j%10 == i {
+					cached.Invalidate(key)
+				}
+
+				err = tm.Unmarshal(EmptyContext, []byte(`{"strings":{"b":"too long","a":"too long"}}`), &ThingWithMapOfStrings{})
+				if err == nil || err.Error() != "Validation Errors: \n/strings/a: too long, may not be more than 5 characters\n/strings/b: too long, may not be more than 5 characters\n" {
+					t.Errorf("unexpected error from Unmarshal: %v", err)
+					return
+				}
+
+				lv := &localizedThing{}
+				err = tm.Unmarshal(WithLocale(EmptyContext, "de"), []byte(`{"ratio": "1,5"}`), lv)
+				if err != nil || lv.Ratio != 1.5 {
+					t.Errorf("unexpected result of localized Unmarshal: %v, %v", err, lv)
+					return
+				}
+
+				name := strconv.Itoa(i)
+				if j == 0 {
+					r.Override(name, OuterSliceThingTypeMap)
+				}
+				if err := r.Get(name).Unmarshal(EmptyContext, []byte(doc), &OuterSliceThing{}); err != nil {
+					t.Errorf("unexpected error from overridden TypeMapper: %v", err)
+					return
+				}
+
+				// Registering is safe while the registries are in use
+				if j == 0 {
+					RegisterLocales(map[string]Locale{"xx-" + name: {DecimalSeparator: ","}})
+					RegisterValidators(map[string]Validator{"test-concurrent-" + name: String(0, 5)})
+					RegisterSchemaTypes(map[string]SchemaTypeBuilder{"test-concurrent-" + name: func(map[string]interface{}) (TypeMap, error) {
+						return NewPrimitiveMap(String(0, 5)), nil
+					}})
+					RegisterTemplateFuncs(template.FuncMap{"concurrent" + name: strings.ToLower})
+				}
+				_, err = ParseSchema([]byte(`{"types": {"Dog": {"fields": [{"name": "name", "type": "string", "validators": ["test-slug"]}, {"name": "price", "type": "test-money", "options": {"currency": "EUR"}}]}}}`), nil)
+				if err != nil {
+					t.Errorf("unexpected error from ParseSchema: %v", err)
+					return
+				}
+				if _, err := ParseStringRenderer("{{shout .Value}}", nil); err != nil {
+					t.Errorf("unexpected error from ParseStringRenderer: %v", err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestOverrideCopiesOptions(t *testing.T) {
+	tm := NewTypeMapper(InnerThingTypeMap)
+	tm.FailFast = true
+	tm.EmptyNilMaps = true
+	tm.MergeMode = MergeReset
+	tm.ValidateOnMarshal = true
+	tm.UnmappedTypes = UnmappedTypesError
+	tm.LegacyMarshal = true
+
+	// Every option is set above, so that any which Override neglects to copy
+	// is reported
+	o := reflect.ValueOf(tm).Elem()
+	for i := 0; i < o.NumField(); i++ {
+		if o.Type().Field(i).IsExported() {
+			require.False(t, o.Field(i).IsZero(), o.Type().Field(i).Name)
+		}
+	}
+
+	overridden := tm.Override(AnotherInnerThingTypeMap)
+	overridden.typeMaps = tm.typeMaps
+	require.Equal(t, tm, overridden)
+}
+
+func TestMaxBytes(t *testing.T) {
+	v := AllOf(MaxBytes(8), String(0, 100))
+
+	val, err := v.Validate("12345678")
+	require.NoError(t, err)
+	require.Equal(t, "12345678", val)
+
+	_, err = v.Validate("123456789")
+	require.EqualError(t, err, "too large, may not be more than 8 bytes")
+
+	// Bytes are counted, not characters
+	_, err = v.Validate("ééééé")
+	require.EqualError(t, err, "too large, may not be more than 8 bytes")
+
+	// Other values are measured by their JSON encoding
+	for doc, ok := range map[string]bool{
+		`[1,2,3]`:         true,
+		`[1,2,3,4]`:       false,
+		`{"a":"b"}`:       false,
+		`{"a":1}`:         true,
+		`{}`:              true,
+		`[[],[],[]]`:      false,
+		`[true]`:          true,
+		`[null,null]`:     false,
+		`12345678`:        true,
+		`123456789`:       false,
+		`{"a":[1,[2,3]]}`: false,
+	} {
+		var partial interface{}
+		require.NoError(t, json.Unmarshal([]byte(doc), &partial))
+		_, err = MaxBytes(len(doc)).Validate(partial)
+		require.NoError(t, err, doc)
+		_, err = MaxBytes(8).Validate(partial)
+		require.Equal(t, ok, err == nil, doc)
+	}
+
+	tm := NewTypeMapper(StructMap{
+		UnderlyingType: InnerThing{},
+		Fields: []MappedField{
+			{
+				StructFieldName: "Foo",
+				JSONFieldName:   "foo",
+				Validator:       AllOf(MaxBytes(1024), String(0, math.MaxInt32)),
+			},
+		},
+	})
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"foo":"`+strings.Repeat("a", 1025)+`"}`), &InnerThing{})
+	require.EqualError(t, err, "Validation Errors: \n/foo: too large, may not be more than 1024 bytes\n")
+}
+
+func TestUUIDStringVersion(t *testing.T) {
+	v4 := "0b6a7e36-3f7c-4b1e-9a3b-2d5c6e7f8a9b"
+	v1 := "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+
+	for _, s := range []string{v4, v1, strings.ToUpper(v4)} {
+		val, err := UUIDString().Validate(s)
+		require.NoError(t, err)
+		require.Equal(t, s, val)
+	}
+
+	val, err := UUIDv4().Validate(strings.ToUpper(v4))
+	require.NoError(t, err)
+	require.Equal(t, strings.ToUpper(v4), val)
+
+	_, err = UUIDv4().Validate(v1)
+	require.EqualError(t, err, "not a valid version 4 UUID")
+
+	_, err = UUIDStringVersion(1).Validate(v1)
+	require.NoError(t, err)
+
+	_, err = UUIDv4().Validate("not-a-uuid")
+	require.EqualError(t, err, "not a valid UUID")
+
+	require.Panics(t, func() { UUIDStringVersion(9) })
+
+	tm := NewTypeMapper(StructMap{
+		UnderlyingType: InnerThing{},
+		Fields: []MappedField{
+			{
+				StructFieldName: "Foo",
+				JSONFieldName:   "foo",
+				Validator:       UUIDv4(),
+			},
+		},
+	})
+
+	v := &InnerThing{}
+	require.NoError(t, tm.Unmarshal(EmptyContext, []byte(`{"foo":"`+v4+`"}`), v))
+	require.Equal(t, v4, v.Foo)
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"foo":"nope"}`), v)
+	require.EqualError(t, err, "Validation Errors: \n/foo: not a valid UUID\n")
+}
+
+func TestURLString(t *testing.T) {
+	tm := NewTypeMapper(StructMap{
+		UnderlyingType: InnerThing{},
+		Fields: []MappedField{
+			{
+				StructFieldName: "Foo",
+				JSONFieldName:   "foo",
+				Validator:       URLString("https"),
+			},
+		},
+	})
+	require.NoError(t, tm.Lint().Err())
+
+	v := &InnerThing{}
+	err := tm.Unmarshal(EmptyContext, []byte(`{"foo": "HTTPS://Example.com/a?b=c"}`), v)
+	require.NoError(t, err)
+	require.Equal(t, "HTTPS://Example.com/a?b=c", v.Foo)
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"foo": "/relative"}`), v)
+	require.EqualError(t, err, "Validation Errors: \n/foo: not an absolute URL\n")
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"foo": "http://example.com"}`), v)
+	require.EqualError(t, err, "Validation Errors: \n/foo: URL scheme must be one of: https\n")
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"foo": 3}`), v)
+	require.EqualError(t, err, "Validation Errors: \n/foo: not a string\n")
+
+	val, err := URLString().Validate("ftp://example.com/file")
+	require.NoError(t, err)
+	require.Equal(t, "ftp://example.com/file", val)
+
+	_, err = (&URLStringValidator{Hosts: []string{"example.com"}}).Validate("https://evil.com")
+	require.EqualError(t, err, "URL host is not permitted")
+
+	c, ok := DescribeValidator(URLString())
+	require.True(t, ok)
+	require.Equal(t, Constraint{Kind: ConstraintString, Format: "uri"}, c)
+}
+
+type protoThing struct {
+	ID        int64
+	ViewCount uint64
+	Ratio     float64
+	Color     testColor
+	CreatedAt time.Time
+	Timeout   time.Duration
+	Inner     *InnerThing
+}
+
+var protoThingTypeMap = StructMap{
+	UnderlyingType: protoThing{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "ID",
+			JSONFieldName:   "id",
+			Validator:       Integer(0, math.MaxInt64),
+		},
+		{
+			StructFieldName: "ViewCount",
+			JSONFieldName:   "view_count",
+			Validator:       LossyUint64(),
+			Optional:        true,
+		},
+		{
+			StructFieldName: "Ratio",
+			JSONFieldName:   "ratio",
+			Validator:       Float(0, 1),
+			Optional:        true,
+		},
+		{
+			StructFieldName: "Color",
+			JSONFieldName:   "color",
+			Contains: Enum(map[string]int{
+				"red":   int(testColorRed),
+				"green": int(testColorGreen),
+				"blue":  int(testColorBlue),
+			}),
+			Optional: true,
+		},
+		{
+			StructFieldName: "CreatedAt",
+			JSONFieldName:   "created_at",
+			Contains:        Time(),
+			Optional:        true,
+		},
+		{
+			StructFieldName: "Timeout",
+			JSONFieldName:   "timeout",
+			Contains:        Duration(DurationString),
+			Optional:        true,
+		},
+		{
+			StructFieldName: "Inner",
+			JSONFieldName:   "inner_thing",
+			Contains:        InnerThingTypeMap,
+			Optional:        true,
+		},
+	},
+}
+
+func TestWithProtoJSON(t *testing.T) {
+	v := protoThing{
+		ID:        math.MaxInt64,
+		ViewCount: 12,
+		Ratio:     0.5,
+		Color:     testColorBlue,
+		CreatedAt: time.Date(2015, 6, 9, 13, 45, 0, 120000000, time.FixedZone("CEST", 2*60*60)),
+		Timeout:   -1500 * time.Millisecond,
+		Inner:     &InnerThing{Foo: "bar"},
+	}
+
+	for _, legacy := range []bool{false, true} {
+		tm := NewTypeMapper(protoThingTypeMap, InnerThingTypeMap)
+		tm.LegacyMarshal = legacy
+
+		data, err := tm.Marshal(WithProtoJSON(EmptyContext), &v)
+		require.NoError(t, err)
+		require.Equal(t, `{"id":"9223372036854775807","viewCount":"12","ratio":0.5,"color":"blue","createdAt":"2015-06-09T11:45:00.120Z","timeout":"-1.500s","innerThing":{"foo":"bar","anInt":"0","aBool":false}}`, string(data))
+
+		data, err = tm.Marshal(EmptyContext, &v)
+		require.NoError(t, err)
+		require.Equal(t, `{"id":9223372036854775807,"view_count":12,"ratio":0.5,"color":"blue","created_at":"2015-06-09T13:45:00.12+02:00","timeout":"-1.5s","inner_thing":{"foo":"bar","an_int":0,"a_bool":false}}`, string(data))
+	}
+
+	tm := NewTypeMapper(protoThingTypeMap, InnerThingTypeMap)
+	doc := `{"id": "9223372036854775807", "viewCount": "12", "ratio": "0.5", "color": 2, "createdAt": "2015-06-09T11:45:00.120Z", "timeout": "-1.500s", "inner_thing": {"foo": "bar", "anInt": "0"}}`
+
+	for _, stream := range []bool{false, true} {
+		u := &protoThing{}
+		var err error
+		if stream {
+			err = tm.UnmarshalReader(WithProtoJSON(EmptyContext), strings.NewReader(doc), u)
+		} else {
+			err = tm.Unmarshal(WithProtoJSON(EmptyContext), []byte(doc), u)
+		}
+		require.NoError(t, err)
+		require.True(t, v.CreatedAt.Equal(u.CreatedAt))
+		u.CreatedAt = v.CreatedAt
+		require.Equal(t, v, *u)
+
+		// Errors are attributed to fields by their lowerCamelCase names
+		if stream {
+			err = tm.UnmarshalReader(WithProtoJSON(EmptyContext), strings.NewReader(`{"view_count": "-1", "color": 7}`), &protoThing{})
+		} else {
+			err = tm.Unmarshal(WithProtoJSON(EmptyContext), []byte(`{"view_count": "-1", "color": 7}`), &protoThing{})
+		}
+		require.EqualError(t, err, "Validation Errors: \n"+
+			"/id: missing required field\n"+
+			"/viewCount: not an integer\n"+
+			"/color: not a string\n")
+	}
+
+	// Outside of ProtoJSON mode, names and values are unchanged
+	err := tm.Unmarshal(EmptyContext, []byte(`{"id": "1", "viewCount": 12, "color": 2}`), &protoThing{})
+	require.EqualError(t, err, "Validation Errors: \n"+
+		"/id: not an integer\n"+
+		"/color: not a string\n")
+
+	require.Equal(t, "innerThing", lowerCamel("inner_thing"))
+	require.Equal(t, "fooBar2", lowerCamel("foo_bar_2"))
+	require.Equal(t, "alreadyCamel", lowerCamel("alreadyCamel"))
+	require.Equal(t, "5s", protoDuration(5*time.Second))
+	require.Equal(t, "0.000001s", protoDuration(time.Microsecond))
+	require.Equal(t, "1970-01-01T00:00:00.000000001Z", protoTimestamp(time.Unix(0, 1)))
+}
+
+type graphQLThing struct {
+	ID        string
+	Name      string
+	Color     testColor
+	Size      string
+	Count     int64
+	Ratio     float64
+	Tags      []string
+	Inner     *InnerThing
+	CreatedAt time.Time
+	Extra     map[string]interface{}
+}
+
+var graphQLThingTypeMap = StructMap{
+	UnderlyingType: graphQLThing{},
+	Fields: []MappedField{
+		{
+			StructFieldName: "ID",
+			JSONFieldName:   "id",
+			Validator:       UUIDString(),
+			ReadOnly:        true,
+		},
+		{
+			StructFieldName: "Name",
+			JSONFieldName:   "name",
+			Validator:       String(1, 20),
+		},
+		{
+			StructFieldName: "Color",
+			JSONFieldName:   "color",
+			Contains: Enum(map[string]int{
+				"red":   int(testColorRed),
+				"green": int(testColorGreen),
+				"blue":  int(testColorBlue),
+			}),
+			Optional: true,
+		},
+		{
+			StructFieldName: "Size",
+			JSONFieldName:   "size",
+			Validator:       OneOf("small", "x-large"),
+			Optional:        true,
+		},
+		{
+			StructFieldName: "Count",
+			JSONFieldName:   "count",
+			Validator:       Integer(0, 100),
+		},
+		{
+			StructFieldName: "Ratio",
+			JSONFieldName:   "ratio",
+			Validator:       Float(0, 1),
+			Optional:        true,
+			OmitEmpty:       true,
+		},
+		{
+			StructFieldName: "Tags",
+			JSONFieldName:   "tags",
+			Contains:        SliceOf(NewPrimitiveMap(String(1, 10))),
+			Optional:        true,
+		},
+		{
+			StructFieldName: "Inner",
+			JSONFieldName:   "inner_thing",
+			Contains:        InnerThingTypeMap,
+			Optional:        true,
+		},
+		{
+			StructFieldName: "CreatedAt",
+			JSONFieldName:   "created_at",
+			Contains:        Time(),
+			ReadOnly:        true,
+		},
+		{
+			StructFieldName: "Extra",
+			JSONFieldName:   "extra",
+			Contains:        MapOf(NewPrimitiveMap(Interface())),
+			Optional:        true,
+		},
+	},
+}
+
+func TestGenerateGraphQL(t *testing.T) {
+	tm := NewTypeMapper(graphQLThingTypeMap)
+
+	buf := &bytes.Buffer{}
+	err := tm.GenerateGraphQL(buf)
+	require.NoError(t, err)
+	require.Equal(t, `scalar JSON
+
+type InnerThing {
+  foo: String!
+  an_int: Int!
+  a_bool: Boolean!
+}
+
+input InnerThingInput {
+  foo: String
+  an_int: Int
+  a_bool: Boolean
+}
+
+type graphQLThing {
+  id: String!
+  name: String!
+  color: graphQLThingColor!
+  size: String!
+  count: Int!
+  ratio: Float
+  tags: [String]
+  inner_thing: InnerThing
+  created_at: String!
+  extra: JSON
+}
+
+input graphQLThingInput {
+  name: String!
+  color: graphQLThingColor
+  size: String
+  count: Int!
+  ratio: Float
+  tags: [String]
+  inner_thing: InnerThingInput
+  extra: JSON
+}
+
+enum graphQLThingColor {
+  red
+  green
+  blue
+}
+`, buf.String())
+
+	// Field names must be valid in GraphQL
+	err = NewTypeMapper(StructMap{
+		UnderlyingType: InnerThing{},
+		Fields: []MappedField{
+			{
+				StructFieldName: "Foo",
+				JSONFieldName:   "foo-bar",
+				Validator:       String(1, 12),
+			},
+		},
+	}).GenerateGraphQL(buf)
+	require.EqualError(t, err, `jsonmap: jsonmap.InnerThing: "foo-bar" is not a valid GraphQL field name`)
+}
+
+func TestUnmarshalGraphQLArgs(t *testing.T) {
+	tm := NewTypeMapper(graphQLThingTypeMap, InnerThingTypeMap)
+
+	v := &graphQLThing{}
+	err := tm.UnmarshalGraphQLArgs(EmptyContext, map[string]interface{}{
+		"name":        "thing",
+		"color":       "green",
+		"count":       int32(12),
+		"ratio":       float32(0.5),
+		"tags":        []interface{}{"a", "b"},
+		"inner_thing": map[string]interface{}{"an_int": 3},
+	}, v)
+	require.NoError(t, err)
+	require.Equal(t, &graphQLThing{
+		Name:  "thing",
+		Color: testColorGreen,
+		Count: 12,
+		Ratio: 0.5,
+		Tags:  []string{"a", "b"},
+		Inner: &InnerThing{AnInt: 3},
+	}, v)
+
+	err = tm.UnmarshalGraphQLArgs(EmptyContext, map[string]interface{}{
+		"name":  "",
+		"count": 101,
+	}, &graphQLThing{})
+	require.EqualError(t, err, "Validation Errors: \n"+
+		"/name: too short, must be at least 1 characters\n"+
+		"/count: too large, may not be larger than 100\n")
+
+	require.PanicsWithValue(t, "cannot unmarshal to non-pointer", func() {
+		tm.UnmarshalGraphQLArgs(EmptyContext, map[string]interface{}{}, nil)
+	})
+}
+
+func TestRegex(t *testing.T) {
+	tm := NewTypeMapper(StructMap{
+		UnderlyingType: InnerThing{},
+		Fields: []MappedField{
+			{
+				StructFieldName: "Foo",
+				JSONFieldName:   "foo",
+				Validator:       Regex(regexp.MustCompile(`^[a-z]+(-[a-z]+)*$`)),
+			},
+		},
+	})
+
+	v := &InnerThing{}
+	err := tm.Unmarshal(EmptyContext, []byte(`{"foo": "a-rather-long-slug-which-is-not-limited-in-length"}`), v)
+	require.NoError(t, err)
+	require.Equal(t, "a-rather-long-slug-which-is-not-limited-in-length", v.Foo)
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"foo": "Not-A-Slug"}`), v)
+	require.EqualError(t, err, "Validation Errors: \n/foo: must match regular expression: ^[a-z]+(-[a-z]+)*$\n")
+
+	err = tm.Unmarshal(EmptyContext, []byte(`{"foo": 12}`), v)
+	require.EqualError(t, err, "Validation Errors: \n/foo: not a string\n")
+
+	re := regexp.MustCompile(`^[a-z]+$`)
+	_, err = Regex(re).RegexError(re, "must be a lowercase word").Validate("Word")
+	require.EqualError(t, err, "must be a lowercase word")
+
+	c, ok := DescribeValidator(Regex(re))
+	require.True(t, ok)
+	require.Equal(t, Constraint{Kind: ConstraintString, Min: bound(0), Pattern: `^[a-z]+$`}, c)
+}
+
+func TestUnmarshalMerged(t *testing.T) {
+	tm := NewTypeMapper(graphQLThingTypeMap, InnerThingTypeMap)
+
+	v := &graphQLThing{}
+	err := tm.UnmarshalMerged(EmptyContext, [][]byte{
+		[]byte(`{"name": "default", "count": 1, "tags": ["a", "b"], "inner_thing": {"foo": "bar", "an_int": 1}}`),
+		[]byte(`null`),
+		[]byte(`{"count": 5, "tags": ["c"], "inner_thing": {"an_int": 2}}`),
+		[]byte(`{"color": "blue", "inner_thing": {"a_bool": true}}`),
+	}, v)
+	require.NoError(t, err)
+	require.Equal(t, &graphQLThing{
+		Name:  "default",
+		Color: testColorBlue,
+		Count: 5,
+		Tags:  []string{"c"},
+		Inner: &InnerThing{Foo: "bar", AnInt: 2, ABool: true},
+	}, v)
+
+	// Only the merged document must be valid
+	err = tm.UnmarshalMerged(EmptyContext, [][]byte{
+		[]byte(`{"count": 500}`),
+		[]byte(`{"name": "override", "count": 50}`),
+	}, &graphQLThing{})
+	require.NoError(t, err)
+
+	err = tm.UnmarshalMerged(EmptyContext, [][]byte{
+		[]byte(`{"name": "default", "inner_thing": {"an_int": 1}}`),
+		[]byte(`{"inner_thing": null}`),
+	}, &graphQLThing{})
+	require.EqualError(t, err, "Validation Errors: \n/count: missing required field\n")
+
+	err = tm.UnmarshalMerged(EmptyContext, [][]byte{[]byte(`{"name": "default"}`), []byte(`[]`)}, &graphQLThing{})
+	require.EqualError(t, err, "json: cannot unmarshal, not an object")
+
+	err = tm.UnmarshalMerged(EmptyContext, nil, &graphQLThing{})
+	require.EqualError(t, err, "Validation Errors: \n"+
+		"/name: missing required field\n"+
+		"/count: missing required field\n")
+
+	require.PanicsWithValue(t, "cannot unmarshal to non-pointer", func() {
+		tm.UnmarshalMerged(EmptyContext, nil, nil)
+	})
+}
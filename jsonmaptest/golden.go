@@ -0,0 +1,79 @@
+package jsonmaptest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/russellhaering/jsonmap"
+	"github.com/stretchr/testify/require"
+)
+
+// UpdateGoldenEnv is the environment variable which, when set to a non-empty
+// value, causes golden files to be written rather than compared. A flag
+// isn't used as it would clash with any defined by the package under test.
+const UpdateGoldenEnv = "JSONMAP_UPDATE_GOLDEN"
+
+func updating() bool {
+	return os.Getenv(UpdateGoldenEnv) != ""
+}
+
+// GoldenDir is the directory in which golden files are kept.
+var GoldenDir = "testdata"
+
+// RequireGolden marshals v, indented, and requires that the output matches
+// the golden file GoldenDir/<name>.golden. When the test is run with
+// JSONMAP_UPDATE_GOLDEN set, the golden file is written instead.
+func RequireGolden(t testing.TB, tm *jsonmap.TypeMapper, ctx jsonmap.Context, name string, v interface{}) {
+	t.Helper()
+
+	data, err := tm.MarshalIndent(ctx, v, "", "  ")
+	require.NoError(t, err)
+	data = append(data, '\n')
+
+	path := filepath.Join(GoldenDir, name+".golden")
+
+	if updating() {
+		err = os.MkdirAll(GoldenDir, 0755)
+		require.NoError(t, err)
+		err = ioutil.WriteFile(path, data, 0644)
+		require.NoError(t, err)
+		return
+	}
+
+	expected, err := ioutil.ReadFile(path)
+	require.NoError(t, err, "run with "+UpdateGoldenEnv+"=1 to create golden files")
+	require.Equal(t, string(expected), string(data), "output differs from %s, run with %s=1 if this is expected", path, UpdateGoldenEnv)
+}
+
+// RequireGoldenAll calls RequireGolden for each of the given samples, naming
+// each golden file after the sample's type, and requires that there is a
+// sample of every type registered with tm, so that no type's output can
+// change unnoticed.
+func RequireGoldenAll(t testing.TB, tm *jsonmap.TypeMapper, ctx jsonmap.Context, samples ...interface{}) {
+	t.Helper()
+
+	sampled := map[reflect.Type]bool{}
+	for _, v := range samples {
+		typ := reflect.TypeOf(v)
+		if typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+		require.False(t, sampled[typ], "more than one sample of %s", typ)
+		sampled[typ] = true
+
+		RequireGolden(t, tm, ctx, typ.Name(), v)
+	}
+
+	var missing []string
+	for _, typ := range tm.RegisteredTypes() {
+		if !sampled[typ] {
+			missing = append(missing, typ.String())
+		}
+	}
+	sort.Strings(missing)
+	require.Empty(t, missing, "no sample of registered types")
+}
@@ -0,0 +1,112 @@
+// Package jsonmaptest provides helpers for testing TypeMaps.
+package jsonmaptest
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/russellhaering/jsonmap"
+	"github.com/stretchr/testify/require"
+)
+
+// RequireRoundTrip marshals v, which must be a pointer, unmarshals the result
+// into a fresh value of the same type, and requires that it equals v. Fields
+// which are ReadOnly, and so are never unmarshaled, are expected to be left
+// with their zero values, and empty slices are expected to become nil.
+func RequireRoundTrip(t testing.TB, tm *jsonmap.TypeMapper, ctx jsonmap.Context, v interface{}) {
+	t.Helper()
+
+	rv := reflect.ValueOf(v)
+	require.Equal(t, reflect.Ptr, rv.Kind(), "RequireRoundTrip requires a pointer")
+
+	m, ok := tm.TypeMap(v)
+	require.True(t, ok, "no TypeMap registered for %T", v)
+
+	data, err := tm.Marshal(ctx, v)
+	require.NoError(t, err)
+
+	result := reflect.New(rv.Type().Elem())
+	err = tm.Unmarshal(ctx, data, result.Interface())
+	require.NoError(t, err, "unmarshaling %s", data)
+
+	expected := withoutReadOnly(m, rv)
+	require.Equal(t, expected.Interface(), result.Interface(), "round trip through %s", data)
+}
+
+// withoutReadOnly returns a copy of v, which is mapped by m, in which every
+// ReadOnly field has been zeroed, and every empty slice made nil. v itself is
+// left untouched.
+func withoutReadOnly(m jsonmap.TypeMap, v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+
+		elem := withoutReadOnly(m, v.Elem())
+		if v.Kind() == reflect.Interface {
+			result := reflect.New(v.Type()).Elem()
+			result.Set(elem)
+			return result
+		}
+
+		ptr := reflect.New(elem.Type())
+		ptr.Elem().Set(elem)
+		return ptr
+	}
+
+	switch tm := m.(type) {
+	case *jsonmap.StructMap:
+		return withoutReadOnly(*tm, v)
+	case jsonmap.StructMap:
+		result := reflect.New(v.Type()).Elem()
+		result.Set(v)
+
+		for _, field := range tm.Fields {
+			if field.StructFieldName == "" {
+				continue
+			}
+
+			f := result.FieldByName(field.StructFieldName)
+			switch {
+			case field.ReadOnly:
+				f.Set(reflect.Zero(f.Type()))
+			case field.Contains != nil:
+				f.Set(withoutReadOnly(field.Contains, f))
+			}
+		}
+		return result
+	case *jsonmap.SliceMap:
+		return withoutReadOnly(*tm, v)
+	case jsonmap.SliceMap:
+		if v.Kind() != reflect.Slice {
+			return v
+		}
+
+		// Empty lists are unmarshaled as nil slices
+		if v.Len() == 0 {
+			return reflect.Zero(v.Type())
+		}
+
+		result := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			result.Index(i).Set(withoutReadOnly(tm.Contains, v.Index(i)))
+		}
+		return result
+	case *jsonmap.MapMap:
+		return withoutReadOnly(*tm, v)
+	case jsonmap.MapMap:
+		if v.Kind() != reflect.Map || v.IsNil() {
+			return v
+		}
+
+		result := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			result.SetMapIndex(iter.Key(), withoutReadOnly(tm.Contains, iter.Value()))
+		}
+		return result
+	default:
+		return v
+	}
+}
@@ -0,0 +1,220 @@
+package jsonmaptest
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/russellhaering/jsonmap"
+	"github.com/stretchr/testify/require"
+)
+
+type Toy struct {
+	Name string
+	ID   string
+}
+
+type Dog struct {
+	ID    string
+	Name  string
+	Toys  []*Toy
+	Best  *Toy
+	Flags map[string]Toy
+}
+
+var ToyTypeMap = jsonmap.StructMap{
+	UnderlyingType: Toy{},
+	Fields: []jsonmap.MappedField{
+		{
+			StructFieldName: "Name",
+			JSONFieldName:   "name",
+			Validator:       jsonmap.String(1, 20),
+		},
+		{
+			StructFieldName: "ID",
+			JSONFieldName:   "id",
+			ReadOnly:        true,
+		},
+	},
+}
+
+var DogTypeMap = jsonmap.StructMap{
+	UnderlyingType: Dog{},
+	Fields: []jsonmap.MappedField{
+		{
+			StructFieldName: "ID",
+			JSONFieldName:   "id",
+			ReadOnly:        true,
+		},
+		{
+			StructFieldName: "Name",
+			JSONFieldName:   "name",
+			Validator:       jsonmap.String(1, 20),
+		},
+		{
+			StructFieldName: "Toys",
+			JSONFieldName:   "toys",
+			Contains:        jsonmap.SliceOf(ToyTypeMap),
+		},
+		{
+			StructFieldName: "Best",
+			JSONFieldName:   "best",
+			Contains:        ToyTypeMap,
+		},
+		{
+			StructFieldName: "Flags",
+			JSONFieldName:   "flags",
+			Contains:        jsonmap.MapOf(ToyTypeMap),
+		},
+	},
+}
+
+var TestTypeMapper = jsonmap.NewTypeMapper(DogTypeMap)
+
+func TestRequireRoundTrip(t *testing.T) {
+	dog := &Dog{
+		ID:    "dog-1",
+		Name:  "Spot",
+		Toys:  []*Toy{{Name: "ball", ID: "toy-1"}, nil},
+		Best:  &Toy{Name: "bone", ID: "toy-2"},
+		Flags: map[string]Toy{"a": {Name: "rope", ID: "toy-3"}},
+	}
+
+	RequireRoundTrip(t, TestTypeMapper, jsonmap.EmptyContext, dog)
+
+	// The value passed in is left untouched
+	require.Equal(t, "dog-1", dog.ID)
+	require.Equal(t, "toy-1", dog.Toys[0].ID)
+	require.Equal(t, "toy-2", dog.Best.ID)
+	require.Equal(t, "toy-3", dog.Flags["a"].ID)
+
+	RequireRoundTrip(t, TestTypeMapper, jsonmap.EmptyContext, &Dog{Name: "Rex", Toys: []*Toy{}, Flags: map[string]Toy{}})
+
+	ft := &fakeT{}
+	func() {
+		defer func() { recover() }()
+		RequireRoundTrip(ft, TestTypeMapper, jsonmap.EmptyContext, &Dog{Toys: []*Toy{}, Flags: map[string]Toy{}})
+	}()
+	require.True(t, ft.Failed())
+}
+
+// fakeT records failures rather than failing the test it is used by.
+type fakeT struct {
+	testing.TB
+	failed bool
+}
+
+func (ft *fakeT) Helper() {}
+
+func (ft *fakeT) Name() string {
+	return "fake"
+}
+
+func (ft *fakeT) Errorf(format string, args ...interface{}) {
+	ft.failed = true
+}
+
+func (ft *fakeT) FailNow() {
+	panic("FailNow")
+}
+
+func (ft *fakeT) Failed() bool {
+	return ft.failed
+}
+
+func TestRequireGolden(t *testing.T) {
+	defer func(dir string) { GoldenDir = dir }(GoldenDir)
+	GoldenDir = t.TempDir()
+
+	dog := &Dog{ID: "dog-1", Name: "Spot", Best: &Toy{Name: "bone"}}
+
+	ft := &fakeT{}
+	func() {
+		defer func() { recover() }()
+		RequireGolden(ft, TestTypeMapper, jsonmap.EmptyContext, "Dog", dog)
+	}()
+	require.True(t, ft.Failed())
+
+	t.Run("update", func(t *testing.T) {
+		t.Setenv(UpdateGoldenEnv, "1")
+		RequireGoldenAll(t, TestTypeMapper, jsonmap.EmptyContext, dog)
+	})
+
+	data, err := ioutil.ReadFile(filepath.Join(GoldenDir, "Dog.golden"))
+	require.NoError(t, err)
+	require.Equal(t, `{
+  "id": "dog-1",
+  "name": "Spot",
+  "toys": null,
+  "best": {
+    "name": "bone",
+    "id": ""
+  },
+  "flags": null
+}
+`, string(data))
+
+	RequireGoldenAll(t, TestTypeMapper, jsonmap.EmptyContext, dog)
+
+	ft = &fakeT{}
+	func() {
+		defer func() { recover() }()
+		RequireGolden(ft, TestTypeMapper, jsonmap.EmptyContext, "Dog", &Dog{Name: "Rex"})
+	}()
+	require.True(t, ft.Failed())
+
+	// Every registered type must have a sample
+	ft = &fakeT{}
+	func() {
+		defer func() { recover() }()
+		RequireGoldenAll(ft, jsonmap.NewTypeMapper(DogTypeMap, ToyTypeMap), jsonmap.EmptyContext, dog)
+	}()
+	require.True(t, ft.Failed())
+}
+
+func TestRecorder(t *testing.T) {
+	rec := NewRecorder(jsonmap.NewTypeMapper(DogTypeMap, ToyTypeMap))
+	tm := rec.TypeMapper
+
+	for i := 0; i < 2; i++ {
+		dog := &Dog{}
+		err := tm.Unmarshal(jsonmap.EmptyContext, []byte(`{"name": "Spot", "best": {"name": "bone"}, "toys": [], "flags": {}}`), dog)
+		require.NoError(t, err)
+		require.Equal(t, "Spot", dog.Name)
+
+		dog.ID = "dog-1"
+		data, err := tm.Marshal(jsonmap.EmptyContext, dog)
+		require.NoError(t, err)
+		require.Equal(t, `{"id":"dog-1","name":"Spot","toys":null,"best":{"name":"bone","id":""},"flags":{}}`, string(data))
+	}
+
+	// Rejected requests aren't recorded
+	err := tm.Unmarshal(jsonmap.EmptyContext, []byte(`{"name": ""}`), &Dog{})
+	require.Error(t, err)
+
+	_, err = tm.Marshal(jsonmap.EmptyContext, []Toy{{Name: "ball", ID: "toy-1"}, {Name: "rope", ID: "toy-2"}})
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "contract.json")
+	require.NoError(t, rec.WriteContractFile(path))
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"types": [
+		{
+			"type": "jsonmaptest.Dog",
+			"requests": [{"best":{"name":"bone"},"flags":{},"name":"Spot","toys":[]}],
+			"responses": [{"id":"dog-1","name":"Spot","toys":null,"best":{"name":"bone","id":""},"flags":{}}]
+		},
+		{
+			"type": "jsonmaptest.Toy",
+			"requests": [],
+			"responses": [{"name":"ball","id":"toy-1"},{"name":"rope","id":"toy-2"}]
+		}
+	]}`, string(data))
+
+	rec.MaxExamples = 2
+	_, err = tm.Marshal(jsonmap.EmptyContext, &Toy{Name: "stick"})
+	require.NoError(t, err)
+	require.Len(t, rec.Contract().Types[1].Responses, 2)
+}
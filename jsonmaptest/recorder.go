@@ -0,0 +1,203 @@
+package jsonmaptest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/russellhaering/jsonmap"
+)
+
+// Recorder captures examples of the documents marshaled and unmarshaled by a
+// TypeMapper during integration tests, from which it produces a contract for
+// consumer-driven contract testing (in the style of Pact): for each
+// registered type, the requests which were accepted and the responses which
+// were produced. See NewRecorder.
+type Recorder struct {
+	// TypeMapper records the documents of the top level values it marshals
+	// and unmarshals, including the elements of top level slices and maps,
+	// and otherwise behaves as the TypeMapper it was created from. Nested
+	// values are recorded as part of their containers.
+	TypeMapper *jsonmap.TypeMapper
+
+	// MaxExamples limits the number of distinct requests and responses
+	// recorded for each type. Zero is unlimited.
+	MaxExamples int
+
+	mu       sync.Mutex
+	examples map[reflect.Type]*TypeExamples
+	seen     map[string]bool
+}
+
+// Contract is the artifact written by Recorder.WriteContract.
+type Contract struct {
+	Types []*TypeExamples `json:"types"`
+}
+
+// TypeExamples are the distinct documents recorded for a type, in the order
+// in which they were first seen.
+type TypeExamples struct {
+	Type string `json:"type"`
+
+	// Requests are documents which were unmarshaled without error
+	Requests []json.RawMessage `json:"requests"`
+
+	// Responses are documents which were marshaled
+	Responses []json.RawMessage `json:"responses"`
+}
+
+// NewRecorder returns a Recorder whose TypeMapper is a copy of tm, in which
+// the TypeMap registered for each type is wrapped so as to record the
+// documents it handles. The copy should be used in place of tm by the code
+// under test. Only Marshal, Unmarshal and the functions built upon them are
+// supported; others, such as GetByPointer, which inspect the TypeMaps
+// themselves, should continue to use tm.
+func NewRecorder(tm *jsonmap.TypeMapper) *Recorder {
+	r := &Recorder{
+		examples: map[reflect.Type]*TypeExamples{},
+		seen:     map[string]bool{},
+	}
+
+	var maps []jsonmap.RegisterableTypeMap
+	for _, t := range tm.RegisteredTypes() {
+		m, _ := tm.TypeMap(reflect.New(t).Interface())
+		maps = append(maps, &recordingMap{
+			TypeMap:  m,
+			t:        t,
+			recorder: r,
+		})
+	}
+
+	r.TypeMapper = tm.Override(maps...)
+	return r
+}
+
+// record adds data to the requests or responses of type t, unless it has
+// already been recorded.
+func (r *Recorder) record(t reflect.Type, data []byte, isRequest bool) {
+	buf := &bytes.Buffer{}
+	if err := json.Compact(buf, data); err != nil {
+		return
+	}
+
+	kind := "response"
+	if isRequest {
+		kind = "request"
+	}
+	key := t.String() + " " + kind + " " + buf.String()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.seen[key] {
+		return
+	}
+
+	e, ok := r.examples[t]
+	if !ok {
+		e = &TypeExamples{
+			Type:      t.String(),
+			Requests:  []json.RawMessage{},
+			Responses: []json.RawMessage{},
+		}
+		r.examples[t] = e
+	}
+
+	list := &e.Responses
+	if isRequest {
+		list = &e.Requests
+	}
+
+	if r.MaxExamples != 0 && len(*list) >= r.MaxExamples {
+		return
+	}
+
+	r.seen[key] = true
+	*list = append(*list, json.RawMessage(buf.Bytes()))
+}
+
+// Contract returns the examples recorded so far, ordered by the names of
+// their types. Types of which nothing has been recorded are left out.
+func (r *Recorder) Contract() *Contract {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c := &Contract{Types: []*TypeExamples{}}
+	for _, e := range r.examples {
+		c.Types = append(c.Types, &TypeExamples{
+			Type:      e.Type,
+			Requests:  append([]json.RawMessage{}, e.Requests...),
+			Responses: append([]json.RawMessage{}, e.Responses...),
+		})
+	}
+
+	sort.Slice(c.Types, func(i, j int) bool {
+		return c.Types[i].Type < c.Types[j].Type
+	})
+	return c
+}
+
+// WriteContract writes the contract, as indented JSON, to w.
+func (r *Recorder) WriteContract(w io.Writer) error {
+	data, err := json.MarshalIndent(r.Contract(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// WriteContractFile writes the contract to the file at path, typically from
+// TestMain once every test has run.
+func (r *Recorder) WriteContractFile(path string) error {
+	buf := &bytes.Buffer{}
+	if err := r.WriteContract(buf); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// recordingMap wraps the TypeMap registered for t, recording the documents
+// it handles with recorder.
+type recordingMap struct {
+	jsonmap.TypeMap
+	t        reflect.Type
+	recorder *Recorder
+}
+
+func (m *recordingMap) GetUnderlyingType() reflect.Type {
+	return m.t
+}
+
+func (m *recordingMap) Unmarshal(ctx jsonmap.Context, parent *reflect.Value, partial interface{}, dstValue reflect.Value) error {
+	err := m.TypeMap.Unmarshal(ctx, parent, partial, dstValue)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(partial)
+	if err == nil {
+		m.recorder.record(m.t, data, true)
+	}
+	return nil
+}
+
+func (m *recordingMap) Marshal(ctx jsonmap.Context, parent *reflect.Value, src reflect.Value) (json.Marshaler, error) {
+	result, err := m.TypeMap.Marshal(ctx, parent, src)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := result.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	m.recorder.record(m.t, data, false)
+	return jsonmap.RawMessage{Data: data}, nil
+}
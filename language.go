@@ -0,0 +1,127 @@
+package jsonmap
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// languageTag matches the syntax of language ranges in an Accept-Language
+// header, per RFC 4647.
+var languageTag = regexp.MustCompile(`^[A-Za-z]{1,8}(-[A-Za-z0-9]{1,8})*$`)
+
+// AcceptLanguageQueryParameterMapper maps an Accept-Language header to a
+// []string field holding the languages accepted by the client which are also
+// in Supported, most preferred first. A language which isn't supported
+// matches a supported base language, so that "en-GB" is accepted as "en" if
+// only the latter is supported. Tags are always given in the form in which
+// they appear in Supported.
+type AcceptLanguageQueryParameterMapper struct {
+	Supported []string
+}
+
+type weightedLanguage struct {
+	tag string
+	q   float64
+}
+
+func (alqpm AcceptLanguageQueryParameterMapper) Decode(src ...string) (interface{}, error) {
+	var accepted []weightedLanguage
+	for _, header := range src {
+		for _, r := range strings.Split(header, ",") {
+			r = strings.TrimSpace(r)
+			if r == "" {
+				continue
+			}
+
+			parts := strings.Split(r, ";")
+			tag := strings.TrimSpace(parts[0])
+			if tag != "*" && !languageTag.MatchString(tag) {
+				return nil, NewValidationError("invalid language range: %s", tag)
+			}
+
+			q := 1.0
+			for _, param := range parts[1:] {
+				kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+				if len(kv) != 2 || !strings.EqualFold(kv[0], "q") {
+					continue
+				}
+
+				var err error
+				q, err = strconv.ParseFloat(kv[1], 64)
+				if err != nil || q < 0 || q > 1 {
+					return nil, NewValidationError("invalid quality value: %s", kv[1])
+				}
+			}
+
+			accepted = append(accepted, weightedLanguage{tag, q})
+		}
+	}
+
+	// Ranges of equal quality keep the order in which they were given
+	sort.SliceStable(accepted, func(i, j int) bool {
+		return accepted[i].q > accepted[j].q
+	})
+
+	var langs []string
+	seen := map[string]bool{}
+	for _, l := range accepted {
+		if l.q == 0 {
+			continue
+		}
+
+		if tag, ok := alqpm.match(l.tag); ok && !seen[tag] {
+			seen[tag] = true
+			langs = append(langs, tag)
+		}
+	}
+
+	return langs, nil
+}
+
+// match finds the supported language matching tag, trying each of its
+// prefixes in turn.
+func (alqpm AcceptLanguageQueryParameterMapper) match(tag string) (string, bool) {
+	for {
+		for _, supported := range alqpm.Supported {
+			if strings.EqualFold(supported, tag) {
+				return supported, true
+			}
+		}
+
+		i := strings.LastIndex(tag, "-")
+		if i < 0 {
+			return "", false
+		}
+		tag = tag[:i]
+	}
+}
+
+// Encode lists the languages in order, giving each a quality value lower
+// than the last, down to a minimum of 0.1.
+func (alqpm AcceptLanguageQueryParameterMapper) Encode(src reflect.Value) ([]string, error) {
+	if src.Type() != reflect.TypeOf([]string(nil)) {
+		return nil, fmt.Errorf("expected []string but got: %s", src.Type())
+	}
+
+	if src.Len() == 0 {
+		return nil, nil
+	}
+
+	ranges := make([]string, src.Len())
+	for i := range ranges {
+		ranges[i] = src.Index(i).String()
+		if i > 0 {
+			q := 10 - i
+			if q < 1 {
+				q = 1
+			}
+			ranges[i] += fmt.Sprintf(";q=0.%d", q)
+		}
+	}
+
+	return []string{strings.Join(ranges, ", ")}, nil
+}
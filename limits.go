@@ -0,0 +1,182 @@
+package jsonmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+)
+
+// LimitedMap bounds the size of free-form values, such as those mapped by
+// Interface() or MapOf(), before passing them to the TypeMap it contains. See
+// Limited().
+type LimitedMap struct {
+	Contains TypeMap
+
+	// MaxDepth limits the nesting of objects and arrays, such that a depth of
+	// 1 allows an object or array holding only primitive values. Zero leaves
+	// the depth unlimited.
+	MaxDepth int
+
+	// MaxKeys limits the total number of object keys and array elements, at
+	// any depth. Zero leaves the number unlimited.
+	MaxKeys int
+}
+
+func (lm *LimitedMap) depthError() *ValidationError {
+	return NewValidationError("exceeds maximum depth of %d", lm.MaxDepth)
+}
+
+func (lm *LimitedMap) keysError() *ValidationError {
+	return NewValidationError("exceeds maximum of %d keys", lm.MaxKeys)
+}
+
+// check checks the already decoded value v, found at the given depth, adding
+// the number of keys it contains to keys.
+func (lm *LimitedMap) check(v interface{}, depth int, keys *int) *ValidationError {
+	var children []interface{}
+	switch tv := v.(type) {
+	case map[string]interface{}:
+		for _, child := range tv {
+			children = append(children, child)
+		}
+	case []interface{}:
+		children = tv
+	default:
+		return nil
+	}
+
+	if lm.MaxDepth != 0 && depth+1 > lm.MaxDepth {
+		return lm.depthError()
+	}
+
+	*keys += len(children)
+	if lm.MaxKeys != 0 && *keys > lm.MaxKeys {
+		return lm.keysError()
+	}
+
+	for _, child := range children {
+		if err := lm.check(child, depth+1, keys); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (lm *LimitedMap) Unmarshal(ctx Context, parent *reflect.Value, partial interface{}, dstValue reflect.Value) error {
+	keys := 0
+	if err := lm.check(partial, 0, &keys); err != nil {
+		return err
+	}
+	return lm.Contains.Unmarshal(ctx, parent, partial, dstValue)
+}
+
+// limitedReader reads a value from a token stream within the limits of a
+// LimitedMap. Once a limit is exceeded the rest of the value is skipped
+// rather than decoded, so that oversized values aren't held in memory.
+type limitedReader struct {
+	lm       *LimitedMap
+	ts       *tokenStream
+	keys     int
+	exceeded *ValidationError
+}
+
+func (r *limitedReader) read(depth int) (interface{}, error) {
+	tok, err := r.ts.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	isObject := isDelim(tok, '{')
+	if !isObject && !isDelim(tok, '[') {
+		return tok, nil
+	}
+
+	if r.lm.MaxDepth != 0 && depth+1 > r.lm.MaxDepth && r.exceeded == nil {
+		r.exceeded = r.lm.depthError()
+	}
+
+	obj := map[string]interface{}{}
+	arr := []interface{}{}
+	for r.ts.more() {
+		var key string
+		if isObject {
+			tok, err := r.ts.Token()
+			if err != nil {
+				return nil, err
+			}
+			key = tok.(string)
+		}
+
+		r.keys++
+		if r.lm.MaxKeys != 0 && r.keys > r.lm.MaxKeys && r.exceeded == nil {
+			r.exceeded = r.lm.keysError()
+		}
+
+		if r.exceeded != nil {
+			err = r.ts.skipValue()
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		val, err := r.read(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		if isObject {
+			obj[key] = val
+		} else {
+			arr = append(arr, val)
+		}
+	}
+
+	_, err = r.ts.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	if isObject {
+		return obj, nil
+	}
+	return arr, nil
+}
+
+func (lm *LimitedMap) unmarshalStream(ctx Context, parent *reflect.Value, ts *tokenStream, dstValue reflect.Value) error {
+	r := &limitedReader{lm: lm, ts: ts}
+
+	val, err := r.read(0)
+	if err != nil {
+		return err
+	}
+
+	if r.exceeded != nil {
+		return r.exceeded
+	}
+
+	return lm.Contains.Unmarshal(ctx, parent, val, dstValue)
+}
+
+func (lm *LimitedMap) Marshal(ctx Context, parent *reflect.Value, src reflect.Value) (json.Marshaler, error) {
+	return lm.Contains.Marshal(ctx, parent, src)
+}
+
+func (lm *LimitedMap) marshalTo(ctx Context, parent *reflect.Value, src reflect.Value, buf *bytes.Buffer) error {
+	return marshalTo(ctx, lm.Contains, parent, src, buf)
+}
+
+// Limited returns a TypeMap which rejects values nested more than maxDepth
+// objects or arrays deep, or containing more than maxKeys object keys and
+// array elements in total, before unmarshaling them with tm. A limit of zero
+// is unlimited. It is intended for free-form fields, such as metadata mapped
+// by Interface() or MapOf(), so that one permissive field can't be used to
+// submit arbitrarily large documents. When unmarshaling from a stream, an
+// oversized value is skipped rather than decoded.
+func Limited(tm TypeMap, maxDepth, maxKeys int) TypeMap {
+	return &LimitedMap{
+		Contains: tm,
+		MaxDepth: maxDepth,
+		MaxKeys:  maxKeys,
+	}
+}
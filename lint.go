@@ -0,0 +1,323 @@
+package jsonmap
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// LintKind classifies the problems reported by TypeMapper.Lint().
+type LintKind string
+
+const (
+	// LintUnreachable is reported for TypeMaps registered under a pointer or
+	// slice type, which are never used, as lookups are made by the type
+	// pointed to or contained.
+	LintUnreachable LintKind = "unreachable"
+
+	// LintInvalidField is reported for fields which would cause a panic when
+	// used: those naming a struct field which doesn't exist, those with
+	// neither Contains nor Validator, and those containing a StructMap with
+	// no UnderlyingType or a VariableType with no TypeMap for a variant.
+	LintInvalidField LintKind = "invalid field"
+
+	// LintMissingJSONTag is reported for the field on which a VariableType
+	// switches if it has no json tag, without which errors about missing
+	// type identifiers can't name the field.
+	LintMissingJSONTag LintKind = "missing json tag"
+
+	// LintTypeMismatch is reported for fields whose type can't hold the value
+	// produced by their Validator, or the struct mapped by their Contains.
+	LintTypeMismatch LintKind = "type mismatch"
+)
+
+// LintIssue is a single problem reported by TypeMapper.Lint().
+type LintIssue struct {
+	Kind LintKind
+
+	// Type is the registered type within whose TypeMap the problem was found
+	Type reflect.Type
+
+	// Path is the JSON path of the field at fault, if any
+	Path string
+
+	Message string
+}
+
+func (i LintIssue) String() string {
+	s := i.Type.String()
+	if i.Path != "" {
+		s += " " + i.Path
+	}
+	return s + ": " + string(i.Kind) + ": " + i.Message
+}
+
+// LintReport lists the problems found by TypeMapper.Lint().
+type LintReport struct {
+	Issues []LintIssue
+}
+
+// Err returns an error describing every issue in the report, or nil if there
+// are none. It is convenient for use in tests:
+//
+//	require.NoError(t, mapper.Lint().Err())
+func (r *LintReport) Err() error {
+	if len(r.Issues) == 0 {
+		return nil
+	}
+
+	lines := make([]string, len(r.Issues))
+	for i, issue := range r.Issues {
+		lines[i] = issue.String()
+	}
+	return errors.New("jsonmap lint: \n" + strings.Join(lines, "\n"))
+}
+
+type linter struct {
+	report  *LintReport
+	root    reflect.Type
+	visited map[reflect.Type]bool
+}
+
+func (l *linter) add(kind LintKind, path []string, message string) {
+	p := ""
+	if len(path) != 0 {
+		p = "/" + strings.Join(path, "/")
+	}
+
+	l.report.Issues = append(l.report.Issues, LintIssue{
+		Kind:    kind,
+		Type:    l.root,
+		Path:    p,
+		Message: message,
+	})
+}
+
+// Lint checks the registered TypeMaps for mistakes which would otherwise only
+// be found when a document exercising them is marshaled or unmarshaled,
+// typically by causing a panic. It is intended to be called from a unit test.
+// TypeMaps are checked in order of the names of their types.
+func (tm *TypeMapper) Lint() *LintReport {
+	report := &LintReport{}
+
+	types := tm.RegisteredTypes()
+	sort.Slice(types, func(i, j int) bool {
+		return types[i].String() < types[j].String()
+	})
+
+	for _, t := range types {
+		l := &linter{
+			report:  report,
+			root:    t,
+			visited: map[reflect.Type]bool{},
+		}
+
+		if t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+			l.add(LintUnreachable, nil, "registered under "+t.String()+", which is never looked up")
+			continue
+		}
+
+		l.typeMap(tm.typeMaps[t], t, nil)
+	}
+
+	return report
+}
+
+// typeMap checks m, which maps values of type t. t is nil where it can't be
+// determined.
+func (l *linter) typeMap(m TypeMap, t reflect.Type, path []string) {
+	switch tm := m.(type) {
+	case StructMap:
+		l.structMap(tm, t, path)
+	case *StructMap:
+		l.structMap(*tm, t, path)
+	case SliceMap:
+		l.typeMap(tm.Contains, elemOf(t, reflect.Slice), path)
+	case *SliceMap:
+		l.typeMap(tm.Contains, elemOf(t, reflect.Slice), path)
+	case *uniqueSliceMap:
+		l.typeMap(tm.Contains, elemOf(t, reflect.Slice), path)
+	case MapMap:
+		l.typeMap(tm.Contains, elemOf(t, reflect.Map), path)
+	case *MapMap:
+		l.typeMap(tm.Contains, elemOf(t, reflect.Map), path)
+	case *nullableMap:
+		l.typeMap(tm.Contains, elemOf(t, reflect.Ptr), path)
+	case *Discriminator:
+		keys := make([]string, 0, len(tm.Mapping))
+		for key := range tm.Mapping {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if tm.Mapping[key] == nil {
+				l.add(LintInvalidField, path, "no TypeMap for variant "+key)
+				continue
+			}
+			l.typeMap(tm.Mapping[key], t, path)
+		}
+	case *PrimitiveMap:
+		l.validator(tm.V, t, path, true)
+	case *optionalMap:
+		l.typeMap(tm.Contains, nil, path)
+	case *orderedMapMap:
+		l.typeMap(tm.Contains, nil, path)
+	case *EncryptedMap:
+		l.typeMap(tm.Contains, t, path)
+	case *LimitedMap:
+		l.typeMap(tm.Contains, t, path)
+	case *CachedMap:
+		l.typeMap(tm.Contains, t, path)
+	case *JSONAPIMap:
+		l.structMap(tm.Map, t, path)
+	case *DynamicMap:
+		if t != nil && t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t != nil && t.Kind() != reflect.Interface && t != dynamicMapType {
+			l.add(LintTypeMismatch, path, "field of type "+t.String()+" can't hold "+dynamicMapType.String())
+		}
+	case *expandableMap:
+		l.typeMap(tm.Full, t, path)
+		l.typeMap(tm.IDOnly, t, path)
+	}
+}
+
+func (l *linter) structMap(sm StructMap, t reflect.Type, path []string) {
+	st := sm.GetUnderlyingType()
+	if st == nil {
+		l.add(LintInvalidField, path, "StructMap has no UnderlyingType")
+		return
+	}
+
+	if t != nil {
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+
+		if t.Kind() != reflect.Interface && t != st {
+			l.add(LintTypeMismatch, path, "field of type "+t.String()+" can't hold "+st.String())
+			return
+		}
+	}
+
+	// Recursive types need only be checked once
+	if l.visited[st] {
+		return
+	}
+	l.visited[st] = true
+
+	for _, field := range sm.Fields {
+		fieldPath := append(path[:len(path):len(path)], field.JSONFieldName)
+
+		var ft reflect.Type
+		if field.StructFieldName != "" {
+			sf, ok := st.FieldByName(field.StructFieldName)
+			if !ok {
+				l.add(LintInvalidField, fieldPath, "no such struct field: "+field.StructFieldName)
+				continue
+			}
+			ft = sf.Type
+		} else if field.StructGetterName == "" {
+			l.add(LintInvalidField, fieldPath, "no struct field or getter")
+			continue
+		}
+
+		if vt, ok := field.Contains.(*Discriminator); ok {
+			l.discriminatorField(st, vt, fieldPath)
+		}
+
+		// Read only fields without a Contains are marshaled as-is, and their
+		// Validators never used
+		switch {
+		case field.Contains != nil:
+			l.typeMap(field.Contains, ft, fieldPath)
+		case field.ReadOnly:
+		case field.Validator != nil:
+			if ft != nil {
+				l.validator(field.Validator, ft, fieldPath, false)
+			}
+		default:
+			l.add(LintInvalidField, fieldPath, "field has neither Contains nor Validator")
+		}
+	}
+}
+
+func (l *linter) discriminatorField(st reflect.Type, vt *Discriminator, path []string) {
+	sf, ok := st.FieldByName(vt.PropertyName)
+	if !ok {
+		l.add(LintInvalidField, path, "no such struct field to switch on: "+vt.PropertyName)
+		return
+	}
+
+	if parseJsonTag(sf) == "" {
+		l.add(LintMissingJSONTag, path, "field switched on has no json tag: "+vt.PropertyName)
+	}
+}
+
+// validator checks that values produced by v can be stored in a field of type
+// t. Numbers may be converted within their family if converts is set, as they
+// are by PrimitiveMap.
+func (l *linter) validator(v Validator, t reflect.Type, path []string, converts bool) {
+	vt := validatorType(v)
+	if vt == nil || t == nil || vt.AssignableTo(t) {
+		return
+	}
+
+	if converts {
+		if converted, _ := convertScalar(reflect.Zero(vt), t); converted.Type().AssignableTo(t) {
+			return
+		}
+	}
+
+	l.add(LintTypeMismatch, path, "field of type "+t.String()+" can't hold "+vt.String()+" produced by its validator")
+}
+
+// validatorType returns the type of the values produced by the validators in
+// this package, or nil for others.
+func validatorType(v Validator) reflect.Type {
+	switch tv := v.(type) {
+	case *StringValidator, *UUIDStringValidator, *URLStringValidator, *EnumeratedValuesValidator:
+		return reflect.TypeOf("")
+	case *BooleanValidator, *BooleanStringsValidator:
+		return reflect.TypeOf(false)
+	case *IntegerValidator:
+		return reflect.TypeOf(int64(0))
+	case *LossyUint64Validator:
+		return reflect.TypeOf(uint64(0))
+	case *FloatValidator:
+		return reflect.TypeOf(float64(0))
+	case *EnumeratedJSONValuesValidator:
+		var t reflect.Type
+		for _, value := range tv.AllowedSlice {
+			if t != nil && reflect.TypeOf(value) != t {
+				return nil
+			}
+			t = reflect.TypeOf(value)
+		}
+		return t
+	case *AllOfValidator:
+		if len(tv.Validators) != 0 {
+			return validatorType(tv.Validators[len(tv.Validators)-1])
+		}
+	}
+	return nil
+}
+
+// elemOf returns the element type of t if it is of the given kind, or nil.
+func elemOf(t reflect.Type, kind reflect.Kind) reflect.Type {
+	if t == nil {
+		return nil
+	}
+
+	if t.Kind() == reflect.Ptr && kind != reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t.Kind() != kind {
+		return nil
+	}
+	return t.Elem()
+}
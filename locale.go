@@ -0,0 +1,218 @@
+package jsonmap
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Locale describes how numbers and dates are written in a locale, for use by
+// the lenient parsing enabled with WithLocale().
+type Locale struct {
+	// DecimalSeparator separates the integer and fractional parts of a
+	// number, such as "," in "1,5"
+	DecimalSeparator string
+
+	// GroupSeparator, if not empty, separates groups of three digits in the
+	// integer part of a number, such as "." in "1.000.000"
+	GroupSeparator string
+
+	// DateLayouts are the layouts, as for time.Parse, in which dates are
+	// written, such as "2.1.2006"
+	DateLayouts []string
+}
+
+// locales are the built in locales, along with any registered with
+// RegisterLocales, keyed by language tag in lower case.
+var (
+	localesMu sync.RWMutex
+	locales   = map[string]Locale{
+		"en":    {".", ",", []string{"1/2/2006"}},
+		"en-gb": {".", ",", []string{"2/1/2006"}},
+		"de":    {",", ".", []string{"2.1.2006"}},
+		"es":    {",", ".", []string{"2/1/2006"}},
+		"fr":    {",", " ", []string{"2/1/2006"}},
+		"it":    {",", ".", []string{"2/1/2006"}},
+		"ja":    {".", ",", []string{"2006/1/2"}},
+		"nl":    {",", ".", []string{"2-1-2006"}},
+		"pt":    {",", ".", []string{"2/1/2006"}},
+	}
+)
+
+// RegisterLocales makes additional locales available to WithLocale, keyed by
+// language tag, such as "de-CH". Registering a tag again replaces its locale.
+func RegisterLocales(l map[string]Locale) {
+	localesMu.Lock()
+	defer localesMu.Unlock()
+
+	for tag, locale := range l {
+		locales[strings.ToLower(tag)] = locale
+	}
+}
+
+// lookupLocale finds the locale for tag, trying each of its prefixes in turn
+// so that "de-AT" falls back to "de".
+func lookupLocale(tag string) (Locale, bool) {
+	localesMu.RLock()
+	defer localesMu.RUnlock()
+
+	tag = strings.ToLower(tag)
+	for {
+		if l, ok := locales[tag]; ok {
+			return l, true
+		}
+
+		i := strings.LastIndex(tag, "-")
+		if i < 0 {
+			return Locale{}, false
+		}
+		tag = tag[:i]
+	}
+}
+
+type localeContextKey struct{}
+
+// WithLocale enables lenient parsing of numbers and dates written for the
+// locale with the given language tag, such as "de-DE". Integer(), Float()
+// and LossyUint64() then also accept strings such as "1,5" or "1.000", and
+// Time() and Date() accept dates written as is usual in the locale, as well as
+// some common variations of RFC 3339. Values are normalized, so are marshaled
+// in their canonical forms as usual. It is intended for clients which can't
+// be changed to send canonical values; tags without a known locale leave
+// parsing unchanged.
+func WithLocale(ctx Context, tag string) Context {
+	return NewCtx(ctx).With(localeContextKey{}, tag)
+}
+
+func localeOf(ctx Context) (Locale, bool) {
+	c, ok := ctx.(*Ctx)
+	if !ok {
+		return Locale{}, false
+	}
+
+	tag, ok := c.Get(localeContextKey{})
+	if !ok {
+		return Locale{}, false
+	}
+	return lookupLocale(tag.(string))
+}
+
+// delocalizeNumber returns the number written in the string value in the
+// locale of ctx, if there is one. Other values are returned as-is, to be
+// rejected by the validator if they aren't numbers.
+func delocalizeNumber(ctx Context, value interface{}) interface{} {
+	s, ok := value.(string)
+	if !ok {
+		return value
+	}
+
+	l, ok := localeOf(ctx)
+	if !ok {
+		return value
+	}
+
+	if f, ok := l.parseNumber(s); ok {
+		return f
+	}
+	return value
+}
+
+// parseNumber parses a number written in the locale, which must use its
+// group separator, if at all, between every group of three digits.
+func (l Locale) parseNumber(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	if l.GroupSeparator == " " {
+		s = strings.NewReplacer("\u00a0", " ", "\u202f", " ").Replace(s)
+	}
+
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+
+	whole, frac := s, ""
+	if i := strings.LastIndex(s, l.DecimalSeparator); i >= 0 {
+		whole, frac = s[:i], s[i+len(l.DecimalSeparator):]
+		if frac == "" || !isDigits(frac) {
+			return 0, false
+		}
+	}
+
+	groups := []string{whole}
+	if l.GroupSeparator != "" {
+		groups = strings.Split(whole, l.GroupSeparator)
+	}
+
+	for i, group := range groups {
+		if !isDigits(group) || (i == 0 && len(groups) > 1 && len(group) > 3) || (i > 0 && len(group) != 3) {
+			return 0, false
+		}
+	}
+
+	canonical := sign + strings.Join(groups, "")
+	if frac != "" {
+		canonical += "." + frac
+	}
+
+	f, err := strconv.ParseFloat(canonical, 64)
+	if err != nil {
+		return 0, false
+	}
+	return f, true
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// lenientTimeLayouts are the variations of RFC 3339 accepted by Time() in
+// addition to the dates and times of a locale. Times without an offset are
+// taken to be in UTC.
+var lenientTimeLayouts = []string{
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	dateLayout,
+}
+
+// parseTime parses a time written in one of the lenient layouts, or as a date
+// in the locale optionally followed by a time of day.
+func (l Locale) parseTime(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+
+	layouts := lenientTimeLayouts
+	for _, layout := range l.DateLayouts {
+		layouts = append(layouts[:len(layouts):len(layouts)], layout+" 15:04:05", layout+" 15:04", layout)
+	}
+
+	for _, layout := range layouts {
+		t, err := time.ParseInLocation(layout, s, time.UTC)
+		if err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseDate parses a date written in the locale.
+func (l Locale) parseDate(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	for _, layout := range l.DateLayouts {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
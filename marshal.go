@@ -0,0 +1,281 @@
+package jsonmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+)
+
+// bufferMarshaler is implemented by TypeMaps which are able to write their
+// JSON representation directly into a shared buffer. This avoids the
+// intermediate json.Marshaler values built by Marshal, each of which would
+// otherwise be re-encoded (and re-validated) by every containing TypeMap.
+type bufferMarshaler interface {
+	marshalTo(ctx Context, parent *reflect.Value, src reflect.Value, buf *bytes.Buffer) error
+}
+
+// marshalTo writes the JSON representation of src, as mapped by m, to buf.
+// TypeMaps which don't implement bufferMarshaler are marshaled as usual, and
+// their output compacted into buf.
+func marshalTo(ctx Context, m TypeMap, parent *reflect.Value, src reflect.Value, buf *bytes.Buffer) error {
+	if bm, ok := m.(bufferMarshaler); ok {
+		return bm.marshalTo(ctx, parent, src, buf)
+	}
+
+	marshaler, err := m.Marshal(ctx, parent, src)
+	if err != nil {
+		return err
+	}
+
+	data, err := marshaler.MarshalJSON()
+	if err != nil {
+		return err
+	}
+
+	return json.Compact(buf, data)
+}
+
+// marshalValueTo writes the encoding/json representation of v to buf.
+func marshalValueTo(v interface{}, buf *bytes.Buffer) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	buf.Write(data)
+	return nil
+}
+
+func (sm StructMap) marshalTo(ctx Context, parent *reflect.Value, src reflect.Value, buf *bytes.Buffer) error {
+	orig := src
+
+	// An Interface's Elem() returns a Ptr whose Elem() returns the actual value
+	if src.Kind() == reflect.Interface {
+		if src.IsNil() {
+			buf.Write(nullJSONValue)
+			return nil
+		}
+		src = src.Elem()
+	}
+
+	if src.Kind() == reflect.Ptr {
+		if src.IsNil() {
+			buf.Write(nullJSONValue)
+			return nil
+		}
+		src = src.Elem()
+	}
+
+	expectedType := reflect.TypeOf(sm.UnderlyingType)
+	if src.Type() != expectedType {
+		return marshalUnmapped(ctx, orig, &UnmappedTypeError{src.Type(), expectedType}, buf)
+	}
+
+	src, err := beforeMarshal(ctx, src)
+	if err != nil {
+		return err
+	}
+
+	fs, err := sm.projection(ctx)
+	if err != nil {
+		return err
+	}
+
+	ctx = sm.marshalContext(ctx, src)
+
+	buf.WriteByte('{')
+
+	written := 0
+	for _, field := range sm.Fields {
+		if !field.activeFor(ctx) {
+			traceField(ctx, field.JSONFieldName, TraceFieldSkipped, "not active for version")
+			continue
+		}
+
+		fieldCtx, ok := fs.selects(ctx, field.JSONFieldName)
+		if !ok {
+			traceField(ctx, field.JSONFieldName, TraceFieldSkipped, "not selected")
+			continue
+		}
+
+		srcField, err := sm.fieldValue(src, field)
+		if err != nil {
+			return err
+		}
+
+		if field.OmitEmpty && srcField.IsZero() {
+			traceField(ctx, field.JSONFieldName, TraceFieldSkipped, "empty")
+			continue
+		}
+
+		if written != 0 {
+			buf.WriteByte(',')
+		}
+		written++
+
+		err = marshalValueTo(field.wireName(ctx), buf)
+		if err != nil {
+			return err
+		}
+
+		buf.WriteByte(':')
+
+		err = sm.marshalFieldTo(fieldCtx, src, field, srcField, buf)
+		if err != nil {
+			return projectionError(fs, field.JSONFieldName, err)
+		}
+	}
+
+	buf.WriteByte('}')
+
+	return nil
+}
+
+// marshalContext returns the Context with which the fields of the struct src
+// should be marshaled.
+func (sm StructMap) marshalContext(ctx Context, src reflect.Value) Context {
+	if sm.ContextTransform == nil {
+		return ctx
+	}
+
+	if !src.CanAddr() {
+		ptr := reflect.New(src.Type())
+		ptr.Elem().Set(src)
+		src = ptr.Elem()
+	}
+
+	return sm.ContextTransform(ctx, src.Addr().Interface())
+}
+
+// marshalFieldTo writes the value of a mapped field, read from the struct
+// src, to buf.
+func (sm StructMap) marshalFieldTo(ctx Context, src reflect.Value, field MappedField, srcField reflect.Value, buf *bytes.Buffer) error {
+	ctx = pathContext(ctx, field.wireName(ctx))
+	trace(ctx, TraceFieldMarshaled, "%s", field.sourceName())
+
+	if field.Redact && redacts(ctx) {
+		return marshalValueTo(RedactedValue, buf)
+	}
+
+	if field.Contains != nil {
+		return marshalTo(expansionContext(ctx, field.JSONFieldName), field.Contains, &src, srcField, buf)
+	}
+
+	if field.Validator != nil && validatesOnMarshal(ctx) {
+		val, err := validateForMarshal(ctx, field.Validator, srcField.Interface())
+		if err != nil {
+			return err
+		}
+		return marshalValueTo(protoScalar(ctx, val), buf)
+	}
+	return marshalValueTo(protoScalar(ctx, srcField.Interface()), buf)
+}
+
+// containerSource returns the slice or map held by src, following any
+// pointers or interfaces, or false if one of them is nil.
+func containerSource(src reflect.Value) (reflect.Value, bool) {
+	for src.Kind() == reflect.Ptr || src.Kind() == reflect.Interface {
+		if src.IsNil() {
+			return reflect.Value{}, false
+		}
+		src = src.Elem()
+	}
+	return src, true
+}
+
+func (sm SliceMap) marshalTo(ctx Context, parent *reflect.Value, src reflect.Value, buf *bytes.Buffer) error {
+	src, ok := containerSource(src)
+	if !ok || src.IsNil() {
+		buf.Write(nullJSONValue)
+		return nil
+	}
+
+	buf.WriteByte('[')
+
+	for i := 0; i < src.Len(); i++ {
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+
+		err := marshalTo(elementContext(ctx, i), sm.Contains, &src, src.Index(i), buf)
+		if err != nil {
+			return err
+		}
+	}
+
+	buf.WriteByte(']')
+
+	return nil
+}
+
+// sortedMapKeys returns the keys of the map src, which must have string keys,
+// in sorted order. This matches the output of encoding/json, and means that
+// marshaling, and any errors it reports, don't depend on the order in which
+// Go happens to iterate over the map.
+func sortedMapKeys(src reflect.Value) []reflect.Value {
+	if src.Type().Key().Kind() != reflect.String {
+		panic("key must be a string")
+	}
+
+	keys := src.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+	return keys
+}
+
+func (mm MapMap) marshalTo(ctx Context, parent *reflect.Value, src reflect.Value, buf *bytes.Buffer) error {
+	src, ok := containerSource(src)
+	if !ok {
+		buf.Write(nullJSONValue)
+		return nil
+	}
+
+	if src.IsNil() {
+		buf.Write(mm.nilValue(ctx))
+		return nil
+	}
+
+	buf.WriteByte('{')
+
+	for i, key := range sortedMapKeys(src) {
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+
+		err := marshalValueTo(key.String(), buf)
+		if err != nil {
+			return err
+		}
+
+		buf.WriteByte(':')
+
+		err = marshalTo(pathContext(ctx, key.String()), mm.Contains, &src, src.MapIndex(key), buf)
+		if err != nil {
+			return err
+		}
+	}
+
+	buf.WriteByte('}')
+
+	return nil
+}
+
+func (vt *Discriminator) marshalTo(ctx Context, parent *reflect.Value, src reflect.Value, buf *bytes.Buffer) error {
+	if src.IsZero() {
+		buf.Write(nullJSONValue)
+		return nil
+	}
+
+	tm, err := vt.pickTypeMap(ctx, parent)
+	if err != nil {
+		return marshalUnmapped(ctx, src, variableTypeError(err), buf)
+	}
+
+	return marshalTo(ctx, tm, parent, src, buf)
+}
+
+func (m *passthroughMarshaler) marshalTo(ctx Context, parent *reflect.Value, field reflect.Value, buf *bytes.Buffer) error {
+	return marshalValueTo(protoScalar(ctx, field.Interface()), buf)
+}
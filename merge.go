@@ -0,0 +1,99 @@
+package jsonmap
+
+import (
+	"reflect"
+)
+
+// MergeMode defines how unmarshaling into an already populated value treats
+// the values it holds. In every mode, the value of each field present in the
+// document replaces the existing value, and maps are replaced rather than
+// added to.
+type MergeMode int
+
+const (
+	// MergeNone, the default, unmarshals objects into new structs, even where
+	// a pointer to one is already populated, and appends the elements of
+	// lists to any existing slice. Only the value unmarshaled into is reused,
+	// and its optional fields absent from the document are left untouched.
+	MergeNone MergeMode = iota
+
+	// MergePreserve unmarshals objects into any existing struct, including
+	// one pointed to, leaving the values of optional fields absent from the
+	// document, or skipped because they are null, untouched. Slices are
+	// replaced rather than appended to. Structs shared by pointer are
+	// modified in place.
+	MergePreserve
+
+	// MergeReset sets optional fields absent from the document, or skipped
+	// because they are null, to their zero values, so that the result is as
+	// if the document had been unmarshaled into a zero value. Slices are
+	// replaced rather than appended to. Read only fields, and fields inactive
+	// for the requested version, are untouched.
+	MergeReset
+)
+
+type mergeModeContextKey struct{}
+
+func mergeModeOf(ctx Context) MergeMode {
+	c, ok := ctx.(*Ctx)
+	if !ok {
+		return MergeNone
+	}
+
+	mode, _ := c.Value(mergeModeContextKey{}).(MergeMode)
+	return mode
+}
+
+// withMergeMode returns a Context specifying the given MergeMode, if it isn't
+// the default.
+func withMergeMode(ctx Context, mode MergeMode) Context {
+	if mode == MergeNone {
+		return ctx
+	}
+	return NewCtx(ctx).With(mergeModeContextKey{}, mode)
+}
+
+// structDest returns the struct of type t into which an object should be
+// unmarshaled, given a pointer to one. Under MergePreserve an existing struct
+// is reused, otherwise a new one is allocated.
+func structDest(ctx Context, dstValue reflect.Value, t reflect.Type) reflect.Value {
+	if dstValue.IsNil() || mergeModeOf(ctx) != MergePreserve {
+		dstValue.Set(reflect.New(t))
+	}
+	return dstValue.Elem()
+}
+
+// resetter resets optional fields absent from the document, or skipped
+// because they are null, under MergeReset, to the values they would have in
+// a new struct of the same type, after any Defaulter has been applied. It is
+// nil in other modes.
+type resetter struct {
+	ctx      Context
+	t        reflect.Type
+	defaults reflect.Value
+}
+
+func newResetter(ctx Context, t reflect.Type) *resetter {
+	if mergeModeOf(ctx) != MergeReset {
+		return nil
+	}
+	return &resetter{ctx: ctx, t: t}
+}
+
+func (r *resetter) reset(field MappedField, dstField reflect.Value) {
+	if r == nil {
+		return
+	}
+
+	if !r.defaults.IsValid() {
+		r.defaults = reflect.New(r.t).Elem()
+		applyDefaults(r.ctx, r.defaults)
+	}
+	dstField.Set(fieldByName(r.defaults, field.StructFieldName))
+}
+
+// appendsSlices reports whether lists are unmarshaled by appending their
+// elements to any existing slice, rather than replacing it.
+func appendsSlices(ctx Context) bool {
+	return mergeModeOf(ctx) == MergeNone
+}
@@ -0,0 +1,53 @@
+package jsonmap
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// UnmarshalMerged merges the JSON objects in docs, in order, and validates
+// the result into dest as Unmarshal would. This suits layered configuration,
+// such as defaults overridden by a file overridden by the environment, where
+// the layers are incomplete and only their combination must be valid.
+//
+// Members of later documents override those of earlier ones. Where both are
+// objects they are merged recursively, while other values, including arrays
+// and null, replace what came before. A null document is treated as empty.
+func (tm *TypeMapper) UnmarshalMerged(ctx Context, docs [][]byte, dest interface{}) error {
+	if dest == nil || reflect.TypeOf(dest).Kind() != reflect.Ptr {
+		panic("cannot unmarshal to non-pointer")
+	}
+	m := tm.getTypeMap(dest)
+
+	merged := map[string]interface{}{}
+	for _, doc := range docs {
+		partial := map[string]interface{}{}
+		err := json.Unmarshal(doc, &partial)
+		if err != nil {
+			return wrapJSONError(err)
+		}
+
+		mergeObjects(merged, partial)
+	}
+
+	return tm.unmarshalPartial(ctx, m, merged, dest)
+}
+
+// mergeObjects merges src into dst, recursing into objects present in both.
+func mergeObjects(dst, src map[string]interface{}) {
+	for key, val := range src {
+		srcObj, ok := val.(map[string]interface{})
+		if !ok {
+			dst[key] = val
+			continue
+		}
+
+		dstObj, ok := dst[key].(map[string]interface{})
+		if !ok {
+			dst[key] = val
+			continue
+		}
+
+		mergeObjects(dstObj, srcObj)
+	}
+}
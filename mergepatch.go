@@ -0,0 +1,183 @@
+package jsonmap
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+)
+
+// ApplyMergePatch applies a JSON Merge Patch (RFC 7386) onto dst, which must
+// be a pointer to an existing instance of a registered type. Only the fields
+// present in the patch are validated and modified, and nested objects are
+// merged recursively, into both structs and maps; null removes a key from a
+// map. ReadOnly fields are ignored as they are by Unmarshal,
+// and setting a required field to null is rejected.
+//
+// dst may be partially modified if validation fails.
+func (tm *TypeMapper) ApplyMergePatch(ctx Context, patch []byte, dst interface{}) error {
+	if dst == nil || reflect.TypeOf(dst).Kind() != reflect.Ptr {
+		panic("cannot apply patch to non-pointer")
+	}
+
+	sm, ok := tm.getTypeMap(dst).(StructMap)
+	if !ok {
+		panic("merge patches may only be applied to structs")
+	}
+
+	partial := map[string]interface{}{}
+	err := json.Unmarshal(patch, &partial)
+	if err != nil {
+		return wrapJSONError(err)
+	}
+
+	err = sm.applyMergePatch(ctx, partial, reflect.ValueOf(dst).Elem())
+	if err != nil {
+		if e, ok := err.(*ValidationError); ok {
+			return e.Flatten()
+		}
+		return err
+	}
+	return nil
+}
+
+func (sm StructMap) applyMergePatch(ctx Context, patch map[string]interface{}, dstValue reflect.Value) error {
+	errs := &ValidationError{}
+
+	for _, field := range sm.Fields {
+		if field.ReadOnly || !field.activeFor(ctx) {
+			continue
+		}
+
+		val, ok := patch[field.JSONFieldName]
+		if !ok {
+			continue
+		}
+
+		dstField := fieldByName(dstValue, field.StructFieldName)
+		if !dstField.IsValid() {
+			panic("no such underlying field: " + field.StructFieldName)
+		}
+
+		if val == nil && !receivesNull(field.Contains) {
+			if !field.Optional {
+				errs.AddError(NewValidationErrorWithField(field.JSONFieldName, "cannot remove required field"))
+				continue
+			}
+			dstField.Set(reflect.Zero(dstField.Type()))
+			continue
+		}
+
+		// Objects are merged into existing structs and maps rather than
+		// replacing them
+		if nestedPatch, ok := val.(map[string]interface{}); ok {
+			merged, err := mergeObject(ctx, field.Contains, nestedPatch, dstField)
+			if merged {
+				if err != nil {
+					errs.AddError(fieldError(field.JSONFieldName, err))
+				}
+				continue
+			}
+		}
+
+		err := sm.unmarshalField(ctx, &dstValue, field, val, dstField)
+		if err != nil {
+			errs.AddError(err)
+		}
+	}
+
+	err := afterUnmarshal(ctx, dstValue, errs)
+	if err != nil {
+		return err
+	}
+
+	if len(errs.NestedErrors) != 0 || errs.Message != "" {
+		return errs
+	}
+
+	return nil
+}
+
+// mergeObject merges patch into target if tm maps a struct or a map, which
+// patches are merged into rather than replacing. It reports whether it did so.
+func mergeObject(ctx Context, tm TypeMap, patch map[string]interface{}, target reflect.Value) (bool, error) {
+	if target.Kind() == reflect.Ptr {
+		target = target.Elem()
+	}
+
+	switch m := tm.(type) {
+	case StructMap:
+		if target.Kind() == reflect.Struct {
+			return true, m.applyMergePatch(ctx, patch, target)
+		}
+	case MapMap:
+		if target.Kind() == reflect.Map {
+			return true, m.applyMergePatch(ctx, patch, target)
+		}
+	case *MapMap:
+		if target.Kind() == reflect.Map {
+			return true, m.applyMergePatch(ctx, patch, target)
+		}
+	}
+	return false, nil
+}
+
+// applyMergePatch merges patch into the map dstValue, deleting the keys which
+// are null in the patch. dstValue is only modified if every member of the
+// patch is valid.
+func (mm MapMap) applyMergePatch(ctx Context, patch map[string]interface{}, dstValue reflect.Value) error {
+	errs := &ValidationError{}
+
+	elementType := dstValue.Type().Elem()
+	keyType := dstValue.Type().Key()
+
+	keys := make([]string, 0, len(patch))
+	for key := range patch {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// A zero Value deletes the key when passed to SetMapIndex()
+	updates := make([]reflect.Value, len(keys))
+
+	for i, key := range keys {
+		val := patch[key]
+		if val == nil {
+			continue
+		}
+
+		mapKey := reflect.ValueOf(key).Convert(keyType)
+		dstElem := reflect.New(elementType).Elem()
+
+		merged := false
+		var err error
+		if nestedPatch, ok := val.(map[string]interface{}); ok {
+			if existing := dstValue.MapIndex(mapKey); existing.IsValid() {
+				dstElem.Set(existing)
+				merged, err = mergeObject(pathContext(ctx, key), mm.Contains, nestedPatch, dstElem)
+			}
+		}
+		if !merged {
+			dstElem.Set(reflect.Zero(elementType))
+			err = mm.Contains.Unmarshal(pathContext(ctx, key), &dstValue, val, dstElem)
+		}
+
+		if err != nil {
+			errs.AddError(fieldError(key, err))
+			continue
+		}
+		updates[i] = dstElem
+	}
+
+	if len(errs.NestedErrors) != 0 {
+		return errs
+	}
+
+	if dstValue.IsNil() {
+		dstValue.Set(reflect.MakeMap(dstValue.Type()))
+	}
+	for i, key := range keys {
+		dstValue.SetMapIndex(reflect.ValueOf(key).Convert(keyType), updates[i])
+	}
+
+	return nil
+}
@@ -0,0 +1,216 @@
+package jsonmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	currencyRegex = regexp.MustCompile(`^[A-Z]{3}$`)
+	decimalRegex  = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+)
+
+// currencyExponents lists the ISO 4217 currencies whose minor unit isn't a
+// hundredth of the major unit. All others are assumed to have two decimal
+// places.
+var currencyExponents = map[string]int{
+	"BHD": 3, "BIF": 0, "CLF": 4, "CLP": 0, "DJF": 0, "GNF": 0, "IQD": 3,
+	"ISK": 0, "JOD": 3, "JPY": 0, "KMF": 0, "KRW": 0, "KWD": 3, "LYD": 3,
+	"OMR": 3, "PYG": 0, "RWF": 0, "TND": 3, "UGX": 0, "UYI": 0, "UYW": 4,
+	"VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+}
+
+// CurrencyExponent returns the number of decimal places used by the currency
+// with the given ISO 4217 code.
+func CurrencyExponent(currency string) int {
+	if exp, ok := currencyExponents[currency]; ok {
+		return exp
+	}
+	return 2
+}
+
+// MonetaryAmount is an amount of a currency, held as an integer number of the
+// currency's minor units (such as cents) so that it is never subject to
+// rounding. It is the target of Money() and MoneyMinorUnits() fields.
+type MonetaryAmount struct {
+	// Currency is an ISO 4217 code, such as "USD"
+	Currency string
+
+	// Minor is the amount in minor units, such that 1234 USD is $12.34
+	Minor int64
+}
+
+// Amount returns the amount in major units as a decimal string, with as many
+// decimal places as the currency uses, such as "12.34".
+func (m MonetaryAmount) Amount() string {
+	exp := CurrencyExponent(m.Currency)
+
+	sign := ""
+	minor := strconv.FormatUint(uint64(m.Minor), 10)
+	if m.Minor < 0 {
+		sign = "-"
+		minor = strconv.FormatUint(uint64(-m.Minor), 10)
+	}
+
+	if exp == 0 {
+		return sign + minor
+	}
+
+	if len(minor) <= exp {
+		minor = strings.Repeat("0", exp-len(minor)+1) + minor
+	}
+	return sign + minor[:len(minor)-exp] + "." + minor[len(minor)-exp:]
+}
+
+// String returns the amount followed by the currency, such as "12.34 USD".
+func (m MonetaryAmount) String() string {
+	return m.Amount() + " " + m.Currency
+}
+
+// parseAmount converts a decimal string in major units into minor units.
+func parseAmount(s string, exp int) (int64, error) {
+	if !decimalRegex.MatchString(s) {
+		return 0, NewValidationError("not a valid decimal amount")
+	}
+
+	whole, frac, _ := strings.Cut(s, ".")
+	if len(frac) > exp {
+		return 0, NewValidationError("too many decimal places, may not have more than %d", exp)
+	}
+
+	digits := whole + frac + strings.Repeat("0", exp-len(frac))
+	minor, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0, NewValidationError("amount out of range")
+	}
+	return minor, nil
+}
+
+type MoneyMap struct {
+	// MinorUnits expects amounts as integers in minor units, such as
+	// {"amount": 1234, "currency": "USD"}, rather than as decimal strings
+	MinorUnits bool
+}
+
+func (mm *MoneyMap) Unmarshal(ctx Context, parent *reflect.Value, partial interface{}, dstValue reflect.Value) error {
+	if _, ok := dstValue.Interface().(MonetaryAmount); !ok {
+		panic("target field for jsonmap.Money() is not a jsonmap.MonetaryAmount")
+	}
+
+	data, ok := partial.(map[string]interface{})
+	if !ok {
+		return NewValidationError("expected an object")
+	}
+
+	errs := &ValidationError{}
+
+	currency, present := data["currency"]
+	if !present {
+		errs.AddError(NewValidationErrorWithField("currency", "missing required field"))
+	} else if s, ok := currency.(string); !ok || !currencyRegex.MatchString(s) {
+		errs.AddError(NewValidationErrorWithField("currency", "not a valid ISO 4217 currency code"))
+	}
+
+	amount, present := data["amount"]
+	if !present {
+		errs.AddError(NewValidationErrorWithField("amount", "missing required field"))
+	}
+
+	if len(errs.NestedErrors) != 0 {
+		return errs
+	}
+
+	m := MonetaryAmount{Currency: currency.(string)}
+
+	var err error
+	if mm.MinorUnits {
+		m.Minor, err = minorUnits(amount)
+	} else if s, ok := amount.(string); ok {
+		m.Minor, err = parseAmount(s, CurrencyExponent(m.Currency))
+	} else {
+		err = NewValidationError("not a string")
+	}
+
+	if err != nil {
+		errs.AddError(fieldError("amount", err))
+		return errs
+	}
+
+	dstValue.Set(reflect.ValueOf(m))
+	return nil
+}
+
+// minorUnits converts a decoded JSON number into an integer amount, rejecting
+// those which aren't integers or can't be represented exactly.
+func minorUnits(amount interface{}) (int64, error) {
+	f, ok := amount.(float64)
+	if !ok {
+		return 0, NewValidationError("not a number")
+	}
+
+	if f != math.Trunc(f) {
+		return 0, NewValidationError("not an integer")
+	}
+
+	if math.Abs(f) > 1<<53 {
+		return 0, NewValidationError("amount out of range")
+	}
+
+	return int64(f), nil
+}
+
+func (mm *MoneyMap) Marshal(ctx Context, parent *reflect.Value, src reflect.Value) (json.Marshaler, error) {
+	buf := &bytes.Buffer{}
+	err := mm.marshalTo(ctx, parent, src, buf)
+	if err != nil {
+		return nil, err
+	}
+	return RawMessage{buf.Bytes()}, nil
+}
+
+func (mm *MoneyMap) marshalTo(ctx Context, parent *reflect.Value, src reflect.Value, buf *bytes.Buffer) error {
+	m, ok := src.Interface().(MonetaryAmount)
+	if !ok {
+		panic("source field for jsonmap.Money() is not a jsonmap.MonetaryAmount")
+	}
+
+	buf.WriteString(`{"amount":`)
+	if mm.MinorUnits {
+		buf.WriteString(strconv.FormatInt(m.Minor, 10))
+	} else {
+		err := marshalValueTo(m.Amount(), buf)
+		if err != nil {
+			return err
+		}
+	}
+
+	buf.WriteString(`,"currency":`)
+	err := marshalValueTo(m.Currency, buf)
+	if err != nil {
+		return err
+	}
+	buf.WriteByte('}')
+
+	return nil
+}
+
+// Money returns a TypeMap for MonetaryAmount fields, represented as objects
+// holding a decimal string amount and an ISO 4217 currency code, such as
+// {"amount": "12.34", "currency": "USD"}. Amounts may not have more decimal
+// places than the currency uses, and are never converted to floating point.
+func Money() TypeMap {
+	return &MoneyMap{}
+}
+
+// MoneyMinorUnits is like Money, but represents amounts as integers in the
+// currency's minor units, such as {"amount": 1234, "currency": "USD"}.
+func MoneyMinorUnits() TypeMap {
+	return &MoneyMap{
+		MinorUnits: true,
+	}
+}
@@ -0,0 +1,210 @@
+package jsonmap
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrNotAcceptable        = errors.New("no acceptable content type")
+	ErrUnsupportedMediaType = errors.New("unsupported content type")
+)
+
+// A Codec translates between a wire format and the generic representation
+// (maps, slices, strings, float64s, bools and nils) that TypeMaps operate on.
+// This allows documents in formats other than JSON to be validated by the same
+// StructMaps.
+type Codec interface {
+	ContentType() string
+	Encode(v interface{}) ([]byte, error)
+	Decode(data []byte) (interface{}, error)
+}
+
+type jsonCodec struct{}
+
+func (c jsonCodec) ContentType() string {
+	return JSONContentType
+}
+
+func (c jsonCodec) Encode(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (c jsonCodec) Decode(data []byte) (interface{}, error) {
+	var partial interface{}
+	err := json.Unmarshal(data, &partial)
+	if err != nil {
+		return nil, NewValidationError(err.Error())
+	}
+	return partial, nil
+}
+
+// JSONCodec returns the Codec for application/json.
+func JSONCodec() Codec {
+	return jsonCodec{}
+}
+
+// Negotiator picks a Codec based on the Accept and Content-Type headers of a
+// request. The first registered Codec is used when the client expresses no
+// preference.
+type Negotiator struct {
+	TypeMapper *TypeMapper
+	Codecs     []Codec
+}
+
+// NewNegotiator returns a Negotiator supporting JSON in addition to any other
+// codecs given.
+func NewNegotiator(tm *TypeMapper, codecs ...Codec) *Negotiator {
+	return &Negotiator{
+		TypeMapper: tm,
+		Codecs:     append([]Codec{JSONCodec()}, codecs...),
+	}
+}
+
+type acceptedRange struct {
+	mediaType string
+	q         float64
+}
+
+// parseAccept parses an Accept header into media ranges ordered by descending
+// preference. Ranges with a quality of zero are dropped.
+func parseAccept(header string) []acceptedRange {
+	ranges := []acceptedRange{}
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if qs, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(qs, 64)
+			if err != nil {
+				continue
+			}
+		}
+
+		if q > 0 {
+			ranges = append(ranges, acceptedRange{mediaType, q})
+		}
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+
+	return ranges
+}
+
+func mediaRangeMatches(mediaRange, contentType string) bool {
+	if mediaRange == "*/*" || mediaRange == contentType {
+		return true
+	}
+	if strings.HasSuffix(mediaRange, "/*") {
+		return strings.HasPrefix(contentType, strings.TrimSuffix(mediaRange, "*"))
+	}
+	return false
+}
+
+// Encoder returns the Codec to use for a response to r.
+func (n *Negotiator) Encoder(r *http.Request) (Codec, error) {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return n.Codecs[0], nil
+	}
+
+	for _, ar := range parseAccept(accept) {
+		for _, c := range n.Codecs {
+			if mediaRangeMatches(ar.mediaType, c.ContentType()) {
+				return c, nil
+			}
+		}
+	}
+
+	return nil, ErrNotAcceptable
+}
+
+// Decoder returns the Codec to use for the body of r.
+func (n *Negotiator) Decoder(r *http.Request) (Codec, error) {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return n.Codecs[0], nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, ErrUnsupportedMediaType
+	}
+
+	for _, c := range n.Codecs {
+		if c.ContentType() == mediaType {
+			return c, nil
+		}
+	}
+
+	return nil, ErrUnsupportedMediaType
+}
+
+// NegotiateMarshal marshals v in the format preferred by r and writes it to w
+// with the given status code. ErrNotAcceptable is returned if no registered
+// Codec satisfies the Accept header.
+func (n *Negotiator) NegotiateMarshal(ctx Context, r *http.Request, w http.ResponseWriter, status int, v interface{}) error {
+	c, err := n.Encoder(r)
+	if err != nil {
+		return err
+	}
+
+	data, err := n.TypeMapper.Marshal(ctx, v)
+	if err != nil {
+		return err
+	}
+
+	if c.ContentType() != JSONContentType {
+		var partial interface{}
+		err = json.Unmarshal(data, &partial)
+		if err != nil {
+			return err
+		}
+
+		data, err = c.Encode(partial)
+		if err != nil {
+			return err
+		}
+	}
+
+	w.Header().Set("Content-Type", c.ContentType())
+	w.WriteHeader(status)
+	_, err = w.Write(data)
+	return err
+}
+
+// NegotiateUnmarshal decodes the body of r according to its Content-Type and
+// validates it into dest. ErrUnsupportedMediaType is returned if no registered
+// Codec handles the Content-Type.
+func (n *Negotiator) NegotiateUnmarshal(ctx Context, r *http.Request, dest interface{}) error {
+	c, err := n.Decoder(r)
+	if err != nil {
+		return err
+	}
+
+	data, err := readBody(r)
+	if err != nil {
+		return err
+	}
+
+	if c.ContentType() == JSONContentType {
+		return n.TypeMapper.Unmarshal(ctx, data, dest)
+	}
+
+	partial, err := c.Decode(data)
+	if err != nil {
+		return err
+	}
+
+	return n.TypeMapper.unmarshalPartial(ctx, n.TypeMapper.getTypeMap(dest), partial, dest)
+}
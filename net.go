@@ -0,0 +1,130 @@
+package jsonmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"reflect"
+)
+
+var (
+	ipType       = reflect.TypeOf(net.IP{})
+	ipNetType    = reflect.TypeOf(net.IPNet{})
+	ipNetPtrType = reflect.TypeOf(&net.IPNet{})
+)
+
+type IPMap struct{}
+
+func (m *IPMap) Unmarshal(ctx Context, parent *reflect.Value, partial interface{}, dstValue reflect.Value) error {
+	if dstValue.Type() != ipType {
+		panic("target field for jsonmap.IP() is not a net.IP")
+	}
+
+	s, ok := partial.(string)
+	if !ok {
+		return NewValidationError("not a string")
+	}
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return NewValidationError("not a valid IP address")
+	}
+
+	// Prefer the 4-byte representation of IPv4 addresses
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	dstValue.Set(reflect.ValueOf(ip))
+
+	return nil
+}
+
+func (m *IPMap) Marshal(ctx Context, parent *reflect.Value, src reflect.Value) (json.Marshaler, error) {
+	buf := &bytes.Buffer{}
+	err := m.marshalTo(ctx, parent, src, buf)
+	if err != nil {
+		return nil, err
+	}
+	return RawMessage{buf.Bytes()}, nil
+}
+
+func (m *IPMap) marshalTo(ctx Context, parent *reflect.Value, src reflect.Value, buf *bytes.Buffer) error {
+	if src.Type() != ipType {
+		panic("source field for jsonmap.IP() is not a net.IP")
+	}
+
+	ip := src.Interface().(net.IP)
+	if ip == nil {
+		buf.Write(nullJSONValue)
+		return nil
+	}
+	return marshalValueTo(ip.String(), buf)
+}
+
+// IP returns a TypeMap for net.IP fields, which accepts IPv4 and IPv6
+// addresses and marshals them in their canonical form.
+func IP() TypeMap {
+	return &IPMap{}
+}
+
+type IPNetMap struct{}
+
+func (m *IPNetMap) Unmarshal(ctx Context, parent *reflect.Value, partial interface{}, dstValue reflect.Value) error {
+	if dstValue.Type() != ipNetType && dstValue.Type() != ipNetPtrType {
+		panic("target field for jsonmap.IPNet() is not a net.IPNet or *net.IPNet")
+	}
+
+	s, ok := partial.(string)
+	if !ok {
+		return NewValidationError("not a string")
+	}
+
+	_, ipNet, err := net.ParseCIDR(s)
+	if err != nil {
+		return NewValidationError("not a valid CIDR")
+	}
+
+	if dstValue.Type() == ipNetPtrType {
+		dstValue.Set(reflect.ValueOf(ipNet))
+	} else {
+		dstValue.Set(reflect.ValueOf(*ipNet))
+	}
+
+	return nil
+}
+
+func (m *IPNetMap) Marshal(ctx Context, parent *reflect.Value, src reflect.Value) (json.Marshaler, error) {
+	buf := &bytes.Buffer{}
+	err := m.marshalTo(ctx, parent, src, buf)
+	if err != nil {
+		return nil, err
+	}
+	return RawMessage{buf.Bytes()}, nil
+}
+
+func (m *IPNetMap) marshalTo(ctx Context, parent *reflect.Value, src reflect.Value, buf *bytes.Buffer) error {
+	var ipNet *net.IPNet
+
+	switch v := src.Interface().(type) {
+	case net.IPNet:
+		ipNet = &v
+	case *net.IPNet:
+		ipNet = v
+	default:
+		panic("source field for jsonmap.IPNet() is not a net.IPNet or *net.IPNet")
+	}
+
+	if ipNet == nil || ipNet.IP == nil {
+		buf.Write(nullJSONValue)
+		return nil
+	}
+	return marshalValueTo(ipNet.String(), buf)
+}
+
+// IPNet returns a TypeMap for net.IPNet (or *net.IPNet) fields, which accepts
+// networks in CIDR notation. As with net.ParseCIDR, the host bits of the
+// address are discarded, so "192.0.2.1/24" is stored as 192.0.2.0/24.
+func IPNet() TypeMap {
+	return &IPNetMap{}
+}
@@ -0,0 +1,129 @@
+package jsonmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+)
+
+type nullableMap struct {
+	Contains TypeMap
+}
+
+// nullValue returns the field of a sql.Null* style struct which holds its
+// value, along with its Valid field. ok is false for other types.
+func nullValue(v reflect.Value) (value reflect.Value, valid reflect.Value, ok bool) {
+	if v.Kind() != reflect.Struct || v.NumField() != 2 {
+		return reflect.Value{}, reflect.Value{}, false
+	}
+
+	valid = v.FieldByName("Valid")
+	if !valid.IsValid() || valid.Kind() != reflect.Bool {
+		return reflect.Value{}, reflect.Value{}, false
+	}
+	return v.Field(0), valid, true
+}
+
+func (nm *nullableMap) Unmarshal(ctx Context, parent *reflect.Value, partial interface{}, dstValue reflect.Value) error {
+	return nm.unmarshal(dstValue, partial == nil, func(dst reflect.Value) error {
+		return nm.Contains.Unmarshal(ctx, parent, partial, dst)
+	})
+}
+
+func (nm *nullableMap) unmarshalStream(ctx Context, parent *reflect.Value, ts *tokenStream, dstValue reflect.Value) error {
+	tok, err := ts.Peek()
+	if err != nil {
+		return err
+	}
+
+	if tok == nil {
+		ts.Token()
+	}
+
+	return nm.unmarshal(dstValue, tok == nil, func(dst reflect.Value) error {
+		return unmarshalStream(ctx, nm.Contains, parent, ts, dst)
+	})
+}
+
+// unmarshal sets dstValue to null if isNull, or otherwise to the value
+// unmarshaled by unmarshal into the destination it is passed.
+func (nm *nullableMap) unmarshal(dstValue reflect.Value, isNull bool, unmarshal func(dst reflect.Value) error) error {
+	if dstValue.Kind() == reflect.Ptr {
+		if isNull {
+			dstValue.Set(reflect.Zero(dstValue.Type()))
+			return nil
+		}
+
+		elem := reflect.New(dstValue.Type().Elem())
+		err := unmarshal(elem.Elem())
+		if err != nil {
+			return err
+		}
+
+		dstValue.Set(elem)
+		return nil
+	}
+
+	value, valid, ok := nullValue(dstValue)
+	if !ok {
+		panic("target field for jsonmap.Nullable() is not a pointer or sql.Null* type: " + dstValue.Type().String())
+	}
+
+	if isNull {
+		dstValue.Set(reflect.Zero(dstValue.Type()))
+		return nil
+	}
+
+	err := unmarshal(value)
+	if err != nil {
+		return err
+	}
+
+	valid.SetBool(true)
+	return nil
+}
+
+func (nm *nullableMap) Marshal(ctx Context, parent *reflect.Value, src reflect.Value) (json.Marshaler, error) {
+	buf := &bytes.Buffer{}
+	err := nm.marshalTo(ctx, parent, src, buf)
+	if err != nil {
+		return nil, err
+	}
+	return RawMessage{buf.Bytes()}, nil
+}
+
+func (nm *nullableMap) marshalTo(ctx Context, parent *reflect.Value, src reflect.Value, buf *bytes.Buffer) error {
+	if src.Kind() == reflect.Ptr {
+		if src.IsNil() {
+			buf.Write(nullJSONValue)
+			return nil
+		}
+		return marshalTo(ctx, nm.Contains, parent, src.Elem(), buf)
+	}
+
+	value, valid, ok := nullValue(src)
+	if !ok {
+		panic("source field for jsonmap.Nullable() is not a pointer or sql.Null* type: " + src.Type().String())
+	}
+
+	if !valid.Bool() {
+		buf.Write(nullJSONValue)
+		return nil
+	}
+	return marshalTo(ctx, nm.Contains, parent, value, buf)
+}
+
+// Nullable wraps a TypeMap such that JSON null is mapped to a nil pointer, or
+// to an invalid sql.NullString, sql.NullInt64, sql.NullTime (or similar)
+// value, and any other value is mapped using tm. For scalar fields, tm is
+// typically a PrimitiveMap, for example:
+//
+//	Nullable(NewPrimitiveMap(String(1, 255)))
+//
+// Note that null values of Optional fields are skipped on unmarshal, leaving
+// the field untouched.
+func Nullable(tm TypeMap) TypeMap {
+	return &nullableMap{
+		Contains: tm,
+	}
+}
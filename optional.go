@@ -0,0 +1,145 @@
+package jsonmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+)
+
+// Optional holds a value which may be absent from a document, explicitly
+// null, or set. This distinction is typically needed for partial updates,
+// where an absent field is left unchanged but a null one is cleared. Fields
+// of this type are mapped using OptionalOf, and should usually be Optional.
+type Optional[T any] struct {
+	Value   T
+	Present bool
+	Null    bool
+}
+
+// Some returns an Optional holding v.
+func Some[T any](v T) Optional[T] {
+	return Optional[T]{Value: v, Present: true}
+}
+
+// Null returns an Optional which is explicitly null.
+func Null[T any]() Optional[T] {
+	return Optional[T]{Present: true, Null: true}
+}
+
+// Get returns the value of o, and whether it was set to a non-null value.
+func (o Optional[T]) Get() (T, bool) {
+	return o.Value, o.Present && !o.Null
+}
+
+// IsAbsent reports whether o was absent from the document.
+func (o Optional[T]) IsAbsent() bool {
+	return !o.Present
+}
+
+// IsNull reports whether o was explicitly null.
+func (o Optional[T]) IsNull() bool {
+	return o.Present && o.Null
+}
+
+func (o Optional[T]) optional() {}
+
+// optionalValue is implemented by every instantiation of Optional.
+type optionalValue interface {
+	optional()
+}
+
+var optionalValueType = reflect.TypeOf((*optionalValue)(nil)).Elem()
+
+type optionalMap struct {
+	Contains TypeMap
+}
+
+func checkOptionalType(t reflect.Type, which string) {
+	if !t.Implements(optionalValueType) {
+		panic(which + " field for jsonmap.OptionalOf() is not a jsonmap.Optional: " + t.String())
+	}
+}
+
+func (om *optionalMap) Unmarshal(ctx Context, parent *reflect.Value, partial interface{}, dstValue reflect.Value) error {
+	return om.unmarshal(dstValue, partial == nil, func(dst reflect.Value) error {
+		return om.Contains.Unmarshal(ctx, parent, partial, dst)
+	})
+}
+
+func (om *optionalMap) unmarshalStream(ctx Context, parent *reflect.Value, ts *tokenStream, dstValue reflect.Value) error {
+	tok, err := ts.Peek()
+	if err != nil {
+		return err
+	}
+
+	if tok == nil {
+		ts.Token()
+	}
+
+	return om.unmarshal(dstValue, tok == nil, func(dst reflect.Value) error {
+		return unmarshalStream(ctx, om.Contains, parent, ts, dst)
+	})
+}
+
+// unmarshal marks dstValue present, and null if isNull, or otherwise sets its
+// value to that unmarshaled by unmarshal into the destination it is passed.
+func (om *optionalMap) unmarshal(dstValue reflect.Value, isNull bool, unmarshal func(dst reflect.Value) error) error {
+	checkOptionalType(dstValue.Type(), "target")
+
+	value := dstValue.FieldByName("Value")
+	if isNull {
+		value.Set(reflect.Zero(value.Type()))
+		dstValue.FieldByName("Null").SetBool(true)
+	} else {
+		err := unmarshal(value)
+		if err != nil {
+			return err
+		}
+		dstValue.FieldByName("Null").SetBool(false)
+	}
+
+	dstValue.FieldByName("Present").SetBool(true)
+	return nil
+}
+
+func (om *optionalMap) Marshal(ctx Context, parent *reflect.Value, src reflect.Value) (json.Marshaler, error) {
+	buf := &bytes.Buffer{}
+	err := om.marshalTo(ctx, parent, src, buf)
+	if err != nil {
+		return nil, err
+	}
+	return RawMessage{buf.Bytes()}, nil
+}
+
+func (om *optionalMap) marshalTo(ctx Context, parent *reflect.Value, src reflect.Value, buf *bytes.Buffer) error {
+	checkOptionalType(src.Type(), "source")
+
+	if !src.FieldByName("Present").Bool() || src.FieldByName("Null").Bool() {
+		buf.Write(nullJSONValue)
+		return nil
+	}
+	return marshalTo(ctx, om.Contains, parent, src.FieldByName("Value"), buf)
+}
+
+// OptionalOf returns a TypeMap for Optional fields, mapping their values with
+// tm. Unlike other TypeMaps, it receives null values even if the field is
+// Optional, so that an explicit null can be distinguished from an absent
+// field. Absent and null values are both marshaled as null.
+func OptionalOf(tm TypeMap) TypeMap {
+	return &optionalMap{
+		Contains: tm,
+	}
+}
+
+// receivesNull reports whether tm needs to see null values, which are
+// otherwise skipped for Optional fields.
+func receivesNull(tm TypeMap) bool {
+	_, ok := tm.(*optionalMap)
+	return ok
+}
+
+// skipsNull reports whether null values of the field are skipped on
+// unmarshal, leaving the field untouched.
+func (f MappedField) skipsNull() bool {
+	return f.Optional && !receivesNull(f.Contains)
+}
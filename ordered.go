@@ -0,0 +1,251 @@
+package jsonmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+)
+
+// OrderedMap is a map with string keys which remembers the order in which
+// they were first set. Fields of this type are mapped using OrderedMapOf, for
+// objects whose key order is meaningful. The zero value is an empty map.
+type OrderedMap[V any] struct {
+	keys   []string
+	values map[string]V
+}
+
+// Set sets the value for key, which keeps its position if already present
+// and is otherwise added at the end.
+func (m *OrderedMap[V]) Set(key string, value V) {
+	if m.values == nil {
+		m.values = map[string]V{}
+	}
+
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+// Get returns the value for key, and whether it is present.
+func (m OrderedMap[V]) Get(key string) (V, bool) {
+	v, ok := m.values[key]
+	return v, ok
+}
+
+// Delete removes key from the map.
+func (m *OrderedMap[V]) Delete(key string) {
+	if _, ok := m.values[key]; !ok {
+		return
+	}
+
+	delete(m.values, key)
+	for i, k := range m.keys {
+		if k == key {
+			m.keys = append(m.keys[:i:i], m.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// Keys returns the keys of the map in order.
+func (m OrderedMap[V]) Keys() []string {
+	return append([]string(nil), m.keys...)
+}
+
+// Len returns the number of keys in the map.
+func (m OrderedMap[V]) Len() int {
+	return len(m.keys)
+}
+
+func (m OrderedMap[V]) valueType() reflect.Type {
+	return reflect.TypeOf((*V)(nil)).Elem()
+}
+
+func (m OrderedMap[V]) index(key string) reflect.Value {
+	v := m.values[key]
+	return reflect.ValueOf(&v).Elem()
+}
+
+func (m *OrderedMap[V]) setValue(key string, value reflect.Value) {
+	m.Set(key, value.Interface().(V))
+}
+
+func (m *OrderedMap[V]) reset() {
+	*m = OrderedMap[V]{}
+}
+
+// orderedMapValue is implemented by pointers to every instantiation of
+// OrderedMap.
+type orderedMapValue interface {
+	Keys() []string
+	valueType() reflect.Type
+	index(key string) reflect.Value
+	setValue(key string, value reflect.Value)
+	reset()
+}
+
+var orderedMapValueType = reflect.TypeOf((*orderedMapValue)(nil)).Elem()
+
+type orderedMapMap struct {
+	Contains TypeMap
+}
+
+func orderedMapOf(v reflect.Value, which string) orderedMapValue {
+	if !v.CanAddr() || !reflect.PtrTo(v.Type()).Implements(orderedMapValueType) {
+		panic(which + " field for jsonmap.OrderedMapOf() is not a jsonmap.OrderedMap: " + v.Type().String())
+	}
+	return v.Addr().Interface().(orderedMapValue)
+}
+
+// Unmarshal is used when the object has already been decoded, and so its key
+// order lost, in which case the keys are sorted. This happens behind TypeMaps
+// which don't stream, such as those of UnmarshalMerged and JSON:API.
+func (om *orderedMapMap) Unmarshal(ctx Context, parent *reflect.Value, partial interface{}, dstValue reflect.Value) error {
+	dst := orderedMapOf(dstValue, "target")
+
+	data, ok := partial.(map[string]interface{})
+	if !ok {
+		return NewValidationError("expected a map")
+	}
+
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	dst.reset()
+	errs := &ValidationError{}
+
+	for _, key := range keys {
+		dstElem := reflect.New(dst.valueType()).Elem()
+
+		err := om.Contains.Unmarshal(pathContext(ctx, key), &dstValue, data[key], dstElem)
+		if err != nil {
+			errs.AddError(fieldError(key, err))
+			continue
+		}
+
+		dst.setValue(key, dstElem)
+	}
+
+	if len(errs.NestedErrors) != 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func (om *orderedMapMap) unmarshalStream(ctx Context, parent *reflect.Value, ts *tokenStream, dstValue reflect.Value) error {
+	dst := orderedMapOf(dstValue, "target")
+
+	tok, err := ts.Peek()
+	if err != nil {
+		return err
+	}
+
+	if !isDelim(tok, '{') {
+		err = ts.skipValue()
+		if err != nil {
+			return err
+		}
+		return NewValidationError("expected a map")
+	}
+
+	ts.Token()
+
+	dst.reset()
+	errs := &ValidationError{}
+
+	for ts.more() {
+		key, err := ts.Token()
+		if err != nil {
+			return err
+		}
+
+		dstElem := reflect.New(dst.valueType()).Elem()
+
+		err = unmarshalStream(pathContext(ctx, key.(string)), om.Contains, &dstValue, ts, dstElem)
+		if ts.err != nil {
+			return ts.err
+		}
+
+		if err != nil {
+			errs.AddError(fieldError(key.(string), err))
+			if ts.failFast {
+				return errs
+			}
+			continue
+		}
+
+		dst.setValue(key.(string), dstElem)
+	}
+
+	_, err = ts.Token()
+	if err != nil {
+		return err
+	}
+
+	if len(errs.NestedErrors) != 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func (om *orderedMapMap) Marshal(ctx Context, parent *reflect.Value, src reflect.Value) (json.Marshaler, error) {
+	buf := &bytes.Buffer{}
+	err := om.marshalTo(ctx, parent, src, buf)
+	if err != nil {
+		return nil, err
+	}
+	return RawMessage{buf.Bytes()}, nil
+}
+
+func (om *orderedMapMap) marshalTo(ctx Context, parent *reflect.Value, src reflect.Value, buf *bytes.Buffer) error {
+	// Values may not be addressable when marshaling
+	if !src.CanAddr() {
+		addressable := reflect.New(src.Type()).Elem()
+		addressable.Set(src)
+		src = addressable
+	}
+	m := orderedMapOf(src, "source")
+
+	buf.WriteByte('{')
+
+	for i, key := range m.Keys() {
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+
+		err := marshalValueTo(key, buf)
+		if err != nil {
+			return err
+		}
+
+		buf.WriteByte(':')
+
+		err = marshalTo(pathContext(ctx, key), om.Contains, &src, m.index(key), buf)
+		if err != nil {
+			return err
+		}
+	}
+
+	buf.WriteByte('}')
+
+	return nil
+}
+
+// OrderedMapOf returns a TypeMap for OrderedMap fields, mapping their values
+// with elem. Keys are kept in the order in which they appear in the document
+// when it is read by TypeMapper.Unmarshal or UnmarshalReader, including
+// behind Nullable, OptionalOf, Encrypted and VariableType. Where the document
+// has already been decoded they are sorted instead. Keys are marshaled in the
+// order of the map.
+func OrderedMapOf(elem TypeMap) TypeMap {
+	return &orderedMapMap{
+		Contains: elem,
+	}
+}
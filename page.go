@@ -0,0 +1,97 @@
+package jsonmap
+
+import (
+	"bytes"
+)
+
+// PageRequest holds the pagination parameters of a request for a list of
+// objects. It is typically embedded in a struct holding the request's other
+// query parameters, and decoded using a QueryMap composed with PageQueryMap.
+type PageRequest struct {
+	Limit  int
+	Cursor string
+}
+
+// PageQueryMap returns a QueryMap for the "limit" and "cursor" parameters of a
+// PageRequest, which should be composed (using ComposeQueryMaps) with a
+// QueryMap specifying the UnderlyingType. The limit must be between 1 and
+// maxLimit, and the cursor must pass any of the given validators. Limit is
+// left as zero if the parameter is absent.
+func PageQueryMap(maxLimit int, cursorValidators ...func(string) bool) QueryMap {
+	return QueryMap{
+		ParameterMaps: []ParameterMap{
+			{
+				StructFieldName: "Limit",
+				ParameterName:   "limit",
+				Mapper: IntQueryParameterMapper{
+					Validators: []func(int64) bool{
+						func(n int64) bool {
+							return n >= 1 && n <= int64(maxLimit)
+						},
+					},
+				},
+				OmitEmpty: true,
+			},
+			{
+				StructFieldName: "Cursor",
+				ParameterName:   "cursor",
+				Mapper: StringQueryParameterMapper{
+					Validators: cursorValidators,
+				},
+				OmitEmpty: true,
+			},
+		},
+	}
+}
+
+// Page is a page of a paginated list of objects, of a type registered with
+// the TypeMapper.
+type Page[T any] struct {
+	Items      []T
+	Total      int
+	NextCursor string
+}
+
+func (p Page[T]) page() (items interface{}, n int, total int, nextCursor string) {
+	return p.Items, len(p.Items), p.Total, p.NextCursor
+}
+
+// PageValue is implemented by every instantiation of Page, and allows
+// MarshalPage to accept a Page of any type of item, as methods can't have
+// type parameters of their own. It can't be implemented outside this package.
+type PageValue interface {
+	page() (items interface{}, n int, total int, nextCursor string)
+}
+
+// MarshalPage marshals a Page, such as a Page[*Dog], in the form
+// {"items": [...], "total": ..., "next_cursor": ...}, with each item rendered
+// through its registered TypeMap. The next cursor is omitted if empty, and an
+// empty page has an empty (rather than null) list of items.
+func (tm *TypeMapper) MarshalPage(ctx Context, page PageValue) ([]byte, error) {
+	items, n, total, nextCursor := page.page()
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(`{"items":`)
+
+	if n == 0 {
+		buf.WriteString("[]")
+	} else {
+		data, err := tm.Marshal(ctx, items)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(data)
+	}
+
+	buf.WriteString(`,"total":`)
+	marshalValueTo(total, buf)
+
+	if nextCursor != "" {
+		buf.WriteString(`,"next_cursor":`)
+		marshalValueTo(nextCursor, buf)
+	}
+
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,272 @@
+package jsonmap
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/rnd42/go-jsonpointer"
+)
+
+// pointerTokens parses a JSON pointer (RFC 6901) into its reference tokens.
+func pointerTokens(pointer string) ([]string, error) {
+	p, err := jsonpointer.NewJSONPointerFromString(pointer)
+	if err != nil {
+		return nil, err
+	}
+	return p.Tokens(), nil
+}
+
+func pointerNotFound(tokens []string) error {
+	return fmt.Errorf("no value at %s", jsonpointer.NewJSONPointerFromTokens(&tokens).String())
+}
+
+// unwrapPointerMap returns the TypeMap which determines how a JSON pointer
+// token is resolved within values mapped by m.
+func unwrapPointerMap(m TypeMap) TypeMap {
+	for {
+		switch tm := m.(type) {
+		case *StructMap:
+			m = *tm
+		case *SliceMap:
+			m = *tm
+		case *MapMap:
+			m = *tm
+		case *uniqueSliceMap:
+			m = tm.SliceMap
+		case *LimitedMap:
+			m = tm.Contains
+		case *CachedMap:
+			m = tm.Contains
+		default:
+			return m
+		}
+	}
+}
+
+// pointerField returns the mapped field of sm with the given JSON name.
+func pointerField(sm StructMap, name string) (MappedField, bool) {
+	for _, field := range sm.Fields {
+		if field.JSONFieldName == name && field.StructFieldName != "" {
+			return field, true
+		}
+	}
+	return MappedField{}, false
+}
+
+// pointerIndex parses token as an index into a slice of length n.
+func pointerIndex(token string, n int) (int, bool) {
+	i, err := strconv.Atoi(token)
+	if err != nil || strconv.Itoa(i) != token || i < 0 || i >= n {
+		return 0, false
+	}
+	return i, true
+}
+
+// pointerChild resolves a single token within val, which is mapped by m,
+// returning the TypeMap of the child (nil for fields with a Validator) and
+// the child itself. Elements of maps aren't addressable, so are returned as
+// addressable copies. If alloc is true nil pointers are allocated as they are
+// traversed.
+func pointerChild(m TypeMap, val reflect.Value, token string, alloc bool) (TypeMap, reflect.Value, bool) {
+	for val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+		if val.IsNil() {
+			if !alloc || !val.CanSet() || val.Kind() == reflect.Interface {
+				return nil, reflect.Value{}, false
+			}
+			val.Set(reflect.New(val.Type().Elem()))
+		}
+		val = val.Elem()
+	}
+
+	switch tm := unwrapPointerMap(m).(type) {
+	case StructMap:
+		if val.Kind() != reflect.Struct {
+			return nil, reflect.Value{}, false
+		}
+		field, ok := pointerField(tm, token)
+		if !ok {
+			return nil, reflect.Value{}, false
+		}
+		return field.Contains, fieldByName(val, field.StructFieldName), true
+
+	case SliceMap:
+		if val.Kind() != reflect.Slice {
+			return nil, reflect.Value{}, false
+		}
+		i, ok := pointerIndex(token, val.Len())
+		if !ok {
+			return nil, reflect.Value{}, false
+		}
+		return tm.Contains, val.Index(i), true
+
+	case MapMap:
+		if val.Kind() != reflect.Map {
+			return nil, reflect.Value{}, false
+		}
+		elem := val.MapIndex(reflect.ValueOf(token).Convert(val.Type().Key()))
+		if !elem.IsValid() {
+			return nil, reflect.Value{}, false
+		}
+		child := reflect.New(elem.Type()).Elem()
+		child.Set(elem)
+		return tm.Contains, child, true
+	}
+
+	return nil, reflect.Value{}, false
+}
+
+// GetByPointer returns the value within v, which must be a registered type
+// or a pointer to one, that is located by the given JSON pointer (RFC 6901).
+// The pointer refers to the JSON representation of v, so is resolved using
+// the JSON field names of its StructMaps, such that "/inner_thing/foo"
+// returns the Go value of the field mapped as "foo" within the field mapped
+// as "inner_thing". Slices are indexed by position and maps by key.
+func (tm *TypeMapper) GetByPointer(v interface{}, pointer string) (interface{}, error) {
+	tokens, err := pointerTokens(pointer)
+	if err != nil {
+		return nil, err
+	}
+
+	m := tm.getTypeMap(v)
+	val := reflect.ValueOf(v)
+
+	for i, token := range tokens {
+		var ok bool
+		m, val, ok = pointerChild(m, val, token, false)
+		if !ok {
+			return nil, pointerNotFound(tokens[:i+1])
+		}
+	}
+
+	return val.Interface(), nil
+}
+
+// SetByPointer validates the JSON value data using the TypeMap of the
+// location within v given by a JSON pointer (RFC 6901), and stores the result
+// there. v must be a pointer to an instance of a registered type, and the
+// pointer is resolved as by GetByPointer, except that nil pointers along the
+// way are allocated and the final token may name a new key within a map.
+// ReadOnly fields can't be set, and setting an Optional field to null
+// clears it.
+//
+// Validation errors are returned as a *MultiValidationError whose paths are
+// relative to v, as if v itself had been unmarshaled.
+func (tm *TypeMapper) SetByPointer(ctx Context, v interface{}, pointer string, data []byte) error {
+	if reflect.TypeOf(v).Kind() != reflect.Ptr || v == nil {
+		panic("cannot set by pointer on non-pointer")
+	}
+
+	tokens, err := pointerTokens(pointer)
+	if err != nil {
+		return err
+	}
+
+	if len(tokens) == 0 {
+		return tm.Unmarshal(ctx, data, v)
+	}
+
+	var partial interface{}
+	err = json.Unmarshal(data, &partial)
+	if err != nil {
+		return wrapJSONError(err)
+	}
+
+	err = setByPointer(ctx, tm.getTypeMap(v), reflect.ValueOf(v), tokens, 0, partial)
+	if err != nil {
+		if ve, ok := err.(*ValidationError); ok {
+			errs := &ValidationError{}
+			errs.AddError(pathError(tokens, ve))
+			return errs.Flatten()
+		}
+		return err
+	}
+	return nil
+}
+
+func setByPointer(ctx Context, m TypeMap, val reflect.Value, tokens []string, depth int, partial interface{}) error {
+	token := tokens[depth]
+
+	if depth < len(tokens)-1 {
+		child, childVal, ok := pointerChild(m, val, token, true)
+		if !ok {
+			return pointerNotFound(tokens[:depth+1])
+		}
+
+		err := setByPointer(ctx, child, childVal, tokens, depth+1, partial)
+		if err != nil {
+			return err
+		}
+
+		// Elements of maps are copies, so must be stored back
+		for val.Kind() == reflect.Ptr {
+			val = val.Elem()
+		}
+		if val.Kind() == reflect.Map {
+			val.SetMapIndex(reflect.ValueOf(token).Convert(val.Type().Key()), childVal)
+		}
+		return nil
+	}
+
+	for val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			if !val.CanSet() {
+				return pointerNotFound(tokens[:depth])
+			}
+			val.Set(reflect.New(val.Type().Elem()))
+		}
+		val = val.Elem()
+	}
+
+	switch tm := unwrapPointerMap(m).(type) {
+	case StructMap:
+		field, ok := pointerField(tm, token)
+		if !ok || val.Kind() != reflect.Struct {
+			break
+		}
+
+		if field.ReadOnly {
+			return NewValidationError("cannot set read-only field")
+		}
+
+		dstField := fieldByName(val, field.StructFieldName)
+		if partial == nil && field.skipsNull() {
+			dstField.Set(reflect.Zero(dstField.Type()))
+			return nil
+		}
+
+		err := tm.unmarshalField(ctx, &val, field, partial, dstField)
+		if err != nil {
+			return err
+		}
+		return nil
+
+	case SliceMap:
+		if val.Kind() != reflect.Slice {
+			break
+		}
+		i, ok := pointerIndex(token, val.Len())
+		if !ok {
+			break
+		}
+		return tm.Contains.Unmarshal(ctx, &val, partial, val.Index(i))
+
+	case MapMap:
+		if val.Kind() != reflect.Map {
+			break
+		}
+		if val.IsNil() {
+			val.Set(reflect.MakeMap(val.Type()))
+		}
+		elem := reflect.New(val.Type().Elem()).Elem()
+		err := tm.Contains.Unmarshal(ctx, &val, partial, elem)
+		if err != nil {
+			return err
+		}
+		val.SetMapIndex(reflect.ValueOf(token).Convert(val.Type().Key()), elem)
+		return nil
+	}
+
+	return pointerNotFound(tokens[:depth+1])
+}
@@ -0,0 +1,144 @@
+package jsonmap
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type protoJSONContextKey struct{}
+
+// WithProtoJSON marshals and unmarshals following the conventions of the
+// canonical JSON mapping of Protocol Buffers, as implemented by protojson, so
+// that REST endpoints and gRPC transcoding layers can share StructMaps:
+//
+//   - Fields are marshaled under the lowerCamelCase form of their JSON field
+//     names, such that "inner_thing" becomes "innerThing", and either form is
+//     accepted when unmarshaling. Errors name fields in lowerCamelCase.
+//   - 64 bit integers are marshaled as strings, and integers are accepted
+//     as strings when unmarshaling, without loss of precision.
+//   - Time() fields are marshaled in UTC, with 0, 3, 6 or 9 fractional
+//     digits, as are google.protobuf.Timestamp values.
+//   - Duration() fields are marshaled as seconds with an "s" suffix, such as
+//     "1.5s", as are google.protobuf.Duration values.
+//   - Enum() fields, which are already marshaled by name, also accept their
+//     numeric values.
+//
+// Unlike protojson, fields with zero values are still marshaled, as with
+// its EmitUnpopulated option. StaticMaps generated by GenerateStatic don't
+// support this mode.
+func WithProtoJSON(ctx Context) Context {
+	return NewCtx(ctx).With(protoJSONContextKey{}, true)
+}
+
+func isProtoJSON(ctx Context) bool {
+	c, ok := ctx.(*Ctx)
+	if !ok {
+		return false
+	}
+
+	_, ok = c.Get(protoJSONContextKey{})
+	return ok
+}
+
+// lowerCamel converts a snake_case name to lowerCamelCase, as protoc does to
+// derive the JSON names of fields.
+func lowerCamel(name string) string {
+	if !strings.Contains(name, "_") {
+		return name
+	}
+
+	var b strings.Builder
+	upper := false
+	for _, r := range name {
+		switch {
+		case r == '_':
+			upper = true
+		case upper && r >= 'a' && r <= 'z':
+			b.WriteRune(r - 'a' + 'A')
+			upper = false
+		default:
+			b.WriteRune(r)
+			upper = false
+		}
+	}
+	return b.String()
+}
+
+// wireName returns the name under which the field appears in documents
+// handled with ctx.
+func (f MappedField) wireName(ctx Context) string {
+	if isProtoJSON(ctx) {
+		return lowerCamel(f.JSONFieldName)
+	}
+	return f.JSONFieldName
+}
+
+// lookupField returns the value of the field in data, and the name under
+// which it was found.
+func (f MappedField) lookupField(ctx Context, data map[string]interface{}) (interface{}, string, bool) {
+	name := f.wireName(ctx)
+	if val, ok := data[name]; ok {
+		return val, name, true
+	}
+
+	if name != f.JSONFieldName {
+		val, ok := data[f.JSONFieldName]
+		return val, f.JSONFieldName, ok
+	}
+	return nil, name, false
+}
+
+// protoScalar returns the value to marshal in place of v: in ProtoJSON mode,
+// 64 bit integers are represented as strings.
+func protoScalar(ctx Context, v interface{}) interface{} {
+	if !isProtoJSON(ctx) {
+		return v
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10)
+	case reflect.Uint, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10)
+	}
+	return v
+}
+
+// protoFraction formats nanos as a fraction of a second with 0, 3, 6 or 9
+// digits, including the leading ".".
+func protoFraction(nanos int) string {
+	switch {
+	case nanos == 0:
+		return ""
+	case nanos%1e6 == 0:
+		return "." + strconv.Itoa(1000 + nanos/1e6)[1:]
+	case nanos%1e3 == 0:
+		return "." + strconv.Itoa(1000000 + nanos/1e3)[1:]
+	default:
+		return "." + strconv.Itoa(1000000000 + nanos)[1:]
+	}
+}
+
+// protoTimestamp formats t as a google.protobuf.Timestamp.
+func protoTimestamp(t time.Time) string {
+	t = t.UTC()
+	return t.Format("2006-01-02T15:04:05") + protoFraction(t.Nanosecond()) + "Z"
+}
+
+// protoDuration formats d as a google.protobuf.Duration.
+func protoDuration(d time.Duration) string {
+	sign := ""
+	if d < 0 {
+		sign = "-"
+	}
+
+	secs := d / time.Second
+	nanos := d % time.Second
+	if d < 0 {
+		secs, nanos = -secs, -nanos
+	}
+	return sign + strconv.FormatInt(int64(secs), 10) + protoFraction(int(nanos)) + "s"
+}
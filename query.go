@@ -3,10 +3,12 @@ package jsonmap
 import (
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"reflect"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -41,8 +43,16 @@ func (qm QueryMap) Encode(src interface{}, urlQuery map[string][]string) error {
 }
 
 // Taking a URL Query (or any string->[]string struct) and shoving it into the struct
-// as specified by qm.UnderlyingType
+// as specified by qm.UnderlyingType. dst may instead be a map[string]interface{}
+// (or a pointer to one), in which case the decoded value of each parameter is
+// stored under its name.
 func (qm QueryMap) Decode(urlQuery map[string][]string, dst interface{}) error {
+	if m, ok := queryMapDest(dst); ok {
+		return decodeMap(qm.ParameterMaps, m, func(param ParameterMap) ([]string, error) {
+			return queryValues(param, urlQuery)
+		})
+	}
+
 	// First sanity check to ensure that the struct passed in matches
 	// the struct the QueryMap was designed to handle
 	if reflect.ValueOf(dst).Elem().Type() != reflect.TypeOf(qm.UnderlyingType) {
@@ -57,13 +67,15 @@ func (qm QueryMap) Decode(urlQuery map[string][]string, dst interface{}) error {
 	for _, param := range qm.ParameterMaps {
 		field := dstVal.FieldByName(param.StructFieldName)
 
-		decodedParam, err := param.Mapper.Decode(urlQuery[param.ParameterName]...)
+		vals, err := queryValues(param, urlQuery)
 		if err != nil {
-			errs.AddError(NewValidationError("error ocurred while reading value (%s) into param %s: %s",
-				urlQuery[param.ParameterName],
-				param.StructFieldName,
-				err.Error(),
-			))
+			errs.AddError(NewValidationErrorWithField(param.ParameterName, err.Error()))
+			continue
+		}
+
+		decodedParam, err := param.Mapper.Decode(vals...)
+		if err != nil {
+			errs.AddError(paramError(param, vals, err))
 			continue
 		}
 
@@ -76,6 +88,33 @@ func (qm QueryMap) Decode(urlQuery map[string][]string, dst interface{}) error {
 	return errs
 }
 
+// DecodeTracked is like Decode, but additionally returns the name of each
+// mapped parameter that was present in urlQuery, even if empty, in the order
+// the parameters are mapped. This distinguishes, for example, "?count=0" from
+// count being absent without making the field a pointer.
+func (qm QueryMap) DecodeTracked(urlQuery map[string][]string, dst interface{}) ([]string, error) {
+	err := qm.Decode(urlQuery, dst)
+	if err != nil {
+		return nil, err
+	}
+
+	return presentParams(qm.ParameterMaps, urlQuery), nil
+}
+
+// presentParams returns the names of the given parameters which are present
+// in urlQuery.
+func presentParams(params []ParameterMap, urlQuery map[string][]string) []string {
+	present := []string{}
+	for _, param := range params {
+		// Values have already been decoded successfully
+		vals, _ := queryValues(param, urlQuery)
+		if len(vals) != 0 {
+			present = append(present, param.ParameterName)
+		}
+	}
+	return present
+}
+
 // This ignores the case of parameter name in favor of the canonical format of
 // http.Header
 func (qm QueryMap) EncodeHeader(src interface{}, headers http.Header) error {
@@ -93,14 +132,21 @@ func (qm QueryMap) EncodeHeader(src interface{}, headers http.Header) error {
 			return errors.New("error in encoding struct: " + err.Error())
 		}
 
-		// Not using .Set() because it only allows strings and not slices
-		headers[http.CanonicalHeaderKey(p.ParameterName)] = sliVal
+		setHeader(headers, p, sliVal)
 	}
 
 	return nil
 }
 
+// DecodeHeader is like Decode, but decodes headers. As with Decode, dst may be
+// a map[string]interface{}.
 func (qm QueryMap) DecodeHeader(headers http.Header, dst interface{}) error {
+	if m, ok := queryMapDest(dst); ok {
+		return decodeMap(qm.ParameterMaps, m, func(param ParameterMap) ([]string, error) {
+			return headerValues(param, headers), nil
+		})
+	}
+
 	if reflect.ValueOf(dst).Elem().Type() != reflect.TypeOf(qm.UnderlyingType) {
 		return errors.New("attempting to decode into the wrong struct")
 	}
@@ -117,15 +163,11 @@ func (qm QueryMap) DecodeHeader(headers http.Header, dst interface{}) error {
 	errs := &MultiValidationError{}
 	dstVal := reflect.ValueOf(dst).Elem()
 	for _, param := range qm.ParameterMaps {
-		headerVal := headers[http.CanonicalHeaderKey(param.ParameterName)]
+		headerVal := headerValues(param, headers)
 		field := dstVal.FieldByName(param.StructFieldName)
 		decodedHeader, err := param.Mapper.Decode(headerVal...)
 		if err != nil {
-			errs.AddError(NewValidationError("error ocurred while reading value (%s) into param %s: %s",
-				headerVal,
-				param.StructFieldName,
-				err.Error(),
-			))
+			errs.AddError(paramError(param, headerVal, err))
 			continue
 		}
 
@@ -138,6 +180,113 @@ func (qm QueryMap) DecodeHeader(headers http.Header, dst interface{}) error {
 	return errs
 }
 
+// queryMapDest returns the map into which parameters should be decoded, if
+// dst is a map[string]interface{} or a pointer to one, allocating the map if
+// necessary.
+func queryMapDest(dst interface{}) (map[string]interface{}, bool) {
+	switch d := dst.(type) {
+	case map[string]interface{}:
+		return d, true
+	case *map[string]interface{}:
+		if *d == nil {
+			*d = map[string]interface{}{}
+		}
+		return *d, true
+	default:
+		return nil, false
+	}
+}
+
+// decodeMap decodes the given parameters into m, keyed by their names.
+func decodeMap(params []ParameterMap, m map[string]interface{}, get func(ParameterMap) ([]string, error)) error {
+	errs := &MultiValidationError{}
+	for _, param := range params {
+		vals, err := get(param)
+		if err != nil {
+			errs.AddError(NewValidationErrorWithField(param.ParameterName, err.Error()))
+			continue
+		}
+
+		decodedParam, err := param.Mapper.Decode(vals...)
+		if err != nil {
+			errs.AddError(paramError(param, vals, err))
+			continue
+		}
+
+		m[param.ParameterName] = decodedParam
+	}
+
+	if len(errs.Errors()) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// paramError describes the failure of a parameter's Mapper to decode vals.
+// The values of parameters carrying credentials are left out, so that they
+// don't find their way into responses or logs.
+func paramError(param ParameterMap, vals []string, err error) *ValidationError {
+	var shown interface{} = vals
+	if _, ok := param.Mapper.(credentialsMapper); ok {
+		shown = "[REDACTED]"
+	}
+
+	return NewValidationErrorWithField(param.ParameterName, fmt.Sprintf("error ocurred while reading value (%s) into param %s: %s",
+		shown,
+		param.StructFieldName,
+		err.Error(),
+	))
+}
+
+// queryValues returns the values given for param in urlQuery. If its Mapper is
+// an IndexedQueryParameterMapper, values given using index syntax are returned
+// in the order of their indices, which must run from zero without gaps.
+func queryValues(param ParameterMap, urlQuery map[string][]string) ([]string, error) {
+	vals := urlQuery[param.ParameterName]
+
+	if _, ok := param.Mapper.(IndexedQueryParameterMapper); !ok {
+		return vals, nil
+	}
+
+	prefix := param.ParameterName + "["
+	byIndex := map[int]string{}
+	for key, indexedVals := range urlQuery {
+		if !strings.HasPrefix(key, prefix) || !strings.HasSuffix(key, "]") {
+			continue
+		}
+
+		index := key[len(prefix) : len(key)-1]
+		i, err := strconv.Atoi(index)
+		if err != nil || i < 0 || strconv.Itoa(i) != index {
+			return nil, NewValidationError("invalid index: %s", key)
+		}
+
+		if len(indexedVals) != 1 {
+			return nil, NewValidationError("too many values for index %d", i)
+		}
+		byIndex[i] = indexedVals[0]
+	}
+
+	if len(byIndex) == 0 {
+		return vals, nil
+	}
+
+	if len(vals) != 0 {
+		return nil, NewValidationError("values may not be given both with and without indices")
+	}
+
+	vals = make([]string, len(byIndex))
+	for i := range vals {
+		val, ok := byIndex[i]
+		if !ok {
+			return nil, NewValidationError("missing index %d", i)
+		}
+		vals[i] = val
+	}
+
+	return vals, nil
+}
+
 // ParameterMap corresponds to each field in a specific struct,
 // it requires struct's name and the corresponding key value in the URL query
 type ParameterMap struct {
@@ -333,8 +482,159 @@ func (uqpm UintQueryParameterMapper) Encode(src reflect.Value) ([]string, error)
 	}
 }
 
+// NumberQueryParameterMapper maps parameters to numbers of the given Kind,
+// which may be any of the int, uint and float kinds. Values which can't be
+// represented by the Kind are rejected, rather than silently truncated. It
+// may be used in place of IntQueryParameterMapper and
+// UintQueryParameterMapper, whose zero BitSize maps to int or uint. Kind
+// must be set: a mapper without one panics when decoding, and is rejected by
+// QueryMap.Compile.
+type NumberQueryParameterMapper struct {
+	Kind reflect.Kind
+}
+
+var numberQueryParameterTypes = map[reflect.Kind]reflect.Type{
+	reflect.Int:     reflect.TypeOf(int(0)),
+	reflect.Int8:    reflect.TypeOf(int8(0)),
+	reflect.Int16:   reflect.TypeOf(int16(0)),
+	reflect.Int32:   reflect.TypeOf(int32(0)),
+	reflect.Int64:   reflect.TypeOf(int64(0)),
+	reflect.Uint:    reflect.TypeOf(uint(0)),
+	reflect.Uint8:   reflect.TypeOf(uint8(0)),
+	reflect.Uint16:  reflect.TypeOf(uint16(0)),
+	reflect.Uint32:  reflect.TypeOf(uint32(0)),
+	reflect.Uint64:  reflect.TypeOf(uint64(0)),
+	reflect.Float32: reflect.TypeOf(float32(0)),
+	reflect.Float64: reflect.TypeOf(float64(0)),
+}
+
+func (nqpm NumberQueryParameterMapper) Decode(src ...string) (interface{}, error) {
+	t, ok := numberQueryParameterTypes[nqpm.Kind]
+	if !ok {
+		panic("NumberQueryParameterMapper Kind is not a number kind: " + nqpm.Kind.String())
+	}
+
+	if len(src) > 1 {
+		return nil, NewValidationError("too many values")
+	}
+
+	v := reflect.New(t).Elem()
+	if len(src) == 0 {
+		return v.Interface(), nil
+	}
+
+	overflow := NewValidationError("%s is out of range for %s", src[0], t)
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(src[0], 10, 64)
+		if errors.Is(err, strconv.ErrRange) {
+			return nil, overflow
+		}
+		if err != nil {
+			return nil, NewValidationError("param could not be converted to integer: %s", err.Error())
+		}
+		if v.OverflowInt(n) {
+			return nil, overflow
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(src[0], 10, 64)
+		if errors.Is(err, strconv.ErrRange) {
+			return nil, overflow
+		}
+		if err != nil {
+			return nil, NewValidationError("param could not be converted to unsigned integer: %s", err.Error())
+		}
+		if v.OverflowUint(n) {
+			return nil, overflow
+		}
+		v.SetUint(n)
+	default:
+		f, err := strconv.ParseFloat(src[0], 64)
+		if errors.Is(err, strconv.ErrRange) {
+			return nil, overflow
+		}
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, NewValidationError("param could not be converted to a finite number")
+		}
+		if v.OverflowFloat(f) {
+			return nil, overflow
+		}
+		v.SetFloat(f)
+	}
+
+	return v.Interface(), nil
+}
+
+func (nqpm NumberQueryParameterMapper) Encode(src reflect.Value) ([]string, error) {
+	if src.Kind() != nqpm.Kind {
+		return nil, fmt.Errorf("expected %s but got: %s", nqpm.Kind, src.Kind())
+	}
+
+	switch src.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return []string{strconv.FormatInt(src.Int(), 10)}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return []string{strconv.FormatUint(src.Uint(), 10)}, nil
+	case reflect.Float32:
+		return []string{strconv.FormatFloat(src.Float(), 'g', -1, 32)}, nil
+	case reflect.Float64:
+		return []string{strconv.FormatFloat(src.Float(), 'g', -1, 64)}, nil
+	default:
+		return nil, fmt.Errorf("expected a number but got: %s", src.Kind())
+	}
+}
+
 type TimeQueryParameterMapper struct {
 	Validators []func(time.Time) bool
+
+	// Layouts are the layouts, as understood by time.Parse, in which times
+	// are accepted, tried in order. If empty, times are accepted in RFC 3339
+	// format.
+	Layouts []string
+
+	// Location is the time zone of times given in layouts which don't
+	// specify one. If nil, UTC is assumed.
+	Location *time.Location
+
+	// AcceptEpochSeconds accepts integers as the number of seconds since the
+	// Unix epoch, in addition to times in any of the Layouts.
+	AcceptEpochSeconds bool
+
+	// Layout is the layout in which times are encoded. If empty, times are
+	// encoded in RFC 3339 format, with fractional seconds if non-zero. Times
+	// are converted to Location first, if it is set.
+	Layout string
+}
+
+func (tqpm TimeQueryParameterMapper) parse(s string) (time.Time, error) {
+	if tqpm.AcceptEpochSeconds {
+		if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return time.Unix(secs, 0).UTC(), nil
+		}
+	}
+
+	if len(tqpm.Layouts) == 0 {
+		t := time.Time{}
+		err := t.UnmarshalText([]byte(s))
+		return t, err
+	}
+
+	loc := tqpm.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	var err error
+	for _, layout := range tqpm.Layouts {
+		var t time.Time
+		t, err = time.ParseInLocation(layout, s, loc)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
 }
 
 func (tqpm TimeQueryParameterMapper) Decode(src ...string) (interface{}, error) {
@@ -347,7 +647,7 @@ func (tqpm TimeQueryParameterMapper) Decode(src ...string) (interface{}, error)
 		return t, nil
 	}
 
-	err := t.UnmarshalText([]byte(src[0]))
+	t, err := tqpm.parse(src[0])
 	if err != nil {
 		return nil, NewValidationError("param could not be marshalled to time.Time: %s", err.Error())
 	}
@@ -368,7 +668,16 @@ func (tqpm TimeQueryParameterMapper) Encode(src reflect.Value) ([]string, error)
 		return nil, fmt.Errorf("expected time.Time but got: %s", src.Type())
 	}
 
-	b, err := src.Interface().(time.Time).MarshalText()
+	t := src.Interface().(time.Time)
+	if tqpm.Location != nil {
+		t = t.In(tqpm.Location)
+	}
+
+	if tqpm.Layout != "" {
+		return []string{t.Format(tqpm.Layout)}, nil
+	}
+
+	b, err := t.MarshalText()
 	if err != nil {
 		return nil, err
 	}
@@ -415,6 +724,98 @@ func (sqpm StrSliceQueryParameterMapper) Encode(src reflect.Value) ([]string, er
 	return retSlice, nil
 }
 
+// IndexedQueryParameterMapper wraps a mapper of slices, such as
+// StrSliceQueryParameterMapper, so that as well as repeated parameters it
+// accepts values given using index syntax, as emitted by PHP and Rails
+// clients, e.g. ?ids[0]=a&ids[1]=b. Indices must run from zero without gaps.
+// It has no effect on headers, and values are always encoded as repeated
+// parameters.
+type IndexedQueryParameterMapper struct {
+	UnderlyingQueryParameterMapper QueryParameterMapper
+}
+
+func (iqpm IndexedQueryParameterMapper) Decode(src ...string) (interface{}, error) {
+	return iqpm.UnderlyingQueryParameterMapper.Decode(src...)
+}
+
+func (iqpm IndexedQueryParameterMapper) Encode(src reflect.Value) ([]string, error) {
+	return iqpm.UnderlyingQueryParameterMapper.Encode(src)
+}
+
+// HeaderListQueryParameterMapper wraps a mapper of slices, such as
+// StrSliceQueryParameterMapper, for headers whose values are comma separated
+// lists, which RFC 7230 allows to be sent either as a single line or as
+// several. When decoding headers, each line is split on commas outside of
+// quoted strings, and empty elements are dropped, before the values are
+// passed to the underlying mapper. It has no effect on query strings.
+type HeaderListQueryParameterMapper struct {
+	UnderlyingQueryParameterMapper QueryParameterMapper
+
+	// Join encodes values as a single comma separated header line, rather
+	// than one line each
+	Join bool
+}
+
+func (hlqpm HeaderListQueryParameterMapper) Decode(src ...string) (interface{}, error) {
+	return hlqpm.UnderlyingQueryParameterMapper.Decode(src...)
+}
+
+func (hlqpm HeaderListQueryParameterMapper) Encode(src reflect.Value) ([]string, error) {
+	return hlqpm.UnderlyingQueryParameterMapper.Encode(src)
+}
+
+// headerValues returns the values given for param in headers, split into list
+// elements if its Mapper is a HeaderListQueryParameterMapper.
+func headerValues(param ParameterMap, headers http.Header) []string {
+	vals := headers[http.CanonicalHeaderKey(param.ParameterName)]
+
+	if _, ok := param.Mapper.(HeaderListQueryParameterMapper); !ok {
+		return vals
+	}
+
+	var elems []string
+	for _, val := range vals {
+		elems = append(elems, splitHeaderList(val)...)
+	}
+	return elems
+}
+
+// splitHeaderList splits a comma separated header value into its non-empty
+// elements, leaving commas within quoted strings alone.
+func splitHeaderList(val string) []string {
+	var elems []string
+	start := 0
+	quoted := false
+
+	for i := 0; i <= len(val); i++ {
+		switch {
+		case i == len(val) || (val[i] == ',' && !quoted):
+			elem := strings.TrimSpace(val[start:i])
+			if elem != "" {
+				elems = append(elems, elem)
+			}
+			start = i + 1
+		case val[i] == '"':
+			quoted = !quoted
+		case val[i] == '\\' && quoted && i+1 < len(val):
+			i++
+		}
+	}
+	return elems
+}
+
+// setHeader sets the encoded values of param in headers, joining them into a
+// single line if its Mapper is a HeaderListQueryParameterMapper which asks
+// for it.
+func setHeader(headers http.Header, param ParameterMap, vals []string) {
+	if hl, ok := param.Mapper.(HeaderListQueryParameterMapper); ok && hl.Join && len(vals) > 1 {
+		vals = []string{strings.Join(vals, ", ")}
+	}
+
+	// Not using .Set() because it only allows strings and not slices
+	headers[http.CanonicalHeaderKey(param.ParameterName)] = vals
+}
+
 type StrPointerQueryParameterMapper struct {
 	UnderlyingQueryParameterMapper QueryParameterMapper
 }
@@ -438,3 +839,228 @@ func (pqpm StrPointerQueryParameterMapper) Encode(src reflect.Value) ([]string,
 	}
 	return []string{src.Elem().String()}, nil
 }
+
+// ComposeQueryMaps combines several QueryMaps into one, so that shared
+// parameter sets (pagination, sorting, tenancy headers, etc) can be defined
+// once and embedded into many endpoint specific QueryMaps. Shared sets will
+// typically leave UnderlyingType unset, and their StructFieldNames resolve
+// against the composed UnderlyingType, which is taken from whichever map
+// specifies one. Parameters are applied in the order the maps are given.
+func ComposeQueryMaps(maps ...QueryMap) QueryMap {
+	composed := QueryMap{}
+	seen := map[string]struct{}{}
+
+	for _, m := range maps {
+		if m.UnderlyingType != nil {
+			if composed.UnderlyingType != nil && reflect.TypeOf(composed.UnderlyingType) != reflect.TypeOf(m.UnderlyingType) {
+				panic("cannot compose QueryMaps with different underlying types: " +
+					reflect.TypeOf(composed.UnderlyingType).String() + ", " +
+					reflect.TypeOf(m.UnderlyingType).String())
+			}
+			composed.UnderlyingType = m.UnderlyingType
+		}
+
+		for _, p := range m.ParameterMaps {
+			if _, ok := seen[p.ParameterName]; ok {
+				panic("duplicate parameter in composed QueryMap: " + p.ParameterName)
+			}
+			seen[p.ParameterName] = struct{}{}
+			composed.ParameterMaps = append(composed.ParameterMaps, p)
+		}
+	}
+
+	return composed
+}
+
+type compiledParameterMap struct {
+	ParameterMap
+	index []int
+}
+
+// CompiledQueryMap is a QueryMap whose struct fields have been resolved ahead
+// of time. Use QueryMap.Compile() to construct one.
+type CompiledQueryMap struct {
+	underlyingType reflect.Type
+	params         []compiledParameterMap
+}
+
+// Compile resolves the struct field backing each ParameterMap, verifying that
+// it exists and that the values produced by its Mapper can be assigned to it.
+// The resulting CompiledQueryMap avoids looking fields up by name on every
+// call.
+func (qm QueryMap) Compile() (*CompiledQueryMap, error) {
+	t := reflect.TypeOf(qm.UnderlyingType)
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, errors.New("QueryMap UnderlyingType must be a struct")
+	}
+
+	cqm := &CompiledQueryMap{
+		underlyingType: t,
+		params:         make([]compiledParameterMap, 0, len(qm.ParameterMaps)),
+	}
+
+	for _, p := range qm.ParameterMaps {
+		if p.Mapper == nil {
+			return nil, fmt.Errorf("no Mapper specified for param %s", p.ParameterName)
+		}
+
+		field, ok := t.FieldByName(p.StructFieldName)
+		if !ok {
+			return nil, fmt.Errorf("no such underlying field: %s", p.StructFieldName)
+		}
+
+		// A NumberQueryParameterMapper without a number Kind would panic when
+		// its zero value is decoded below
+		if nm, ok := p.Mapper.(NumberQueryParameterMapper); ok {
+			if _, ok := numberQueryParameterTypes[nm.Kind]; !ok {
+				return nil, fmt.Errorf("param %s has a NumberQueryParameterMapper whose Kind is not a number kind: %s", p.ParameterName, nm.Kind)
+			}
+		}
+
+		// Decoding an absent parameter yields the zero value of whatever type
+		// the Mapper produces, which lets us check assignability up front.
+		// Mappers which refuse to decode nothing can't be checked this way.
+		if zero, err := p.Mapper.Decode(); err == nil {
+			zt := reflect.TypeOf(zero)
+			if zt == nil || !zt.AssignableTo(field.Type) {
+				return nil, fmt.Errorf("param %s decodes to %v, which cannot be assigned to field %s of type %s",
+					p.ParameterName,
+					zt,
+					p.StructFieldName,
+					field.Type,
+				)
+			}
+		}
+
+		cqm.params = append(cqm.params, compiledParameterMap{
+			ParameterMap: p,
+			index:        field.Index,
+		})
+	}
+
+	return cqm, nil
+}
+
+// MustCompile is like Compile but panics if the QueryMap is invalid. It is
+// intended for use when initializing package level variables.
+func (qm QueryMap) MustCompile() *CompiledQueryMap {
+	cqm, err := qm.Compile()
+	if err != nil {
+		panic(err)
+	}
+	return cqm
+}
+
+func (cqm *CompiledQueryMap) checkDst(dst interface{}) (reflect.Value, error) {
+	dstVal := reflect.ValueOf(dst)
+	if dstVal.Kind() != reflect.Ptr || dstVal.Type().Elem() != cqm.underlyingType {
+		return reflect.Value{}, fmt.Errorf("attempting to decode into mismatched struct: expected %s but got %T",
+			cqm.underlyingType,
+			dst,
+		)
+	}
+	if dstVal.IsNil() {
+		return reflect.Value{}, fmt.Errorf("attempting to decode into nil %T", dst)
+	}
+	return dstVal.Elem(), nil
+}
+
+func (cqm *CompiledQueryMap) encode(src interface{}, set func(ParameterMap, []string)) error {
+	srcVal := reflect.ValueOf(src)
+
+	for _, p := range cqm.params {
+		fieldVal := srcVal.FieldByIndex(p.index)
+
+		if fieldVal.IsZero() && p.OmitEmpty {
+			continue
+		}
+
+		strVal, err := p.Mapper.Encode(fieldVal)
+		if err != nil {
+			return errors.New("error in encoding struct: " + err.Error())
+		}
+
+		set(p.ParameterMap, strVal)
+	}
+
+	return nil
+}
+
+func (cqm *CompiledQueryMap) decode(dst interface{}, get func(ParameterMap) ([]string, error)) error {
+	if m, ok := queryMapDest(dst); ok {
+		return decodeMap(cqm.parameterMaps(), m, get)
+	}
+
+	dstVal, err := cqm.checkDst(dst)
+	if err != nil {
+		return err
+	}
+
+	errs := &MultiValidationError{}
+	for _, param := range cqm.params {
+		vals, err := get(param.ParameterMap)
+		if err != nil {
+			errs.AddError(NewValidationErrorWithField(param.ParameterName, err.Error()))
+			continue
+		}
+
+		decodedParam, err := param.Mapper.Decode(vals...)
+		if err != nil {
+			errs.AddError(paramError(param.ParameterMap, vals, err))
+			continue
+		}
+
+		dstVal.FieldByIndex(param.index).Set(reflect.ValueOf(decodedParam))
+	}
+
+	if len(errs.Errors()) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// Encode behaves like QueryMap.Encode.
+func (cqm *CompiledQueryMap) Encode(src interface{}, urlQuery map[string][]string) error {
+	return cqm.encode(src, func(param ParameterMap, vals []string) {
+		urlQuery[param.ParameterName] = vals
+	})
+}
+
+// Decode behaves like QueryMap.Decode.
+func (cqm *CompiledQueryMap) Decode(urlQuery map[string][]string, dst interface{}) error {
+	return cqm.decode(dst, func(param ParameterMap) ([]string, error) {
+		return queryValues(param, urlQuery)
+	})
+}
+
+// DecodeTracked behaves like QueryMap.DecodeTracked.
+func (cqm *CompiledQueryMap) DecodeTracked(urlQuery map[string][]string, dst interface{}) ([]string, error) {
+	err := cqm.Decode(urlQuery, dst)
+	if err != nil {
+		return nil, err
+	}
+
+	return presentParams(cqm.parameterMaps(), urlQuery), nil
+}
+
+func (cqm *CompiledQueryMap) parameterMaps() []ParameterMap {
+	params := make([]ParameterMap, len(cqm.params))
+	for i, param := range cqm.params {
+		params[i] = param.ParameterMap
+	}
+	return params
+}
+
+// EncodeHeader behaves like QueryMap.EncodeHeader.
+func (cqm *CompiledQueryMap) EncodeHeader(src interface{}, headers http.Header) error {
+	return cqm.encode(src, func(param ParameterMap, vals []string) {
+		setHeader(headers, param, vals)
+	})
+}
+
+// DecodeHeader behaves like QueryMap.DecodeHeader.
+func (cqm *CompiledQueryMap) DecodeHeader(headers http.Header, dst interface{}) error {
+	return cqm.decode(dst, func(param ParameterMap) ([]string, error) {
+		return headerValues(param, headers), nil
+	})
+}
@@ -0,0 +1,26 @@
+package jsonmap
+
+// RedactedValue replaces the values of fields marked Redact when marshaled by
+// MarshalForLogging.
+var RedactedValue = "[REDACTED]"
+
+type redactContextKey struct{}
+
+func redacts(ctx Context) bool {
+	c, ok := ctx.(*Ctx)
+	if !ok {
+		return false
+	}
+
+	_, ok = c.Get(redactContextKey{})
+	return ok
+}
+
+// MarshalForLogging is like Marshal, but replaces the value of every field
+// marked Redact, at any depth, with RedactedValue, so that requests and
+// responses can be logged without exposing secrets. The document is otherwise
+// unchanged, so a redacted field which is empty is still left out if it is
+// marked OmitEmpty.
+func (tm *TypeMapper) MarshalForLogging(ctx Context, src interface{}) ([]byte, error) {
+	return tm.Marshal(NewCtx(ctx).With(redactContextKey{}, true), src)
+}
@@ -0,0 +1,112 @@
+package jsonmap
+
+import (
+	"reflect"
+	"sync"
+)
+
+// Override returns a copy of the TypeMapper in which the given TypeMaps
+// replace any registered for the same types, leaving the original untouched.
+// Only the TypeMaps used for top level values are replaced: a TypeMap
+// nested within another via Contains continues to be used by its container.
+func (tm *TypeMapper) Override(maps ...RegisterableTypeMap) *TypeMapper {
+	t := &TypeMapper{
+		typeMaps:          make(map[reflect.Type]TypeMap, len(tm.typeMaps)+len(maps)),
+		FailFast:          tm.FailFast,
+		EmptyNilMaps:      tm.EmptyNilMaps,
+		MergeMode:         tm.MergeMode,
+		ValidateOnMarshal: tm.ValidateOnMarshal,
+		UnmappedTypes:     tm.UnmappedTypes,
+		LegacyMarshal:     tm.LegacyMarshal,
+	}
+
+	for k, v := range tm.typeMaps {
+		t.typeMaps[k] = v
+	}
+
+	visited := map[TypeMap]bool{}
+	for _, m := range maps {
+		t.typeMaps[m.GetUnderlyingType()] = m
+		warmFieldCache(m, visited)
+	}
+	return t
+}
+
+// Registry holds TypeMappers by name, so that different tenants or API
+// surfaces may map the same types differently, with the TypeMapper to use
+// chosen at request time. It is safe for concurrent use.
+type Registry struct {
+	base    *TypeMapper
+	mu      sync.RWMutex
+	mappers map[string]*TypeMapper
+}
+
+// NewRegistry returns a Registry which resolves names for which nothing has
+// been registered to the given base TypeMapper.
+func NewRegistry(base *TypeMapper) *Registry {
+	return &Registry{
+		base:    base,
+		mappers: map[string]*TypeMapper{},
+	}
+}
+
+// Register registers tm under the given name, replacing any TypeMapper
+// previously registered under it.
+func (r *Registry) Register(name string, tm *TypeMapper) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mappers[name] = tm
+}
+
+// Override registers under the given name a copy of the base TypeMapper in
+// which the given TypeMaps replace those registered for the same types, and
+// returns it. See TypeMapper.Override().
+func (r *Registry) Override(name string, maps ...RegisterableTypeMap) *TypeMapper {
+	tm := r.base.Override(maps...)
+	r.Register(name, tm)
+	return tm
+}
+
+// Get returns the TypeMapper registered under the given name, or the base
+// TypeMapper if there is none.
+func (r *Registry) Get(name string) *TypeMapper {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if tm, ok := r.mappers[name]; ok {
+		return tm
+	}
+	return r.base
+}
+
+// Resolve returns the TypeMapper registered under the name specified by
+// WithMapperName, or the base TypeMapper if no name is specified.
+func (r *Registry) Resolve(ctx Context) *TypeMapper {
+	name, ok := MapperNameOf(ctx)
+	if !ok {
+		return r.base
+	}
+	return r.Get(name)
+}
+
+type mapperNameContextKey struct{}
+
+// WithMapperName returns a Ctx specifying the name, such as of a tenant,
+// under which Registry.Resolve() finds the TypeMapper to use.
+func WithMapperName(ctx Context, name string) *Ctx {
+	return NewCtx(ctx).With(mapperNameContextKey{}, name)
+}
+
+// MapperNameOf returns the name specified by WithMapperName, if any.
+func MapperNameOf(ctx Context) (string, bool) {
+	c, ok := ctx.(*Ctx)
+	if !ok {
+		return "", false
+	}
+
+	v, ok := c.Get(mapperNameContextKey{})
+	if !ok {
+		return "", false
+	}
+	return v.(string), true
+}
@@ -0,0 +1,97 @@
+package jsonmap_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/russellhaering/jsonmap"
+	"github.com/russellhaering/jsonmap/jsonmaptest"
+	"github.com/stretchr/testify/require"
+)
+
+// Round trips are tested from outside the package, as jsonmaptest imports it.
+
+func durationTypeMap(format jsonmap.DurationFormat) jsonmap.StructMap {
+	return jsonmap.StructMap{
+		UnderlyingType: jsonmap.ThingWithDuration{},
+		Fields: []jsonmap.MappedField{
+			{
+				StructFieldName: "Timeout",
+				JSONFieldName:   "timeout",
+				Contains:        jsonmap.Duration(format),
+			},
+		},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+
+	nested := &jsonmap.NestedContainerThing{}
+	err := jsonmap.NewTypeMapper(jsonmap.NestedContainerThingTypeMap).UnmarshalReader(jsonmap.EmptyContext, bytes.NewReader([]byte(`{`+
+		`"slices_by_key":{"a":[{"foo":"a0"},{"foo":"a1"}]},`+
+		`"maps_in_slice":[{"k":{"foo":"k"}},{"n":null}],`+
+		`"pointer_to_slice":[{"foo":"p"}],`+
+		`"pointer_to_map":{"m":{"foo":"m"}},`+
+		`"slice_pointers":{"s":[{"foo":"s"}],"t":null},`+
+		`"named_keys":{"x":[[{"foo":"x"}]]},`+
+		`"dynamic":{"d":[1,2]},`+
+		`"maps_in_slice_by_key":{"q":[{"r":{"foo":"r"}}]}}`)), nested)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name string
+		tm   jsonmap.RegisterableTypeMap
+		ctx  jsonmap.Context
+		v    interface{}
+	}{
+		{
+			name: "encrypted",
+			tm:   jsonmap.PatientTypeMap,
+			ctx:  jsonmap.PatientContext,
+			v:    &jsonmap.Patient{Name: "Ann", Contact: jsonmap.InnerThing{Foo: "bar", AnInt: 3, ABool: true}},
+		},
+		{
+			name: "claims",
+			tm:   jsonmap.SessionClaimsTypeMap,
+			v: &jsonmap.SessionClaims{
+				RegisteredClaims: jsonmap.RegisteredClaims{
+					Subject:   "user-1",
+					Audience:  []string{"api"},
+					ExpiresAt: now.Add(time.Hour),
+					IssuedAt:  now,
+				},
+				Scope: "read",
+			},
+		},
+		{
+			name: "duration string",
+			tm:   durationTypeMap(jsonmap.DurationString),
+			v:    &jsonmap.ThingWithDuration{Timeout: 90500 * time.Millisecond},
+		},
+		{
+			name: "duration seconds",
+			tm:   durationTypeMap(jsonmap.DurationSeconds),
+			v:    &jsonmap.ThingWithDuration{Timeout: 90500 * time.Millisecond},
+		},
+		{
+			name: "duration milliseconds",
+			tm:   durationTypeMap(jsonmap.DurationMilliseconds),
+			v:    &jsonmap.ThingWithDuration{Timeout: 90500 * time.Millisecond},
+		},
+		{
+			name: "nested containers",
+			tm:   jsonmap.NestedContainerThingTypeMap,
+			v:    nested,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, legacy := range []bool{false, true} {
+				tm := jsonmap.NewTypeMapper(tc.tm)
+				tm.LegacyMarshal = legacy
+				jsonmaptest.RequireRoundTrip(t, tm, tc.ctx, tc.v)
+			}
+		})
+	}
+}
@@ -0,0 +1,520 @@
+package jsonmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	dynamicMapType     = reflect.TypeOf(map[string]interface{}(nil))
+	interfaceType      = reflect.TypeOf((*interface{})(nil)).Elem()
+	interfaceSliceType = reflect.TypeOf([]interface{}(nil))
+)
+
+// DynamicMap maps JSON objects to map[string]interface{} values, validating
+// their fields as a StructMap would. Each field's JSONFieldName is used as
+// its key in the map, and its StructFieldName and StructGetterName are
+// ignored. Fields which aren't present are left out of the map, and are
+// likewise left out when marshaling.
+type DynamicMap struct {
+	Fields []MappedField
+}
+
+func (dm *DynamicMap) GetUnderlyingType() reflect.Type {
+	return dynamicMapType
+}
+
+func (dm *DynamicMap) Unmarshal(ctx Context, parent *reflect.Value, partial interface{}, dstValue reflect.Value) error {
+	if partial == nil && (dstValue.Kind() == reflect.Interface || dstValue.Kind() == reflect.Ptr) {
+		return nil
+	}
+
+	data, ok := partial.(map[string]interface{})
+	if !ok {
+		return NewValidationError("expected an object")
+	}
+
+	result := map[string]interface{}{}
+	resultValue := reflect.ValueOf(result)
+	errs := &ValidationError{}
+
+	for _, field := range dm.Fields {
+		if field.ReadOnly || !field.activeFor(ctx) {
+			continue
+		}
+
+		val, ok := data[field.JSONFieldName]
+		if !ok {
+			if !field.Optional {
+				errs.AddError(NewValidationErrorWithField(field.JSONFieldName, "missing required field"))
+			}
+			continue
+		}
+
+		if val == nil && field.skipsNull() {
+			continue
+		}
+
+		fieldCtx := pathContext(ctx, field.JSONFieldName)
+
+		var err error
+		if field.Contains != nil {
+			dst := reflect.New(interfaceType).Elem()
+			err = field.Contains.Unmarshal(expansionContext(fieldCtx, field.JSONFieldName), &resultValue, val, dst)
+			val = dst.Interface()
+		} else if field.Validator != nil {
+			val, err = ValidateWithContext(fieldCtx, field.Validator, val)
+		} else {
+			panic("Field must have Contains or Validator: " + field.JSONFieldName)
+		}
+
+		if err != nil {
+			errs.AddError(fieldError(field.JSONFieldName, err))
+			continue
+		}
+
+		result[field.JSONFieldName] = val
+	}
+
+	if len(errs.NestedErrors) != 0 {
+		return errs
+	}
+
+	dstValue.Set(resultValue)
+	return nil
+}
+
+func (dm *DynamicMap) Marshal(ctx Context, parent *reflect.Value, src reflect.Value) (json.Marshaler, error) {
+	buf := &bytes.Buffer{}
+	err := dm.marshalTo(ctx, parent, src, buf)
+	if err != nil {
+		return nil, err
+	}
+	return RawMessage{buf.Bytes()}, nil
+}
+
+func (dm *DynamicMap) marshalTo(ctx Context, parent *reflect.Value, src reflect.Value, buf *bytes.Buffer) error {
+	if src.Kind() == reflect.Interface || src.Kind() == reflect.Ptr {
+		if src.IsNil() {
+			buf.Write(nullJSONValue)
+			return nil
+		}
+		src = src.Elem()
+	}
+
+	data, ok := src.Interface().(map[string]interface{})
+	if !ok {
+		panic("source for jsonmap.DynamicMap is not a map[string]interface{}")
+	}
+
+	if data == nil {
+		buf.Write(nullJSONValue)
+		return nil
+	}
+
+	buf.WriteByte('{')
+
+	written := 0
+	for _, field := range dm.Fields {
+		if !field.activeFor(ctx) {
+			continue
+		}
+
+		val, ok := data[field.JSONFieldName]
+		if !ok {
+			continue
+		}
+
+		if written != 0 {
+			buf.WriteByte(',')
+		}
+		written++
+
+		err := marshalValueTo(field.JSONFieldName, buf)
+		if err != nil {
+			return err
+		}
+
+		buf.WriteByte(':')
+
+		if field.Redact && redacts(ctx) {
+			err = marshalValueTo(RedactedValue, buf)
+		} else if field.Contains == nil || val == nil {
+			err = marshalValueTo(val, buf)
+		} else {
+			err = marshalTo(expansionContext(ctx, field.JSONFieldName), field.Contains, &src, reflect.ValueOf(val), buf)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	buf.WriteByte('}')
+
+	return nil
+}
+
+// schemaDocument is the declarative definition parsed by ParseSchema.
+type schemaDocument struct {
+	Types map[string]struct {
+		Fields []schemaField `yaml:"fields"`
+	} `yaml:"types"`
+}
+
+type schemaField struct {
+	Name     string       `yaml:"name"`
+	Field    string       `yaml:"field"`
+	Type     string       `yaml:"type"`
+	Optional bool         `yaml:"optional"`
+	ReadOnly bool         `yaml:"readOnly"`
+	Redact   bool         `yaml:"redact"`
+	Min      *int64       `yaml:"min"`
+	Max      *int64       `yaml:"max"`
+	Pattern  string       `yaml:"pattern"`
+	Enum     []string     `yaml:"enum"`
+	Items    *schemaField `yaml:"items"`
+
+	Validators []string               `yaml:"validators"`
+	Options    map[string]interface{} `yaml:"options"`
+}
+
+// Schema is a set of named TypeMaps built from a declarative definition. See
+// ParseSchema.
+type Schema struct {
+	maps map[string]RegisterableTypeMap
+}
+
+// TypeMap returns the TypeMap for the named type.
+func (s *Schema) TypeMap(name string) (RegisterableTypeMap, bool) {
+	m, ok := s.maps[name]
+	return m, ok
+}
+
+// TypeMapper returns a TypeMapper with the TypeMaps for the named types
+// registered. At most one of them may target map[string]interface{}.
+func (s *Schema) TypeMapper(names ...string) (*TypeMapper, error) {
+	maps := make([]RegisterableTypeMap, 0, len(names))
+	for _, name := range names {
+		m, ok := s.maps[name]
+		if !ok {
+			return nil, fmt.Errorf("no such type: %s", name)
+		}
+		maps = append(maps, m)
+	}
+	return NewTypeMapper(maps...), nil
+}
+
+// LoadSchema reads the file at path and parses it with ParseSchema. As the
+// TypeMaps are built at runtime, validation rules may be changed without
+// redeploying, for instance by loading the file again when it changes and
+// registering the new TypeMapper with a Registry.
+func LoadSchema(path string, goTypes map[string]interface{}) (*Schema, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSchema(data, goTypes)
+}
+
+// ParseSchema builds TypeMaps from a declarative definition in YAML or JSON,
+// such as:
+//
+//	types:
+//	  Dog:
+//	    fields:
+//	      - {name: name, field: Name, type: string, min: 1, max: 40}
+//	      - {name: breed, field: Breed, type: string, enum: [lab, pug]}
+//	      - {name: tags, field: Tags, type: list, max: 10, items: {type: string}}
+//	      - {name: owner, field: Owner, type: Person, optional: true}
+//
+// Each field has a JSON name, and the name of the struct field it is mapped
+// to. Its type is one of "string" (with optional min and max lengths, a
+// regular expression pattern, or enum of allowed values), "integer" (with
+// optional min and max values), "boolean", "uuid", "any", "list" or "map"
+// (whose elements are described by items, and a list's length bounded by min
+// and max), or the name of another type in the definition. Fields of the
+// primitive types may additionally list validators registered with
+// RegisterValidators by name, which are applied in order after those implied
+// by the type, e.g. {name: slug, field: Slug, type: string, validators: [slug]}.
+// A field's type may also be one registered with RegisterSchemaTypes, which
+// is given the field's options, e.g. {name: price, type: money, options:
+// {currency: EUR}}. Types in the definition take precedence.
+//
+// Types named in goTypes are mapped by StructMaps to the given Go type, and
+// all others by DynamicMaps to map[string]interface{}, in which case the
+// field key may be omitted. The struct fields must be able to hold the values
+// of the types given for them, or an error is returned.
+func ParseSchema(data []byte, goTypes map[string]interface{}) (*Schema, error) {
+	doc := schemaDocument{}
+	err := yaml.UnmarshalStrict(data, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create every TypeMap up front, so that types may refer to each other
+	// regardless of order, or recursively
+	s := &Schema{maps: map[string]RegisterableTypeMap{}}
+	for name := range doc.Types {
+		if t, ok := goTypes[name]; ok {
+			if reflect.TypeOf(t).Kind() != reflect.Struct {
+				return nil, fmt.Errorf("type %s: %s is not a struct", name, reflect.TypeOf(t))
+			}
+			s.maps[name] = &StructMap{UnderlyingType: t}
+		} else {
+			s.maps[name] = &DynamicMap{}
+		}
+	}
+
+	names := make([]string, 0, len(doc.Types))
+	for name := range doc.Types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		def := doc.Types[name]
+		fields := make([]MappedField, 0, len(def.Fields))
+		for _, f := range def.Fields {
+			field, err := s.mappedField(name, f)
+			if err != nil {
+				return nil, fmt.Errorf("type %s: field %s: %v", name, f.Name, err)
+			}
+			fields = append(fields, field)
+		}
+
+		switch m := s.maps[name].(type) {
+		case *StructMap:
+			m.Fields = fields
+		case *DynamicMap:
+			m.Fields = fields
+		}
+	}
+
+	return s, nil
+}
+
+func (s *Schema) mappedField(typeName string, f schemaField) (MappedField, error) {
+	if f.Name == "" {
+		return MappedField{}, fmt.Errorf("missing name")
+	}
+
+	var fieldType reflect.Type
+	if sm, ok := s.maps[typeName].(*StructMap); ok {
+		if f.Field == "" {
+			return MappedField{}, fmt.Errorf("missing struct field")
+		}
+		sf, ok := sm.GetUnderlyingType().FieldByName(f.Field)
+		if !ok {
+			return MappedField{}, fmt.Errorf("no such struct field: %s", f.Field)
+		}
+		fieldType = sf.Type
+	}
+
+	tm, err := s.typeMap(f)
+	if err != nil {
+		return MappedField{}, err
+	}
+
+	if fieldType != nil {
+		err = s.checkFieldType(f, tm, fieldType)
+		if err != nil {
+			return MappedField{}, err
+		}
+	}
+
+	return MappedField{
+		StructFieldName: f.Field,
+		JSONFieldName:   f.Name,
+		Contains:        tm,
+		Optional:        f.Optional,
+		ReadOnly:        f.ReadOnly,
+		Redact:          f.Redact,
+	}, nil
+}
+
+// checkFieldType returns an error if values produced by tm, the TypeMap of
+// f, can't be stored in a struct field of type t, so that a mismatch is
+// reported when the definition is parsed rather than as a panic when a
+// document is unmarshaled.
+func (s *Schema) checkFieldType(f schemaField, tm TypeMap, t reflect.Type) error {
+	// The type of values produced by validators given by name isn't known,
+	// so the field is checked against those implied by its type
+	if len(f.Validators) > 0 {
+		f.Validators = nil
+		var err error
+		tm, err = s.typeMap(f)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Other types in the definition are checked in their own right
+	l := &linter{
+		report:  &LintReport{},
+		visited: map[reflect.Type]bool{},
+	}
+	for _, m := range s.maps {
+		if sm, ok := m.(*StructMap); ok {
+			l.visited[sm.GetUnderlyingType()] = true
+		}
+	}
+
+	l.typeMap(tm, t, nil)
+	if len(l.report.Issues) > 0 {
+		return fmt.Errorf("%s", l.report.Issues[0].Message)
+	}
+	return nil
+}
+
+func (s *Schema) typeMap(f schemaField) (TypeMap, error) {
+	_, registered := lookupSchemaType(f.Type)
+	if _, local := s.maps[f.Type]; f.Options != nil && (!registered || local) {
+		return nil, fmt.Errorf("options may only be given for registered types")
+	}
+
+	var v Validator
+	switch f.Type {
+	case "string":
+		sv := String(int(boundOr(f.Min, 0)), int(boundOr(f.Max, math.MaxInt32)))
+		if f.Pattern != "" {
+			re, err := regexp.Compile(f.Pattern)
+			if err != nil {
+				return nil, err
+			}
+			sv.Regex(re)
+		}
+		v = sv
+		if len(f.Enum) > 0 {
+			v = AllOf(sv, OneOf(f.Enum...))
+		}
+	case "integer":
+		v = Integer(boundOr(f.Min, math.MinInt64), boundOr(f.Max, math.MaxInt64))
+	case "boolean":
+		v = Boolean()
+	case "uuid":
+		v = UUIDString()
+	case "any":
+		v = Interface()
+	case "list", "map":
+		if len(f.Validators) > 0 {
+			return nil, fmt.Errorf("validators may only be applied to primitive types")
+		}
+
+		if f.Items == nil {
+			return nil, fmt.Errorf("%s requires items", f.Type)
+		}
+
+		elem, err := s.typeMap(*f.Items)
+		if err != nil {
+			return nil, err
+		}
+
+		if f.Type == "map" {
+			return MapOf(elem), nil
+		}
+
+		return SliceOfRange(elem, int(boundOr(f.Min, 0)), int(boundOr(f.Max, math.MaxInt32))), nil
+	case "":
+		return nil, fmt.Errorf("missing type")
+	default:
+		if len(f.Validators) > 0 {
+			return nil, fmt.Errorf("validators may only be applied to primitive types")
+		}
+
+		if m, ok := s.maps[f.Type]; ok {
+			return m, nil
+		}
+
+		build, ok := lookupSchemaType(f.Type)
+		if !ok {
+			return nil, fmt.Errorf("unknown type: %s", f.Type)
+		}
+
+		options := f.Options
+		if options == nil {
+			options = map[string]interface{}{}
+		}
+		return build(options)
+	}
+
+	if len(f.Validators) > 0 {
+		all := []Validator{v}
+		for _, name := range f.Validators {
+			named, ok := LookupValidator(name)
+			if !ok {
+				return nil, fmt.Errorf("unknown validator: %s", name)
+			}
+			all = append(all, named)
+		}
+		v = AllOf(all...)
+	}
+
+	return NewPrimitiveMap(v), nil
+}
+
+// SchemaTypeBuilder builds the TypeMap for a field whose type was registered
+// with RegisterSchemaTypes, given the options of the field, or an empty map.
+// Errors it returns are reported by ParseSchema against the field.
+type SchemaTypeBuilder func(options map[string]interface{}) (TypeMap, error)
+
+// schemaTypes are the types registered with RegisterSchemaTypes.
+var (
+	schemaTypesMu sync.RWMutex
+	schemaTypes   = map[string]SchemaTypeBuilder{}
+)
+
+// RegisterSchemaTypes makes additional field types, such as "money" or
+// "geojson", available to definitions parsed by ParseSchema, so that other
+// packages can provide their own TypeMaps. As with RegisterValidators, only
+// definitions parsed afterwards see the types, and registering a name again
+// replaces its builder. Registering one of the built in types panics.
+func RegisterSchemaTypes(types map[string]SchemaTypeBuilder) {
+	schemaTypesMu.Lock()
+	defer schemaTypesMu.Unlock()
+
+	for name, build := range types {
+		switch name {
+		case "string", "integer", "boolean", "uuid", "any", "list", "map", "":
+			panic("cannot register built in schema type: " + name)
+		}
+		schemaTypes[name] = build
+	}
+}
+
+func lookupSchemaType(name string) (SchemaTypeBuilder, bool) {
+	schemaTypesMu.RLock()
+	defer schemaTypesMu.RUnlock()
+
+	build, ok := schemaTypes[name]
+	return build, ok
+}
+
+// RegisteredSchemaTypes returns the names of the types registered with
+// RegisterSchemaTypes, in order.
+func RegisteredSchemaTypes() []string {
+	schemaTypesMu.RLock()
+	defer schemaTypesMu.RUnlock()
+
+	names := make([]string, 0, len(schemaTypes))
+	for name := range schemaTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func boundOr(bound *int64, def int64) int64 {
+	if bound == nil {
+		return def
+	}
+	return *bound
+}
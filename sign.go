@@ -0,0 +1,170 @@
+package jsonmap
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+// Signer signs and verifies payloads, such as webhook bodies, produced by
+// MarshalSigned() and consumed by UnmarshalSigned().
+type Signer interface {
+	Sign(payload []byte) ([]byte, error)
+	Verify(payload, signature []byte) bool
+}
+
+type hmacSigner struct {
+	key []byte
+}
+
+func (s *hmacSigner) Sign(payload []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(payload)
+	return mac.Sum(nil), nil
+}
+
+func (s *hmacSigner) Verify(payload, signature []byte) bool {
+	expected, _ := s.Sign(payload)
+	return hmac.Equal(expected, signature)
+}
+
+// HMACSHA256 returns a Signer which uses HMAC-SHA256 with the given key.
+func HMACSHA256(key []byte) Signer {
+	return &hmacSigner{
+		key: key,
+	}
+}
+
+type ed25519Signer struct {
+	private ed25519.PrivateKey
+	public  ed25519.PublicKey
+}
+
+func (s *ed25519Signer) Sign(payload []byte) ([]byte, error) {
+	if s.private == nil {
+		return nil, errors.New("no private key to sign with")
+	}
+	return ed25519.Sign(s.private, payload), nil
+}
+
+func (s *ed25519Signer) Verify(payload, signature []byte) bool {
+	return ed25519.Verify(s.public, payload, signature)
+}
+
+// Ed25519 returns a Signer which uses Ed25519 with the given keys. The
+// private key may be nil if the Signer is only used for verification, and the
+// public key may be nil if there is a private key, from which it is derived.
+func Ed25519(private ed25519.PrivateKey, public ed25519.PublicKey) Signer {
+	if public == nil && private != nil {
+		public = private.Public().(ed25519.PublicKey)
+	}
+
+	return &ed25519Signer{
+		private: private,
+		public:  public,
+	}
+}
+
+// canonicalJSON returns the canonical form of a decoded JSON document: that
+// produced by encoding/json, with object keys sorted and no whitespace.
+// Numbers must have been decoded as json.Number to be preserved exactly.
+func canonicalJSON(doc interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(doc)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// decodeObject decodes data, which must be a JSON object, preserving numbers.
+func decodeObject(data []byte) (map[string]interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var doc map[string]interface{}
+	err := dec.Decode(&doc)
+	if err != nil {
+		return nil, wrapJSONError(err)
+	}
+
+	if doc == nil {
+		return nil, NewValidationError("json: cannot unmarshal, not an object")
+	}
+	return doc, nil
+}
+
+// MarshalSigned marshals src, and adds to the resulting object a field with
+// the given name containing a base64url encoded signature of the rest of the
+// object. The signature covers the object's canonical form (see
+// UnmarshalSigned()), so it is unaffected by re-ordering of fields or changes
+// in whitespace.
+func (tm *TypeMapper) MarshalSigned(ctx Context, src interface{}, signer Signer, signatureField string) ([]byte, error) {
+	data, err := tm.Marshal(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := decodeObject(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := doc[signatureField]; ok {
+		return nil, errors.New("signature field is already mapped: " + signatureField)
+	}
+
+	payload, err := canonicalJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := signer.Sign(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	doc[signatureField] = base64.RawURLEncoding.EncodeToString(signature)
+	return canonicalJSON(doc)
+}
+
+// UnmarshalSigned verifies the signature held in the named field of the
+// object in data, as produced by MarshalSigned(), and only if it is valid
+// strips it and unmarshals the rest of the object into dest. The signature
+// is verified against the canonical form of the object without the signature
+// field: that produced by encoding/json, with object keys sorted and no
+// whitespace.
+func (tm *TypeMapper) UnmarshalSigned(ctx Context, data []byte, dest interface{}, signer Signer, signatureField string) error {
+	doc, err := decodeObject(data)
+	if err != nil {
+		return err
+	}
+
+	encoded, ok := doc[signatureField].(string)
+	if !ok {
+		errs := &ValidationError{}
+		errs.AddError(NewValidationErrorWithField(signatureField, "missing signature"))
+		return errs.Flatten()
+	}
+	delete(doc, signatureField)
+
+	payload, err := canonicalJSON(doc)
+	if err != nil {
+		return err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || !signer.Verify(payload, signature) {
+		errs := &ValidationError{}
+		errs.AddError(NewValidationErrorWithField(signatureField, "invalid signature"))
+		return errs.Flatten()
+	}
+
+	return tm.Unmarshal(ctx, payload, dest)
+}
@@ -0,0 +1,167 @@
+package jsonmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+)
+
+// StaticMap is a TypeMap backed by concrete, generated functions rather than
+// reflection over a StructMap. See GenerateStatic.
+type StaticMap struct {
+	UnderlyingType interface{}
+
+	// MarshalFunc writes the JSON representation of src, which is always a
+	// pointer to an instance of UnderlyingType, to buf.
+	MarshalFunc func(ctx Context, src interface{}, buf *bytes.Buffer) error
+
+	// UnmarshalFunc validates data into dst, which is always a pointer to an
+	// instance of UnderlyingType.
+	UnmarshalFunc func(ctx Context, data map[string]interface{}, dst interface{}) error
+
+	// Map is the StructMap from which the functions were generated. Values
+	// are unmarshaled by it instead where the generated functions don't
+	// implement the behaviour requested, such as a MergeMode other than
+	// MergeNone, and marshaled by it under ValidateOnMarshal.
+	Map StructMap
+}
+
+func (sm StaticMap) GetUnderlyingType() reflect.Type {
+	return reflect.TypeOf(sm.UnderlyingType)
+}
+
+func (sm StaticMap) Unmarshal(ctx Context, parent *reflect.Value, partial interface{}, dstValue reflect.Value) error {
+	if partial == nil && (dstValue.Kind() == reflect.Interface || dstValue.Kind() == reflect.Ptr) {
+		return nil
+	}
+
+	if sm.fallsBack(ctx) {
+		return sm.Map.Unmarshal(ctx, parent, partial, dstValue)
+	}
+
+	data, ok := partial.(map[string]interface{})
+	if !ok {
+		return NewValidationError("expected an object")
+	}
+
+	if dstValue.Kind() == reflect.Interface {
+		dstValue.Set(reflect.New(reflect.TypeOf(sm.UnderlyingType)))
+		dstValue = dstValue.Elem().Elem()
+	}
+
+	if dstValue.Kind() == reflect.Ptr {
+		dstValue = structDest(ctx, dstValue, reflect.TypeOf(sm.UnderlyingType))
+	}
+
+	err := sm.UnmarshalFunc(ctx, data, dstValue.Addr().Interface())
+	if err != nil {
+		return err
+	}
+
+	errs := &ValidationError{}
+	err = afterUnmarshal(ctx, dstValue, errs)
+	if err != nil {
+		return err
+	}
+
+	if len(errs.NestedErrors) != 0 || errs.Message != "" {
+		return errs
+	}
+
+	return nil
+}
+
+// fallsBack reports whether values must be unmarshaled by sm.Map rather than
+// by the generated functions.
+func (sm StaticMap) fallsBack(ctx Context) bool {
+	return sm.Map.UnderlyingType != nil && mergeModeOf(ctx) != MergeNone
+}
+
+func (sm StaticMap) Marshal(ctx Context, parent *reflect.Value, src reflect.Value) (json.Marshaler, error) {
+	buf := &bytes.Buffer{}
+	err := sm.marshalTo(ctx, parent, src, buf)
+	if err != nil {
+		return nil, err
+	}
+	return RawMessage{buf.Bytes()}, nil
+}
+
+func (sm StaticMap) marshalTo(ctx Context, parent *reflect.Value, src reflect.Value, buf *bytes.Buffer) error {
+	// Generated code writes every field as it is, so Contexts which change
+	// the output, such as field sets, expansions or validation, are left to
+	// sm.Map. These are the same Contexts whose output CachedMap won't cache.
+	if sm.Map.UnderlyingType != nil && !cacheable(ctx) {
+		return sm.Map.marshalTo(ctx, parent, src, buf)
+	}
+
+	if src.Kind() == reflect.Interface || src.Kind() == reflect.Ptr {
+		if src.IsNil() {
+			buf.Write(nullJSONValue)
+			return nil
+		}
+		src = src.Elem()
+	}
+
+	if src.Kind() == reflect.Ptr {
+		if src.IsNil() {
+			buf.Write(nullJSONValue)
+			return nil
+		}
+		src = src.Elem()
+	}
+
+	if !src.CanAddr() {
+		ptr := reflect.New(src.Type())
+		ptr.Elem().Set(src)
+		src = ptr.Elem()
+	}
+
+	src, err := beforeMarshal(ctx, src)
+	if err != nil {
+		return err
+	}
+
+	return sm.MarshalFunc(ctx, src.Addr().Interface(), buf)
+}
+
+// MarshalMappedField writes the i'th field of sm, read from the struct src, to
+// buf. It is used by generated code for fields which can't be handled
+// statically.
+func MarshalMappedField(ctx Context, sm StructMap, i int, src reflect.Value, buf *bytes.Buffer) error {
+	field := sm.Fields[i]
+
+	srcField, err := sm.fieldValue(src, field)
+	if err != nil {
+		return err
+	}
+
+	return sm.marshalFieldTo(ctx, src, field, srcField, buf)
+}
+
+// UnmarshalMappedField validates val into the i'th field of sm, in the struct
+// dst. It is used by generated code for fields which can't be handled
+// statically.
+func UnmarshalMappedField(ctx Context, sm StructMap, i int, dst reflect.Value, val interface{}) *ValidationError {
+	field := sm.Fields[i]
+
+	dstField := fieldByName(dst, field.StructFieldName)
+	if !dstField.IsValid() {
+		panic("no such underlying field: " + field.StructFieldName)
+	}
+
+	return sm.unmarshalField(ctx, &dst, field, val, dstField)
+}
+
+// InitMappedField initializes the i'th field of sm, in the struct dst, as a
+// field marked InitIfAbsent which was absent from the document. It is used by
+// generated code.
+func InitMappedField(ctx Context, sm StructMap, i int, dst reflect.Value) {
+	field := sm.Fields[i]
+
+	dstField := fieldByName(dst, field.StructFieldName)
+	if !dstField.IsValid() {
+		panic("no such underlying field: " + field.StructFieldName)
+	}
+
+	initAbsent(ctx, field, dstField, map[reflect.Type]bool{})
+}
@@ -0,0 +1,757 @@
+package jsonmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// tokenStream wraps a json.Decoder, adding single token lookahead. Any error
+// encountered while reading is sticky, and is distinct from validation errors:
+// once err is set the document can't be read any further.
+type tokenStream struct {
+	dec      *json.Decoder
+	peeked   json.Token
+	hasPeek  bool
+	err      error
+	failFast bool
+}
+
+func newTokenStream(r io.Reader, failFast bool) *tokenStream {
+	return &tokenStream{
+		dec:      json.NewDecoder(r),
+		failFast: failFast,
+	}
+}
+
+func (ts *tokenStream) Token() (json.Token, error) {
+	if ts.hasPeek {
+		ts.hasPeek = false
+		return ts.peeked, nil
+	}
+
+	if ts.err != nil {
+		return nil, ts.err
+	}
+
+	tok, err := ts.dec.Token()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		ts.err = err
+		return nil, err
+	}
+
+	return tok, nil
+}
+
+func (ts *tokenStream) Peek() (json.Token, error) {
+	if ts.hasPeek {
+		return ts.peeked, nil
+	}
+
+	tok, err := ts.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	ts.peeked = tok
+	ts.hasPeek = true
+	return tok, nil
+}
+
+// more reports whether there is another element in the current array or
+// object.
+func (ts *tokenStream) more() bool {
+	if ts.err != nil {
+		return false
+	}
+	if ts.hasPeek {
+		return !isDelim(ts.peeked, ']') && !isDelim(ts.peeked, '}')
+	}
+	return ts.dec.More()
+}
+
+func isDelim(tok json.Token, d json.Delim) bool {
+	delim, ok := tok.(json.Delim)
+	return ok && delim == d
+}
+
+// readValue reads the next value in its entirety, returning the same generic
+// representation json.Unmarshal() would have produced.
+func (ts *tokenStream) readValue() (interface{}, error) {
+	tok, err := ts.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case isDelim(tok, '{'):
+		obj := map[string]interface{}{}
+		for ts.more() {
+			key, err := ts.Token()
+			if err != nil {
+				return nil, err
+			}
+
+			val, err := ts.readValue()
+			if err != nil {
+				return nil, err
+			}
+
+			obj[key.(string)] = val
+		}
+		_, err = ts.Token()
+		return obj, err
+
+	case isDelim(tok, '['):
+		arr := []interface{}{}
+		for ts.more() {
+			val, err := ts.readValue()
+			if err != nil {
+				return nil, err
+			}
+
+			arr = append(arr, val)
+		}
+		_, err = ts.Token()
+		return arr, err
+
+	default:
+		return tok, nil
+	}
+}
+
+// readRaw reads the next value without interpreting it.
+func (ts *tokenStream) readRaw() (json.RawMessage, error) {
+	if !ts.hasPeek {
+		if ts.err != nil {
+			return nil, ts.err
+		}
+
+		var raw json.RawMessage
+		err := ts.dec.Decode(&raw)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			ts.err = err
+			return nil, err
+		}
+		return raw, nil
+	}
+
+	val, err := ts.readValue()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(val)
+}
+
+// skipValue discards the next value.
+func (ts *tokenStream) skipValue() error {
+	depth := 0
+	for {
+		tok, err := ts.Token()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case isDelim(tok, '{') || isDelim(tok, '['):
+			depth++
+		case isDelim(tok, '}') || isDelim(tok, ']'):
+			depth--
+		}
+
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// streamUnmarshaler is implemented by TypeMaps which are able to unmarshal
+// directly from a token stream, rather than from a fully decoded document.
+// Errors reading the stream are recorded on the tokenStream, and should be
+// checked by callers before handling any returned validation error.
+type streamUnmarshaler interface {
+	unmarshalStream(ctx Context, parent *reflect.Value, ts *tokenStream, dstValue reflect.Value) error
+}
+
+// unmarshalStream unmarshals the next value in ts using m. TypeMaps which
+// don't implement streamUnmarshaler receive the value fully decoded.
+func unmarshalStream(ctx Context, m TypeMap, parent *reflect.Value, ts *tokenStream, dstValue reflect.Value) error {
+	if su, ok := m.(streamUnmarshaler); ok {
+		return su.unmarshalStream(ctx, parent, ts, dstValue)
+	}
+
+	val, err := ts.readValue()
+	if err != nil {
+		return err
+	}
+
+	return m.Unmarshal(ctx, parent, val, dstValue)
+}
+
+func (sm StructMap) unmarshalStream(ctx Context, parent *reflect.Value, ts *tokenStream, dstValue reflect.Value) error {
+	tok, err := ts.Peek()
+	if err != nil {
+		return err
+	}
+
+	if tok == nil && (dstValue.Kind() == reflect.Interface || dstValue.Kind() == reflect.Ptr) {
+		_, err = ts.Token()
+		return err
+	}
+
+	if !isDelim(tok, '{') {
+		err = ts.skipValue()
+		if err != nil {
+			return err
+		}
+		return NewValidationError("expected an object")
+	}
+
+	ts.Token()
+
+	if dstValue.Kind() == reflect.Interface {
+		dstValue.Set(reflect.New(reflect.TypeOf(sm.UnderlyingType)))
+		dstValue = dstValue.Elem().Elem()
+	}
+
+	if dstValue.Kind() == reflect.Ptr {
+		dstValue = structDest(ctx, dstValue, reflect.TypeOf(sm.UnderlyingType))
+	}
+
+	applyDefaults(ctx, dstValue)
+	absent := newResetter(ctx, dstValue.Type())
+
+	fieldIndexes := make(map[string]int, len(sm.Fields))
+	dstFields := make([]reflect.Value, len(sm.Fields))
+	for i, field := range sm.Fields {
+		if field.ReadOnly || !field.activeFor(ctx) {
+			continue
+		}
+
+		dstFields[i] = fieldByName(dstValue, field.StructFieldName)
+		if !dstFields[i].IsValid() {
+			panic("no such underlying field: " + field.StructFieldName)
+		}
+
+		fieldIndexes[field.JSONFieldName] = i
+		fieldIndexes[field.wireName(ctx)] = i
+	}
+
+	present := make([]bool, len(sm.Fields))
+	fieldErrs := make([]*ValidationError, len(sm.Fields))
+
+	// Fields using TypeMaps which can't be streamed are unmarshaled once the
+	// rest of the object has been, as they may depend on the values of other
+	// fields (for example a VariableType's type identifier).
+	postponed := make([]interface{}, len(sm.Fields))
+	isPostponed := make([]bool, len(sm.Fields))
+
+	errs := &ValidationError{}
+
+	for ts.more() {
+		key, err := ts.Token()
+		if err != nil {
+			return err
+		}
+
+		i, ok := fieldIndexes[key.(string)]
+		if !ok {
+			traceField(ctx, key.(string), TraceFieldIgnored, "")
+			err = ts.skipValue()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		field := sm.Fields[i]
+		present[i] = true
+		fieldErrs[i] = nil
+
+		if _, ok := field.Contains.(*Discriminator); ok {
+			// The type identifier may yet follow, so the value is kept as
+			// written until it can be streamed
+			raw, err := ts.readRaw()
+			if err != nil {
+				return err
+			}
+
+			postponed[i] = raw
+			isPostponed[i] = true
+			continue
+		}
+
+		if su, ok := field.Contains.(streamUnmarshaler); ok {
+			// Deferred values handle null themselves, and peeking would
+			// prevent them capturing their raw input efficiently
+			if _, isDeferred := su.(*DeferredMap); !isDeferred {
+				tok, err := ts.Peek()
+				if err != nil {
+					return err
+				}
+
+				if tok == nil && field.skipsNull() {
+					traceField(ctx, field.JSONFieldName, TraceFieldSkipped, "null")
+					absent.reset(field, dstFields[i])
+					ts.Token()
+					continue
+				}
+			}
+
+			fieldCtx := pathContext(ctx, field.wireName(ctx))
+			trace(fieldCtx, TraceFieldMatched, "%s", field.StructFieldName)
+			err = su.unmarshalStream(expansionContext(fieldCtx, field.JSONFieldName), &dstValue, ts, dstFields[i])
+			if ts.err != nil {
+				return ts.err
+			}
+			if err != nil {
+				fieldErrs[i] = fieldError(field.wireName(ctx), err)
+			}
+		} else {
+			val, err := ts.readValue()
+			if err != nil {
+				return err
+			}
+
+			if field.Contains != nil {
+				postponed[i] = val
+				isPostponed[i] = true
+				continue
+			}
+
+			if val == nil && field.skipsNull() {
+				traceField(ctx, field.JSONFieldName, TraceFieldSkipped, "null")
+				absent.reset(field, dstFields[i])
+				continue
+			}
+
+			fieldErrs[i] = sm.unmarshalField(ctx, &dstValue, field, val, dstFields[i])
+		}
+
+		if fieldErrs[i] != nil && ts.failFast {
+			errs.AddError(fieldErrs[i])
+			return errs
+		}
+	}
+
+	_, err = ts.Token()
+	if err != nil {
+		return err
+	}
+
+	for i, field := range sm.Fields {
+		if field.ReadOnly || !field.activeFor(ctx) {
+			continue
+		}
+
+		if !present[i] {
+			if !field.Optional {
+				traceField(ctx, field.wireName(ctx), TraceFieldMissing, "required")
+				errs.AddError(NewValidationErrorWithField(field.wireName(ctx), "missing required field"))
+			} else {
+				traceField(ctx, field.JSONFieldName, TraceFieldMissing, "optional")
+				absent.reset(field, dstFields[i])
+				if field.InitIfAbsent {
+					initAbsent(ctx, field, dstFields[i], map[reflect.Type]bool{})
+				}
+			}
+		} else if isPostponed[i] {
+			if raw, ok := postponed[i].(json.RawMessage); ok {
+				postponed[i] = nil
+				if string(raw) != "null" {
+					postponed[i] = raw
+				}
+			}
+
+			if raw, ok := postponed[i].(json.RawMessage); ok {
+				fieldErrs[i] = sm.unmarshalRawField(ctx, &dstValue, field, raw, dstFields[i], ts.failFast)
+			} else if postponed[i] != nil || !field.skipsNull() {
+				fieldErrs[i] = sm.unmarshalField(ctx, &dstValue, field, postponed[i], dstFields[i])
+			} else {
+				traceField(ctx, field.JSONFieldName, TraceFieldSkipped, "null")
+				absent.reset(field, dstFields[i])
+			}
+		}
+
+		if fieldErrs[i] != nil {
+			errs.AddError(fieldErrs[i])
+		}
+
+		if ts.failFast && len(errs.NestedErrors) != 0 {
+			return errs
+		}
+	}
+
+	err = afterUnmarshal(ctx, dstValue, errs)
+	if err != nil {
+		return err
+	}
+
+	if len(errs.NestedErrors) != 0 || errs.Message != "" {
+		return errs
+	}
+
+	return nil
+}
+
+// unmarshalRawField is unmarshalField for a value postponed as it was
+// written, which is streamed to the field's TypeMap.
+func (sm StructMap) unmarshalRawField(ctx Context, parent *reflect.Value, field MappedField, raw json.RawMessage, dstField reflect.Value, failFast bool) *ValidationError {
+	ctx = pathContext(ctx, field.wireName(ctx))
+	trace(ctx, TraceFieldMatched, "%s", field.StructFieldName)
+
+	ts := newTokenStream(bytes.NewReader(raw), failFast)
+	err := unmarshalStream(expansionContext(ctx, field.JSONFieldName), field.Contains, parent, ts, dstField)
+	if ts.err != nil {
+		err = ts.err
+	}
+
+	if err != nil {
+		return fieldError(field.wireName(ctx), err)
+	}
+	return nil
+}
+
+func (sm SliceMap) unmarshalStream(ctx Context, parent *reflect.Value, ts *tokenStream, dstValue reflect.Value) error {
+	tok, err := ts.Peek()
+	if err != nil {
+		return err
+	}
+
+	if tok == nil && isIndirect(dstValue) {
+		_, err = ts.Token()
+		return err
+	}
+
+	if isDelim(tok, '[') && isIndirect(dstValue) {
+		return unmarshalIndirect(dstValue, interfaceSliceType, func(dst reflect.Value) error {
+			return sm.unmarshalStream(ctx, parent, ts, dst)
+		})
+	}
+
+	if !isDelim(tok, '[') {
+		err = ts.skipValue()
+		if err != nil {
+			return err
+		}
+		return NewValidationError("expected a list")
+	}
+
+	ts.Token()
+
+	// See SliceMap.Unmarshal()
+	a := newElementAllocator(ctx, &sm, dstValue, 0)
+
+	errs := &ValidationError{}
+
+	// n counts the elements, less any null elements skipped
+	n := 0
+	for i := 0; ts.more(); i++ {
+		// Null pointers to structs aren't allocated from the backing slice
+		isNull := false
+		if sm.Nulls != NullElementsPassed || a.structType != nil {
+			tok, err := ts.Peek()
+			if err != nil {
+				return err
+			}
+			isNull = tok == nil
+		}
+
+		if isNull && sm.Nulls == NullElementsSkipped {
+			ts.Token()
+			continue
+		}
+
+		n++
+
+		// There's no point validating elements once we know there are too
+		// many of them
+		if sm.MaxLen != nil && n > *sm.MaxLen {
+			err = ts.skipValue()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if isNull && sm.Nulls != NullElementsPassed {
+			ts.Token()
+			a.result, _ = sm.nullElement(i, a.result, errs)
+			if ts.failFast && len(errs.NestedErrors) != 0 {
+				return errs
+			}
+			continue
+		}
+
+		dstElem := a.next(isNull)
+
+		err := unmarshalStream(elementContext(ctx, i), sm.Contains, &dstValue, ts, dstElem)
+		if ts.err != nil {
+			return ts.err
+		}
+
+		if err != nil {
+			a.discard(dstElem)
+			errs.AddError(fieldError(strconv.Itoa(i), err))
+			if ts.failFast {
+				return errs
+			}
+			continue
+		}
+
+		a.keep(dstElem)
+	}
+
+	_, err = ts.Token()
+	if err != nil {
+		return err
+	}
+
+	err = sm.validateSliceWithinRange(n)
+	if err != nil {
+		return err
+	}
+
+	if len(errs.NestedErrors) != 0 {
+		return errs
+	}
+
+	dstValue.Set(a.slice())
+
+	return nil
+}
+
+func (mm MapMap) unmarshalStream(ctx Context, parent *reflect.Value, ts *tokenStream, dstValue reflect.Value) error {
+	tok, err := ts.Peek()
+	if err != nil {
+		return err
+	}
+
+	if tok == nil && isIndirect(dstValue) {
+		_, err = ts.Token()
+		return err
+	}
+
+	if isDelim(tok, '{') && isIndirect(dstValue) {
+		return unmarshalIndirect(dstValue, dynamicMapType, func(dst reflect.Value) error {
+			return mm.unmarshalStream(ctx, parent, ts, dst)
+		})
+	}
+
+	if !isDelim(tok, '{') {
+		err = ts.skipValue()
+		if err != nil {
+			return err
+		}
+		return NewValidationError("expected a map")
+	}
+
+	ts.Token()
+
+	errs := &ValidationError{}
+
+	// Maps default to nil, so we need to make() one
+	dstValue.Set(reflect.MakeMap(dstValue.Type()))
+
+	elementType := dstValue.Type().Elem()
+	keyType := dstValue.Type().Key()
+
+	for ts.more() {
+		key, err := ts.Token()
+		if err != nil {
+			return err
+		}
+
+		dstElem := reflect.New(elementType).Elem()
+
+		err = unmarshalStream(pathContext(ctx, key.(string)), mm.Contains, &dstValue, ts, dstElem)
+		if ts.err != nil {
+			return ts.err
+		}
+
+		if err != nil {
+			errs.AddError(fieldError(key.(string), err))
+			if ts.failFast {
+				return errs
+			}
+			continue
+		}
+
+		dstValue.SetMapIndex(reflect.ValueOf(key).Convert(keyType), dstElem)
+	}
+
+	_, err = ts.Token()
+	if err != nil {
+		return err
+	}
+
+	if len(errs.NestedErrors) != 0 {
+		// Report errors in the order of their keys, as MapMap.Unmarshal does,
+		// rather than the order of the document
+		sort.SliceStable(errs.NestedErrors, func(i, j int) bool {
+			return errs.NestedErrors[i].Field < errs.NestedErrors[j].Field
+		})
+		return errs
+	}
+
+	return nil
+}
+
+// wrapStreamError converts errors encountered reading a token stream into
+// validation errors, where they may have been caused by invalid input.
+func wrapStreamError(err error) error {
+	if err == io.ErrUnexpectedEOF {
+		return NewValidationError("unexpected end of JSON input")
+	}
+	return wrapJSONError(err)
+}
+
+// UnmarshalReader validates the JSON document read from r into dest. The
+// document is validated as it is read, without first being decoded in its
+// entirety, and if the TypeMapper is configured to FailFast it stops reading
+// at the first validation error.
+func (tm *TypeMapper) UnmarshalReader(ctx Context, r io.Reader, dest interface{}) error {
+	if reflect.TypeOf(dest).Kind() != reflect.Ptr || dest == nil {
+		panic("cannot unmarshal to non-pointer")
+	}
+	m := tm.getTypeMap(dest)
+	ts := newTokenStream(r, tm.FailFast)
+	ctx = withMergeMode(ctx, tm.MergeMode)
+
+	tok, err := ts.Peek()
+	if err != nil {
+		return wrapStreamError(err)
+	}
+
+	dstValue := reflect.ValueOf(dest).Elem()
+
+	// Slices and maps may be unmarshaled from top-level arrays and objects,
+	// with a null document leaving them nil, as with json.Unmarshal()
+	if dstValue.Kind() == reflect.Slice {
+		if tok != nil && !isDelim(tok, '[') {
+			return NewValidationError("json: cannot unmarshal, not an array")
+		}
+	} else if tok != nil && !isDelim(tok, '{') {
+		return NewValidationError("json: cannot unmarshal, not an object")
+	}
+
+	if tok == nil && isContainer(dstValue.Type()) {
+		ts.Token()
+		dstValue.Set(reflect.Zero(dstValue.Type()))
+	} else if tok == nil {
+		// A null document leaves the object empty, as with json.Unmarshal()
+		ts.Token()
+		err = tm.unmarshalPartial(ctx, m, map[string]interface{}{}, dest)
+	} else {
+		// A top-level slice is replaced rather than appended to, as with
+		// json.Unmarshal()
+		replace := dstValue.Kind() == reflect.Slice && appendsSlices(ctx)
+		dst := dstValue
+		if replace {
+			dst = reflect.New(dstValue.Type()).Elem()
+		}
+
+		err = unmarshalStream(ctx, m, nil, ts, dst)
+		if ts.err != nil {
+			return wrapStreamError(ts.err)
+		}
+
+		if err == nil && replace {
+			dstValue.Set(dst)
+		}
+
+		if e, ok := err.(*ValidationError); ok {
+			err = e.Flatten()
+		}
+	}
+
+	// Bailing out early leaves the remainder of the document unread
+	if err != nil && tm.FailFast {
+		return err
+	}
+
+	if _, terr := ts.dec.Token(); terr != io.EOF {
+		if terr == nil {
+			return NewValidationError("invalid character after top-level value")
+		}
+		return wrapStreamError(terr)
+	}
+
+	return err
+}
+
+// UnmarshalArrayStream reads a JSON document consisting of an array from r,
+// validating each element in turn into a new value of elemType, using the
+// TypeMap registered for it. Each element is passed to fn as a pointer to its
+// value, or with the validation error it caused, as soon as it has been read,
+// so that arbitrarily long arrays can be processed without being held in
+// memory. fn returns false to stop reading. Validation errors of elements are
+// only passed to fn, with paths beginning with their index, and if the
+// TypeMapper is configured to FailFast reading stops after the first of them.
+// The error returned is that of a document which isn't a well formed array.
+func (tm *TypeMapper) UnmarshalArrayStream(ctx Context, r io.Reader, elemType reflect.Type, fn func(i int, elem interface{}, err error) bool) error {
+	m := tm.getTypeMap(reflect.New(elemType).Interface())
+	ts := newTokenStream(r, tm.FailFast)
+	ctx = withMergeMode(ctx, tm.MergeMode)
+
+	tok, err := ts.Token()
+	if err != nil {
+		return wrapStreamError(err)
+	}
+
+	if tok != nil {
+		if !isDelim(tok, '[') {
+			return NewValidationError("json: cannot unmarshal, not an array")
+		}
+
+		for i := 0; ts.more(); i++ {
+			elem := reflect.New(elemType)
+
+			err := unmarshalStream(elementContext(ctx, i), m, nil, ts, elem.Elem())
+			if ts.err != nil {
+				return wrapStreamError(ts.err)
+			}
+
+			if err != nil {
+				if e, ok := err.(*ValidationError); ok {
+					errs := &ValidationError{}
+					errs.AddError(fieldError(strconv.Itoa(i), e))
+					err = errs.Flatten()
+				}
+
+				// Bailing out early leaves the remainder of the element unread
+				if !fn(i, nil, err) || tm.FailFast {
+					return nil
+				}
+				continue
+			}
+
+			if !fn(i, elem.Interface(), nil) {
+				return nil
+			}
+		}
+
+		_, err = ts.Token()
+		if err != nil {
+			return wrapStreamError(err)
+		}
+	}
+
+	if _, terr := ts.dec.Token(); terr != io.EOF {
+		if terr == nil {
+			return NewValidationError("invalid character after top-level value")
+		}
+		return wrapStreamError(terr)
+	}
+
+	return nil
+}
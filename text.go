@@ -0,0 +1,88 @@
+package jsonmap
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"reflect"
+)
+
+var (
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+type TextMap struct{}
+
+func (m *TextMap) Unmarshal(ctx Context, parent *reflect.Value, partial interface{}, dstValue reflect.Value) error {
+	t := dstValue.Type()
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if !reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		panic("target field for jsonmap.TextValue() does not implement encoding.TextUnmarshaler: " + t.String())
+	}
+
+	s, ok := partial.(string)
+	if !ok {
+		return NewValidationError("not a string")
+	}
+
+	ptr := reflect.New(t)
+	err := ptr.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	if err != nil {
+		return NewValidationError("not a valid value: %s", err.Error())
+	}
+
+	if dstValue.Kind() == reflect.Ptr {
+		dstValue.Set(ptr)
+	} else {
+		dstValue.Set(ptr.Elem())
+	}
+
+	return nil
+}
+
+func (m *TextMap) Marshal(ctx Context, parent *reflect.Value, src reflect.Value) (json.Marshaler, error) {
+	buf := &bytes.Buffer{}
+	err := m.marshalTo(ctx, parent, src, buf)
+	if err != nil {
+		return nil, err
+	}
+	return RawMessage{buf.Bytes()}, nil
+}
+
+func (m *TextMap) marshalTo(ctx Context, parent *reflect.Value, src reflect.Value, buf *bytes.Buffer) error {
+	if src.Kind() == reflect.Ptr {
+		if src.IsNil() {
+			buf.Write(nullJSONValue)
+			return nil
+		}
+	} else if !src.Type().Implements(textMarshalerType) {
+		// The method may have a pointer receiver
+		ptr := reflect.New(src.Type())
+		ptr.Elem().Set(src)
+		src = ptr
+	}
+
+	tm, ok := src.Interface().(encoding.TextMarshaler)
+	if !ok {
+		panic("source field for jsonmap.TextValue() does not implement encoding.TextMarshaler: " + src.Type().String())
+	}
+
+	text, err := tm.MarshalText()
+	if err != nil {
+		return err
+	}
+	return marshalValueTo(string(text), buf)
+}
+
+// TextValue returns a TypeMap for fields whose types implement
+// encoding.TextMarshaler and encoding.TextUnmarshaler, such as time.Time,
+// net.IP and many custom scalar types, which are represented as JSON strings.
+// Fields may also be pointers to such types, in which case nil is marshaled as
+// null.
+func TextValue() TypeMap {
+	return &TextMap{}
+}
@@ -0,0 +1,157 @@
+package jsonmap
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/rnd42/go-jsonpointer"
+)
+
+// TraceKind identifies the kind of decision described by a TraceEvent.
+type TraceKind string
+
+const (
+	// TraceFieldMatched is recorded when a mapped field is found in the input.
+	TraceFieldMatched TraceKind = "field matched"
+
+	// TraceFieldMissing is recorded when a mapped field is absent from the
+	// input.
+	TraceFieldMissing TraceKind = "field missing"
+
+	// TraceFieldIgnored is recorded for members of the input which aren't
+	// mapped to any field.
+	TraceFieldIgnored TraceKind = "field ignored"
+
+	// TraceFieldSkipped is recorded when a field is passed over, such as for
+	// being null in the input, or empty or not selected when marshaling.
+	TraceFieldSkipped TraceKind = "field skipped"
+
+	// TraceValidated is recorded when a Validator has been run.
+	TraceValidated TraceKind = "validator run"
+
+	// TraceVariantSelected is recorded when a VariableType chooses the
+	// TypeMap to use.
+	TraceVariantSelected TraceKind = "variant selected"
+
+	// TraceFieldMarshaled is recorded when a field is written when
+	// marshaling.
+	TraceFieldMarshaled TraceKind = "field marshaled"
+)
+
+// TraceEvent describes a decision made while marshaling or unmarshaling.
+type TraceEvent struct {
+	// Path is the JSON pointer of the value concerned.
+	Path   string
+	Kind   TraceKind
+	Detail string
+}
+
+func (e TraceEvent) String() string {
+	if e.Detail == "" {
+		return fmt.Sprintf("%s: %s", e.Path, e.Kind)
+	}
+	return fmt.Sprintf("%s: %s: %s", e.Path, e.Kind, e.Detail)
+}
+
+// TraceSink receives TraceEvents. See WithTrace.
+type TraceSink interface {
+	Trace(e TraceEvent)
+}
+
+// TraceFunc adapts a function to a TraceSink.
+type TraceFunc func(e TraceEvent)
+
+func (f TraceFunc) Trace(e TraceEvent) {
+	f(e)
+}
+
+type traceContextKey struct{}
+
+// WithTrace returns a Ctx which records each decision made while marshaling
+// or unmarshaling to sink, which helps when debugging why a field came out
+// empty or a variant wasn't selected. It carries a cost, and is not intended
+// for use in production.
+func WithTrace(ctx Context, sink TraceSink) *Ctx {
+	return NewCtx(ctx).With(traceContextKey{}, sink)
+}
+
+func traceOf(ctx Context) TraceSink {
+	c, ok := ctx.(*Ctx)
+	if !ok {
+		return nil
+	}
+
+	sink, _ := c.Value(traceContextKey{}).(TraceSink)
+	return sink
+}
+
+// trace records an event for the value at the current path, if ctx has a
+// TraceSink.
+func trace(ctx Context, kind TraceKind, format string, a ...interface{}) {
+	sink := traceOf(ctx)
+	if sink == nil {
+		return
+	}
+
+	path := pathOf(ctx)
+	sink.Trace(TraceEvent{
+		Path:   jsonpointer.NewJSONPointerFromTokens(&path).String(),
+		Kind:   kind,
+		Detail: fmt.Sprintf(format, a...),
+	})
+}
+
+// traceField records an event for the named member of the current value, if
+// ctx has a TraceSink.
+func traceField(ctx Context, name string, kind TraceKind, format string, a ...interface{}) {
+	if traceOf(ctx) == nil {
+		return
+	}
+	trace(pathContext(ctx, name), kind, format, a...)
+}
+
+// traceValidated records the outcome of running a Validator on input.
+func traceValidated(ctx Context, input, output interface{}, err error) {
+	if traceOf(ctx) == nil {
+		return
+	}
+
+	if err != nil {
+		trace(ctx, TraceValidated, "%#v rejected: %v", input, err)
+	} else {
+		trace(ctx, TraceValidated, "%#v accepted as %#v", input, output)
+	}
+}
+
+// traceIgnored records an event for each member of data which doesn't map to
+// a field which may be unmarshaled.
+func (sm StructMap) traceIgnored(ctx Context, data map[string]interface{}) {
+	mapped := make(map[string]bool, len(sm.Fields))
+	for _, field := range sm.Fields {
+		if !field.ReadOnly && field.activeFor(ctx) {
+			mapped[field.JSONFieldName] = true
+			mapped[field.wireName(ctx)] = true
+		}
+	}
+
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		if !mapped[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		traceField(ctx, key, TraceFieldIgnored, "")
+	}
+}
+
+// sourceName returns the name of the struct field or getter a field is
+// marshaled from.
+func (f MappedField) sourceName() string {
+	if f.StructFieldName != "" {
+		return f.StructFieldName
+	}
+	return f.StructGetterName + "()"
+}
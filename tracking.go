@@ -0,0 +1,74 @@
+package jsonmap
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/rnd42/go-jsonpointer"
+)
+
+// UnmarshalTracked is like Unmarshal, but additionally returns a JSON pointer
+// for each mapped field that was present in the input, in the order the
+// fields are mapped. Fields of nested structs are reported individually along
+// with the struct itself, while other containers such as slices and maps are
+// reported as a whole. ReadOnly fields are never reported, as they are never
+// set.
+//
+// This is useful for persistence layers which need to update only the fields
+// that a client actually supplied.
+func (tm *TypeMapper) UnmarshalTracked(ctx Context, data []byte, dest interface{}) ([]string, error) {
+	if reflect.TypeOf(dest).Kind() != reflect.Ptr || dest == nil {
+		panic("cannot unmarshal to non-pointer")
+	}
+	m := tm.getTypeMap(dest)
+	partial := map[string]interface{}{}
+
+	err := json.Unmarshal(data, &partial)
+	if err != nil {
+		return nil, wrapJSONError(err)
+	}
+
+	err = tm.unmarshalPartial(ctx, m, partial, dest)
+	if err != nil {
+		return nil, err
+	}
+
+	present := []string{}
+	collectPresentFields(ctx, m, partial, []string{}, &present)
+	return present, nil
+}
+
+func collectPresentFields(ctx Context, m TypeMap, partial interface{}, path []string, present *[]string) {
+	var sm StructMap
+	switch v := m.(type) {
+	case StructMap:
+		sm = v
+	case *StructMap:
+		sm = *v
+	default:
+		return
+	}
+
+	data, ok := partial.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	for _, field := range sm.Fields {
+		if field.ReadOnly || !field.activeFor(ctx) {
+			continue
+		}
+
+		val, ok := data[field.JSONFieldName]
+		if !ok {
+			continue
+		}
+
+		fieldPath := append(path[:len(path):len(path)], field.JSONFieldName)
+		*present = append(*present, jsonpointer.NewJSONPointerFromTokens(&fieldPath).String())
+
+		if field.Contains != nil && val != nil && reflect.TypeOf(val).Kind() == reflect.Map {
+			collectPresentFields(ctx, field.Contains, val, fieldPath, present)
+		}
+	}
+}
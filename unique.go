@@ -0,0 +1,108 @@
+package jsonmap
+
+import (
+	"reflect"
+	"strconv"
+)
+
+// uniqueSliceMap is a SliceMap of structs which rejects elements sharing the
+// same value for a key field.
+type uniqueSliceMap struct {
+	SliceMap
+	elem StructMap
+	key  MappedField
+}
+
+func (um *uniqueSliceMap) Unmarshal(ctx Context, parent *reflect.Value, partial interface{}, dstValue reflect.Value) error {
+	err := um.SliceMap.Unmarshal(ctx, parent, partial, dstValue)
+	if err != nil {
+		return err
+	}
+	return um.checkUnique(dstValue)
+}
+
+func (um *uniqueSliceMap) unmarshalStream(ctx Context, parent *reflect.Value, ts *tokenStream, dstValue reflect.Value) error {
+	err := um.SliceMap.unmarshalStream(ctx, parent, ts, dstValue)
+	if err != nil {
+		return err
+	}
+	return um.checkUnique(dstValue)
+}
+
+// checkUnique reports an error for each element whose key duplicates that of
+// an earlier element.
+func (um *uniqueSliceMap) checkUnique(slice reflect.Value) error {
+	errs := &ValidationError{}
+	seen := map[interface{}]int{}
+
+	for i := 0; i < slice.Len(); i++ {
+		elem := slice.Index(i)
+		if elem.Kind() == reflect.Ptr {
+			if elem.IsNil() {
+				continue
+			}
+			elem = elem.Elem()
+		}
+
+		key, err := um.elem.fieldValue(elem, um.key)
+		if err != nil {
+			return err
+		}
+
+		if first, ok := seen[key.Interface()]; ok {
+			dup := NewValidationErrorWithField(strconv.Itoa(i), "")
+			dup.AddError(NewValidationErrorWithField(um.key.JSONFieldName, "duplicate value, already used at index "+strconv.Itoa(first)))
+			errs.AddError(dup)
+			continue
+		}
+		seen[key.Interface()] = i
+	}
+
+	if len(errs.NestedErrors) != 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// SliceOfUniqueBy is like SliceOf, for slices of structs mapped by elem, but
+// rejects slices in which two elements have the same value for the field
+// with the given JSON name. Errors are reported at that field of each
+// duplicate element.
+func SliceOfUniqueBy(elem TypeMap, jsonFieldName string) TypeMap {
+	var sm StructMap
+	switch m := elem.(type) {
+	case StructMap:
+		sm = m
+	case *StructMap:
+		sm = *m
+	default:
+		panic("SliceOfUniqueBy requires a StructMap")
+	}
+
+	for _, field := range sm.Fields {
+		if field.JSONFieldName != jsonFieldName {
+			continue
+		}
+
+		if field.StructFieldName != "" {
+			sf, ok := sm.GetUnderlyingType().FieldByName(field.StructFieldName)
+			if !ok {
+				panic("no such underlying field: " + field.StructFieldName)
+			}
+			if !sf.Type.Comparable() {
+				panic("unique key field is not comparable: " + field.StructFieldName)
+			}
+		}
+
+		return &uniqueSliceMap{
+			SliceMap: SliceMap{
+				Contains: elem,
+			},
+			elem: sm,
+			key:  field,
+		}
+	}
+
+	panic("no such field: " + jsonFieldName)
+}
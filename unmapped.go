@@ -0,0 +1,87 @@
+package jsonmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+)
+
+// UnmappedTypes defines how marshaling treats a value which its TypeMap can't
+// marshal: a value of a type other than that of its StructMap or JSONAPIMap,
+// such as an unregistered type held by an interface field, or a VariableType
+// field whose type identifier has no TypeMap.
+type UnmappedTypes int
+
+const (
+	// UnmappedTypesPanic panics, on the basis that the mapping is broken.
+	UnmappedTypesPanic UnmappedTypes = iota
+
+	// UnmappedTypesError returns an error from Marshal, such as an
+	// *UnmappedTypeError.
+	UnmappedTypesError
+
+	// UnmappedTypesEncodingJSON marshals the value with encoding/json, as if
+	// it had no TypeMap.
+	UnmappedTypesEncodingJSON
+)
+
+// UnmappedTypeError reports a value of a type other than that of the
+// StructMap or JSONAPIMap marshaling it, under UnmappedTypesError.
+type UnmappedTypeError struct {
+	Type     reflect.Type
+	Expected reflect.Type
+}
+
+func (e *UnmappedTypeError) Error() string {
+	return "wrong type: " + e.Type.String() + ", expected: " + e.Expected.String()
+}
+
+type unmappedTypesContextKey struct{}
+
+func unmappedTypesOf(ctx Context) UnmappedTypes {
+	c, ok := ctx.(*Ctx)
+	if !ok {
+		return UnmappedTypesPanic
+	}
+
+	mode, _ := c.Value(unmappedTypesContextKey{}).(UnmappedTypes)
+	return mode
+}
+
+// withUnmappedTypes returns a Context specifying the given UnmappedTypes, if
+// it isn't the default.
+func withUnmappedTypes(ctx Context, mode UnmappedTypes) Context {
+	if mode == UnmappedTypesPanic {
+		return ctx
+	}
+	return NewCtx(ctx).With(unmappedTypesContextKey{}, mode)
+}
+
+// marshalUnmapped handles src, which couldn't be marshaled for the reason
+// given by err, according to the UnmappedTypes specified by ctx.
+func marshalUnmapped(ctx Context, src reflect.Value, err error, buf *bytes.Buffer) error {
+	switch unmappedTypesOf(ctx) {
+	case UnmappedTypesError:
+		return err
+	case UnmappedTypesEncodingJSON:
+		return marshalValueTo(src.Interface(), buf)
+	default:
+		panic(err.Error())
+	}
+}
+
+// unmappedMarshaler is like marshalUnmapped, but returns the result as a
+// json.Marshaler.
+func unmappedMarshaler(ctx Context, src reflect.Value, err error) (json.Marshaler, error) {
+	buf := &bytes.Buffer{}
+	err = marshalUnmapped(ctx, src, err, buf)
+	if err != nil {
+		return nil, err
+	}
+	return RawMessage{buf.Bytes()}, nil
+}
+
+func variableTypeError(err error) error {
+	return errors.New("variable type serialization error: " + err.Error())
+}
@@ -0,0 +1,153 @@
+package jsonmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"strings"
+)
+
+var (
+	urlType    = reflect.TypeOf(url.URL{})
+	urlPtrType = reflect.TypeOf(&url.URL{})
+)
+
+// URLMap maps URL strings to url.URL (or *url.URL) fields. All URLs must be
+// absolute, with a scheme and host.
+type URLMap struct {
+	// Schemes, if not empty, are the permitted URL schemes, such as "https".
+	Schemes []string
+
+	// Hosts, if not empty, are the permitted URL hosts (including any port).
+	Hosts []string
+}
+
+func (m *URLMap) Unmarshal(ctx Context, parent *reflect.Value, partial interface{}, dstValue reflect.Value) error {
+	if dstValue.Type() != urlType && dstValue.Type() != urlPtrType {
+		panic("target field for jsonmap.URL() is not a url.URL or *url.URL")
+	}
+
+	s, ok := partial.(string)
+	if !ok {
+		return NewValidationError("not a string")
+	}
+
+	u, err := parseURL(s, m.Schemes, m.Hosts)
+	if err != nil {
+		return err
+	}
+
+	if dstValue.Type() == urlPtrType {
+		dstValue.Set(reflect.ValueOf(u))
+	} else {
+		dstValue.Set(reflect.ValueOf(*u))
+	}
+
+	return nil
+}
+
+// parseURL parses s, which must be an absolute URL with one of the given
+// schemes and hosts, if any are given.
+func parseURL(s string, schemes, hosts []string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, NewValidationError("not a valid URL")
+	}
+
+	if !u.IsAbs() || u.Host == "" {
+		return nil, NewValidationError("not an absolute URL")
+	}
+
+	if len(schemes) > 0 && !containsFold(schemes, u.Scheme) {
+		return nil, NewValidationError("URL scheme must be one of: %s", strings.Join(schemes, ", "))
+	}
+
+	if len(hosts) > 0 && !containsFold(hosts, u.Host) {
+		return nil, NewValidationError("URL host is not permitted")
+	}
+
+	return u, nil
+}
+
+func (m *URLMap) Marshal(ctx Context, parent *reflect.Value, src reflect.Value) (json.Marshaler, error) {
+	buf := &bytes.Buffer{}
+	err := m.marshalTo(ctx, parent, src, buf)
+	if err != nil {
+		return nil, err
+	}
+	return RawMessage{buf.Bytes()}, nil
+}
+
+func (m *URLMap) marshalTo(ctx Context, parent *reflect.Value, src reflect.Value, buf *bytes.Buffer) error {
+	var u *url.URL
+
+	switch v := src.Interface().(type) {
+	case url.URL:
+		u = &v
+	case *url.URL:
+		u = v
+	default:
+		panic("source field for jsonmap.URL() is not a url.URL or *url.URL")
+	}
+
+	if u == nil {
+		buf.Write(nullJSONValue)
+		return nil
+	}
+	return marshalValueTo(u.String(), buf)
+}
+
+func containsFold(options []string, s string) bool {
+	for _, o := range options {
+		if strings.EqualFold(o, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// URL returns a TypeMap for url.URL (or *url.URL) fields, which accepts
+// absolute URLs with one of the given schemes, or any scheme if none are
+// given. To also restrict the host, use a URLMap directly.
+func URL(schemes ...string) TypeMap {
+	return &URLMap{
+		Schemes: schemes,
+	}
+}
+
+// URLStringValidator accepts strings holding URLs, applying the same rules
+// as URLMap, for fields which hold URLs as strings. See URLString().
+type URLStringValidator struct {
+	// Schemes, if not empty, are the permitted URL schemes, such as "https".
+	Schemes []string
+
+	// Hosts, if not empty, are the permitted URL hosts (including any port).
+	Hosts []string
+}
+
+func (v *URLStringValidator) Validate(value interface{}) (interface{}, error) {
+	s, ok := value.(string)
+	if !ok {
+		return nil, NewValidationError("not a string")
+	}
+
+	return v.ValidateString(s)
+}
+
+func (v *URLStringValidator) ValidateString(s string) (string, error) {
+	_, err := parseURL(s, v.Schemes, v.Hosts)
+	if err != nil {
+		return "", err
+	}
+	return s, nil
+}
+
+// URLString returns a Validator for string fields which accepts absolute URLs
+// with one of the given schemes, or any scheme if none are given. The string
+// is left as it was given. Fields of type url.URL should use URL() instead.
+func URLString(schemes ...string) *URLStringValidator {
+	return &URLStringValidator{
+		Schemes: schemes,
+	}
+}
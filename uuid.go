@@ -0,0 +1,69 @@
+package jsonmap
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// UUIDMap maps UUID strings to UUID-typed fields. Any type whose underlying
+// type is [16]byte is supported, which includes the UUID types of both
+// github.com/google/uuid and github.com/gofrs/uuid.
+type UUIDMap struct{}
+
+func checkUUIDType(t reflect.Type, which string) {
+	if t.Kind() != reflect.Array || t.Len() != 16 || t.Elem().Kind() != reflect.Uint8 {
+		panic(which + " field for jsonmap.UUID() is not a [16]byte UUID type")
+	}
+}
+
+func (m *UUIDMap) Unmarshal(ctx Context, parent *reflect.Value, partial interface{}, dstValue reflect.Value) error {
+	checkUUIDType(dstValue.Type(), "target")
+
+	s, ok := partial.(string)
+	if !ok {
+		return NewValidationError("not a string")
+	}
+
+	s, err := UUIDString().ValidateString(s)
+	if err != nil {
+		return err
+	}
+
+	b, err := hex.DecodeString(strings.Replace(s, "-", "", -1))
+	if err != nil {
+		return NewValidationError("not a valid UUID")
+	}
+
+	reflect.Copy(dstValue, reflect.ValueOf(b))
+
+	return nil
+}
+
+func (m *UUIDMap) Marshal(ctx Context, parent *reflect.Value, src reflect.Value) (json.Marshaler, error) {
+	buf := &bytes.Buffer{}
+	err := m.marshalTo(ctx, parent, src, buf)
+	if err != nil {
+		return nil, err
+	}
+	return RawMessage{buf.Bytes()}, nil
+}
+
+func (m *UUIDMap) marshalTo(ctx Context, parent *reflect.Value, src reflect.Value, buf *bytes.Buffer) error {
+	checkUUIDType(src.Type(), "source")
+
+	b := make([]byte, 16)
+	reflect.Copy(reflect.ValueOf(b), src)
+
+	h := hex.EncodeToString(b)
+	return marshalValueTo(h[0:8]+"-"+h[8:12]+"-"+h[12:16]+"-"+h[16:20]+"-"+h[20:], buf)
+}
+
+// UUID returns a TypeMap for UUID-typed fields (see UUIDMap), which accepts
+// UUIDs as validated by UUIDString and marshals them in canonical lowercase
+// form.
+func UUID() TypeMap {
+	return &UUIDMap{}
+}
@@ -6,6 +6,9 @@ import (
 	"math"
 	"reflect"
 	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 var uuidRegex = regexp.MustCompile(`(?i)^[0-9a-f]{8}-[0-9a-f]{4}-[1-5][0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
@@ -63,6 +66,106 @@ func String(minLen int, maxLen int) *StringValidator {
 	}
 }
 
+// Regex returns a Validator which accepts strings of any length matching re,
+// as StringRegexValidator does for query parameters. Use RegexError on the
+// result for a friendlier error message, or String(minLen, maxLen).Regex(re)
+// to bound the length as well.
+func Regex(re *regexp.Regexp) *StringValidator {
+	return &StringValidator{
+		MinLen: 0,
+		MaxLen: math.MaxInt,
+		RE:     re,
+	}
+}
+
+// MaxBytesValidator rejects values larger than a number of bytes. See
+// MaxBytes().
+type MaxBytesValidator struct {
+	N int
+}
+
+func (v *MaxBytesValidator) Validate(value interface{}) (interface{}, error) {
+	ok := true
+	if s, isString := value.(string); isString {
+		ok = len(s) <= v.N
+	} else {
+		_, ok = jsonSize(value, v.N)
+	}
+
+	if !ok {
+		return nil, NewValidationError("too large, may not be more than %d bytes", v.N)
+	}
+	return value, nil
+}
+
+// jsonSize returns the size in bytes of the compact JSON encoding of the
+// decoded value v, not counting any escaping of strings, or false as soon as
+// it is found to exceed max.
+func jsonSize(v interface{}, max int) (int, bool) {
+	size := 0
+	switch tv := v.(type) {
+	case string:
+		size = len(tv) + 2
+	case float64:
+		// As encoding/json, which uses exponents only for very large and small
+		// numbers
+		format := byte('f')
+		if abs := math.Abs(tv); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+			format = 'e'
+		}
+		size = len(strconv.FormatFloat(tv, format, -1, 64))
+	case bool:
+		size = len(strconv.FormatBool(tv))
+	case nil:
+		size = len(nullJSONValue)
+	case []interface{}:
+		size = separatedSize(len(tv))
+		for _, elem := range tv {
+			n, ok := jsonSize(elem, max-size)
+			if !ok {
+				return 0, false
+			}
+			size += n
+		}
+	case map[string]interface{}:
+		size = separatedSize(len(tv))
+		for key, elem := range tv {
+			size += len(key) + 3
+			n, ok := jsonSize(elem, max-size)
+			if !ok {
+				return 0, false
+			}
+			size += n
+		}
+	}
+
+	if size > max {
+		return 0, false
+	}
+	return size, true
+}
+
+// separatedSize returns the size of the brackets and commas of an array or
+// object with n elements.
+func separatedSize(n int) int {
+	if n == 0 {
+		return 2
+	}
+	return n + 1
+}
+
+// MaxBytes returns a Validator which rejects strings longer than n bytes, and
+// other values whose JSON encoding is longer than n bytes. It is intended to
+// be combined with other Validators using AllOf(), being applied first, so
+// that oversized values are rejected before any further work is done on them:
+//
+//	Validator: AllOf(MaxBytes(64*1024), String(1, math.MaxInt32))
+func MaxBytes(n int) *MaxBytesValidator {
+	return &MaxBytesValidator{
+		N: n,
+	}
+}
+
 type BooleanValidator struct{}
 
 func (v *BooleanValidator) Validate(value interface{}) (interface{}, error) {
@@ -77,6 +180,53 @@ func Boolean() Validator {
 	return &BooleanValidator{}
 }
 
+// BooleanStringsValidator accepts booleans, and strings or numbers matching
+// the representations of true and false it is configured with. See
+// BooleanStrings().
+type BooleanStringsValidator struct {
+	TrueValues  []string
+	FalseValues []string
+}
+
+func (v *BooleanStringsValidator) Validate(value interface{}) (interface{}, error) {
+	var s string
+	switch tv := value.(type) {
+	case bool:
+		return tv, nil
+	case string:
+		s = tv
+	case float64:
+		s = strconv.FormatFloat(tv, 'f', -1, 64)
+	default:
+		return nil, NewValidationError("not a boolean")
+	}
+
+	for _, t := range v.TrueValues {
+		if strings.EqualFold(s, t) {
+			return true, nil
+		}
+	}
+
+	for _, f := range v.FalseValues {
+		if strings.EqualFold(s, f) {
+			return false, nil
+		}
+	}
+
+	return nil, NewValidationError("not a boolean")
+}
+
+// BooleanStrings returns a Validator which accepts booleans, along with
+// strings (compared case insensitively) and numbers written as one of
+// trueVals or falseVals, such as "yes" and "no" or "1" and "0", normalizing
+// them into a bool. Values are still marshaled as booleans.
+func BooleanStrings(trueVals, falseVals []string) Validator {
+	return &BooleanStringsValidator{
+		TrueValues:  trueVals,
+		FalseValues: falseVals,
+	}
+}
+
 // TODO: The spectrum of numeric types deserves more thought. Do we ship
 // independent validators for each?
 type IntegerValidator struct {
@@ -93,7 +243,10 @@ func (v *IntegerValidator) Validate(value interface{}) (interface{}, error) {
 		return nil, NewValidationError("not an integer")
 	}
 
-	i := int64(f)
+	return v.validateInt(int64(f))
+}
+
+func (v *IntegerValidator) validateInt(i int64) (interface{}, error) {
 	if i < v.MinVal {
 		return nil, NewValidationError("too small, must be at least %d", v.MinVal)
 	}
@@ -105,6 +258,20 @@ func (v *IntegerValidator) Validate(value interface{}) (interface{}, error) {
 	return i, nil
 }
 
+// ValidateContext is like Validate, but also accepts numbers written as
+// strings in the locale given by WithLocale, and integers written as strings
+// in ProtoJSON mode, which are parsed without loss of precision.
+func (v *IntegerValidator) ValidateContext(ctx Context, value interface{}) (interface{}, error) {
+	if s, ok := value.(string); ok && isProtoJSON(ctx) {
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, NewValidationError("not an integer")
+		}
+		return v.validateInt(i)
+	}
+	return v.Validate(delocalizeNumber(ctx, value))
+}
+
 func Integer(minVal, maxVal int64) Validator {
 	return &IntegerValidator{
 		MinVal: minVal,
@@ -112,6 +279,53 @@ func Integer(minVal, maxVal int64) Validator {
 	}
 }
 
+// FloatValidator accepts any number within a range, including those with a
+// fractional part. See Float().
+type FloatValidator struct {
+	MinVal float64
+	MaxVal float64
+}
+
+func (v *FloatValidator) Validate(value interface{}) (interface{}, error) {
+	f, ok := value.(float64)
+	if !ok {
+		return nil, NewValidationError("not a number")
+	}
+
+	if f < v.MinVal {
+		return nil, NewValidationError("too small, must be at least %s", strconv.FormatFloat(v.MinVal, 'f', -1, 64))
+	}
+
+	if f > v.MaxVal {
+		return nil, NewValidationError("too large, may not be larger than %s", strconv.FormatFloat(v.MaxVal, 'f', -1, 64))
+	}
+
+	return f, nil
+}
+
+// ValidateContext is like Validate, but also accepts numbers written as
+// strings in the locale given by WithLocale, or in ProtoJSON mode.
+func (v *FloatValidator) ValidateContext(ctx Context, value interface{}) (interface{}, error) {
+	if s, ok := value.(string); ok && isProtoJSON(ctx) {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, NewValidationError("not a number")
+		}
+		return v.Validate(f)
+	}
+	return v.Validate(delocalizeNumber(ctx, value))
+}
+
+// Float returns a Validator which accepts numbers between minVal and maxVal
+// inclusive, producing a float64. Used with NewPrimitiveMap, the target field
+// may be a float32 or a float64.
+func Float(minVal, maxVal float64) Validator {
+	return &FloatValidator{
+		MinVal: minVal,
+		MaxVal: maxVal,
+	}
+}
+
 type InterfaceValidator struct{}
 
 func (v *InterfaceValidator) Validate(value interface{}) (interface{}, error) {
@@ -133,7 +347,10 @@ func (v *LossyUint64Validator) Validate(value interface{}) (interface{}, error)
 		return nil, NewValidationError("not an integer")
 	}
 
-	i := uint64(f)
+	return v.validateUint(uint64(f))
+}
+
+func (v *LossyUint64Validator) validateUint(i uint64) (interface{}, error) {
 	if i < v.MinVal {
 		return nil, NewValidationError("too small, must be at least %d", v.MinVal)
 	}
@@ -145,6 +362,20 @@ func (v *LossyUint64Validator) Validate(value interface{}) (interface{}, error)
 	return i, nil
 }
 
+// ValidateContext is like Validate, but also accepts numbers written as
+// strings in the locale given by WithLocale, and integers written as strings
+// in ProtoJSON mode, which are parsed without loss of precision.
+func (v *LossyUint64Validator) ValidateContext(ctx Context, value interface{}) (interface{}, error) {
+	if s, ok := value.(string); ok && isProtoJSON(ctx) {
+		i, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return nil, NewValidationError("not an integer")
+		}
+		return v.validateUint(i)
+	}
+	return v.Validate(delocalizeNumber(ctx, value))
+}
+
 func (v *LossyUint64Validator) Min(min uint64) {
 	v.MinVal = min
 }
@@ -162,7 +393,12 @@ func LossyUint64() *LossyUint64Validator {
 	}
 }
 
-type UUIDStringValidator struct{}
+// UUIDStringValidator accepts UUIDs of versions 1 to 5 written in the usual
+// hyphenated form, in either case. See UUIDString() and UUIDStringVersion().
+type UUIDStringValidator struct {
+	// Version, if not zero, is the only version of UUID accepted
+	Version int
+}
 
 func (v *UUIDStringValidator) Validate(value interface{}) (interface{}, error) {
 	s, ok := value.(string)
@@ -178,6 +414,11 @@ func (v *UUIDStringValidator) ValidateString(value string) (string, error) {
 		return "", NewValidationError("not a valid UUID")
 	}
 
+	// The version is the first digit of the third group
+	if v.Version != 0 && int(value[14]-'0') != v.Version {
+		return "", NewValidationError("not a valid version %d UUID", v.Version)
+	}
+
 	return value, nil
 }
 
@@ -185,6 +426,24 @@ func UUIDString() *UUIDStringValidator {
 	return &UUIDStringValidator{}
 }
 
+// UUIDStringVersion is like UUIDString, but accepts only UUIDs of the given
+// version, from 1 to 5.
+func UUIDStringVersion(version int) *UUIDStringValidator {
+	if version < 1 || version > 5 {
+		panic(fmt.Sprintf("unsupported UUID version: %d", version))
+	}
+
+	return &UUIDStringValidator{
+		Version: version,
+	}
+}
+
+// UUIDv4 returns a Validator which accepts only version 4 (random) UUIDs, as
+// are typically used as identifiers.
+func UUIDv4() *UUIDStringValidator {
+	return UUIDStringVersion(4)
+}
+
 type StringsSliceMapper struct {
 	StringValidator *StringValidator
 }
@@ -304,3 +563,157 @@ func KeyFromVariableTypeMap(m map[string]TypeMap) Validator {
 
 	return OneOf(keys...)
 }
+
+// EnumeratedJSONValuesValidator is like EnumeratedValuesValidator, but allows
+// values of any JSON type, such as numbers and booleans. See OneOfValues().
+type EnumeratedJSONValuesValidator struct {
+	AllowedSlice []interface{}
+
+	// AllowedValues maps the JSON encoding of each allowed value to the value
+	AllowedValues map[string]interface{}
+}
+
+func (v *EnumeratedJSONValuesValidator) Validate(value interface{}) (interface{}, error) {
+	key, err := json.Marshal(value)
+	if err == nil {
+		if allowed, ok := v.AllowedValues[string(key)]; ok {
+			return allowed, nil
+		}
+	}
+
+	serialized, err := json.Marshal(v.AllowedSlice)
+	if err != nil {
+		// As with OneOf(), this represents a programming error
+		panic(err)
+	}
+
+	return nil, NewValidationError("Value must be one of: %s", string(serialized))
+}
+
+// OneOfValues returns a Validator which accepts only the given values, which
+// may be of any type whose JSON encoding is a string, number or boolean. A
+// value is accepted if its JSON encoding is that of an allowed value, so that
+// numbers match regardless of their Go type, but never strings containing
+// them. The allowed value is returned, allowing fields to be of its type.
+func OneOfValues(allowed ...interface{}) Validator {
+	v := &EnumeratedJSONValuesValidator{
+		AllowedSlice:  allowed,
+		AllowedValues: map[string]interface{}{},
+	}
+
+	for _, value := range allowed {
+		key, err := json.Marshal(value)
+		if err != nil {
+			panic(err)
+		}
+		v.AllowedValues[string(key)] = value
+	}
+
+	return v
+}
+
+// ContextValidator is a Validator which makes use of the Context, such as to
+// look values up in a repository provided by the caller. ValidateContext is
+// used in preference to Validate wherever a Context is available.
+type ContextValidator interface {
+	Validator
+	ValidateContext(ctx Context, value interface{}) (interface{}, error)
+}
+
+// ValidateWithContext validates value using v, passing it ctx if v is a
+// ContextValidator.
+func ValidateWithContext(ctx Context, v Validator, value interface{}) (interface{}, error) {
+	if cv, ok := v.(ContextValidator); ok {
+		return cv.ValidateContext(ctx, value)
+	}
+	return v.Validate(value)
+}
+
+type AllOfValidator struct {
+	Validators []Validator
+}
+
+func (v *AllOfValidator) Validate(value interface{}) (interface{}, error) {
+	return v.ValidateContext(EmptyContext, value)
+}
+
+func (v *AllOfValidator) ValidateContext(ctx Context, value interface{}) (interface{}, error) {
+	var err error
+	for _, validator := range v.Validators {
+		value, err = ValidateWithContext(ctx, validator, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
+
+// AllOf returns a Validator which applies each of the given validators in
+// turn, passing the value returned by each to the next.
+func AllOf(validators ...Validator) *AllOfValidator {
+	return &AllOfValidator{
+		Validators: validators,
+	}
+}
+
+type ExistsInValidator struct {
+	Exists func(ctx Context, value interface{}) (bool, error)
+}
+
+func (v *ExistsInValidator) Validate(value interface{}) (interface{}, error) {
+	return v.ValidateContext(EmptyContext, value)
+}
+
+func (v *ExistsInValidator) ValidateContext(ctx Context, value interface{}) (interface{}, error) {
+	ok, err := v.Exists(ctx, value)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, NewValidationError("does not exist")
+	}
+
+	return value, nil
+}
+
+// ExistsIn returns a Validator which checks that a value, typically an ID,
+// refers to something which exists, using a lookup function which is passed
+// the Context (for example, to find a repository stored in a Ctx). Errors
+// returned by the lookup are reported as validation errors of the field. To
+// check the form of the value first, combine it with another Validator using
+// AllOf.
+func ExistsIn(exists func(ctx Context, value interface{}) (bool, error)) *ExistsInValidator {
+	return &ExistsInValidator{
+		Exists: exists,
+	}
+}
+
+// namedValidators are the Validators registered with RegisterValidators.
+var (
+	namedValidatorsMu sync.RWMutex
+	namedValidators   = map[string]Validator{}
+)
+
+// RegisterValidators makes validators available by name to declarative
+// definitions, such as those parsed by ParseSchema. Definitions are resolved
+// when they are parsed, so only those parsed afterwards see the validators;
+// they are typically registered from an init function. Registering a name
+// again replaces its validator.
+func RegisterValidators(validators map[string]Validator) {
+	namedValidatorsMu.Lock()
+	defer namedValidatorsMu.Unlock()
+
+	for name, v := range validators {
+		namedValidators[name] = v
+	}
+}
+
+// LookupValidator returns the Validator registered under name, if any.
+func LookupValidator(name string) (Validator, bool) {
+	namedValidatorsMu.RLock()
+	defer namedValidatorsMu.RUnlock()
+
+	v, ok := namedValidators[name]
+	return v, ok
+}